@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/kumagai-s/uploader-v2/internal/app"
+)
+
+// cleanup は、EventBridge のスケジュールから呼び出され、期限切れのリンクを送信したメッセージを整理する関数です。
+func main() {
+	lambda.Start(app.HandleCleanup)
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/kumagai-s/uploader-v2/internal/app"
+)
+
+// receiver は、Slackのイベント、スラッシュコマンド、インタラクションを受け付ける関数です。
+// Lambda以外で起動した場合は、確認ページやポータルも含めた常駐するHTTPサーバーとして動作します。
+func main() {
+	if !app.InLambda() {
+		app.Serve()
+		return
+	}
+	lambda.Start(app.HandleReceiver)
+}
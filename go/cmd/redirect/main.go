@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/kumagai-s/uploader-v2/internal/app"
+)
+
+// redirect は、内部の短縮URLサービスの短縮URL（SHORTENER_BASE_URL/短縮コード）をリダイレクトする関数です。
+func main() {
+	lambda.Start(app.HandleRedirect)
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/kumagai-s/uploader-v2/internal/app"
+)
+
+// worker は、受付の関数から非同期に渡されたイベントと、予約したURLの送信、返信の再送、Slackへの復元を処理する関数です。
+// 受付の関数の ASYNC_WORKER_FUNCTION と SCHEDULER_TARGET_ARN にこの関数を指定してください。
+func main() {
+	lambda.Start(app.HandleWorker)
+}
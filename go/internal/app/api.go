@@ -0,0 +1,634 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/glacier"
+	"github.com/kumagai-s/uploader-v2/internal/openapi"
+	"github.com/kumagai-s/uploader-v2/internal/provenance"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// apiRequest は、APIのハンドラーに渡すリクエストです。
+type apiRequest struct {
+	events.APIGatewayProxyRequest
+	Params    map[string]string // パスのパラメーター（「{id}」など）
+	Principal string            // 認証した呼び出し元（APIキーの名前またはIAMのARN）
+}
+
+// apiHandler と apiMiddleware は、APIの各操作の処理と、その前後に処理を加えるミドルウェアです。
+type (
+	apiHandler    func(r *apiRequest) (events.APIGatewayProxyResponse, error)
+	apiMiddleware func(next apiHandler) apiHandler
+)
+
+// apiRoute は、APIの1つの操作です。OpenAPI の文書もこの定義から生成します。
+type apiRoute struct {
+	openapi.Operation
+	Handler apiHandler
+}
+
+// apiError は、APIがエラーの場合に返すJSONです。
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// refreshedLink は、GET /api/links/{id}/refresh が返すJSONです。
+type refreshedLink struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	ExpiresAt string `json:"expires_at"` // RFC 3339
+	Region    string `json:"region,omitempty"`
+}
+
+// linkMetadata は、リンクの情報を返す操作が返すJSONです。
+type linkMetadata struct {
+	ID            string `json:"id"`
+	FileName      string `json:"file_name"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256,omitempty"`
+	ShortURL      string `json:"short_url"`
+	Note          string `json:"note,omitempty"`
+	CreatedAt     string `json:"created_at"`     // RFC 3339
+	ExpiresAt     string `json:"expires_at"`     // RFC 3339
+	Status        string `json:"status"`         // active、expired または revoked
+	DownloadCount int64  `json:"download_count"` // 短縮URL、確認ページ、ポータルからダウンロードされた回数
+	LegalHold     bool   `json:"legal_hold,omitempty"`
+	Region        string `json:"region,omitempty"`
+	Provenance    string `json:"provenance,omitempty"` // in-toto の来歴の短縮URL
+}
+
+// linkList は、GET /api/links が返すJSONです。
+type linkList struct {
+	Links []*linkMetadata `json:"links"`
+}
+
+// linkStats は、GET /api/stats が返すJSONです。
+type linkStats struct {
+	From       string          `json:"from"` // RFC 3339
+	To         string          `json:"to"`   // RFC 3339
+	Created    int             `json:"created"`
+	Expired    int             `json:"expired"`
+	Downloads  int64           `json:"downloads"` // 期間に作成されたリンクがダウンロードされた回数
+	TotalBytes int64           `json:"total_bytes"`
+	Channels   []*channelStats `json:"channels"`
+}
+
+// channelStats は、linkStats のチャンネルごとの集計です。
+type channelStats struct {
+	Channel    string `json:"channel"`
+	TeamID     string `json:"team_id,omitempty"`
+	Created    int    `json:"created"`
+	Expired    int    `json:"expired"`
+	Downloads  int64  `json:"downloads"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+// apiRoutes は、APIのすべての操作です。
+func apiRoutes() []apiRoute {
+	period := []openapi.Parameter{
+		{Name: "from", In: "query", Description: "期間の開始（RFC 3339）"},
+		{Name: "to", In: "query", Description: "期間の終了（RFC 3339）。省略した場合は現在"},
+	}
+	id := openapi.Parameter{Name: "id", In: "path", Description: "監査記録のID"}
+	return []apiRoute{
+		{Operation: openapi.Operation{
+			Method: http.MethodGet, Path: "/api/openapi.json", ID: "getOpenAPI", Public: true,
+			Summary:  "このAPIの OpenAPI の文書を返します。",
+			Response: map[string]interface{}{},
+		}, Handler: handleOpenAPI},
+		{Operation: openapi.Operation{
+			Method: http.MethodGet, Path: "/api/links", ID: "listLinks",
+			Summary:    "期間（デフォルトは過去7日間）に作成されたか期限切れになったリンクを返します。",
+			Parameters: append(period, openapi.Parameter{Name: "team_id", In: "query", Description: "ワークスペースのID"}),
+			Response:   &linkList{},
+			Errors:     []int{http.StatusBadRequest, http.StatusUnauthorized},
+		}, Handler: handleListLinks},
+		{Operation: openapi.Operation{
+			Method: http.MethodPost, Path: "/api/links", ID: "createLink", Status: http.StatusCreated,
+			Summary: "リクエストボディのファイルを検証してアップロードし、リンクを発行します。",
+			Parameters: []openapi.Parameter{
+				{Name: "name", In: "query", Description: "ファイル名", Required: true},
+				{Name: "expiry", In: "query", Description: "署名付きURLの有効期限（例: 3d）"},
+				{Name: "note", In: "query", Description: "リンクに添える説明"},
+				{Name: "team_id", In: "query", Description: "ワークスペースのID"},
+				{Name: "repo", In: "query", Description: "ビルドしたリポジトリのURL。指定した場合は in-toto の来歴をオブジェクトの隣に保存する"},
+				{Name: "commit", In: "query", Description: "ビルドしたコミットのID。repo と一緒に指定する"},
+				{Name: "workflow_run", In: "query", Description: "ビルドを実行したワークフローの実行のURL"},
+			},
+			RequestType: "application/octet-stream",
+			Response:    &linkMetadata{},
+			Errors:      []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusUnprocessableEntity},
+		}, Handler: handleCreateLink},
+		{Operation: openapi.Operation{
+			Method: http.MethodGet, Path: "/api/links/{id}", ID: "getLink",
+			Summary:    "リンクの情報を返します。",
+			Parameters: []openapi.Parameter{id},
+			Response:   &linkMetadata{},
+			Errors:     []int{http.StatusUnauthorized, http.StatusNotFound},
+		}, Handler: handleLinkMetadata},
+		{Operation: openapi.Operation{
+			Method: http.MethodDelete, Path: "/api/links/{id}", ID: "revokeLink",
+			Summary:    "リンクのオブジェクトを削除して無効にします。",
+			Parameters: []openapi.Parameter{id},
+			Response:   &linkMetadata{},
+			Errors:     []int{http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict},
+		}, Handler: handleRevokeLink},
+		{Operation: openapi.Operation{
+			Method: http.MethodGet, Path: "/api/links/{id}/refresh", ID: "refreshLink",
+			Summary: "S3に残っているオブジェクトの署名付きURLを発行し直します。",
+			Parameters: []openapi.Parameter{id, {
+				Name: "expiry", In: "query", Description: "署名付きURLの有効期限。省略した場合は API_REFRESH_EXPIRY",
+			}},
+			Response: &refreshedLink{},
+			Errors:   []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusGone},
+		}, Handler: handleRefreshLink},
+		{Operation: openapi.Operation{
+			Method: http.MethodGet, Path: "/api/stats", ID: "getStats",
+			Summary:    "期間（デフォルトは過去24時間）のリンクの作成数、期限切れの数、ダウンロード数を集計します。",
+			Parameters: period,
+			Response:   &linkStats{},
+			Errors:     []int{http.StatusBadRequest, http.StatusUnauthorized},
+		}, Handler: handleLinkStats},
+	}
+}
+
+// handleAPI は、LAMBDA_HANDLER=api で起動したときのハンドラーです。
+// Slackを介さずにリンクを作成・一覧・無効化・再発行・集計する、下流のシステム向けのAPIです。
+// 操作の一覧は apiRoutes にあり、GET /api/openapi.json で OpenAPI の文書を返します。
+// /api/openapi.json 以外は authenticateAPI で認証します。
+func handleAPI(r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	route, params, allowed := matchAPIRoute(r.HTTPMethod, r.Path)
+	if route == nil {
+		if len(allowed) > 0 {
+			res, err := apiResponse(http.StatusMethodNotAllowed, &apiError{Error: "method not allowed"})
+			res.Headers["Allow"] = strings.Join(allowed, ", ")
+			return res, err
+		}
+		return apiResponse(http.StatusNotFound, &apiError{Error: "not found"})
+	}
+
+	handler := route.Handler
+	if !route.Public {
+		if auditStore == nil {
+			return apiResponse(http.StatusServiceUnavailable, &apiError{Error: "audit table is not configured"})
+		}
+		handler = authenticateAPI(handler)
+	}
+	return handler(&apiRequest{APIGatewayProxyRequest: r, Params: params})
+}
+
+// matchAPIRoute は、メソッドとパスに一致する操作とパスのパラメーターを返します。
+// パスに一致してメソッドが異なる場合は、nil と、そのパスで使えるメソッドを返します。
+func matchAPIRoute(method, p string) (*apiRoute, map[string]string, []string) {
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+	var allowed []string
+	for _, route := range apiRoutes() {
+		params, ok := matchAPIPath(route.Path, segments)
+		if !ok {
+			continue
+		}
+		if route.Method == method {
+			route := route
+			return &route, params, nil
+		}
+		allowed = append(allowed, route.Method)
+	}
+	return nil, nil, allowed
+}
+
+// matchAPIPath は、「/api/links/{id}」のようなパターンとパスの各部分を比べ、一致する場合はパラメーターを返します。
+func matchAPIPath(pattern string, segments []string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[strings.Trim(part, "{}")] = segments[i]
+			continue
+		}
+		if part != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// authenticateAPI は、呼び出し元を認証してから next を呼び出すミドルウェアです。
+// API Gateway の IAM 認証（SigV4）で署名を検証したリクエストは、呼び出し元のARNが API_IAM_PRINCIPALS に含まれる場合に許可します。
+// それ以外は、「Authorization: Bearer キー」または「X-Api-Key: キー」で API_KEYS のいずれかのキーを送信する必要があります。
+var authenticateAPI apiMiddleware = func(next apiHandler) apiHandler {
+	return func(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+		principal, ok := apiPrincipal(r)
+		if !ok {
+			return apiResponse(http.StatusUnauthorized, &apiError{Error: "unauthorized"})
+		}
+		r.Principal = principal
+		return next(r)
+	}
+}
+
+// apiPrincipal は、リクエストの呼び出し元を返します。認証できない場合は false を返します。
+func apiPrincipal(r *apiRequest) (string, bool) {
+	if arn := r.RequestContext.Identity.UserArn; arn != "" {
+		for _, p := range splitEnvList("API_IAM_PRINCIPALS") {
+			// 「arn:aws:iam::123456789012:role/*」のように、末尾の「*」で前方一致を指定できる。
+			if arn == p || (strings.HasSuffix(p, "*") && strings.HasPrefix(arn, strings.TrimSuffix(p, "*"))) {
+				return arn, true
+			}
+		}
+	}
+
+	key := headerValue(r.Headers, "X-Api-Key")
+	if auth := headerValue(r.Headers, "Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" {
+		return "", false
+	}
+	// API_KEYS の各キーには、「ci:キー」のように呼び出し元の名前を付けられる。
+	for _, entry := range splitEnvList("API_KEYS") {
+		name, k, ok := strings.Cut(entry, ":")
+		if !ok {
+			name, k = "", entry
+		}
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return strings.TrimSuffix("api-key:"+name, ":"), true
+		}
+	}
+	return "", false
+}
+
+// handleOpenAPI は、apiRoutes から生成した OpenAPI の文書を返します。
+func handleOpenAPI(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+	spec := &openapi.Spec{
+		Title:       "slack-download-url-generator",
+		Version:     "1.0.0",
+		Description: "Slackで発行したダウンロードURLを管理するAPIです。",
+		ErrorType:   &apiError{},
+		Security: []openapi.SecurityScheme{
+			{Name: "apiKey", Type: "apiKey", In: "header", Header: "X-Api-Key", Description: "API_KEYS のキー"},
+			{Name: "bearer", Type: "http", Scheme: "bearer", Description: "API_KEYS のキー"},
+			{Name: "sigv4", Type: "apiKey", In: "header", Header: "Authorization", AuthType: "awsSigv4", Description: "API_IAM_PRINCIPALS の IAM プリンシパル"},
+		},
+	}
+	if base := os.Getenv("API_BASE_URL"); base != "" {
+		spec.Servers = []string{base}
+	}
+	for _, route := range apiRoutes() {
+		spec.Operations = append(spec.Operations, route.Operation)
+	}
+	doc, err := openapi.Generate(spec)
+	if err != nil {
+		log.Println("OpenAPI の文書の生成中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(doc),
+	}, nil
+}
+
+// handleListLinks は、期間に作成されたか期限切れになったリンクを返します。
+func handleListLinks(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+	from, to, err := apiPeriod(r.QueryStringParameters, 7*24*time.Hour)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, &apiError{Error: err.Error()})
+	}
+	records, err := auditStore.ListActiveBetween(context.TODO(), from, to)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt < records[j].CreatedAt })
+
+	now := time.Now()
+	list := &linkList{Links: []*linkMetadata{}}
+	for _, record := range records {
+		if teamID := r.QueryStringParameters["team_id"]; teamID != "" && record.TeamID != teamID {
+			continue
+		}
+		list.Links = append(list.Links, newLinkMetadata(record, now))
+	}
+	return apiResponse(http.StatusOK, list)
+}
+
+// handleCreateLink は、リクエストボディのファイルをメンションで送られたファイルと同じ規則で検証してアップロードし、
+// 短縮URLを発行して監査記録に保存します。依頼者には呼び出し元を記録します。
+// CIが repo、commit、workflow_run でビルドの情報を添えた場合は、in-toto の来歴をオブジェクトの隣に保存し、その短縮URLを返します。
+func handleCreateLink(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+	q := r.QueryStringParameters
+	var build *provenance.Build
+	if q["repo"] != "" || q["commit"] != "" || q["workflow_run"] != "" {
+		build = &provenance.Build{Repository: q["repo"], Commit: q["commit"], WorkflowRun: q["workflow_run"]}
+		if err := build.Validate(); err != nil {
+			return apiResponse(http.StatusBadRequest, &apiError{Error: err.Error()})
+		}
+	}
+	opts := &mentionOptions{Note: q["note"]}
+	if q["expiry"] != "" {
+		d, err := parseDuration(q["expiry"])
+		if err != nil || d <= 0 || d > presignExpiry {
+			return apiResponse(http.StatusBadRequest, &apiError{Error: "invalid expiry"})
+		}
+		opts.Expiry = d
+	}
+	if utf8.RuneCountInString(opts.Note) > maxNoteLength {
+		return apiResponse(http.StatusBadRequest, &apiError{Error: "note is too long"})
+	}
+	body, err := decodeRequestBody(r.APIGatewayProxyRequest)
+	if err != nil || q["name"] == "" || body == "" {
+		return apiResponse(http.StatusBadRequest, &apiError{Error: "name and body are required"})
+	}
+
+	file := &SlackAppMentionEventFile{Name: q["name"], Size: int64(len(body)), Binary: []byte(body)}
+	if _, err := inspectInboxFile(&slackevents.AppMentionEvent{}, file); err != nil {
+		if errors.Is(err, ErrValidation) {
+			return apiResponse(http.StatusUnprocessableEntity, &apiError{Error: err.Error()})
+		}
+		log.Println("ファイルの検査中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	ws, err := resolveWorkspace(q["team_id"], "")
+	if errors.Is(err, errUnknownWorkspace) {
+		return apiResponse(http.StatusNotFound, &apiError{Error: "team is not registered"})
+	}
+	if err != nil {
+		log.Println("ワークスペースの確認中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	uploaded, err := uploadFileToS3AndGetPresignedURL(ws, file, opts)
+	if err != nil {
+		if errors.Is(err, ErrValidation) {
+			return apiResponse(http.StatusUnprocessableEntity, &apiError{Error: err.Error()})
+		}
+		log.Println("S3へのアップロード中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	shortURL, err := shortenerFor(q["team_id"], "").Shorten(uploaded.PresignedURL)
+	if err != nil {
+		log.Println("URLの短縮中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+
+	var attestation string
+	if build != nil {
+		attestation, err = attachProvenance(ws, uploaded, file, build, r.Principal)
+		if err != nil {
+			log.Println("来歴の保存中にエラーが発生しました。", err)
+			return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+		}
+	}
+
+	// 作成したリンクのIDを返すため、IDを先に決めてから保存する。
+	id, err := audit.NewID()
+	if err != nil {
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	record := &audit.Record{
+		ID:         id,
+		TeamID:     q["team_id"],
+		User:       r.Principal,
+		FileName:   file.Name,
+		Bucket:     uploaded.Bucket,
+		ObjectKey:  uploaded.Key,
+		VersionID:  uploaded.VersionID,
+		Region:     uploaded.Region,
+		Size:       file.Size,
+		ShortURL:   shortURL,
+		ExpiresAt:  uploaded.ExpiresAt.Unix(),
+		Note:       opts.Note,
+		SHA256:     contentSHA256(file.Binary),
+		Provenance: attestation,
+	}
+	recordAudit(record)
+	log.Println("APIでリンクを作成しました。", record.ID, r.Principal)
+	return apiResponse(http.StatusCreated, newLinkMetadata(record, time.Now()))
+}
+
+// handleLinkMetadata は、監査記録 id のリンクの情報を返します。
+// 外部のシステムが、Slackに送信したリンクと手元の成果物を突き合わせるために使います。
+func handleLinkMetadata(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+	record, err := auditStore.Get(context.TODO(), r.Params["id"])
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	if record == nil {
+		return apiResponse(http.StatusNotFound, &apiError{Error: "not found"})
+	}
+	return apiResponse(http.StatusOK, newLinkMetadata(record, time.Now()))
+}
+
+// handleRevokeLink は、監査記録 id のオブジェクトと、その隣に保存したメタリンク、マニフェスト、来歴を削除してリンクを無効にします。
+// 監査記録は残し、無効にした日時と呼び出し元を記録します。リーガルホールド中のリンクは無効にできません。
+func handleRevokeLink(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+	record, err := auditStore.Get(context.TODO(), r.Params["id"])
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	switch {
+	case record == nil:
+		return apiResponse(http.StatusNotFound, &apiError{Error: "not found"})
+	case record.RevokedAt != 0:
+		return apiResponse(http.StatusOK, newLinkMetadata(record, time.Now()))
+	case record.LegalHold:
+		return apiResponse(http.StatusConflict, &apiError{Error: "link is under legal hold"})
+	}
+
+	if err := deleteRecordObjects(context.TODO(), record); err != nil {
+		log.Println("オブジェクトの削除中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	record.RevokedAt = time.Now().Unix()
+	record.RevokedBy = r.Principal
+	if err := auditStore.Put(context.TODO(), record); err != nil {
+		log.Println("監査記録の保存中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	log.Println("APIでリンクを無効にしました。", record.ID, r.Principal)
+	return apiResponse(http.StatusOK, newLinkMetadata(record, time.Now()))
+}
+
+// handleRefreshLink は、S3に残っている監査記録 id のオブジェクトの署名付きURLを発行し直します。
+// 有効期限は expiry（省略した場合は API_REFRESH_EXPIRY、デフォルト 1h）で、presignExpiry を超えることはできません。
+// 受取人やグループを限定したリンクは、確認を経ずにダウンロードできるようになるため発行し直しません。
+func handleRefreshLink(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+	d, err := parseDuration(getEnvOrDefault("API_REFRESH_EXPIRY", "1h"))
+	if expiry := r.QueryStringParameters["expiry"]; expiry != "" {
+		d, err = parseDuration(expiry)
+	}
+	if err != nil || d <= 0 || d > presignExpiry {
+		return apiResponse(http.StatusBadRequest, &apiError{Error: "invalid expiry"})
+	}
+
+	record, err := auditStore.Get(context.TODO(), r.Params["id"])
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	switch {
+	case record == nil:
+		return apiResponse(http.StatusNotFound, &apiError{Error: "not found"})
+	case record.RevokedAt != 0:
+		return apiResponse(http.StatusGone, &apiError{Error: "link is revoked"})
+	case len(record.Recipients) > 0 || len(record.AllowedGroups) > 0:
+		return apiResponse(http.StatusForbidden, &apiError{Error: "link is restricted to recipients"})
+	}
+
+	// ライフサイクルで削除されたオブジェクトや、アーカイブされたオブジェクトの署名付きURLは発行しない。
+	state, _, err := objectArchiveState(context.TODO(), record)
+	switch {
+	case isObjectNotFound(err):
+		return apiResponse(http.StatusGone, &apiError{Error: "object is no longer retained"})
+	case err != nil:
+		log.Println("オブジェクトの状態の取得中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	case state != glacier.StateAvailable:
+		return apiResponse(http.StatusConflict, &apiError{Error: "object is " + state})
+	}
+
+	uploaded := &uploadedObject{
+		Bucket:       record.Bucket,
+		Key:          record.ObjectKey,
+		VersionID:    record.VersionID,
+		Region:       record.Region,
+		TeamID:       record.TeamID,
+		EnterpriseID: record.EnterpriseID,
+		Expiry:       d,
+	}
+	if err := presignObject(uploaded); err != nil {
+		log.Println("署名付きURLの生成中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+	log.Println("APIで署名付きURLを発行し直しました。", record.ID, r.Principal)
+	return apiResponse(http.StatusOK, &refreshedLink{
+		ID:        record.ID,
+		URL:       uploaded.PresignedURL,
+		ExpiresAt: uploaded.ExpiresAt.UTC().Format(time.RFC3339),
+		Region:    record.Region,
+	})
+}
+
+// handleLinkStats は、日次集計と同じ方法で、期間のリンクをチャンネルごとに集計します。
+func handleLinkStats(r *apiRequest) (events.APIGatewayProxyResponse, error) {
+	from, to, err := apiPeriod(r.QueryStringParameters, 24*time.Hour)
+	if err != nil {
+		return apiResponse(http.StatusBadRequest, &apiError{Error: err.Error()})
+	}
+	records, err := auditStore.ListActiveBetween(context.TODO(), from, to)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return apiResponse(http.StatusInternalServerError, &apiError{Error: "internal server error"})
+	}
+
+	stats := &linkStats{
+		From:     from.UTC().Format(time.RFC3339),
+		To:       to.UTC().Format(time.RFC3339),
+		Channels: []*channelStats{},
+	}
+	digests := summarizeAuditRecords(records, from, to)
+	channels := make([]string, 0, len(digests))
+	for channel := range digests {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		d := digests[channel]
+		stats.Created += d.Created
+		stats.Expired += d.Expired
+		stats.Downloads += d.Downloads
+		stats.TotalBytes += d.TotalBytes
+		stats.Channels = append(stats.Channels, &channelStats{
+			Channel:    channel,
+			TeamID:     d.TeamID,
+			Created:    d.Created,
+			Expired:    d.Expired,
+			Downloads:  d.Downloads,
+			TotalBytes: d.TotalBytes,
+		})
+	}
+	return apiResponse(http.StatusOK, stats)
+}
+
+// apiPeriod は、クエリの from と to（RFC 3339）から期間を求めます。from を省略した場合は to の span 前からです。
+func apiPeriod(q map[string]string, span time.Duration) (time.Time, time.Time, error) {
+	to := time.Now()
+	if q["to"] != "" {
+		t, err := time.Parse(time.RFC3339, q["to"])
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to")
+		}
+		to = t
+	}
+	from := to.Add(-span)
+	if q["from"] != "" {
+		t, err := time.Parse(time.RFC3339, q["from"])
+		if err != nil || !t.Before(to) {
+			return time.Time{}, time.Time{}, errors.New("invalid from")
+		}
+		from = t
+	}
+	return from, to, nil
+}
+
+// newLinkMetadata は、監査記録から時刻 now でのリンクの情報を作ります。
+func newLinkMetadata(record *audit.Record, now time.Time) *linkMetadata {
+	status := "active"
+	switch {
+	case record.RevokedAt != 0:
+		status = "revoked"
+	case now.Unix() >= record.ExpiresAt:
+		status = "expired"
+	}
+	return &linkMetadata{
+		ID:            record.ID,
+		FileName:      record.FileName,
+		Size:          record.Size,
+		SHA256:        record.SHA256,
+		ShortURL:      record.ShortURL,
+		Note:          record.Note,
+		CreatedAt:     time.Unix(record.CreatedAt, 0).UTC().Format(time.RFC3339),
+		ExpiresAt:     time.Unix(record.ExpiresAt, 0).UTC().Format(time.RFC3339),
+		Status:        status,
+		DownloadCount: record.DownloadCount,
+		LegalHold:     record.LegalHold,
+		Region:        record.Region,
+		Provenance:    record.Provenance,
+	}
+}
+
+// apiResponse は、v をJSONにしたレスポンスを返します。
+func apiResponse(statusCode int, v interface{}) (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type":  "application/json",
+			"Cache-Control": "no-store",
+		},
+		Body: string(body),
+	}, nil
+}
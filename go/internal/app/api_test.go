@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/tokenstore"
+)
+
+// recordStore は、登録した監査記録を ID で返し、ダウンロード回数を記録に加える audit.Store です。
+type recordStore struct {
+	audit.Store
+	records map[string]*audit.Record
+}
+
+func (s *recordStore) Get(ctx context.Context, id string) (*audit.Record, error) {
+	return s.records[id], nil
+}
+
+func (s *recordStore) ListActiveBetween(ctx context.Context, from, to time.Time) ([]*audit.Record, error) {
+	var records []*audit.Record
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *recordStore) CountDownload(ctx context.Context, id string) error {
+	s.records[id].DownloadCount++
+	return nil
+}
+
+func TestLinkStatsCountsDownloads(t *testing.T) {
+	saved := auditStore
+	t.Cleanup(func() { auditStore = saved })
+	store := &recordStore{records: map[string]*audit.Record{
+		"r1": {ID: "r1", Channel: "C1", CreatedAt: time.Now().Add(-time.Hour).Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}}
+	auditStore = store
+	for i := 0; i < 3; i++ {
+		countDownload(store.records["r1"])
+	}
+
+	res, err := handleLinkStats(&apiRequest{})
+	if err != nil {
+		t.Fatalf("handleLinkStats() error = %v", err)
+	}
+	var stats linkStats
+	if err := json.Unmarshal([]byte(res.Body), &stats); err != nil {
+		t.Fatalf("unable to unmarshal response, %s", err)
+	}
+	if stats.Downloads != 3 {
+		t.Errorf("Downloads = %d, want 3", stats.Downloads)
+	}
+}
+
+func TestRefreshLinkInRegistryMode(t *testing.T) {
+	withRegistry(t, &fakeRegistry{tokens: map[string]tokenstore.Tokens{"E1": {Bot: "xoxb-e1"}}})
+	objects := withFakeObjectStore(t)
+	objects.objects["T1/report.zip"] = http.Header{}
+	saved := auditStore
+	t.Cleanup(func() { auditStore = saved })
+	auditStore = &recordStore{records: map[string]*audit.Record{
+		"r1": {ID: "r1", TeamID: "T1", EnterpriseID: "E1", ObjectKey: "T1/report.zip", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}}
+
+	res, err := handleRefreshLink(&apiRequest{Params: map[string]string{"id": "r1"}, Principal: "ci"})
+	if err != nil {
+		t.Fatalf("handleRefreshLink() error = %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("StatusCode = %d, want %d: %s", res.StatusCode, http.StatusOK, res.Body)
+	}
+	var link refreshedLink
+	if err := json.Unmarshal([]byte(res.Body), &link); err != nil {
+		t.Fatalf("unable to unmarshal response, %s", err)
+	}
+	if !strings.Contains(link.URL, "/keys/T1/report.zip?") {
+		t.Errorf("URL = %q, want a presigned URL for the object", link.URL)
+	}
+}
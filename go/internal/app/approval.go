@@ -0,0 +1,295 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/approval"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+const (
+	approveActionID = "approval_approve"
+	denyActionID    = "approval_deny"
+)
+
+// approvalTTL は、承認されなかった申請を保持する期間です。
+const approvalTTL = 7 * 24 * time.Hour
+
+// approvalRequired は、URLの発行に承認者の承認が必要かどうかを返します。
+// APPROVAL_CHANNEL と APPROVAL_TABLE が設定されている場合に有効になります。
+func approvalRequired() bool {
+	return os.Getenv("APPROVAL_CHANNEL") != "" && approvalStore != nil
+}
+
+// errApproversNotConfigured は、二人承認が有効なのに承認者が設定されていない場合に返されます。
+var errApproversNotConfigured = validationError("二人承認の承認者が設定されていないため、URLを発行できません。管理者に APPROVER_USER_IDS または APPROVER_USERGROUP_IDS の設定を依頼してください。")
+
+// approversConfigured は、APPROVER_USER_IDS または APPROVER_USERGROUP_IDS で承認者が設定されているかを返します。
+func approversConfigured() bool {
+	return len(splitEnvList("APPROVER_USER_IDS")) > 0 || len(splitEnvList("APPROVER_USERGROUP_IDS")) > 0
+}
+
+// isApprover は、user が申請を承認・却下できるかを返します。
+// APPROVER_USER_IDS のユーザーと、APPROVER_USERGROUP_IDS のユーザーグループのメンバーが承認者です。
+// どちらも設定されていない場合は、誰も承認・却下できません。
+func isApprover(ws *workspace, user string) (bool, error) {
+	for _, id := range splitEnvList("APPROVER_USER_IDS") {
+		if id == user {
+			return true, nil
+		}
+	}
+	for _, group := range splitEnvList("APPROVER_USERGROUP_IDS") {
+		members, err := ws.Bot.GetUserGroupMembers(group)
+		if err != nil {
+			return false, fmt.Errorf("unable to get members of usergroup %s, %s", group, err)
+		}
+		for _, id := range members {
+			if id == user {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// newApprovalRequest は、アップロード済みのファイルの承認申請を生成します。
+func newApprovalRequest(ws *workspace, ev *slackevents.AppMentionEvent, p *publishedFile, opts *mentionOptions) *approval.Request {
+	return &approval.Request{
+		TeamID:        ws.TeamID,
+		EnterpriseID:  ws.EnterpriseID,
+		Channel:       ev.Channel,
+		ThreadTS:      ev.TimeStamp,
+		Requester:     ev.User,
+		FileName:      p.file.Name,
+		Bucket:        p.uploaded.Bucket,
+		ObjectKey:     p.uploaded.Key,
+		VersionID:     p.uploaded.VersionID,
+		Region:        p.uploaded.Region,
+		Size:          int64(len(p.file.Binary)),
+		Expiry:        int64(p.uploaded.Expiry / time.Second),
+		Warnings:      p.warnings(),
+		Notify:        opts.Notify,
+		Note:          opts.Note,
+		AllowedGroups: portalAllowedGroups(opts),
+	}
+}
+
+// requestApproval は、アップロード済みのファイルについて、承認者のチャンネル（APPROVAL_CHANNEL）に
+// 「承認 / 却下」のボタン付きのメッセージを送信し、依頼者のスレッドに承認待ちであることを返信します。
+// request の ID、Status、CreatedAt、TTL はこの関数で設定します。
+func requestApproval(ws *workspace, request *approval.Request) error {
+	// 承認できる人がいない申請は、いつまでも判断されないため受け付けない。
+	if !approversConfigured() {
+		return errApproversNotConfigured
+	}
+	id, err := audit.NewID()
+	if err != nil {
+		return err
+	}
+	request.ID = id
+	request.Status = approval.StatusPending
+	request.CreatedAt = time.Now().Unix()
+	request.TTL = time.Now().Add(approvalTTL).Unix()
+	if err := approvalStore.Put(context.TODO(), request); err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("<@%s> が <#%s> で「%s」のダウンロードURLの発行を申請しています。", request.Requester, request.Channel, request.FileName)
+	if request.Note != "" {
+		text += "\n:memo: " + escapeMrkdwn(request.Note)
+	}
+	if request.Warnings != "" {
+		text += "\n" + request.Warnings
+	}
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewActionBlock(
+			"approval",
+			slack.NewButtonBlockElement(approveActionID, request.ID, slack.NewTextBlockObject(slack.PlainTextType, "承認", false, false)).WithStyle(slack.StylePrimary),
+			slack.NewButtonBlockElement(denyActionID, request.ID, slack.NewTextBlockObject(slack.PlainTextType, "却下", false, false)).WithStyle(slack.StyleDanger),
+		),
+	}
+	if _, _, err := ws.Bot.PostMessage(
+		os.Getenv("APPROVAL_CHANNEL"),
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionBlocks(blocks...),
+	); err != nil {
+		return err
+	}
+
+	_, _, err = ws.Bot.PostMessage(
+		request.Channel,
+		slack.MsgOptionText(fmt.Sprintf("「%s」のURLの発行を承認者に申請しました。承認されるとこのスレッドにURLを送信します。", request.FileName), false),
+		slack.MsgOptionTS(request.ThreadTS),
+	)
+	return err
+}
+
+// handleInteraction は、承認者のチャンネルに送信した「承認 / 却下」ボタンの操作を処理します。
+// body: SlackAPIから受信したフォーム形式のリクエストボディ
+// Slackに再送させないよう、処理の成否にかかわらず200を返します。
+func handleInteraction(body string) (events.APIGatewayProxyResponse, error) {
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		log.Println("インタラクションの解析中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, err
+	}
+	var callback slack.InteractionCallback
+	if err := json.Unmarshal([]byte(values.Get("payload")), &callback); err != nil {
+		log.Println("インタラクションの解析中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, err
+	}
+
+	if callback.Type != slack.InteractionTypeBlockActions || approvalStore == nil {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: ""}, nil
+	}
+	for _, action := range callback.ActionCallback.BlockActions {
+		switch action.ActionID {
+		case approveActionID, denyActionID:
+			if err := decideApproval(&callback, action); err != nil {
+				log.Println("承認の処理中にエラーが発生しました。", err)
+			}
+		}
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: ""}, nil
+}
+
+// decideApproval は、申請を承認または却下します。
+// 承認された場合は署名付きURLと短縮URLを発行して依頼者のスレッドに送信し、依頼者と承認者を監査記録に残します。
+// 却下された場合はアップロード済みのオブジェクトを削除し、依頼者のスレッドに通知します。
+func decideApproval(callback *slack.InteractionCallback, action *slack.BlockAction) error {
+	ctx := context.TODO()
+	approver := callback.User.ID
+
+	request, err := approvalStore.Get(ctx, action.Value)
+	if err != nil {
+		return err
+	}
+	if request == nil {
+		return fmt.Errorf("approval request %s is not found", action.Value)
+	}
+	ws, err := resolveWorkspace(request.TeamID, request.EnterpriseID)
+	if err != nil {
+		return err
+	}
+
+	// 申請のメッセージを別のチャンネルに転送しても判断できないよう、APPROVAL_CHANNEL での操作に限る。
+	if callback.Channel.ID != os.Getenv("APPROVAL_CHANNEL") {
+		_, err := ws.Bot.PostEphemeral(callback.Channel.ID, approver, slack.MsgOptionText("申請は承認用のチャンネルでのみ承認・却下できます。", false))
+		return err
+	}
+
+	// APPROVAL_CHANNEL に参加しているだけでは判断できないよう、承認者として登録されたユーザーに限る。
+	allowed, err := isApprover(ws, approver)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		_, err := ws.Bot.PostEphemeral(callback.Channel.ID, approver, slack.MsgOptionText("この申請を承認・却下できるのは承認者のみです。", false))
+		return err
+	}
+
+	// 二人承認のため、依頼者自身による承認は認めない。
+	if approver == request.Requester {
+		_, err := ws.Bot.PostEphemeral(callback.Channel.ID, approver, slack.MsgOptionText("自分の申請を承認・却下することはできません。", false))
+		return err
+	}
+
+	status := approval.StatusApproved
+	if action.ActionID == denyActionID {
+		status = approval.StatusDenied
+	}
+	request, err = approvalStore.Decide(ctx, request.ID, status, approver)
+	if errors.Is(err, approval.ErrAlreadyDecided) {
+		_, err := ws.Bot.PostEphemeral(callback.Channel.ID, approver, slack.MsgOptionText("この申請は既に判断済みです。", false))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if status == approval.StatusDenied {
+		client, err := s3ClientFor(request.TeamID, request.EnterpriseID, request.Region)
+		if err == nil {
+			err = deleteObject(client, request.Bucket, request.ObjectKey, request.VersionID)
+		}
+		if err != nil {
+			log.Println("却下されたファイルの削除中にエラーが発生しました。", err)
+		}
+		updateApprovalMessage(ws, callback, fmt.Sprintf(":no_entry: <@%s> が「%s」の申請を却下しました。", approver, request.FileName))
+		_, _, err = ws.Bot.PostMessage(
+			request.Channel,
+			slack.MsgOptionText(fmt.Sprintf("「%s」のURLの発行は承認者に却下されました。", request.FileName), false),
+			slack.MsgOptionTS(request.ThreadTS),
+		)
+		return err
+	}
+
+	uploaded := &uploadedObject{
+		Bucket:       request.Bucket,
+		Key:          request.ObjectKey,
+		VersionID:    request.VersionID,
+		Region:       request.Region,
+		TeamID:       request.TeamID,
+		EnterpriseID: request.EnterpriseID,
+		Expiry:       time.Duration(request.Expiry) * time.Second,
+	}
+	if err := presignObject(uploaded); err != nil {
+		return err
+	}
+	shortURLs, auditIDs, err := shortenLinks(shortenerFor(request.TeamID, request.Channel), []string{uploaded.PresignedURL}, nil)
+	if err != nil {
+		return err
+	}
+	shortURL := shortURLs[0]
+
+	message := formatPublishedMessage(shortURL, request.Size, request.Warnings) + portalGroupsMessage(request.AllowedGroups)
+	message += fmt.Sprintf("\n承認者: <@%s>", approver)
+	if err := notifyPublished(context.TODO(), ws, request.Channel, request.ThreadTS, request.Requester, request.Notify, message, request.Note); err != nil {
+		return err
+	}
+	updateApprovalMessage(ws, callback, fmt.Sprintf(":white_check_mark: <@%s> が「%s」の申請を承認しました。", approver, request.FileName))
+
+	recordAudit(&audit.Record{
+		ID:            auditIDs[0],
+		TeamID:        request.TeamID,
+		EnterpriseID:  request.EnterpriseID,
+		Channel:       request.Channel,
+		MessageTS:     request.ThreadTS,
+		User:          request.Requester,
+		Approver:      approver,
+		FileName:      request.FileName,
+		Bucket:        request.Bucket,
+		ObjectKey:     request.ObjectKey,
+		VersionID:     request.VersionID,
+		Region:        request.Region,
+		Size:          request.Size,
+		ShortURL:      shortURL,
+		Note:          request.Note,
+		ExpiresAt:     uploaded.ExpiresAt.Unix(),
+		AllowedGroups: request.AllowedGroups,
+	})
+	return nil
+}
+
+// updateApprovalMessage は、承認者のチャンネルのメッセージからボタンを取り除き、判断の結果に置き換えます。
+func updateApprovalMessage(ws *workspace, callback *slack.InteractionCallback, text string) {
+	if _, _, _, err := ws.Bot.UpdateMessage(
+		callback.Channel.ID,
+		callback.Message.Timestamp,
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionBlocks(),
+	); err != nil {
+		log.Println("承認依頼のメッセージの更新中にエラーが発生しました。", err)
+	}
+}
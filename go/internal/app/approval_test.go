@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kumagai-s/uploader-v2/internal/approval"
+	"github.com/slack-go/slack"
+)
+
+// fakeApprovalStore は、登録済みの申請を返し、判断された申請のIDを記録するストアです。
+type fakeApprovalStore struct {
+	requests map[string]*approval.Request
+	decided  []string
+}
+
+func (s *fakeApprovalStore) Put(ctx context.Context, request *approval.Request) error {
+	s.requests[request.ID] = request
+	return nil
+}
+
+func (s *fakeApprovalStore) Get(ctx context.Context, id string) (*approval.Request, error) {
+	return s.requests[id], nil
+}
+
+func (s *fakeApprovalStore) Decide(ctx context.Context, id, status, approver string) (*approval.Request, error) {
+	s.decided = append(s.decided, id)
+	return nil, errors.New("decided")
+}
+
+// withApproval は、テストの間だけ APPROVAL_CHANNEL の二人承認を有効にし、Slack API を記録するだけのサーバーに向けます。
+// 戻り値は、chat.postEphemeral で送られたメッセージを返します。
+func withApproval(t *testing.T, store approval.Store) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var ephemeral []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/chat.postEphemeral") {
+			r.ParseForm()
+			mu.Lock()
+			ephemeral = append(ephemeral, r.Form.Get("text"))
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("SLACK_API_URL", server.URL+"/")
+	t.Setenv("APPROVAL_CHANNEL", "CAPPROVAL")
+
+	saved := approvalStore
+	approvalStore = store
+	t.Cleanup(func() { approvalStore = saved })
+	withRegistry(t, nil)
+	workspacesMu.Lock()
+	workspaces["/T1"] = &workspace{TeamID: "T1", Bot: newSlackClient("xoxb-t1")}
+	workspacesMu.Unlock()
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ephemeral...)
+	}
+}
+
+func TestIsApproverRequiresConfiguredApprovers(t *testing.T) {
+	t.Setenv("APPROVER_USER_IDS", "")
+	t.Setenv("APPROVER_USERGROUP_IDS", "")
+	ws := &workspace{TeamID: "T1"}
+	if ok, err := isApprover(ws, "U1"); ok || err != nil {
+		t.Errorf("isApprover() without approvers = %v, %v, want false", ok, err)
+	}
+	if err := requestApproval(ws, &approval.Request{}); !errors.Is(err, errApproversNotConfigured) {
+		t.Errorf("requestApproval() error = %v, want errApproversNotConfigured", err)
+	}
+
+	t.Setenv("APPROVER_USER_IDS", "U1")
+	if ok, err := isApprover(ws, "U1"); !ok || err != nil {
+		t.Errorf("isApprover() = %v, %v, want true", ok, err)
+	}
+	if ok, _ := isApprover(ws, "U2"); ok {
+		t.Error("isApprover() for a user who is not an approver = true, want false")
+	}
+}
+
+func TestDecideApprovalRejectsUnauthorizedDecisions(t *testing.T) {
+	store := &fakeApprovalStore{requests: map[string]*approval.Request{
+		"R1": {ID: "R1", TeamID: "T1", Requester: "UREQUESTER", Status: approval.StatusPending},
+	}}
+	ephemeral := withApproval(t, store)
+	action := &slack.BlockAction{ActionID: approveActionID, Value: "R1"}
+	decide := func(channel, user string) {
+		t.Helper()
+		callback := &slack.InteractionCallback{}
+		callback.Channel.ID = channel
+		callback.User.ID = user
+		if err := decideApproval(callback, action); err != nil {
+			t.Fatalf("decideApproval() error = %v", err)
+		}
+	}
+
+	// 承認者が設定されていない場合は、APPROVAL_CHANNEL のメンバーでも判断できない。
+	t.Setenv("APPROVER_USER_IDS", "")
+	decide("CAPPROVAL", "UMEMBER")
+
+	// 申請のメッセージを別のチャンネルに転送しても、承認者でさえ判断できない。
+	t.Setenv("APPROVER_USER_IDS", "UAPPROVER")
+	decide("COTHER", "UAPPROVER")
+
+	if len(store.decided) != 0 {
+		t.Errorf("decided %v, want nothing", store.decided)
+	}
+	got := ephemeral()
+	if len(got) != 2 || !strings.Contains(got[0], "承認者のみ") || !strings.Contains(got[1], "承認用のチャンネル") {
+		t.Errorf("ephemeral messages = %q", got)
+	}
+}
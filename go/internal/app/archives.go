@@ -0,0 +1,83 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/kumagai-s/uploader-v2/internal/archive"
+)
+
+// allowedArchiveFormats は、ARCHIVE_FORMATS（デフォルト「zip」）にカンマ区切りで指定した、受け付けるアーカイブの形式です。
+// 「zip」「tar.gz」（tgz を含む）「7z」「rar」を指定できます。
+func allowedArchiveFormats() []archive.Format {
+	var formats []archive.Format
+	for _, name := range strings.Split(getEnvOrDefault("ARCHIVE_FORMATS", "zip"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		format, err := archive.ParseFormat(name)
+		if err != nil {
+			log.Println("ARCHIVE_FORMATS の設定が不正です。", err)
+			continue
+		}
+		formats = append(formats, format)
+	}
+	return formats
+}
+
+// archiveFormatAllowed は、format のアーカイブを受け付けるかを返します。
+func archiveFormatAllowed(format archive.Format) bool {
+	for _, f := range allowedArchiveFormats() {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveFormatOf は、ファイル名の拡張子からアーカイブの形式を返します。
+func archiveFormatOf(name string) archive.Format {
+	_, format, _ := archive.SplitExt(name)
+	return format
+}
+
+// archiveLimits は、圧縮爆弾とみなす基準です。
+// ARCHIVE_MAX_ENTRIES（デフォルト 100000）、ARCHIVE_MAX_TOTAL_SIZE（展開後の合計バイト数、デフォルト 16GiB）、
+// ARCHIVE_MAX_RATIO（展開後とアーカイブのサイズの比、デフォルト 1000）のいずれかを超えた場合に公開を拒否します。
+func archiveLimits() archive.Limits {
+	ratio, err := strconv.ParseFloat(getEnvOrDefault("ARCHIVE_MAX_RATIO", "1000"), 64)
+	if err != nil {
+		ratio = 1000
+	}
+	return archive.Limits{
+		MaxEntries:   int(getEnvInt64("ARCHIVE_MAX_ENTRIES", 100000)),
+		MaxTotalSize: getEnvInt64("ARCHIVE_MAX_TOTAL_SIZE", 16<<30),
+		MaxRatio:     ratio,
+	}
+}
+
+// inspectArchive は、アーカイブのエントリの一覧を読み取れるかを検証し、圧縮爆弾を検出します。
+func inspectArchive(file *SlackAppMentionEventFile) error {
+	err := archive.Inspect(archiveFormatOf(file.Name), file.Binary, archiveLimits())
+	if err == nil {
+		return nil
+	}
+	var bomb *archive.BombError
+	if errors.As(err, &bomb) {
+		log.Println("圧縮爆弾の可能性があるファイルを検出しました。", file.ID, err)
+		return errors.New("展開後のサイズやファイルの数が大きすぎるため公開できません。")
+	}
+	log.Println("アーカイブの検査中にエラーが発生しました。", file.ID, err)
+	return errors.New("ファイルを開けないため公開できません。壊れているか、ファイル名の一覧が暗号化されている可能性があります。")
+}
+
+// archiveEntries は、DLPやシークレットの検出のために、アップロードされたアーカイブのエントリの一覧を返します。
+// 7z と rar のエントリと、暗号化された zip のエントリは内容を展開できないため、ReadAll が archive.ErrContentUnavailable を返します。
+func archiveEntries(file *SlackAppMentionEventFile) ([]*archive.Entry, error) {
+	return archive.Open(archiveFormatOf(file.Name), file.Binary, archiveLimits().MaxTotalSize)
+}
+
+// errContentNotInspectable は、内容を検査する必要があるのに、展開できない形式や暗号化されたアーカイブが送られた場合のエラーです。
+var errContentNotInspectable = validationError("この形式のファイルや暗号化されたファイルは内容を検査できないため公開できません。暗号化していない zip または tar.gz 形式にしてください。")
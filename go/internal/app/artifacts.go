@@ -0,0 +1,32 @@
+package app
+
+import (
+	"context"
+
+	"github.com/kumagai-s/uploader-v2/internal/slackfiles"
+)
+
+// artifactEnabled は、ボットが生成した kind のファイルを依頼者のスレッドにも投稿するかを返します。
+// SLACK_ARTIFACTS に「manifest,index」のようにカンマ区切りで指定します。
+//   - manifest: 署名付きマニフェスト（manifest.json）
+//   - index: bundle=on で作成したファイルの一覧のページ（index.html）
+func artifactEnabled(kind string) bool {
+	for _, k := range splitEnvList("SLACK_ARTIFACTS") {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// postArtifact は、ボットが生成したファイルを、外部アップロードの API（files.getUploadURLExternal）で依頼者のスレッドに投稿します。
+// ボットのトークンには files:write のスコープが必要です。
+func postArtifact(ctx context.Context, ws *workspace, channel, threadTS string, file *slackfiles.File) error {
+	_, err := slackUploader(ws).Upload(ctx, channel, threadTS, "", file)
+	return err
+}
+
+// slackUploader は、ボットのトークンでSlackにファイルをアップロードする slackfiles.Uploader を返します。
+func slackUploader(ws *workspace) slackfiles.Uploader {
+	return slackfiles.NewUploader(httpClient, getEnvOrDefault("SLACK_API_URL", "https://slack.com/api/"), ws.BotToken)
+}
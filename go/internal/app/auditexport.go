@@ -0,0 +1,107 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/parquet"
+)
+
+// handleAuditExport は、LAMBDA_HANDLER=auditexport で起動したときのハンドラーです。
+// EventBridge のスケジュールから1日1回（UTCの0時過ぎ）呼び出され、前日（UTC）に作成された監査記録を Parquet にして
+// 「AUDIT_EXPORT_BUCKET/AUDIT_EXPORT_PREFIX/dt=YYYY-MM-DD/audit.parquet」に保存します。
+// dt をパーティションとするテーブルを Athena に作成すると、DynamoDB をスキャンせずにリンクの履歴を検索できます。
+func handleAuditExport(ctx context.Context, _ events.CloudWatchEvent) error {
+	if auditStore == nil {
+		return errors.New("AUDIT_TABLE is not configured")
+	}
+	bucket := os.Getenv("AUDIT_EXPORT_BUCKET")
+	if bucket == "" {
+		return errors.New("AUDIT_EXPORT_BUCKET is not configured")
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.Add(-24 * time.Hour)
+	records, err := auditStore.ListActiveBetween(ctx, from, to)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return err
+	}
+	// 期間内に期限切れになった記録も返されるため、期間内に作成した記録だけを出力する。
+	created := make([]*audit.Record, 0, len(records))
+	for _, r := range records {
+		if r.CreatedAt >= from.Unix() && r.CreatedAt < to.Unix() {
+			created = append(created, r)
+		}
+	}
+	sort.Slice(created, func(i, j int) bool { return created[i].CreatedAt < created[j].CreatedAt })
+
+	var buf bytes.Buffer
+	if err := parquet.Write(&buf, auditColumns(created)); err != nil {
+		log.Println("監査記録の Parquet への変換中にエラーが発生しました。", err)
+		return err
+	}
+	key := fmt.Sprintf("%s/dt=%s/audit.parquet", getEnvOrDefault("AUDIT_EXPORT_PREFIX", "audit"), from.Format("2006-01-02"))
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/vnd.apache.parquet"),
+	}); err != nil {
+		log.Println("監査記録の出力中にエラーが発生しました。", err)
+		return err
+	}
+	log.Println("監査記録を出力しました。", key, len(created))
+	return nil
+}
+
+// auditColumns は、監査記録を Parquet の列に変換します。日時はUNIX時間（秒）のままです。
+func auditColumns(records []*audit.Record) []parquet.Column {
+	stringColumn := func(name string, f func(*audit.Record) string) parquet.Column {
+		values := make([]string, len(records))
+		for i, r := range records {
+			values[i] = f(r)
+		}
+		return parquet.Column{Name: name, Strings: values}
+	}
+	int64Column := func(name string, f func(*audit.Record) int64) parquet.Column {
+		values := make([]int64, len(records))
+		for i, r := range records {
+			values[i] = f(r)
+		}
+		return parquet.Column{Name: name, Int64s: values}
+	}
+	return []parquet.Column{
+		stringColumn("id", func(r *audit.Record) string { return r.ID }),
+		stringColumn("team_id", func(r *audit.Record) string { return r.TeamID }),
+		stringColumn("enterprise_id", func(r *audit.Record) string { return r.EnterpriseID }),
+		stringColumn("channel", func(r *audit.Record) string { return r.Channel }),
+		stringColumn("message_ts", func(r *audit.Record) string { return r.MessageTS }),
+		stringColumn("permalink", func(r *audit.Record) string { return r.Permalink }),
+		stringColumn("user", func(r *audit.Record) string { return r.User }),
+		stringColumn("approver", func(r *audit.Record) string { return r.Approver }),
+		stringColumn("file_name", func(r *audit.Record) string { return r.FileName }),
+		stringColumn("bucket", func(r *audit.Record) string { return bucketOrDefault(r.Bucket) }),
+		stringColumn("object_key", func(r *audit.Record) string { return r.ObjectKey }),
+		stringColumn("version_id", func(r *audit.Record) string { return r.VersionID }),
+		stringColumn("region", func(r *audit.Record) string { return r.Region }),
+		int64Column("size", func(r *audit.Record) int64 { return r.Size }),
+		stringColumn("short_url", func(r *audit.Record) string { return r.ShortURL }),
+		int64Column("created_at", func(r *audit.Record) int64 { return r.CreatedAt }),
+		int64Column("expires_at", func(r *audit.Record) int64 { return r.ExpiresAt }),
+		int64Column("download_count", func(r *audit.Record) int64 { return r.DownloadCount }),
+		stringColumn("note", func(r *audit.Record) string { return r.Note }),
+		stringColumn("sha256", func(r *audit.Record) string { return r.SHA256 }),
+		stringColumn("recipient_group", func(r *audit.Record) string { return r.RecipientGroup }),
+	}
+}
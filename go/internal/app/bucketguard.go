@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/slack-go/slack"
+)
+
+// bucketGuardResult は、バケットの公開設定を確認した結果です。
+type bucketGuardResult struct {
+	err       error
+	checkedAt time.Time
+}
+
+var (
+	bucketGuardMu      sync.Mutex
+	bucketGuardResults = map[string]bucketGuardResult{}
+)
+
+// bucketGuardEnabled は、バケットが公開されていないことを確認してから操作するかを返します。BUCKET_GUARD=off で無効にできます。
+func bucketGuardEnabled() bool {
+	return os.Getenv("BUCKET_GUARD") != "off"
+}
+
+// bucketGuardInterval は、確認した結果を使い続ける時間です（BUCKET_GUARD_INTERVAL、デフォルト 1h）。
+func bucketGuardInterval() time.Duration {
+	d, err := parseDuration(getEnvOrDefault("BUCKET_GUARD_INTERVAL", "1h"))
+	if err != nil || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// ensureBucketPrivate は、バケットが公開されていないことを確認し、公開されている場合や確認できない場合はエラーを返します。
+// 共有したファイルはすべてバケットに保存されるため、バケットが公開されていると気付かないうちにすべてのファイルが漏洩します。
+// 確認した結果は BUCKET_GUARD_INTERVAL の間使い続け、その後に確認し直します。
+func ensureBucketPrivate(ctx context.Context, client *s3.Client, bucket string) error {
+	if !bucketGuardEnabled() {
+		return nil
+	}
+	bucketGuardMu.Lock()
+	result, ok := bucketGuardResults[bucket]
+	bucketGuardMu.Unlock()
+	if ok && time.Since(result.checkedAt) < bucketGuardInterval() {
+		return result.err
+	}
+	return recheckBucketPrivate(ctx, client, bucket)
+}
+
+// recheckBucketPrivate は、前回の結果を使わずにバケットの公開設定を確認し、結果を記録します。
+func recheckBucketPrivate(ctx context.Context, client *s3.Client, bucket string) error {
+	err := verifyBucketPrivate(ctx, client, bucket)
+	bucketGuardMu.Lock()
+	bucketGuardResults[bucket] = bucketGuardResult{err: err, checkedAt: time.Now()}
+	bucketGuardMu.Unlock()
+	metrics.SetBucketPrivate(bucket, err == nil)
+	if err != nil {
+		log.Println("バケットが公開されていないことを確認できないため、このバケットは使用しません。", bucket, err)
+	}
+	return err
+}
+
+// verifyBucketPrivate は、バケットのパブリックアクセスブロックがすべて有効で、バケットポリシーが公開を許可していないことを確認します。
+func verifyBucketPrivate(ctx context.Context, client *s3.Client, bucket string) error {
+	block, err := client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchPublicAccessBlockConfiguration") {
+			return fmt.Errorf("bucket %s has no public access block configuration", bucket)
+		}
+		return fmt.Errorf("unable to get public access block of %s, %s", bucket, err)
+	}
+	config := block.PublicAccessBlockConfiguration
+	if config == nil || !config.BlockPublicAcls || !config.IgnorePublicAcls || !config.BlockPublicPolicy || !config.RestrictPublicBuckets {
+		return fmt.Errorf("bucket %s does not block all public access", bucket)
+	}
+
+	status, err := client.GetBucketPolicyStatus(ctx, &s3.GetBucketPolicyStatusInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		// バケットポリシーがない場合は、公開されていない。
+		if strings.Contains(err.Error(), "NoSuchBucketPolicy") {
+			return nil
+		}
+		return fmt.Errorf("unable to get policy status of %s, %s", bucket, err)
+	}
+	if status.PolicyStatus != nil && status.PolicyStatus.IsPublic {
+		return fmt.Errorf("bucket policy of %s is public", bucket)
+	}
+	return nil
+}
+
+// handleBucketGuardCheck は、EventBridge のスケジュールから定期的に呼び出され、すべてのバケットの公開設定を確認し直します。
+// 公開されているバケットが見つかった場合は OPS_CHANNEL に通知します。
+func handleBucketGuardCheck(ctx context.Context, _ events.CloudWatchEvent) error {
+	var exposed []string
+	for _, target := range doctorBuckets() {
+		if err := recheckBucketPrivate(ctx, target.Client, target.Bucket); err != nil {
+			exposed = append(exposed, fmt.Sprintf("・%s（%s）: %s", target.Name, target.Bucket, err))
+		}
+	}
+	if len(exposed) == 0 {
+		return nil
+	}
+	message := "*公開されている、または公開設定を確認できないバケットがあるため、URLの発行を停止しています。*\n" + strings.Join(exposed, "\n")
+	log.Println(message)
+
+	channel := os.Getenv("OPS_CHANNEL")
+	if channel == "" {
+		return nil
+	}
+	if _, _, err := slackClientAsBot.PostMessageContext(ctx, channel, slack.MsgOptionText(message, false)); err != nil {
+		log.Println("バケットの公開設定をSlackに通知中にエラーが発生しました。", err)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/archive"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/bundle"
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/kumagai-s/uploader-v2/internal/slackfiles"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// bundlePrefix は、バンドルのインデックスページを保存するS3キーの接頭辞です。
+func bundlePrefix() string {
+	return getEnvOrDefault("BUNDLE_PREFIX", "bundles")
+}
+
+// createBundle は、bundle=on が指定された場合に、アップロードした複数のファイルのインデックスページを
+// S3に保存し、その署名付きURLを生成します。
+// ページにはファイル名、サイズ、SHA-256 のチェックサムを並べ、各ファイルへのリンクには短縮URLを使います。
+// ページ内のリンクはページと同じ有効期限で署名されています。ページの内容と、各ファイルのリンクが指す監査記録のIDも返します。
+// PORTAL_URL が設定されている場合、ページ内のリンクはポータルを指すため、ページから直接ダウンロードすることはできません。
+func createBundle(ws *workspace, shortener urlshortener.URLShortener, published []*publishedFile, opts *mentionOptions) (*uploadedObject, []byte, []string, error) {
+	shortURLs, auditIDs, err := shortenPublished(shortener, published, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	entries := make([]bundle.Entry, 0, len(published))
+	for i, p := range published {
+		sum := sha256.Sum256(p.file.Binary)
+		entries = append(entries, bundle.Entry{
+			Name:   p.file.Name,
+			Size:   int64(len(p.file.Binary)),
+			SHA256: hex.EncodeToString(sum[:]),
+			URL:    shortURLs[i],
+		})
+	}
+	page, err := bundle.RenderIndex(&bundle.Index{
+		Title:     fmt.Sprintf("%d件のファイル", len(published)),
+		Note:      opts.Note,
+		ExpiresAt: published[0].uploaded.ExpiresAt,
+		Entries:   entries,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	id, err := audit.NewID()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	client, bucket := uploadTarget(ws, opts)
+	if err := ensureBucketPrivate(context.TODO(), client, bucket); err != nil {
+		return nil, nil, nil, err
+	}
+	index := &uploadedObject{
+		Bucket:       bucket,
+		Key:          bundlePrefix() + "/" + id + "/index.html",
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+		Expiry:       opts.Expiry,
+	}
+	if _, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(index.Bucket),
+		Key:         aws.String(index.Key),
+		Body:        bytes.NewReader(page),
+		ContentType: aws.String("text/html; charset=utf-8"),
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := presignObject(index); err != nil {
+		return nil, nil, nil, err
+	}
+	return index, page, auditIDs, nil
+}
+
+// defaultArchiveName は、bundle=zip で name を指定しなかった場合のzipファイルの名前です。
+const defaultArchiveName = "bundle.zip"
+
+// createArchive は、bundle=zip が指定された場合に、メンションのすべてのファイルを1つのzipファイルにまとめて
+// S3にアップロードし、その署名付きURLを生成します。
+// zipファイルはメモリ上に組み立てず、マルチパートアップロードでS3に送信しながら書き出します。
+// 各ファイルは既にzip形式のため、圧縮せずに格納します。同じ名前のファイルには連番を付けます。
+func createArchive(ws *workspace, published []*publishedFile, opts *mentionOptions) (*uploadedObject, error) {
+	name := opts.Name
+	if name == "" {
+		name = defaultArchiveName
+	}
+	id, err := audit.NewID()
+	if err != nil {
+		return nil, err
+	}
+	client, bucket := uploadTarget(ws, opts)
+	if err := ensureBucketPrivate(context.TODO(), client, bucket); err != nil {
+		return nil, err
+	}
+	archive := &uploadedObject{
+		Bucket:       bucket,
+		Key:          bundlePrefix() + "/" + id + "/" + name,
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+		Expiry:       opts.Expiry,
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeArchive(pw, published))
+	}()
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.Concurrency = int(getEnvInt64("MULTIPART_UPLOAD_CONCURRENCY", 4))
+		u.PartSize = getEnvInt64("MULTIPART_UPLOAD_PART_SIZE", memoryBudget.PartSize(u.Concurrency))
+	})
+	input := &s3.PutObjectInput{
+		Bucket:             aws.String(archive.Bucket),
+		Key:                aws.String(archive.Key),
+		Body:               pr,
+		ContentType:        aws.String("application/zip"),
+		ContentDisposition: aws.String(fmt.Sprintf(`attachment; filename="%s"`, name)),
+	}
+	applyRetentionClass(opts.Class, &input.StorageClass, &input.Tagging)
+	out, err := uploader.Upload(context.TODO(), input)
+	// アップロードが途中で失敗した場合でも、書き出し側の goroutine を終了させる。
+	pr.CloseWithError(err)
+	if err != nil {
+		return nil, err
+	}
+	archive.VersionID = aws.ToString(out.VersionID)
+
+	if err := presignObject(archive); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// writeArchive は、ファイルを1つのzipファイルとして w に書き出します。
+func writeArchive(w io.Writer, published []*publishedFile) error {
+	zw := zip.NewWriter(w)
+	used := make(map[string]bool, len(published))
+	for _, p := range published {
+		name := p.file.Name
+		ext := path.Ext(name)
+		for i := 1; used[name]; i++ {
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(p.file.Name, ext), i, ext)
+		}
+		used[name] = true
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Store,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(p.file.Binary); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// bundleAllowed は、bundle=on または bundle=zip を利用できる設定かを返します。
+// 二人承認、publish_at、Step Functions での実行はファイルごとにURLを発行するため、バンドルには対応しません。
+// bundle=zip はマルチパートアップロードで送信するため、パートごとに Content-MD5 が必要な retain とは併用できません。
+func bundleAllowed(opts *mentionOptions) error {
+	if opts.Bundle == bundleModeZip {
+		if opts.Retain > 0 {
+			return fmt.Errorf("bundle=zip と retain は同時に指定できません。")
+		}
+		if opts.Name != "" {
+			if archiveFormatOf(opts.Name) != archive.FormatZip {
+				return fmt.Errorf("bundle=zip の name には「.zip」で終わる名前を指定してください。")
+			}
+			if err := validateFile(nil, &SlackAppMentionEventFile{Name: opts.Name}); err != nil {
+				return err
+			}
+		}
+	}
+	switch {
+	case approvalRequired():
+		return fmt.Errorf("bundle は二人承認が有効な環境では利用できません。")
+	case !opts.PublishAt.IsZero():
+		return fmt.Errorf("bundle と publish_at は同時に指定できません。")
+	case os.Getenv("EXECUTION_MODE") == "stepfunctions":
+		return fmt.Errorf("bundle はこの環境では利用できません。")
+	}
+	return nil
+}
+
+// publishBundle は、複数のファイルをまとめたインデックスページまたはzipファイルの短縮URLを1つだけスレッドに送信します。
+func publishBundle(ws *workspace, ev *slackevents.AppMentionEvent, published []*publishedFile, opts *mentionOptions) (events.APIGatewayProxyResponse, error) {
+	var (
+		index    *uploadedObject
+		page     []byte
+		auditIDs []string
+		err      error
+	)
+	shortener := shortenerFor(ws.TeamID, ev.Channel)
+	stageStart := time.Now()
+	if opts.Bundle == bundleModeZip {
+		index, err = createArchive(ws, published, opts)
+	} else {
+		index, page, auditIDs, err = createBundle(ws, shortener, published, opts)
+	}
+	if err != nil {
+		log.Println("バンドルの作成中にエラーが発生しました。", err)
+		// まだ誰もURLを知らないため、ROLLBACK_ON_FAILURE に関わらず元に戻す。
+		revertPublished(ws, ev, published)
+		return errorResponse(ws, ev, classify(ErrStorage, err))
+	}
+	if opts.Bundle == bundleModeZip {
+		metrics.ObserveStage("upload", stageStart)
+	}
+	// まとめたzipファイルはそれ自体をダウンロードさせるため、ファイルと同じくポータルで保護する。
+	// ファイルの一覧のページには保護したリンクのみを並べているため、ページの署名付きURLをそのまま短縮する。
+	var shortURL, archiveAuditID string
+	if opts.Bundle == bundleModeZip {
+		var shortURLs, ids []string
+		shortURLs, ids, err = shortenLinks(shortener, []string{index.PresignedURL}, nil)
+		if err == nil {
+			shortURL, archiveAuditID = shortURLs[0], ids[0]
+		}
+	} else {
+		shortURL, err = shortener.Shorten(index.PresignedURL)
+	}
+	if err != nil {
+		log.Println("URLの短縮中にエラーが発生しました。", err)
+		rollbackPublished(ws, ev, published, index)
+		return errorResponse(ws, ev, classify(ErrShortener, err))
+	}
+
+	var totalSize int64
+	var warnings []string
+	for _, p := range published {
+		totalSize += int64(len(p.file.Binary))
+		if w := p.warnings(); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	message := fmt.Sprintf("%d件のファイル\n", len(published)) + formatPublishedMessage(shortURL, totalSize, strings.Join(warnings, "\n")) + portalMessage(opts, nil)
+	if err := notifyPublished(context.TODO(), ws, ev.Channel, ev.TimeStamp, ev.User, opts.Notify, message, opts.Note); err != nil {
+		log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+		rollbackPublished(ws, ev, published, index)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	// SLACK_ARTIFACTS に index を指定した場合は、ファイルの一覧のページをスレッドにも投稿する。
+	if page != nil && artifactEnabled("index") {
+		if err := postArtifact(context.TODO(), ws, ev.Channel, ev.TimeStamp, &slackfiles.File{Name: "index.html", Content: page}); err != nil {
+			log.Println("ファイルの一覧のSlackへの投稿中にエラーが発生しました。", err)
+		}
+	}
+
+	// bundle=zip の場合は、まとめたzipファイルを1つの監査記録として保存する。
+	if opts.Bundle == bundleModeZip {
+		recordAudit(&audit.Record{
+			ID:            archiveAuditID,
+			TeamID:        ws.TeamID,
+			EnterpriseID:  ws.EnterpriseID,
+			Channel:       ev.Channel,
+			MessageTS:     ev.TimeStamp,
+			User:          ev.User,
+			FileName:      path.Base(index.Key),
+			Bucket:        index.Bucket,
+			ObjectKey:     index.Key,
+			VersionID:     index.VersionID,
+			Region:        index.Region,
+			Size:          totalSize,
+			ShortURL:      shortURL,
+			ExpiresAt:     index.ExpiresAt.Unix(),
+			Note:          opts.Note,
+			AllowedGroups: portalAllowedGroups(opts),
+		})
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+
+	// 監査記録はファイルごとに保存し、バンドルの短縮URLから各ファイルを辿れるようにする。
+	for i, p := range published {
+		recordAudit(&audit.Record{
+			ID:            auditIDs[i],
+			TeamID:        ws.TeamID,
+			EnterpriseID:  ws.EnterpriseID,
+			Channel:       ev.Channel,
+			MessageTS:     ev.TimeStamp,
+			User:          ev.User,
+			FileName:      p.file.Name,
+			Bucket:        p.uploaded.Bucket,
+			ObjectKey:     p.uploaded.Key,
+			VersionID:     p.uploaded.VersionID,
+			Region:        p.uploaded.Region,
+			Size:          int64(len(p.file.Binary)),
+			ShortURL:      shortURL,
+			ExpiresAt:     index.ExpiresAt.Unix(),
+			Note:          opts.Note,
+			AllowedGroups: portalAllowedGroups(opts),
+		})
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/redact"
+)
+
+// captureRedactor は、デバッグ用に保存するスナップショットからトークンと認証に使うヘッダーを取り除きます。
+// 再現に必要なため、ボディは切り詰めません。
+var captureRedactor = redact.New(redact.Rules{Tokens: true})
+
+// debugCaptureEnabled は、DEBUG_CAPTURE=true で、DEBUG_CAPTURE_UNTIL（RFC 3339）を過ぎていないかを返します。
+// DEBUG_CAPTURE_UNTIL を指定すると、設定を戻し忘れても期限を過ぎたら保存を止めます。
+func debugCaptureEnabled() bool {
+	if os.Getenv("DEBUG_CAPTURE") != "true" {
+		return false
+	}
+	if until := os.Getenv("DEBUG_CAPTURE_UNTIL"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil || time.Now().After(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// debugCapture は、1つのリクエストの各段階のスナップショットを
+// 「DEBUG_CAPTURE_PREFIX/日付/ID/連番-段階.json」としてS3に保存します。
+// オブジェクトには「debug-capture=true」のタグを付けるため、そのタグで数日後に削除するライフサイクルルールを設定してください。
+type debugCapture struct {
+	id  string
+	mu  sync.Mutex
+	seq int
+}
+
+// startDebugCapture は、デバッグキャプチャが有効な場合に新しいキャプチャを開始します。無効な場合は nil を返します。
+// id が空の場合は新しいIDを生成します。Step Functions の実行のように、複数の呼び出しを1つにまとめる場合は同じ id を指定します。
+func startDebugCapture(id string) *debugCapture {
+	if !debugCaptureEnabled() {
+		return nil
+	}
+	if id == "" {
+		var err error
+		if id, err = audit.NewID(); err != nil {
+			log.Println("デバッグキャプチャの開始中にエラーが発生しました。", err)
+			return nil
+		}
+	}
+	return &debugCapture{id: id}
+}
+
+// add は、段階 stage のスナップショット v を保存します。c が nil の場合は何もしません。
+// 保存に失敗しても処理は続けます。
+func (c *debugCapture) add(stage string, v interface{}) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	c.mu.Unlock()
+
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Println("デバッグキャプチャの保存中にエラーが発生しました。", err)
+		return
+	}
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s/%s/%s/%02d-%s.json", getEnvOrDefault("DEBUG_CAPTURE_PREFIX", "debug"), now.Format("2006-01-02"), c.id, seq, stage)
+	if _, err := s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucketOrDefault(os.Getenv("DEBUG_CAPTURE_BUCKET"))),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader([]byte(captureRedactor.String(string(body)))),
+		ContentType: aws.String("application/json"),
+		Tagging:     aws.String("debug-capture=true"),
+	}); err != nil {
+		log.Println("デバッグキャプチャの保存中にエラーが発生しました。", err)
+	}
+}
+
+// requestSnapshot は、API Gateway のリクエストから認証に使うヘッダーを伏せたスナップショットを作ります。
+func requestSnapshot(r events.APIGatewayProxyRequest) interface{} {
+	body, err := decodeRequestBody(r)
+	if err != nil {
+		body = r.Body
+	}
+	return map[string]interface{}{
+		"method":  r.HTTPMethod,
+		"path":    r.Path,
+		"headers": captureRedactor.Headers(r.Headers),
+		"body":    body,
+	}
+}
+
+// responseSnapshot は、レスポンスとエラーのスナップショットを作ります。
+func responseSnapshot(res events.APIGatewayProxyResponse, err error) interface{} {
+	snapshot := map[string]interface{}{
+		"status":  res.StatusCode,
+		"headers": captureRedactor.Headers(res.Headers),
+		"body":    res.Body,
+	}
+	if err != nil {
+		snapshot["error"] = err.Error()
+	}
+	return snapshot
+}
@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/slackutil"
+	"github.com/slack-go/slack"
+)
+
+const (
+	cleanupModeAnnotate = "annotate" // 有効期限が切れたことを追記する
+	cleanupModeRedact   = "redact"   // 短縮URLを消す
+	cleanupModeDelete   = "delete"   // メッセージを削除する
+)
+
+// expiredNotice は、cleanupModeAnnotate でメッセージに追記する文言です。
+const expiredNotice = ":warning: このリンクは有効期限が切れました。"
+
+// handleMessageCleanup は、LAMBDA_HANDLER=cleanup で起動したときのハンドラーで、EventBridge のスケジュールから定期的に呼び出されます。
+// 過去 CLEANUP_LOOKBACK（デフォルト 24h）に有効期限が切れたリンクについて、URLを知らせたボットのメッセージを
+// CLEANUP_MESSAGE_MODE に従って書き換え、期限切れのURLがSlackの中で使われ続けないようにします。
+//   - annotate（デフォルト）: 有効期限が切れたことを追記する
+//   - redact: 短縮URLを消す
+//   - delete: メッセージを削除する。ステータスメッセージでは、同じメッセージの他のファイルの結果も削除される
+//
+// 実行のたびに同じ期間を見直しても、書き換え済みのメッセージは再び書き換えません。
+// リーガルホールド中のリンクは、証拠として残すため annotate 以外では書き換えません。
+func handleMessageCleanup(ctx context.Context, _ events.CloudWatchEvent) error {
+	if auditStore == nil {
+		return errors.New("AUDIT_TABLE is not configured")
+	}
+	mode := getEnvOrDefault("CLEANUP_MESSAGE_MODE", cleanupModeAnnotate)
+	switch mode {
+	case cleanupModeAnnotate, cleanupModeRedact, cleanupModeDelete:
+	default:
+		return fmt.Errorf("invalid CLEANUP_MESSAGE_MODE %q", mode)
+	}
+	lookback, err := parseDuration(getEnvOrDefault("CLEANUP_LOOKBACK", "24h"))
+	if err != nil || lookback <= 0 {
+		lookback = 24 * time.Hour
+	}
+
+	to := time.Now()
+	from := to.Add(-lookback)
+	records, err := auditStore.ListActiveBetween(ctx, from, to)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return err
+	}
+
+	botIDs := map[string]string{}
+	for _, r := range records {
+		if r.ExpiresAt < from.Unix() || r.ExpiresAt >= to.Unix() || r.MessageTS == "" || r.ShortURL == "" {
+			continue
+		}
+		if r.LegalHold && mode != cleanupModeAnnotate {
+			continue
+		}
+		ws, err := resolveWorkspace(r.TeamID, r.EnterpriseID)
+		if err != nil {
+			log.Println("ワークスペースの確認中にエラーが発生しました。", r.TeamID, err)
+			continue
+		}
+		botID, ok := botIDs[r.TeamID]
+		if !ok {
+			auth, err := ws.Bot.AuthTestContext(ctx)
+			if err != nil {
+				log.Println("ボットの情報の取得中にエラーが発生しました。", r.TeamID, err)
+				continue
+			}
+			botID = auth.BotID
+			botIDs[r.TeamID] = botID
+		}
+		// 1件の書き換えに失敗しても、他のリンクの書き換えは続ける。
+		if err := cleanupLinkMessages(ctx, ws, botID, r, mode); err != nil {
+			log.Println("期限切れのリンクのメッセージの書き換え中にエラーが発生しました。", r.ID, err)
+		}
+	}
+	return nil
+}
+
+// cleanupLinkMessages は、依頼したメッセージのスレッドから、記録の短縮URLを含むボットのメッセージを探して書き換えます。
+func cleanupLinkMessages(ctx context.Context, ws *workspace, botID string, r *audit.Record, mode string) error {
+	return slackutil.EachReply(ctx, ws.Bot, r.Channel, r.MessageTS, func(msg slack.Message) error {
+		if msg.BotID != botID || !strings.Contains(msg.Text, r.ShortURL) {
+			return nil
+		}
+		return cleanupMessage(ctx, ws, r, msg, mode)
+	})
+}
+
+// cleanupMessage は、メッセージを mode に従って書き換えるか削除します。
+// note の説明を添えたメッセージはブロックで表示されるため、本文と同じようにブロックの文章も書き換えます。
+func cleanupMessage(ctx context.Context, ws *workspace, r *audit.Record, msg slack.Message, mode string) error {
+	var rewrite func(string) string
+	switch mode {
+	case cleanupModeDelete:
+		return slackutil.RetryRateLimited(ctx, func() error {
+			_, _, err := ws.Bot.DeleteMessageContext(ctx, r.Channel, msg.Timestamp)
+			return err
+		})
+	case cleanupModeRedact:
+		rewrite = func(text string) string {
+			return strings.ReplaceAll(text, r.ShortURL, "（有効期限切れ）")
+		}
+	default:
+		if strings.Contains(msg.Text, expiredNotice) {
+			return nil
+		}
+		rewrite = func(text string) string {
+			return text + "\n" + expiredNotice
+		}
+	}
+
+	options := []slack.MsgOption{slack.MsgOptionText(rewrite(msg.Text), false)}
+	if len(msg.Blocks.BlockSet) > 0 {
+		for _, block := range msg.Blocks.BlockSet {
+			if section, ok := block.(*slack.SectionBlock); ok && section.Text != nil {
+				section.Text.Text = rewrite(section.Text.Text)
+			}
+		}
+		options = append(options, slack.MsgOptionBlocks(msg.Blocks.BlockSet...))
+	}
+	return slackutil.RetryRateLimited(ctx, func() error {
+		_, _, _, err := ws.Bot.UpdateMessageContext(ctx, r.Channel, msg.Timestamp, options...)
+		return err
+	})
+}
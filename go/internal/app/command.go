@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/slack-go/slack"
+)
+
+// searchResultLimit は、/geturl-search で返す記録の最大件数です。
+const searchResultLimit = 20
+
+// parseSlashCommand は、リクエストボディがスラッシュコマンドの場合にフォームの値を返します。
+// スラッシュコマンドは、フォーム形式で command と text が送信されます。
+func parseSlashCommand(body string) (url.Values, bool) {
+	if strings.HasPrefix(body, "{") {
+		return nil, false
+	}
+	values, err := url.ParseQuery(body)
+	if err != nil || values.Get("command") == "" {
+		return nil, false
+	}
+	return values, true
+}
+
+// handleSlashCommand は、スラッシュコマンドを処理し、実行したユーザーにだけ表示される応答を返します。
+func handleSlashCommand(values url.Values) (events.APIGatewayProxyResponse, error) {
+	var text string
+	switch values.Get("command") {
+	case "/geturl-search":
+		text = searchPublishedLinks(values.Get("team_id"), values.Get("text"))
+	case "/geturl-admin":
+		text = handleAdminCommand(values.Get("team_id"), values.Get("user_id"), values.Get("text"))
+	case "/geturl-restore":
+		text = handleRestoreCommand(values.Get("team_id"), values.Get("user_id"), values.Get("text"))
+	case "/geturl-inbox":
+		text = handleInboxCommand(values.Get("team_id"), values.Get("enterprise_id"), values.Get("channel_id"), values.Get("user_id"), values.Get("text"))
+	case "/geturl-doctor":
+		text = handleDoctorCommand(&doctorRequest{
+			TeamID:       values.Get("team_id"),
+			EnterpriseID: values.Get("enterprise_id"),
+			Channel:      values.Get("channel_id"),
+			ResponseURL:  values.Get("response_url"),
+		}, values.Get("user_id"))
+	default:
+		text = fmt.Sprintf("%s には対応していません。", values.Get("command"))
+	}
+	return slashCommandResponse(text)
+}
+
+// slashCommandResponse は、実行したユーザーにだけ表示されるスラッシュコマンドの応答を生成します。
+func slashCommandResponse(text string) (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(&slack.Msg{ResponseType: slack.ResponseTypeEphemeral, Text: text})
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}, nil
+}
+
+// parseSearchQuery は、/geturl-search の引数を検索条件にします。
+// メンション（<@U...>）は依頼者、チャンネル（<#C...>）は送信したチャンネル、それ以外はファイル名と説明のキーワードとして扱います。
+func parseSearchQuery(text string) *audit.Query {
+	query := &audit.Query{}
+	var keywords []string
+	for _, field := range splitOptionFields(text) {
+		kind, id, ok := parseSlackReference(field)
+		switch {
+		case ok && kind == "user":
+			query.User = id
+		case ok && kind == "channel":
+			query.Channel = id
+		default:
+			keywords = append(keywords, field)
+		}
+	}
+	query.Keyword = strings.Join(keywords, " ")
+	return query
+}
+
+// searchPublishedLinks は、監査記録から有効期限内のリンクを検索し、応答のテキストを返します。
+// 同じファイルを何度もアップロードしなくて済むよう、既に共有されたリンクを探せるようにします。
+func searchPublishedLinks(teamID, text string) string {
+	if auditStore == nil {
+		return "AUDIT_TABLE が設定されていないため、検索できません。"
+	}
+	query := parseSearchQuery(text)
+	if *query == (audit.Query{}) {
+		return "検索する文字列を指定してください。例: /geturl-search release <@U012345> <#C012345>"
+	}
+
+	records, err := auditStore.Search(context.TODO(), teamID, query, time.Now())
+	if err != nil {
+		log.Println("監査記録の検索中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	if len(records) == 0 {
+		return "有効なリンクは見つかりませんでした。"
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt > records[j].CreatedAt })
+	lines := []string{fmt.Sprintf("%d件の有効なリンクが見つかりました。", len(records))}
+	if len(records) > searchResultLimit {
+		lines[0] += fmt.Sprintf("新しい順に%d件を表示します。", searchResultLimit)
+		records = records[:searchResultLimit]
+	}
+	for _, r := range records {
+		line := fmt.Sprintf("• %s %s（<@%s> が <#%s> で共有、有効期限: %s）",
+			escapeMrkdwn(r.FileName), r.ShortURL, r.User, r.Channel,
+			time.Unix(r.ExpiresAt, 0).Format("2006-01-02 15:04"))
+		if r.Permalink != "" {
+			line += fmt.Sprintf(" <%s|元のメッセージ>", r.Permalink)
+		}
+		if r.Note != "" {
+			line += "\n    :memo: " + escapeMrkdwn(r.Note)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
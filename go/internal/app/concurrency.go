@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulertypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// queuedMessage は、大きなファイルの処理が混み合っていて、順番待ちにしたことを依頼者に知らせるメッセージです。
+const queuedMessage = "大きなファイルの処理が混み合っているため、順番を待っています。処理が完了したらこのスレッドにURLを送信します。"
+
+// acquireLargeFileSlot は、ワーカーで処理する大きなファイル（INLINE_SIZE_THRESHOLD を超えるもの）のメンションについて、
+// 組織全体で同時に処理する LARGE_FILE_CONCURRENCY 件の枠を確保します。
+// 空きがない場合は、LARGE_FILE_QUEUE_DELAY（デフォルト 1m）後にもう一度ワーカーを呼び出すよう予約し、queued に true を返します。
+// 枠の確認や予約に失敗した場合は、メンションを取りこぼさないよう、制限せずに処理します。
+func acquireLargeFileSlot(ev workerEvent, eventsAPIEvent slackevents.EventsAPIEvent) (release func(), queued bool) {
+	release = func() {}
+	if largeFileSemaphore == nil {
+		return release, false
+	}
+	var req SlackAppMentionEventRequest
+	if err := json.Unmarshal([]byte(ev.Body), &req); err != nil || len(req.Event.Files) == 0 || processInline(req.Event.Files) {
+		return release, false
+	}
+
+	holder, err := randomToken()
+	if err != nil {
+		log.Println("同時に処理するファイルの枠の確保中にエラーが発生しました。", err)
+		return release, false
+	}
+	acquired, err := largeFileSemaphore.TryAcquire(context.TODO(), holder)
+	if err != nil {
+		log.Println("同時に処理するファイルの枠の確保中にエラーが発生しました。", err)
+		return release, false
+	}
+	if acquired {
+		return func() {
+			if err := largeFileSemaphore.Release(context.TODO(), holder); err != nil {
+				log.Println("同時に処理するファイルの枠の解放中にエラーが発生しました。", err)
+			}
+		}, false
+	}
+
+	delay, err := parseDuration(getEnvOrDefault("LARGE_FILE_QUEUE_DELAY", "1m"))
+	if err != nil || delay <= 0 {
+		delay = time.Minute
+	}
+	first := ev.QueueSchedule == ""
+	if err := queueWorkerEvent(ev, delay); err != nil {
+		log.Println("ワーカーの呼び出しの予約中にエラーが発生しました。", err)
+		return release, false
+	}
+	log.Println("同時に処理するファイルの枠に空きがないため、順番待ちにしました。")
+
+	// 順番待ちにしたことは、最初の1回だけ知らせる。
+	if mention, ok := eventsAPIEvent.InnerEvent.Data.(*slackevents.AppMentionEvent); ok && first {
+		ws, err := resolveWorkspace(eventsAPIEvent.TeamID, eventsAPIEvent.EnterpriseID)
+		if err == nil {
+			_, _, err = ws.Bot.PostMessage(mention.Channel, slack.MsgOptionText(queuedMessage, false), slack.MsgOptionTS(mention.TimeStamp))
+		}
+		if err != nil {
+			log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+		}
+	}
+	return release, true
+}
+
+// queueWorkerEvent は、EventBridge Scheduler に1回限りのスケジュールを作成し、delay 後に ev でワーカーを呼び出すよう予約します。
+func queueWorkerEvent(ev workerEvent, delay time.Duration) error {
+	id, err := audit.NewID()
+	if err != nil {
+		return err
+	}
+	ev.QueueSchedule = "queue-" + id
+
+	input, err := json.Marshal(&ev)
+	if err != nil {
+		return err
+	}
+	return createOneTimeSchedule(context.TODO(), ev.QueueSchedule, time.Now().Add(delay), input)
+}
+
+// createOneTimeSchedule は、EventBridge Scheduler に at に input で呼び出す1回限りのスケジュール name を作成します。
+// スケジュールは SCHEDULER_TARGET_ARN の Lambda を SCHEDULER_ROLE_ARN のロールで呼び出します。
+func createOneTimeSchedule(ctx context.Context, name string, at time.Time, input []byte) error {
+	_, err := schedulerClient.CreateSchedule(ctx, &scheduler.CreateScheduleInput{
+		Name:                       aws.String(name),
+		GroupName:                  aws.String(getEnvOrDefault("SCHEDULE_GROUP", "default")),
+		ScheduleExpression:         aws.String("at(" + at.UTC().Format("2006-01-02T15:04:05") + ")"),
+		ScheduleExpressionTimezone: aws.String("UTC"),
+		FlexibleTimeWindow:         &schedulertypes.FlexibleTimeWindow{Mode: schedulertypes.FlexibleTimeWindowModeOff},
+		Target: &schedulertypes.Target{
+			Arn:     aws.String(os.Getenv("SCHEDULER_TARGET_ARN")),
+			RoleArn: aws.String(os.Getenv("SCHEDULER_ROLE_ARN")),
+			Input:   aws.String(string(input)),
+		},
+	})
+	return err
+}
+
+// deleteSchedule は、実行済みの1回限りのスケジュールを削除します。実行済みのスケジュールは自動で削除されないため、呼び出された側で削除します。
+func deleteSchedule(ctx context.Context, name string) {
+	if _, err := schedulerClient.DeleteSchedule(ctx, &scheduler.DeleteScheduleInput{
+		Name:      aws.String(name),
+		GroupName: aws.String(getEnvOrDefault("SCHEDULE_GROUP", "default")),
+	}); err != nil {
+		log.Println("スケジュールの削除中にエラーが発生しました。", err)
+	}
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// contentSHA256 は、ファイルの内容の SHA-256 を16進数で返します。
+func contentSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// dedupEnabled は、同じ内容のファイルの既存のリンクを再利用するかを返します。
+// DEDUP_MODE=on の場合に有効になります。
+// 二人承認、publish_at、bundle や、保存先・保持期間・区分・ファイル名を指定した場合は、
+// 既存のリンクでは依頼の内容を満たせないため、通常どおりアップロードします。
+func dedupEnabled(opts *mentionOptions) bool {
+	if os.Getenv("DEDUP_MODE") != "on" || auditStore == nil || approvalRequired() {
+		return false
+	}
+	return opts.PublishAt.IsZero() && opts.Bundle == "" && opts.Retain == 0 && opts.Bucket == "" && opts.Name == "" && opts.Class == nil && opts.For == "" && !opts.Password && len(opts.Groups) == 0
+}
+
+// replyWithDuplicate は、同じ内容のファイルの既存のリンクを、以前の共有者と日時を添えてスレッドに送信します。
+func replyWithDuplicate(ws *workspace, ev *slackevents.AppMentionEvent, p *publishedFile, duplicate *audit.Record, opts *mentionOptions) error {
+	message := formatPublishedMessage(duplicate.ShortURL, int64(len(p.file.Binary)), p.warnings()) + duplicateMessage(duplicate)
+	return notifyPublished(context.TODO(), ws, ev.Channel, ev.TimeStamp, ev.User, opts.Notify, message, opts.Note)
+}
+
+// duplicateMessage は、既存のリンクを返すことを知らせる行を返します。
+func duplicateMessage(duplicate *audit.Record) string {
+	return fmt.Sprintf("\n:recycle: 同じ内容のファイルを以前 <@%s> が %s に共有したため、そのリンクを返します。",
+		duplicate.User, time.Unix(duplicate.CreatedAt, 0).Format("2006-01-02 15:04"))
+}
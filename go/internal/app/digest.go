@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/cost"
+	"github.com/slack-go/slack"
+)
+
+// channelDigest は、1チャンネル分の日次集計です。
+type channelDigest struct {
+	TeamID       string
+	EnterpriseID string
+	Created      int
+	Expired      int
+	Downloads    int64
+	TotalBytes   int64
+}
+
+// summarizeAuditRecords は、監査記録を期間 [from, to) についてチャンネルごとに集計します。
+func summarizeAuditRecords(records []*audit.Record, from, to time.Time) map[string]*channelDigest {
+	digests := map[string]*channelDigest{}
+	for _, r := range records {
+		d, ok := digests[r.Channel]
+		if !ok {
+			d = &channelDigest{TeamID: r.TeamID, EnterpriseID: r.EnterpriseID}
+			digests[r.Channel] = d
+		}
+		if r.CreatedAt >= from.Unix() && r.CreatedAt < to.Unix() {
+			d.Created++
+			d.TotalBytes += r.Size
+			d.Downloads += r.DownloadCount
+		}
+		if r.ExpiresAt >= from.Unix() && r.ExpiresAt < to.Unix() {
+			d.Expired++
+		}
+	}
+	return digests
+}
+
+// handleDailyDigest は、EventBridge のスケジュールから1日1回呼び出され、
+// 過去24時間に作成・期限切れになったリンクの集計を各チャンネルに投稿します。
+func handleDailyDigest(ctx context.Context, _ events.CloudWatchEvent) error {
+	if auditStore == nil {
+		return errors.New("AUDIT_TABLE is not configured")
+	}
+
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	records, err := auditStore.ListActiveBetween(ctx, from, to)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return err
+	}
+
+	digests := summarizeAuditRecords(records, from, to)
+	channels := make([]string, 0, len(digests))
+	for channel := range digests {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	for _, channel := range channels {
+		d := digests[channel]
+		message := fmt.Sprintf(
+			"*過去24時間のダウンロードURLの集計*\n・作成: %d件（合計 %s）\n・期限切れ: %d件\n・ダウンロード: %d回",
+			d.Created, cost.HumanSize(d.TotalBytes), d.Expired, d.Downloads,
+		)
+		ws, err := resolveWorkspace(d.TeamID, d.EnterpriseID)
+		if err == nil {
+			_, _, err = ws.Bot.PostMessageContext(ctx, channel, slack.MsgOptionText(message, false))
+		}
+		if err != nil {
+			// 1つのチャンネルへの投稿に失敗しても、他のチャンネルへの投稿は続ける。
+			log.Println("日次集計をSlackに送信中にエラーが発生しました。", channel, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	lambdaservice "github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/slack-go/slack"
+)
+
+// doctorProbePrefix は、/geturl-doctor が書き込みを確認するために作成するS3キーの接頭辞です。確認後にすぐ削除します。
+const doctorProbePrefix = "geturl-doctor/"
+
+// doctorRequest は、/geturl-doctor の確認を、ワーカーに渡すときのペイロードです。
+type doctorRequest struct {
+	TeamID       string `json:"team_id"`
+	EnterpriseID string `json:"enterprise_id,omitempty"`
+	Channel      string `json:"channel"`
+	ResponseURL  string `json:"response_url"`
+}
+
+// doctorEvent は、/geturl-doctor の確認をするためにワーカーとして呼び出されたときのペイロードです。
+type doctorEvent struct {
+	Doctor *doctorRequest `json:"doctor_request"`
+}
+
+// doctorCheck は、/geturl-doctor の確認項目ひとつの結果です。
+type doctorCheck struct {
+	Name    string
+	OK      bool
+	Warning bool // 必須ではない機能だけに影響する場合
+	Detail  string
+}
+
+// scopeRequirement は、トークンに必要なスコープと、そのスコープを使う機能です。
+type scopeRequirement struct {
+	Scope    string
+	Required bool
+	Feature  string
+}
+
+// botScopeRequirements は、ボットのトークンに必要なスコープです。
+var botScopeRequirements = []scopeRequirement{
+	{Scope: "app_mentions:read", Required: true, Feature: "メンションの受信"},
+	{Scope: "chat:write", Required: true, Feature: "URLの投稿"},
+	{Scope: "files:read", Required: true, Feature: "ファイルのダウンロード"},
+	{Scope: "files:write", Required: true, Feature: "/geturl-restore"},
+	{Scope: "users:read", Required: true, Feature: "表示名の取得"},
+	{Scope: "channels:read", Required: true, Feature: "チャンネルの確認"},
+	{Scope: "commands", Required: true, Feature: "スラッシュコマンド"},
+	{Scope: "channels:history", Feature: "スレッドの整理"},
+	{Scope: "usergroups:read", Feature: "for="},
+	{Scope: "users:read.email", Feature: "for= と確認コード"},
+	{Scope: "team:read", Feature: "PDF_WATERMARK"},
+	{Scope: "links:read", Feature: "リンクの展開"},
+	{Scope: "links:write", Feature: "リンクの展開"},
+	{Scope: "bookmarks:read", Feature: "LATEST_BUILD=bookmark"},
+	{Scope: "bookmarks:write", Feature: "LATEST_BUILD=bookmark"},
+	{Scope: "canvases:read", Feature: "LATEST_BUILD=canvas"},
+	{Scope: "canvases:write", Feature: "LATEST_BUILD=canvas"},
+}
+
+// userScopeRequirements は、ユーザーのトークンに必要なスコープです。
+var userScopeRequirements = []scopeRequirement{
+	{Scope: "files:write", Required: true, Feature: "公開後のファイルの削除"},
+}
+
+// handleDoctorCommand は、/geturl-doctor を処理します。
+// ボットとユーザーのトークンのスコープ、チャンネルへの参加、S3の権限、KMSの鍵、短縮URLサービスへの接続を確認し、チェックリストで返します。
+// 導入時や権限を変更したときに、実際にURLを発行する前に設定の誤りを見つけるためのものです。ADMIN_USER_IDS の管理者のみ実行できます。
+// スラッシュコマンドは3秒以内に応答する必要があるため、ASYNC_WORKER_FUNCTION が設定されている場合はワーカーで確認し、response_url に結果を送ります。
+func handleDoctorCommand(req *doctorRequest, user string) string {
+	if !isAdminUser(user) {
+		return "/geturl-doctor を実行できるのは管理者のみです。"
+	}
+	if asyncWorkerFunction() == "" {
+		return renderDoctorChecks(runDoctorChecks(context.TODO(), req))
+	}
+	payload, err := json.Marshal(&doctorEvent{Doctor: req})
+	if err != nil {
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	if _, err := lambdaClient.Invoke(context.TODO(), &lambdaservice.InvokeInput{
+		FunctionName:   aws.String(asyncWorkerFunction()),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	}); err != nil {
+		log.Println("ワーカーの呼び出し中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	return "設定を確認しています。完了すると結果が表示されます。"
+}
+
+// handleDoctorEvent は、ワーカーとして呼び出されたときに設定を確認し、結果を response_url に送ります。
+func handleDoctorEvent(ctx context.Context, req *doctorRequest) error {
+	message := &slack.WebhookMessage{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         renderDoctorChecks(runDoctorChecks(ctx, req)),
+	}
+	if err := slack.PostWebhookCustomHTTPContext(ctx, req.ResponseURL, httpClient, message); err != nil {
+		log.Println("確認の結果をSlackに送信中にエラーが発生しました。", err)
+	}
+	// 確認をやり直すと、S3への書き込みなどを繰り返すため再実行しない。
+	return nil
+}
+
+// runDoctorChecks は、すべての確認項目を実行します。
+func runDoctorChecks(ctx context.Context, req *doctorRequest) []doctorCheck {
+	ws, err := resolveWorkspace(req.TeamID, req.EnterpriseID)
+	if err != nil {
+		log.Println("ワークスペースの確認中にエラーが発生しました。", err)
+		return []doctorCheck{{Name: "ワークスペースのトークン", Detail: "このワークスペースのトークンを取得できませんでした。登録されているか確認してください。"}}
+	}
+	tokens := doctorTokens(req.TeamID, req.EnterpriseID)
+
+	var checks []doctorCheck
+	checks = append(checks, checkTokenScopes(ctx, "ボットのトークン", tokens.Bot, botScopeRequirements)...)
+	checks = append(checks, checkTokenScopes(ctx, "ユーザーのトークン", tokens.User, userScopeRequirements)...)
+	checks = append(checks, checkExternalFileScope(ctx, tokens.Bot))
+	if approvalRequired() {
+		checks = append(checks, checkApprovers())
+	}
+	checks = append(checks, checkChannelMembership(ctx, ws, req.Channel))
+	for _, target := range doctorBuckets() {
+		checks = append(checks, checkBucketWrite(ctx, target.Name, target.Bucket, target.Client))
+	}
+	if ws.Storage != nil {
+		checks = append(checks, checkBucketWrite(ctx, "チームのロール", bucketOrDefault(ws.Storage.Bucket), ws.Storage.Client))
+	}
+	checks = append(checks, checkKMSKeys(ctx)...)
+	checks = append(checks, checkShortener(ctx))
+	return checks
+}
+
+// renderDoctorChecks は、確認の結果をチェックリストにします。
+func renderDoctorChecks(checks []doctorCheck) string {
+	var b strings.Builder
+	b.WriteString("*設定の確認結果*\n")
+	failed := 0
+	for _, check := range checks {
+		icon := ":white_check_mark:"
+		switch {
+		case !check.OK && check.Warning:
+			icon = ":warning:"
+		case !check.OK:
+			icon = ":x:"
+			failed++
+		}
+		fmt.Fprintf(&b, "%s %s", icon, check.Name)
+		if check.Detail != "" {
+			fmt.Fprintf(&b, ": %s", check.Detail)
+		}
+		b.WriteString("\n")
+	}
+	if failed > 0 {
+		fmt.Fprintf(&b, "%d件の問題が見つかりました。", failed)
+	} else {
+		b.WriteString("問題は見つかりませんでした。")
+	}
+	return b.String()
+}
+
+// doctorTokens は、チームで使うトークンを返します。個別のトークンが設定されていない場合は、デフォルトのトークンです。
+func doctorTokens(teamID, enterpriseID string) workspaceTokens {
+	if tokens, ok, err := lookupWorkspaceTokens(teamID, enterpriseID); err == nil && ok {
+		return tokens
+	}
+	return workspaceTokens{
+		Bot:  os.Getenv("SLACK_BOT_OAUTH_TOKEN"),
+		User: os.Getenv("SLACK_USER_OAUTH_TOKEN"),
+	}
+}
+
+// checkTokenScopes は、トークンに必要なスコープが付与されているかを確認します。
+// 必須ではないスコープが足りない場合は、その機能だけが使えないため警告にします。
+func checkTokenScopes(ctx context.Context, name, token string, requirements []scopeRequirement) []doctorCheck {
+	if token == "" {
+		return []doctorCheck{{Name: name, Detail: "設定されていません。"}}
+	}
+	scopes, err := fetchTokenScopes(ctx, token)
+	if err != nil {
+		return []doctorCheck{{Name: name, Detail: fmt.Sprintf("スコープを取得できませんでした（%s）。", err)}}
+	}
+	granted := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		granted[scope] = true
+	}
+	var missingRequired, missingOptional []string
+	for _, r := range requirements {
+		if granted[r.Scope] {
+			continue
+		}
+		entry := fmt.Sprintf("%s（%s）", r.Scope, r.Feature)
+		if r.Required {
+			missingRequired = append(missingRequired, entry)
+		} else {
+			missingOptional = append(missingOptional, entry)
+		}
+	}
+	checks := []doctorCheck{{Name: name + "のスコープ", OK: len(missingRequired) == 0}}
+	if len(missingRequired) > 0 {
+		checks[0].Detail = "不足: " + strings.Join(missingRequired, ", ")
+	}
+	if len(missingOptional) > 0 {
+		checks = append(checks, doctorCheck{
+			Name:    name + "の任意のスコープ",
+			Warning: true,
+			Detail:  "不足: " + strings.Join(missingOptional, ", "),
+		})
+	}
+	return checks
+}
+
+// checkExternalFileScope は、EXTERNAL_FILE_POLICY で外部の組織のユーザーがアップロードしたファイルを確認できるかを確認します。
+// refuse（デフォルト）と approval では、ボットのトークンに users:read がないと users.info でアップロードしたユーザーの組織を確認できず、
+// ファイルを処理できなくなるため警告します。allow の場合は、外部の組織のファイルも確認せずに処理することを警告します。
+func checkExternalFileScope(ctx context.Context, token string) doctorCheck {
+	policy := externalFilePolicy()
+	check := doctorCheck{Name: fmt.Sprintf("EXTERNAL_FILE_POLICY（%s）", policy), Warning: true}
+	if policy == "allow" {
+		check.Detail = "外部の組織のユーザーがアップロードしたファイルも処理します。"
+		return check
+	}
+	scopes, err := fetchTokenScopes(ctx, token)
+	if err != nil {
+		check.Detail = fmt.Sprintf("ボットのトークンのスコープを取得できませんでした（%s）。", err)
+		return check
+	}
+	for _, scope := range scopes {
+		if scope == "users:read" {
+			check.OK = true
+			return check
+		}
+	}
+	check.Detail = "ボットのトークンに users:read がないため、アップロードしたユーザーの組織を確認できず、ファイルを処理できない場合があります。"
+	return check
+}
+
+// checkApprovers は、二人承認が有効な場合に承認者が設定されているかを確認します。
+// 設定されていない場合は、申請を誰も判断できないためURLを発行できません。
+func checkApprovers() doctorCheck {
+	check := doctorCheck{Name: "二人承認の承認者", OK: approversConfigured()}
+	if !check.OK {
+		check.Detail = "APPROVER_USER_IDS または APPROVER_USERGROUP_IDS を設定してください。"
+	}
+	return check
+}
+
+// fetchTokenScopes は、auth.test を呼び出し、X-OAuth-Scopes ヘッダーからトークンのスコープを返します。
+// slack-go はレスポンスヘッダーを返さないため、直接呼び出します。
+func fetchTokenScopes(ctx context.Context, token string) ([]string, error) {
+	apiURL := getEnvOrDefault("SLACK_API_URL", slack.APIURL)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"auth.test", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var body slack.SlackResponse
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to decode auth.test response, %s", err)
+	}
+	if !body.Ok {
+		return nil, fmt.Errorf("%s", body.Error)
+	}
+	var scopes []string
+	for _, scope := range strings.Split(response.Header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// checkChannelMembership は、コマンドを実行したチャンネルにボットが参加しているかを確認します。
+// 参加していないチャンネルでは、メンションを受け取ってもファイルを取得できません。
+func checkChannelMembership(ctx context.Context, ws *workspace, channelID string) doctorCheck {
+	check := doctorCheck{Name: fmt.Sprintf("<#%s> への参加", channelID)}
+	channel, err := ws.Bot.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		check.Detail = fmt.Sprintf("チャンネルの情報を取得できませんでした（%s）。", err)
+		return check
+	}
+	check.OK = channel.IsMember
+	if !check.OK {
+		check.Detail = "ボットをチャンネルに招待してください。"
+	}
+	return check
+}
+
+// doctorBucket は、書き込みを確認するバケットです。
+type doctorBucket struct {
+	Name   string
+	Bucket string
+	Client *s3.Client
+}
+
+// doctorBuckets は、S3_BUCKET、S3_BUCKETS、INBOX_BUCKET、FAILOVER_BUCKET のバケットを重複なく返します。
+func doctorBuckets() []doctorBucket {
+	var targets []doctorBucket
+	seen := map[string]bool{}
+	add := func(name, bucket string, client *s3.Client) {
+		if bucket == "" || seen[bucket] {
+			return
+		}
+		seen[bucket] = true
+		targets = append(targets, doctorBucket{Name: name, Bucket: bucket, Client: client})
+	}
+	add("S3_BUCKET", bucketOrDefault(""), s3Client)
+	aliases := map[string]string{}
+	if v := os.Getenv("S3_BUCKETS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &aliases); err != nil {
+			log.Println("S3_BUCKETS の読み込み中にエラーが発生しました。", err)
+		}
+	}
+	names := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	for _, alias := range names {
+		add("S3_BUCKETS["+alias+"]", aliases[alias], s3Client)
+	}
+	add("INBOX_BUCKET", inboxBucket(), s3Client)
+	if failoverS3Client != nil {
+		add("FAILOVER_BUCKET", os.Getenv("FAILOVER_BUCKET"), failoverS3Client)
+	}
+	return targets
+}
+
+// checkBucketWrite は、バケットに小さなオブジェクトを書き込み、すぐに削除して権限を確認します。
+// 削除できない場合も、取り消しや保存期間の削除が失敗するため問題として報告します。
+func checkBucketWrite(ctx context.Context, name, bucket string, client *s3.Client) doctorCheck {
+	check := doctorCheck{Name: fmt.Sprintf("%s（%s）への書き込み", name, bucket)}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	key := doctorProbePrefix + hex.EncodeToString(id)
+	if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte("geturl-doctor")),
+	}); err != nil {
+		check.Detail = fmt.Sprintf("s3:PutObject に失敗しました（%s）。", err)
+		return check
+	}
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		check.Detail = fmt.Sprintf("s3:DeleteObject に失敗しました（%s）。%s が残っています。", err, key)
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// checkKMSKeys は、TOKEN_KMS_KEY_ID と MANIFEST_KMS_KEY_ID の鍵を、アプリケーションと同じ操作で使えるかを確認します。
+func checkKMSKeys(ctx context.Context) []doctorCheck {
+	var checks []doctorCheck
+	if keyID := os.Getenv("TOKEN_KMS_KEY_ID"); keyID != "" {
+		check := doctorCheck{Name: "TOKEN_KMS_KEY_ID の鍵"}
+		output, err := kmsClient.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+			KeyId:   aws.String(keyID),
+			KeySpec: kmstypes.DataKeySpecAes256,
+		})
+		if err != nil {
+			check.Detail = fmt.Sprintf("kms:GenerateDataKey に失敗しました（%s）。", err)
+		} else {
+			zero(output.Plaintext)
+			check.OK = true
+		}
+		checks = append(checks, check)
+	}
+	if keyID := os.Getenv("MANIFEST_KMS_KEY_ID"); keyID != "" {
+		check := doctorCheck{Name: "MANIFEST_KMS_KEY_ID の鍵"}
+		digest := sha256.Sum256([]byte("geturl-doctor"))
+		if _, err := kmsClient.Sign(ctx, &kms.SignInput{
+			KeyId:            aws.String(keyID),
+			Message:          digest[:],
+			MessageType:      kmstypes.MessageTypeDigest,
+			SigningAlgorithm: kmstypes.SigningAlgorithmSpec(getEnvOrDefault("MANIFEST_SIGNING_ALGORITHM", "ECDSA_SHA_256")),
+		}); err != nil {
+			check.Detail = fmt.Sprintf("kms:Sign に失敗しました（%s）。", err)
+		} else {
+			check.OK = true
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// zero は、不要になった鍵をメモリから消去します。
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// checkShortener は、短縮URLサービスに接続できるかを確認します。
+// 短縮URLを発行すると使われない対応が残るため、内部の短縮URLでは存在しないコードを引き、外部のサービスではエンドポイントに接続するだけにします。
+func checkShortener(ctx context.Context) doctorCheck {
+	if shortLinkResolver != nil {
+		check := doctorCheck{Name: "短縮URL（SHORTENER_TABLE）"}
+		if _, err := shortLinkResolver.Resolve(ctx, "geturl-doctor"); err != nil {
+			check.Detail = fmt.Sprintf("dynamodb:GetItem に失敗しました（%s）。", err)
+			return check
+		}
+		check.OK = true
+		return check
+	}
+
+	check := doctorCheck{Name: "短縮URL（URL_SHORTENER_URL）"}
+	endpoint := os.Getenv("URL_SHORTENER_URL")
+	if endpoint == "" {
+		check.Detail = "設定されていません。"
+		return check
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	response, err := internalHTTPClient.Do(request)
+	if err != nil {
+		check.Detail = fmt.Sprintf("接続できませんでした（%s）。", err)
+		return check
+	}
+	response.Body.Close()
+	// HEAD に対応していないサービスもあるため、サーバーのエラー以外は接続できたものとする。
+	if response.StatusCode >= 500 {
+		check.Detail = fmt.Sprintf("ステータス %d が返りました。", response.StatusCode)
+		return check
+	}
+	check.OK = true
+	return check
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// cmd 以下のサブシステムごとのバイナリは、次の関数で Lambda のハンドラーを起動します。
+// 各サブシステムを別の関数としてデプロイできるよう、設定はこれまでどおり環境変数で行います。
+
+// InLambda は、Lambda の実行環境で起動したかを返します。
+// provided.al2023 などのカスタムランタイムでは、Runtime API（AWS_LAMBDA_RUNTIME_API）で判断します。
+// go1.x ランタイムの RPC での呼び出しを含むかはビルドタグ lambda.norpc で決まり、lambdaRPCAvailable が判断します。
+func InLambda() bool {
+	return os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" || lambdaRPCAvailable()
+}
+
+// Serve は、Lambda以外（ローカルやコンテナ）で起動した場合に、常駐するHTTPサーバーとして動作します。
+func Serve() {
+	runServer()
+}
+
+// HandleReceiver は、Slackからのリクエスト（API Gateway）と、同じ関数に届いた非同期のイベントを処理します（cmd/receiver）。
+func HandleReceiver(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	return handleInvocation(ctx, payload)
+}
+
+// HandleWorker は、受付の関数から非同期に渡されたイベントと、EventBridge Scheduler で予約した処理を行います（cmd/worker）。
+func HandleWorker(ctx context.Context, payload json.RawMessage) error {
+	return handleWorkerInvocation(ctx, payload)
+}
+
+// HandleRedirect は、内部の短縮URLサービスの短縮URLをリダイレクトします（cmd/redirect）。
+func HandleRedirect(r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	return handleRedirect(r)
+}
+
+// HandleCleanup は、期限切れのリンクを送信したSlackのメッセージを整理します（cmd/cleanup）。
+func HandleCleanup(ctx context.Context, ev events.CloudWatchEvent) error {
+	return handleMessageCleanup(ctx, ev)
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// 処理の失敗の分類です。errors.Is で判定し、HTTPのステータス、Slackに表示するメッセージ、メトリクスの分類を決めます。
+var (
+	ErrValidation    = errors.New("validation error")     // ファイルやオプションが条件を満たさない。メッセージをそのまま依頼者に表示する
+	ErrSlackDownload = errors.New("slack download error") // Slackからのファイルの取得・削除に失敗した
+	ErrStorage       = errors.New("storage error")        // S3へのアップロードや署名付きURLの生成に失敗した
+	ErrShortener     = errors.New("shortener error")      // URLの短縮に失敗した
+)
+
+// classifiedError は、分類を付けたエラーです。
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// classify は、err に分類 class を付けます。err が nil の場合は nil を返します。
+func classify(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+// validationError は、依頼者にそのまま表示するメッセージのエラーを ErrValidation に分類します。
+func validationError(message string) error {
+	return classify(ErrValidation, errors.New(message))
+}
+
+// errorClass は、エラーの分類をメトリクスのラベルとして返します。
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrValidation):
+		return "validation"
+	case errors.Is(err, ErrSlackDownload):
+		return "slack_download"
+	case errors.Is(err, ErrStorage):
+		return "storage"
+	case errors.Is(err, ErrShortener):
+		return "shortener"
+	}
+	return "internal"
+}
+
+// errorResponse は、エラーの分類に応じて依頼者のスレッドにメッセージを返信し、レスポンスを返します。
+// ログは呼び出し元で出力します。
+func errorResponse(ws *workspace, ev *slackevents.AppMentionEvent, err error) (events.APIGatewayProxyResponse, error) {
+	metrics.ObserveError(errorClass(err))
+
+	status := http.StatusInternalServerError
+	message := "エラーが発生しました。処理を完了できませんでした。"
+	var policyErr *policyError
+	switch {
+	case errors.As(err, &policyErr):
+		status, message = http.StatusForbidden, policyErr.Error()
+	case errors.Is(err, errObjectAlreadyExists):
+		status, message = http.StatusConflict, errObjectAlreadyExists.Error()
+	case errors.Is(err, ErrValidation):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, ErrShortener):
+		message = "URLの短縮中にエラーが発生しました。処理を完了できませんでした。"
+	}
+	sendErrorToSlack(ws, ev, message)
+	return events.APIGatewayProxyResponse{StatusCode: status, Body: http.StatusText(status)}, err
+}
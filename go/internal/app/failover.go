@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// failoverEnabled は、プライマリのバケットへのアップロードに失敗した場合に、FAILOVER_REGION の FAILOVER_BUCKET に
+// アップロードし直すかを返します。bucket= で別のバケットを指定した場合は、指定したバケット以外に保存しないようフェイルオーバーしません。
+func failoverEnabled(opts *mentionOptions) bool {
+	return failoverS3Client != nil && os.Getenv("FAILOVER_BUCKET") != "" && opts.Bucket == ""
+}
+
+// primaryUploadContext は、プライマリのバケットへのアップロードに使うコンテキストを返します。
+// フェイルオーバーが有効で FAILOVER_LATENCY_SLO（例: 「10s」）が設定されている場合は、その時間を超えたアップロードを打ち切り、
+// セカンダリのバケットにアップロードし直します。大きなファイルも含めて、通常は十分に完了できる時間を設定してください。
+func primaryUploadContext(opts *mentionOptions) (context.Context, context.CancelFunc) {
+	if !failoverEnabled(opts) {
+		return context.WithCancel(context.TODO())
+	}
+	slo, err := parseDuration(os.Getenv("FAILOVER_LATENCY_SLO"))
+	if err != nil || slo <= 0 {
+		return context.WithCancel(context.TODO())
+	}
+	return context.WithTimeout(context.TODO(), slo)
+}
+
+// s3ClientFor は、チームの region のバケットを操作する S3 のクライアントを返します。
+// チームのIAMロールが登録されている場合は、そのロールのクライアントで操作します。
+// フェイルオーバーでセカンダリのリージョンにアップロードしたオブジェクトは、そのリージョンのクライアントで操作します。
+// チームを確認できない場合は、共有のクライアントで別のチームのバケットを操作しないようエラーを返します。
+func s3ClientFor(teamID, enterpriseID, region string) (*s3.Client, error) {
+	ws, err := resolveWorkspace(teamID, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	if ws.Storage != nil {
+		return ws.Storage.Client, nil
+	}
+	if failoverS3Client != nil && region != "" && region == os.Getenv("FAILOVER_REGION") {
+		return failoverS3Client, nil
+	}
+	return s3Client, nil
+}
+
+// uploadWithFailover は、upload でプライマリのバケットにアップロードし、失敗した場合や FAILOVER_LATENCY_SLO を超えた場合は
+// セカンダリのバケットにアップロードし直します。アップロードしたバケットとリージョンを返します。
+// チームのIAMロールが登録されている場合は、そのロールではセカンダリのバケットを操作できないため、フェイルオーバーしません。
+func uploadWithFailover(ws *workspace, bucket string, opts *mentionOptions, upload func(ctx context.Context, client *s3.Client, bucket string) error) (string, string, error) {
+	if ws.Storage != nil {
+		return bucket, s3Config.Region, upload(context.TODO(), ws.Storage.Client, bucket)
+	}
+	ctx, cancel := primaryUploadContext(opts)
+	start := time.Now()
+	err := upload(ctx, s3Client, bucket)
+	cancel()
+	if err == nil || !failoverEnabled(opts) {
+		return bucket, s3Config.Region, err
+	}
+
+	log.Println("プライマリのバケットへのアップロードに失敗したため、セカンダリのバケットにアップロードします。", time.Since(start), err)
+	secondary := os.Getenv("FAILOVER_BUCKET")
+	if err := ensureBucketPrivate(context.TODO(), failoverS3Client, secondary); err != nil {
+		return "", "", err
+	}
+	if err := upload(context.TODO(), failoverS3Client, secondary); err != nil {
+		return "", "", err
+	}
+	return secondary, os.Getenv("FAILOVER_REGION"), nil
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/glacier"
+	"github.com/slack-go/slack"
+)
+
+// glacierRestoreDays は、アーカイブから復元したコピーを保持する日数です（GLACIER_RESTORE_DAYS、デフォルト 1）。
+func glacierRestoreDays() int32 {
+	days := getEnvInt64("GLACIER_RESTORE_DAYS", 1)
+	if days <= 0 {
+		return 1
+	}
+	return int32(days)
+}
+
+// glacierRestoreTier は、アーカイブからの取り出し階層です（GLACIER_RESTORE_TIER、デフォルト Standard）。
+func glacierRestoreTier() types.Tier {
+	return types.Tier(getEnvOrDefault("GLACIER_RESTORE_TIER", string(types.TierStandard)))
+}
+
+// objectArchiveState は、監査記録のオブジェクトがアーカイブされているかを調べ、状態とストレージクラスを返します。
+func objectArchiveState(ctx context.Context, record *audit.Record) (string, types.StorageClass, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucketOrDefault(record.Bucket)),
+		Key:    aws.String(record.ObjectKey),
+	}
+	if record.VersionID != "" {
+		input.VersionId = aws.String(record.VersionID)
+	}
+	client, err := s3ClientFor(record.TeamID, record.EnterpriseID, record.Region)
+	if err != nil {
+		return "", "", err
+	}
+	out, err := client.HeadObject(ctx, input)
+	if err != nil {
+		return "", "", err
+	}
+	return glacier.State(out.StorageClass, out.Restore), out.StorageClass, nil
+}
+
+// restoreArchivedObject は、アーカイブされたオブジェクトの復元を開始し、完了したら waiter のスレッドにリンクを投稿するよう登録します。
+// 既に復元中の場合は、登録のみ行います。復元にかかるおおよその時間を返します。
+// 完了の通知には、バケットの S3 イベント通知（s3:ObjectRestore:Completed）で LAMBDA_HANDLER=s3restore の関数を呼び出すよう設定してください。
+func restoreArchivedObject(ctx context.Context, record *audit.Record, state string, storageClass types.StorageClass, waiter *glacier.Waiter) (string, error) {
+	if glacierStore == nil {
+		return "", fmt.Errorf("GLACIER_RESTORE_TABLE is not configured")
+	}
+	days := glacierRestoreDays()
+	tier := glacierRestoreTier()
+	object := glacier.ObjectID(bucketOrDefault(record.Bucket), record.ObjectKey, record.VersionID)
+	// 完了の通知が届かなかった場合に備えて、復元したコピーの保持期間が過ぎたら依頼を削除する。
+	if err := glacierStore.Add(ctx, object, waiter, time.Now().Add(time.Duration(days)*24*time.Hour+48*time.Hour)); err != nil {
+		return "", err
+	}
+	if state == glacier.StateArchived {
+		input := &s3.RestoreObjectInput{
+			Bucket: aws.String(bucketOrDefault(record.Bucket)),
+			Key:    aws.String(record.ObjectKey),
+			RestoreRequest: &types.RestoreRequest{
+				Days:                 days,
+				GlacierJobParameters: &types.GlacierJobParameters{Tier: tier},
+			},
+		}
+		if record.VersionID != "" {
+			input.VersionId = aws.String(record.VersionID)
+		}
+		// 他の依頼で既に復元を開始していた場合は、その完了を待つ。
+		client, err := s3ClientFor(record.TeamID, record.EnterpriseID, record.Region)
+		if err != nil {
+			return "", err
+		}
+		if _, err := client.RestoreObject(ctx, input); err != nil && !strings.Contains(err.Error(), "RestoreAlreadyInProgress") {
+			return "", err
+		}
+	}
+	return glacier.ETA(storageClass, tier), nil
+}
+
+// handleS3RestoreEvent は、LAMBDA_HANDLER=s3restore で起動したときのハンドラーで、S3 のイベント通知から呼び出されます。
+// アーカイブからの復元が完了したオブジェクトについて、復元を待っていたスレッドに新しい短縮URLを投稿します。
+// 署名付きURLの有効期限は、復元したコピーを保持する GLACIER_RESTORE_DAYS を超えないようにします。
+func handleS3RestoreEvent(ctx context.Context, ev events.S3Event) error {
+	if glacierStore == nil || auditStore == nil {
+		return fmt.Errorf("GLACIER_RESTORE_TABLE and AUDIT_TABLE are required")
+	}
+	for _, r := range ev.Records {
+		if !strings.HasPrefix(r.EventName, "ObjectRestore:Completed") {
+			continue
+		}
+		object := glacier.ObjectID(r.S3.Bucket.Name, r.S3.Object.URLDecodedKey, r.S3.Object.VersionID)
+		waiters, err := glacierStore.Take(ctx, object)
+		if err != nil {
+			log.Println("復元を待っている依頼の取得中にエラーが発生しました。", object, err)
+			return err
+		}
+		for _, w := range waiters {
+			// 1件の投稿に失敗しても、他の依頼への投稿は続ける。
+			if err := postRestoredLink(ctx, w); err != nil {
+				log.Println("復元したファイルのリンクの投稿中にエラーが発生しました。", w.RecordID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// postRestoredLink は、復元したオブジェクトの署名付きURLを短縮し、復元を待っていたスレッドに投稿します。
+func postRestoredLink(ctx context.Context, w *glacier.Waiter) error {
+	record, err := auditStore.Get(ctx, w.RecordID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return fmt.Errorf("audit record %s not found", w.RecordID)
+	}
+
+	expiry := time.Duration(glacierRestoreDays()) * 24 * time.Hour
+	if expiry > presignExpiry {
+		expiry = presignExpiry
+	}
+	uploaded := &uploadedObject{Bucket: record.Bucket, Key: record.ObjectKey, VersionID: record.VersionID, Region: record.Region, TeamID: record.TeamID, EnterpriseID: record.EnterpriseID, Expiry: expiry}
+	if err := presignObject(uploaded); err != nil {
+		return err
+	}
+	shortURL, err := shortenerFor(w.TeamID, w.Channel).Shorten(uploaded.PresignedURL)
+	if err != nil {
+		return err
+	}
+
+	ws, err := resolveWorkspace(w.TeamID, w.EnterpriseID)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("<@%s> %s のアーカイブからの復元が完了しました。\n", w.User, escapeMrkdwn(record.FileName)) +
+		formatPublishedMessage(shortURL, record.Size, "")
+	options := []slack.MsgOption{slack.MsgOptionText(message, false)}
+	if w.ThreadTS != "" {
+		options = append(options, slack.MsgOptionTS(w.ThreadTS))
+	}
+	_, _, err = ws.Bot.PostMessageContext(ctx, w.Channel, options...)
+	return err
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/kumagai-s/uploader-v2/internal/hooks"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// hookTargets は、段階ごとに実行するフックの宛先を返します。
+// 宛先は HOOK_AFTER_VALIDATE、HOOK_AFTER_UPLOAD、HOOK_AFTER_PUBLISH にカンマ区切りで指定し、
+// Webhook のURLまたは Lambda の関数名（ARN）を指定できます。
+// WEBHOOK_SIGNING_SECRET を設定した場合は、Webhook のリクエストに X-Signature-Timestamp と X-Signature の署名を付けます。
+func hookTargets(stage string) []string {
+	return splitEnvList("HOOK_" + strings.ToUpper(strings.ReplaceAll(stage, "-", "_")))
+}
+
+// runHooks は、段階に設定されたフックを順に実行します。
+// フックは社内のカタログへの登録などの追加の処理のためのものなので、失敗してもログに出力するのみとします。
+func runHooks(stage string, ev *hooks.Event) {
+	targets := hookTargets(stage)
+	if len(targets) == 0 {
+		return
+	}
+	ev.Stage = stage
+	for _, target := range targets {
+		if err := hooks.New(target, webhookHTTPClient, lambdaClient).Run(context.TODO(), ev); err != nil {
+			log.Println("フックの実行中にエラーが発生しました。", stage, err)
+		}
+	}
+}
+
+// fileHookEvent は、メンションで受け取ったファイルのフックのイベントを生成します。
+func fileHookEvent(ws *workspace, ev *slackevents.AppMentionEvent, file *SlackAppMentionEventFile, uploaded *uploadedObject) *hooks.Event {
+	hookEvent := &hooks.Event{
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+		Channel:      ev.Channel,
+		User:         ev.User,
+		FileName:     file.Name,
+		Size:         int64(len(file.Binary)),
+	}
+	if uploaded != nil {
+		hookEvent.Bucket = uploaded.Bucket
+		hookEvent.ObjectKey = uploaded.Key
+		hookEvent.VersionID = uploaded.VersionID
+	}
+	return hookEvent
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/uploadform"
+)
+
+// inboxBucket は、社外からアップロードされたファイルを受け付けるバケットです。INBOX_BUCKET が空の場合は S3_BUCKET です。
+func inboxBucket() string {
+	return bucketOrDefault(os.Getenv("INBOX_BUCKET"))
+}
+
+// inboxPrefix は、社外からアップロードされたファイルを保存するS3キーの接頭辞です。
+func inboxPrefix() string {
+	return getEnvOrDefault("INBOX_PREFIX", "inbox")
+}
+
+// s3Endpoint は、ブラウザからS3に接続するときのエンドポイントです。
+func s3Endpoint() string {
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		return endpoint
+	}
+	return "https://s3." + s3Config.Region + ".amazonaws.com"
+}
+
+// createUploadPage は、社外の人がブラウザからファイルを送れるよう、S3の署名付きPOSTのフォームを含む
+// アップロードページを作成し、その署名付きURLを生成します。
+// ファイルは「INBOX_PREFIX/チーム/チャンネル/ID/ファイル名」に保存され、依頼者、チャンネルとワークスペースをメタデータに残します。
+// ページは ws のバケットに保存します。ページとフォームの有効期限は INBOX_EXPIRY（デフォルト 24h、最大7日）です。
+func createUploadPage(ws *workspace, channel, user, note string) (*uploadedObject, error) {
+	expiry, err := parseDuration(getEnvOrDefault("INBOX_EXPIRY", "24h"))
+	if err != nil || expiry <= 0 || expiry > presignExpiry {
+		return nil, fmt.Errorf("invalid INBOX_EXPIRY")
+	}
+	id, err := audit.NewID()
+	if err != nil {
+		return nil, err
+	}
+	creds, err := s3Config.Credentials.Retrieve(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := uploadform.Sign(creds, s3Config.Region, s3Endpoint(), &uploadform.Policy{
+		Bucket:    inboxBucket(),
+		KeyPrefix: fmt.Sprintf("%s/%s/%s/%s/", inboxPrefix(), ws.TeamID, channel, id),
+		Fields: map[string]string{
+			"success_action_status":    "201",
+			"x-amz-meta-requester":     user,
+			"x-amz-meta-channel":       channel,
+			"x-amz-meta-team-id":       ws.TeamID,
+			"x-amz-meta-enterprise-id": ws.EnterpriseID,
+		},
+		MaxSize: getEnvInt64("INBOX_MAX_SIZE", 5<<30),
+		Expires: expiry,
+	}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	page, err := uploadform.RenderPage(&uploadform.Page{
+		Title: "ファイルのアップロード",
+		Note:  note,
+		Form:  form,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, bucket := uploadTarget(ws, &mentionOptions{})
+	uploaded := &uploadedObject{
+		Bucket:       bucket,
+		Key:          bundlePrefix() + "/" + id + "/upload.html",
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+		Expiry:       expiry,
+	}
+	if err := ensureBucketPrivate(context.TODO(), client, uploaded.Bucket); err != nil {
+		return nil, err
+	}
+	if _, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(uploaded.Bucket),
+		Key:         aws.String(uploaded.Key),
+		Body:        bytes.NewReader(page),
+		ContentType: aws.String("text/html; charset=utf-8"),
+	}); err != nil {
+		return nil, err
+	}
+	if err := presignObject(uploaded); err != nil {
+		return nil, err
+	}
+	return uploaded, nil
+}
+
+// handleInboxCommand は、/geturl-inbox を処理し、アップロードページの短縮URLを返します。
+// 引数はページに表示する説明になります。
+func handleInboxCommand(teamID, enterpriseID, channel, user, text string) string {
+	if len([]rune(text)) > maxNoteLength {
+		return fmt.Sprintf("説明は%d文字以内で指定してください。", maxNoteLength)
+	}
+	ws, err := resolveWorkspace(teamID, enterpriseID)
+	if err != nil {
+		log.Println("ワークスペースの確認中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	page, err := createUploadPage(ws, channel, user, text)
+	if err != nil {
+		log.Println("アップロードページの作成中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	shortURL, err := shortenerFor(teamID, channel).Shorten(page.PresignedURL)
+	if err != nil {
+		log.Println("URLの短縮中にエラーが発生しました。", err)
+		return "URLの短縮中にエラーが発生しました。処理を完了できませんでした。"
+	}
+	return fmt.Sprintf("アップロード用のページを作成しました。ファイルを送ってもらう相手に共有してください。届いたファイルはこのチャンネルでお知らせします。\n%s\n有効期限: %s",
+		shortURL, page.ExpiresAt.Format("2006-01-02 15:04"))
+}
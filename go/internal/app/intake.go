@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/hooks"
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/kumagai-s/uploader-v2/internal/secretscan"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// handleInboxUpload は、LAMBDA_HANDLER=intake で起動したときのハンドラーです。
+// INBOX_BUCKET の ObjectCreated イベントの通知から呼び出され、INBOX_PREFIX 以下に届いたファイルを検証・検査し、
+// 依頼したチャンネル（メタデータがない場合は INBOX_CHANNEL）に概要と社内向けの短縮URLを送信します。
+// 検証や検査で公開できないと判断したファイルは、S3から削除して理由をチャンネルに知らせます。
+func handleInboxUpload(ctx context.Context, ev events.S3Event) error {
+	defer recoverPanic("intake", nil)
+	for _, record := range ev.Records {
+		// イベントのキーはURLエンコードされている。
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			log.Println("S3イベントの解析中にエラーが発生しました。", err)
+			continue
+		}
+		if !strings.HasPrefix(key, inboxPrefix()+"/") {
+			continue
+		}
+		if err := processInboxObject(ctx, record.S3.Bucket.Name, key); err != nil {
+			log.Println("届いたファイルの処理中にエラーが発生しました。", key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// processInboxObject は、受付用のバケットに届いた1つのファイルを検証し、結果をSlackに送信します。
+func processInboxObject(ctx context.Context, bucket, key string) error {
+	head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	channel := head.Metadata["channel"]
+	if channel == "" {
+		channel = os.Getenv("INBOX_CHANNEL")
+	}
+	if channel == "" {
+		return fmt.Errorf("no channel to announce %s", key)
+	}
+	ws, err := resolveWorkspace(head.Metadata["team-id"], head.Metadata["enterprise-id"])
+	if err != nil {
+		return err
+	}
+	ev := &slackevents.AppMentionEvent{Channel: channel, User: head.Metadata["requester"]}
+	file := &SlackAppMentionEventFile{Name: path.Base(key), Size: head.ContentLength}
+
+	buf, err := memoryBudget.NewBuffer(head.ContentLength)
+	if err != nil {
+		return err
+	}
+	defer buf.Close()
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: head.VersionId,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(buf, out.Body)
+	out.Body.Close()
+	if err != nil {
+		return err
+	}
+	if file.Binary, err = buf.Bytes(); err != nil {
+		return err
+	}
+	metrics.AddBytes("download", len(file.Binary))
+
+	secretFindings, err := inspectInboxFile(ev, file)
+	if err != nil {
+		if !errors.Is(err, ErrValidation) {
+			return err
+		}
+		return rejectInboxObject(ctx, ws, ev, bucket, key, err)
+	}
+	runHooks(hooks.StageAfterValidate, fileHookEvent(ws, ev, file, nil))
+
+	uploaded := &uploadedObject{
+		Bucket:       bucket,
+		Key:          key,
+		VersionID:    aws.ToString(head.VersionId),
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+	}
+	if err := presignObject(uploaded); err != nil {
+		return err
+	}
+	shortURL, err := shortenerFor(ws.TeamID, channel).Shorten(uploaded.PresignedURL)
+	if err != nil {
+		return err
+	}
+
+	p := &publishedFile{file: file, uploaded: uploaded, secretFindings: secretFindings}
+	message := fmt.Sprintf(":inbox_tray: アップロードページからファイル「%s」が届きました。", escapeMrkdwn(file.Name))
+	if ev.User != "" {
+		message += fmt.Sprintf("（依頼者: <@%s>）", ev.User)
+	}
+	message += "\n" + formatPublishedMessage(shortURL, int64(len(file.Binary)), p.warnings())
+	_, ts, err := ws.Bot.PostMessageContext(ctx, channel, slack.MsgOptionText(message, false))
+	if err != nil {
+		return err
+	}
+
+	// アップロードページからの依頼には元のメッセージがないため、届いたことを知らせたメッセージを記録する。
+	recordAudit(&audit.Record{
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+		Channel:      channel,
+		MessageTS:    ts,
+		User:         ev.User,
+		FileName:     file.Name,
+		Bucket:       bucket,
+		ObjectKey:    key,
+		VersionID:    uploaded.VersionID,
+		Region:       uploaded.Region,
+		Size:         int64(len(file.Binary)),
+		ShortURL:     shortURL,
+		ExpiresAt:    uploaded.ExpiresAt.Unix(),
+		SHA256:       contentSHA256(file.Binary),
+	})
+	return nil
+}
+
+// inspectInboxFile は、メンションで送られたファイルと同じ規則で届いたファイルを検証し、DLPとシークレットの検出を行います。
+// 公開できない場合は ErrValidation に分類されるエラーを返します。
+func inspectInboxFile(ev *slackevents.AppMentionEvent, file *SlackAppMentionEventFile) ([]secretscan.Finding, error) {
+	if err := validateFile(ev, file); err != nil {
+		return nil, classify(ErrValidation, err)
+	}
+	// 受付箱に届いたファイルにはメンションがないため、dlp=override は指定できない。
+	if err := scanFileWithDLP(ev, file, &mentionOptions{}); err != nil {
+		var violation *dlpViolationError
+		if errors.As(err, &violation) {
+			return nil, classify(ErrValidation, err)
+		}
+		return nil, err
+	}
+	findings, err := scanFileForSecrets(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(findings) > 0 && os.Getenv("SECRET_SCAN_MODE") == "block" {
+		return nil, validationError("APIキーや秘密鍵などのシークレットが含まれている可能性があるため公開できません。\n" + secretscan.Summary(findings))
+	}
+	return findings, nil
+}
+
+// rejectInboxObject は、公開できないファイルをS3から削除し、その理由をチャンネルに送信します。
+func rejectInboxObject(ctx context.Context, ws *workspace, ev *slackevents.AppMentionEvent, bucket, key string, reason error) error {
+	if _, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		return err
+	}
+	metrics.ObserveError(errorClass(reason))
+	message := fmt.Sprintf(":no_entry: アップロードページから届いたファイル「%s」は公開できないため削除しました。\n%s", escapeMrkdwn(path.Base(key)), reason.Error())
+	_, _, err := ws.Bot.PostMessageContext(ctx, ev.Channel, slack.MsgOptionText(message, false))
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build lambda.norpc
+
+package app
+
+// lambdaRPCAvailable は、-tags lambda.norpc でビルドした場合は常に false です。
+// RPC での呼び出しを含まない小さなバイナリは、provided.al2023 などのカスタムランタイムでのみ動作します。
+func lambdaRPCAvailable() bool {
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !lambda.norpc
+
+package app
+
+import "os"
+
+// lambdaRPCAvailable は、go1.x ランタイムが RPC でハンドラーを呼び出す環境かを返します。
+func lambdaRPCAvailable() bool {
+	return os.Getenv("_LAMBDA_SERVER_PORT") != ""
+}
@@ -0,0 +1,241 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/slack-go/slack"
+)
+
+// latestBuildTitle は、最新のリンクを置くブックマークとキャンバスのセクションの見出しです。
+const latestBuildTitle = "Latest build"
+
+// previousBuildTitle は、それ以前のリンクを置くブックマークの見出しです。「Previous build 1」のように番号を付けます。
+const previousBuildTitle = "Previous build"
+
+// latestBuildEnabled は、チャンネルの「Latest build」を更新するかを返します。
+// LATEST_BUILD に「bookmark」または「canvas」を指定し、LATEST_BUILD_CHANNELS を指定した場合はそのチャンネルだけを更新します。
+func latestBuildEnabled(channel string) bool {
+	switch os.Getenv("LATEST_BUILD") {
+	case "bookmark", "canvas":
+	default:
+		return false
+	}
+	channels := splitEnvList("LATEST_BUILD_CHANNELS")
+	if len(channels) == 0 {
+		return true
+	}
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// updateLatestBuild は、発行したリンクをチャンネルのブックマークまたはキャンバスの「Latest build」に置き、
+// それ以前のリンクを LATEST_BUILD_KEEP 件（デフォルト 3）まで残します。
+// スレッドを遡らなくても、チャンネルで最新の成果物を見つけられるようにするためのものです。
+// 受取人を限定したリンクは、チャンネルの全員に見せるものではないため置きません。
+// 更新に失敗してもリンクは共有済みのため、ログに出力するのみとします。
+func updateLatestBuild(record *audit.Record) {
+	if !latestBuildEnabled(record.Channel) || len(record.Recipients) > 0 {
+		return
+	}
+	ctx := context.TODO()
+	ws, err := resolveWorkspace(record.TeamID, record.EnterpriseID)
+	if err != nil {
+		log.Println("「Latest build」の更新中にエラーが発生しました。", record.Channel, err)
+		return
+	}
+	links := latestBuildLinks(ctx, record)
+
+	if os.Getenv("LATEST_BUILD") == "canvas" {
+		err = updateLatestBuildCanvas(ctx, ws, record.Channel, links)
+	} else {
+		err = updateLatestBuildBookmarks(ctx, ws, record.Channel, links)
+	}
+	if err != nil {
+		log.Println("「Latest build」の更新中にエラーが発生しました。", record.Channel, err)
+	}
+}
+
+// latestBuildLinks は、チャンネルで発行した有効期限内のリンクを新しい順に、最新の1件と LATEST_BUILD_KEEP 件まで返します。
+// 以前のリンクは監査記録から探すため、AUDIT_TABLE が設定されていない場合は最新のリンクのみです。
+func latestBuildLinks(ctx context.Context, latest *audit.Record) []*audit.Record {
+	links := []*audit.Record{latest}
+	if auditStore == nil {
+		return links
+	}
+	records, err := auditStore.Search(ctx, latest.TeamID, &audit.Query{Channel: latest.Channel}, time.Now())
+	if err != nil {
+		log.Println("以前のリンクの検索中にエラーが発生しました。", err)
+		return links
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt > records[j].CreatedAt })
+	keep := int(getEnvInt64("LATEST_BUILD_KEEP", 3))
+	for _, r := range records {
+		if len(links) > keep {
+			break
+		}
+		if r.ID == latest.ID || r.RevokedAt != 0 || len(r.Recipients) > 0 {
+			continue
+		}
+		links = append(links, r)
+	}
+	return links
+}
+
+// latestBuildBookmarkTitle は、i 番目に新しいリンクのブックマークの見出しです。
+func latestBuildBookmarkTitle(i int, r *audit.Record) string {
+	if i == 0 {
+		return fmt.Sprintf("%s: %s", latestBuildTitle, r.FileName)
+	}
+	return fmt.Sprintf("%s %d: %s", previousBuildTitle, i, r.FileName)
+}
+
+// latestBuildBookmarkIndex は、このアプリケーションが置いたブックマークの見出しから、何番目に新しいリンクかを返します。
+func latestBuildBookmarkIndex(title string) (int, bool) {
+	if strings.HasPrefix(title, latestBuildTitle+":") {
+		return 0, true
+	}
+	var i int
+	if _, err := fmt.Sscanf(title, previousBuildTitle+" %d:", &i); err == nil && i > 0 {
+		return i, true
+	}
+	return 0, false
+}
+
+// updateLatestBuildBookmarks は、チャンネルのブックマークを links の順に並べ直します。
+// 既に置いたブックマークは書き換え、足りない分は追加し、余った分は削除します。
+func updateLatestBuildBookmarks(ctx context.Context, ws *workspace, channel string, links []*audit.Record) error {
+	bookmarks, err := ws.Bot.ListBookmarksContext(ctx, channel)
+	if err != nil {
+		return err
+	}
+	var managed []slack.Bookmark
+	indexes := map[string]int{}
+	for _, b := range bookmarks {
+		if i, ok := latestBuildBookmarkIndex(b.Title); ok {
+			managed = append(managed, b)
+			indexes[b.ID] = i
+		}
+	}
+	sort.Slice(managed, func(i, j int) bool { return indexes[managed[i].ID] < indexes[managed[j].ID] })
+
+	for i, r := range links {
+		title := latestBuildBookmarkTitle(i, r)
+		if i < len(managed) {
+			if _, err := ws.Bot.EditBookmarkContext(ctx, channel, managed[i].ID, slack.EditBookmarkParameters{Title: &title, Link: r.ShortURL}); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := ws.Bot.AddBookmarkContext(ctx, channel, slack.AddBookmarkParameters{Title: title, Type: "link", Link: r.ShortURL, Emoji: ":package:"}); err != nil {
+			return err
+		}
+	}
+	for i := len(links); i < len(managed); i++ {
+		if err := ws.Bot.RemoveBookmarkContext(ctx, channel, managed[i].ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestBuildCanvasMarkdown は、キャンバスの「Latest build」のセクションの内容です。
+func latestBuildCanvasMarkdown(links []*audit.Record) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n", latestBuildTitle)
+	for i, r := range links {
+		created := time.Now()
+		if r.CreatedAt != 0 {
+			created = time.Unix(r.CreatedAt, 0)
+		}
+		line := fmt.Sprintf("- [%s](%s) %s", r.FileName, r.ShortURL, created.Format("2006-01-02 15:04"))
+		if i == 0 {
+			line = fmt.Sprintf("- **[%s](%s)** %s", r.FileName, r.ShortURL, created.Format("2006-01-02 15:04"))
+		}
+		if r.Note != "" {
+			line += " " + r.Note
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// updateLatestBuildCanvas は、LATEST_BUILD_CANVASES でチャンネルに対応付けたキャンバスの「Latest build」のセクションを書き換えます。
+// LATEST_BUILD_CANVASES は、チャンネルIDをキー、キャンバスのファイルID（F...）を値とするJSONです。
+// セクションがない場合は、キャンバスの末尾に追加します。
+func updateLatestBuildCanvas(ctx context.Context, ws *workspace, channel string, links []*audit.Record) error {
+	canvases := map[string]string{}
+	if v := os.Getenv("LATEST_BUILD_CANVASES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &canvases); err != nil {
+			return fmt.Errorf("unable to parse LATEST_BUILD_CANVASES, %s", err)
+		}
+	}
+	canvasID := canvases[channel]
+	if canvasID == "" {
+		return fmt.Errorf("no canvas is configured for channel %s", channel)
+	}
+
+	var lookup struct {
+		slack.SlackResponse
+		Sections []struct {
+			ID string `json:"id"`
+		} `json:"sections"`
+	}
+	if err := callSlackAPI(ctx, ws.BotToken, "canvases.sections.lookup", map[string]interface{}{
+		"canvas_id": canvasID,
+		"criteria":  map[string]interface{}{"section_types": []string{"any_header"}, "contains_text": latestBuildTitle},
+	}, &lookup); err != nil {
+		return err
+	}
+
+	change := map[string]interface{}{
+		"operation":        "insert_at_end",
+		"document_content": map[string]string{"type": "markdown", "markdown": latestBuildCanvasMarkdown(links)},
+	}
+	if len(lookup.Sections) > 0 {
+		change["operation"] = "replace"
+		change["section_id"] = lookup.Sections[0].ID
+	}
+	var edit slack.SlackResponse
+	return callSlackAPI(ctx, ws.BotToken, "canvases.edit", map[string]interface{}{
+		"canvas_id": canvasID,
+		"changes":   []interface{}{change},
+	}, &edit)
+}
+
+// callSlackAPI は、slack-go が対応していない Web API の method を JSON で呼び出し、結果を result に読み込みます。
+// result は slack.SlackResponse を埋め込んだ構造体へのポインタです。
+func callSlackAPI(ctx context.Context, token, method string, params interface{}, result interface{ Err() error }) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("unable to marshal %s request, %s", method, err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, getEnvOrDefault("SLACK_API_URL", slack.APIURL)+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("unable to decode %s response, %s", method, err)
+	}
+	return result.Err()
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+)
+
+// legalHoldEvent は、リーガルホールドの設定・解除の記録です。
+type legalHoldEvent struct {
+	Action    string `json:"action"` // "hold" または "release"
+	RecordID  string `json:"record_id"`
+	Bucket    string `json:"bucket"`
+	ObjectKey string `json:"object_key"`
+	VersionID string `json:"version_id,omitempty"`
+	User      string `json:"user"` // 操作した管理者
+	Reason    string `json:"reason,omitempty"`
+	At        int64  `json:"at"` // UNIX時間（秒）
+}
+
+// handleLegalHoldCommand は、/geturl-admin hold と release を処理します。
+// S3 Object Lock のリーガルホールドをオブジェクトに設定するため、ライフサイクルによる期限切れの削除も含めて、
+// 解除するまでオブジェクトは削除されません。監査記録にも状態を残し、purge の対象から除きます。
+// 設定・解除の操作は LEGAL_HOLD_LOG_PREFIX（デフォルト legal-hold）にすべて記録します。
+func handleLegalHoldCommand(teamID, user, shortURL, reason string, hold bool) string {
+	record, err := auditStore.FindByShortURL(context.TODO(), shortURL)
+	if err != nil {
+		log.Println("監査記録の検索中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	if record == nil || record.TeamID != teamID {
+		return "指定した短縮URLのリンクが見つかりません。"
+	}
+	if record.LegalHold == hold {
+		if hold {
+			return "このリンクは既にリーガルホールド中です。"
+		}
+		return "このリンクはリーガルホールド中ではありません。"
+	}
+
+	if err := setLegalHold(context.TODO(), record, hold); err != nil {
+		log.Println("リーガルホールドの設定中にエラーが発生しました。", err)
+		return "リーガルホールドを設定できませんでした。Object Lock が有効なバケットでのみ利用できます。"
+	}
+	record.LegalHold = hold
+	record.LegalHoldBy, record.LegalHoldReason = "", ""
+	if hold {
+		record.LegalHoldBy, record.LegalHoldReason = user, reason
+	}
+	if err := auditStore.Put(context.TODO(), record); err != nil {
+		log.Println("監査記録の保存中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+
+	action := "release"
+	if hold {
+		action = "hold"
+	}
+	if err := writeLegalHoldEvent(context.TODO(), teamID, &legalHoldEvent{
+		Action:    action,
+		RecordID:  record.ID,
+		Bucket:    bucketOrDefault(record.Bucket),
+		ObjectKey: record.ObjectKey,
+		VersionID: record.VersionID,
+		User:      user,
+		Reason:    reason,
+		At:        time.Now().Unix(),
+	}); err != nil {
+		log.Println("リーガルホールドの操作の記録中にエラーが発生しました。", err)
+	}
+
+	// 対象の会話を確認できるよう、URLの発行を依頼したメッセージへのリンクを添える。
+	var origin string
+	if record.Permalink != "" {
+		origin = fmt.Sprintf("\n<%s|元のメッセージ>", record.Permalink)
+	}
+	if hold {
+		return fmt.Sprintf("%s（%s）をリーガルホールドにしました。解除するまで削除されません。", escapeMrkdwn(record.FileName), shortURL) + origin
+	}
+	return fmt.Sprintf("%s（%s）のリーガルホールドを解除しました。", escapeMrkdwn(record.FileName), shortURL) + origin
+}
+
+// setLegalHold は、監査記録のオブジェクトの S3 Object Lock のリーガルホールドを設定または解除します。
+func setLegalHold(ctx context.Context, record *audit.Record, hold bool) error {
+	status := types.ObjectLockLegalHoldStatusOff
+	if hold {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+	input := &s3.PutObjectLegalHoldInput{
+		Bucket:    aws.String(bucketOrDefault(record.Bucket)),
+		Key:       aws.String(record.ObjectKey),
+		LegalHold: &types.ObjectLockLegalHold{Status: status},
+	}
+	if record.VersionID != "" {
+		input.VersionId = aws.String(record.VersionID)
+	}
+	client, err := s3ClientFor(record.TeamID, record.EnterpriseID, record.Region)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutObjectLegalHold(ctx, input)
+	return err
+}
+
+// writeLegalHoldEvent は、リーガルホールドの操作をS3に記録します。
+func writeLegalHoldEvent(ctx context.Context, teamID string, ev *legalHoldEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s/%s/%s-%d-%s.json", getEnvOrDefault("LEGAL_HOLD_LOG_PREFIX", "legal-hold"), teamID, ev.RecordID, ev.At, ev.Action)
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketOrDefault("")),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
@@ -0,0 +1,1565 @@
+package app
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	lambdaservice "github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/kumagai-s/uploader-v2/internal/approval"
+	"github.com/kumagai-s/uploader-v2/internal/archive"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/cost"
+	"github.com/kumagai-s/uploader-v2/internal/dlp"
+	"github.com/kumagai-s/uploader-v2/internal/egress"
+	"github.com/kumagai-s/uploader-v2/internal/faultinject"
+	"github.com/kumagai-s/uploader-v2/internal/glacier"
+	"github.com/kumagai-s/uploader-v2/internal/hooks"
+	"github.com/kumagai-s/uploader-v2/internal/httpclient"
+	"github.com/kumagai-s/uploader-v2/internal/idempotency"
+	"github.com/kumagai-s/uploader-v2/internal/lrucache"
+	"github.com/kumagai-s/uploader-v2/internal/manifest"
+	"github.com/kumagai-s/uploader-v2/internal/membudget"
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/kumagai-s/uploader-v2/internal/otp"
+	"github.com/kumagai-s/uploader-v2/internal/redact"
+	"github.com/kumagai-s/uploader-v2/internal/secretscan"
+	"github.com/kumagai-s/uploader-v2/internal/semaphore"
+	"github.com/kumagai-s/uploader-v2/internal/signature"
+	"github.com/kumagai-s/uploader-v2/internal/throttle"
+	"github.com/kumagai-s/uploader-v2/internal/tokenstore"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+	"github.com/kumagai-s/uploader-v2/internal/watermark"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+var (
+	httpClient          *http.Client
+	internalHTTPClient  *http.Client
+	webhookHTTPClient   *http.Client
+	urlShortener        urlshortener.URLShortener
+	shortLinkResolver   urlshortener.Resolver
+	statusPages         urlshortener.StatusPages
+	redirectIPBuckets   throttle.Buckets
+	redirectLinkBuckets throttle.Buckets
+	slackClientAsBot    *slack.Client
+	slackClientAsUser   *slack.Client
+	s3Client            *s3.Client
+	s3PresignClient     *s3.PresignClient
+	replicaS3Client     *s3.Client
+	failoverS3Client    *s3.Client
+	s3Config            aws.Config
+	objectLockMode      types.ObjectLockMode // 空の場合は OBJECT_LOCK_MODE が不正なため、retain= を受け付けない
+	dlpInspector        dlp.Inspector
+	auditStore          audit.Store
+	approvalStore       approval.Store
+	idempotencyStore    idempotency.Store
+	tokenRegistry       tokenstore.Registry
+	sfnClient           *sfn.Client
+	lambdaClient        *lambdaservice.Client
+	stsClient           *sts.Client
+	schedulerClient     *scheduler.Client
+	memoryBudget        *membudget.Budget
+	manifestSigner      manifest.Signer
+	kmsClient           *kms.Client
+	otpStore            otp.Store
+	glacierStore        glacier.Store
+	slackMetadataCache  lrucache.Cache
+	logRedactor         redact.Redactor
+	downloadLimiter     throttle.Limiter
+	largeFileSemaphore  semaphore.Semaphore
+)
+
+func init() {
+	configure()
+}
+
+// configure は、環境変数からログ、HTTPクライアント、Slack と AWS のクライアント、各種のストアを設定します。
+// 結合テストでは、AWS_ENDPOINT_URL や SLACK_API_URL を設定してから呼び出し直します。
+func configure() {
+	// ログにトークンやファイル名などを残さないよう、すべてのログに LOG_REDACTION の規則を適用する。
+	rules, err := redact.ParseRules(getEnvOrDefault("LOG_REDACTION", "tokens"), int(getEnvInt64("LOG_BODY_LIMIT", 4096)))
+	if err != nil {
+		log.Println("初期設定中にエラーが発生しました。", err)
+		rules = redact.Rules{Tokens: true, URLs: true, FileNames: true, BodyLimit: 4096}
+	}
+	logRedactor = redact.New(rules)
+	log.SetOutput(redact.NewWriter(os.Stderr, logRedactor))
+
+	// 外部サービスとの通信には、コネクションを使い回す共通の http.Client を使用する。
+	httpClient = withFaultInjection(withEgressAllowlist(httpclient.New(httpclient.ConfigFromEnv())))
+
+	// 割り当てられたメモリに応じて、転送の単位やファイルを一時ファイルに書き出すかを決める。
+	memoryBudget = membudget.FromEnv()
+
+	// Slackのユーザーやチャンネルの情報は、ウォームスタート間で共有するキャッシュに保持する。
+	slackMetadataCache = lrucache.New(int(getEnvInt64("SLACK_METADATA_CACHE_SIZE", 1000)), slackMetadataCacheTTL())
+
+	slackClientAsBot = newSlackClient(os.Getenv("SLACK_BOT_OAUTH_TOKEN"))
+	slackClientAsUser = newSlackClient(os.Getenv("SLACK_USER_OAUTH_TOKEN"))
+
+	cred := aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(
+		os.Getenv("AWS_ACCESS_KEY_ID_FOR_S3"),
+		os.Getenv("AWS_SECRET_ACCESS_KEY_FOR_S3"),
+		"",
+	))
+
+	sdkconfig, err := config.LoadDefaultConfig(context.TODO(), append(awsConfigOptions(), config.WithCredentialsProvider(cred))...)
+	if err != nil {
+		log.Println("初期設定中にエラーが発生しました。", err)
+	}
+	s3Client = s3.NewFromConfig(sdkconfig, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+
+	s3Config = sdkconfig
+	s3PresignClient = s3.NewPresignClient(s3Client)
+	if region := os.Getenv("REPLICA_REGION"); region != "" {
+		replicaS3Client = s3.NewFromConfig(sdkconfig, func(o *s3.Options) {
+			o.UsePathStyle = true
+			o.Region = region
+		})
+	}
+	if region := os.Getenv("FAILOVER_REGION"); region != "" {
+		failoverS3Client = s3.NewFromConfig(sdkconfig, func(o *s3.Options) {
+			o.UsePathStyle = true
+			o.Region = region
+		})
+	}
+
+	if objectLockMode, err = parseObjectLockMode(os.Getenv("OBJECT_LOCK_MODE")); err != nil {
+		log.Println("初期設定中にエラーが発生しました。", err)
+	}
+
+	// S3以外のAWSサービスには、Lambdaの実行ロールの認証情報を使用する。
+	defaultConfig, err := config.LoadDefaultConfig(context.TODO(), awsConfigOptions()...)
+	if err != nil {
+		log.Println("初期設定中にエラーが発生しました。", err)
+	}
+	// 社内の短縮URLサービスやWebhookには、必要に応じてクライアント証明書と独自の認証局を使用する。
+	internalHTTPClient = httpClient
+	if secretID := os.Getenv("INTERNAL_TLS_SECRET_ID"); secretID != "" {
+		tlsConfig, err := loadTLSConfigFromSecret(secretsmanager.NewFromConfig(defaultConfig), secretID)
+		if err != nil {
+			log.Println("初期設定中にエラーが発生しました。", err)
+		} else {
+			cfg := httpclient.ConfigFromEnv()
+			cfg.TLSConfig = tlsConfig
+			internalHTTPClient = withFaultInjection(withEgressAllowlist(httpclient.New(cfg)))
+		}
+	}
+	// Webhook の受信側が、このサービスからの呼び出しであることを確かめられるよう、Slackと同じ方式でHMACの署名を付ける。
+	webhookHTTPClient = internalHTTPClient
+	if secret := os.Getenv("WEBHOOK_SIGNING_SECRET"); secret != "" {
+		webhookHTTPClient = signature.Wrap(internalHTTPClient, secret)
+	}
+	// SHORTENER_BACKEND=internal の場合は、外部の短縮URLサービスを使わずに、短縮コードの対応を SHORTENER_TABLE に保存する。
+	if os.Getenv("SHORTENER_BACKEND") == "internal" {
+		client := dynamodb.NewFromConfig(defaultConfig)
+		table := os.Getenv("SHORTENER_TABLE")
+		namespaceIndex := getEnvOrDefault("SHORTENER_NAMESPACE_INDEX", "namespace-index")
+		urlShortener, err = urlshortener.NewInternalURLShortener(client, table, namespaceIndex, os.Getenv("SHORTENER_BASE_URL"), shortenerGrace(), shortCodeOptions())
+		if err != nil {
+			// 短縮コードの設定が不正な場合は、既定の設定で生成する。
+			log.Println("初期設定中にエラーが発生しました。", err)
+			urlShortener, _ = urlshortener.NewInternalURLShortener(client, table, namespaceIndex, os.Getenv("SHORTENER_BASE_URL"), shortenerGrace(), urlshortener.DefaultCodeOptions())
+		}
+		shortLinkResolver = urlshortener.NewInternalResolver(client, table)
+	} else {
+		urlShortener = urlshortener.NewURLShortener(internalHTTPClient)
+	}
+	statusPages = newStatusPages()
+	redirectIPBuckets = newRedirectBuckets(dynamodb.NewFromConfig(defaultConfig), "REDIRECT_RATE_LIMIT_PER_IP", "ip/")
+	redirectLinkBuckets = newRedirectBuckets(dynamodb.NewFromConfig(defaultConfig), "REDIRECT_RATE_LIMIT_PER_LINK", "link/")
+	if os.Getenv("SHORTENER_CACHE") != "off" {
+		cache := urlshortener.NewMemoryCache()
+		if table := os.Getenv("SHORTENER_CACHE_TABLE"); table != "" {
+			cache = urlshortener.NewTieredCache(cache, urlshortener.NewDynamoCache(dynamodb.NewFromConfig(defaultConfig), table))
+		}
+		urlShortener = urlshortener.NewCachingURLShortener(urlShortener, cache)
+	}
+
+	// Slackからの受信が NAT ゲートウェイの帯域を使い切らないよう、必要に応じて受信する速さを抑える。
+	downloadLimiter = newDownloadLimiter(dynamodb.NewFromConfig(defaultConfig))
+
+	sfnClient = sfn.NewFromConfig(defaultConfig)
+	lambdaClient = lambdaservice.NewFromConfig(defaultConfig)
+	stsClient = sts.NewFromConfig(defaultConfig)
+	schedulerClient = scheduler.NewFromConfig(defaultConfig)
+
+	if table := os.Getenv("APPROVAL_TABLE"); table != "" {
+		approvalStore = approval.NewStore(dynamodb.NewFromConfig(defaultConfig), table)
+	}
+	kmsClient = kms.NewFromConfig(defaultConfig)
+	if table := os.Getenv("TOKEN_REGISTRY_TABLE"); table != "" {
+		tokenRegistry = tokenstore.NewRegistry(dynamodb.NewFromConfig(defaultConfig), kmsClient, table, os.Getenv("TOKEN_KMS_KEY_ID"))
+	}
+	if keyID := os.Getenv("MANIFEST_KMS_KEY_ID"); keyID != "" {
+		manifestSigner = manifest.NewSigner(kmsClient, keyID, getEnvOrDefault("MANIFEST_SIGNING_ALGORITHM", "ECDSA_SHA_256"))
+	}
+	if table := os.Getenv("IDEMPOTENCY_TABLE"); table != "" {
+		lease, err := parseDuration(getEnvOrDefault("IDEMPOTENCY_LEASE", "3m"))
+		if err != nil {
+			log.Println("初期設定中にエラーが発生しました。", err)
+			lease = 3 * time.Minute
+		}
+		idempotencyStore = idempotency.NewStore(dynamodb.NewFromConfig(defaultConfig), table, lease, 24*time.Hour)
+	}
+	// 短縮URLサービスやSlackのレート制限を守るため、組織全体で同時に処理する大きなファイルの数を制限する。
+	if limit := getEnvInt64("LARGE_FILE_CONCURRENCY", 0); limit > 0 {
+		if table := os.Getenv("SEMAPHORE_TABLE"); table != "" {
+			lease, err := parseDuration(getEnvOrDefault("LARGE_FILE_LEASE", "15m"))
+			if err != nil {
+				log.Println("初期設定中にエラーが発生しました。", err)
+				lease = 15 * time.Minute
+			}
+			largeFileSemaphore = semaphore.NewDynamoSemaphore(dynamodb.NewFromConfig(defaultConfig), table, "large-file", int(limit), lease)
+		}
+	}
+	if table := os.Getenv("AUDIT_TABLE"); table != "" {
+		auditStore = audit.NewStore(dynamodb.NewFromConfig(defaultConfig), table, getEnvOrDefault("AUDIT_SHORT_URL_INDEX", "short_url-index"), getEnvOrDefault("AUDIT_SHA256_INDEX", "sha256-index"))
+	}
+	if table := os.Getenv("GLACIER_RESTORE_TABLE"); table != "" {
+		glacierStore = glacier.NewStore(dynamodb.NewFromConfig(defaultConfig), table)
+	}
+	if table := os.Getenv("OTP_TABLE"); table != "" {
+		otpStore = otp.NewStore(dynamodb.NewFromConfig(defaultConfig), table)
+	}
+
+	if os.Getenv("DLP_PROVIDER") == "google" {
+		minLikelihood, err := dlp.ParseLikelihood(getEnvOrDefault("DLP_MIN_LIKELIHOOD", "POSSIBLE"))
+		if err != nil {
+			log.Println("初期設定中にエラーが発生しました。", err)
+		}
+		dlpInspector = dlp.NewGoogleInspector(
+			httpClient,
+			os.Getenv("GOOGLE_DLP_PROJECT_ID"),
+			os.Getenv("GOOGLE_DLP_API_KEY"),
+			minLikelihood,
+		)
+	}
+
+	// 起動時にバケットが公開されていないことを確認する。公開されている場合は、URLを発行しない。
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		ensureBucketPrivate(context.TODO(), s3Client, bucket)
+	}
+}
+
+// withEgressAllowlist は、EGRESS_ALLOWLIST が設定されている場合に、含まれないホストへの通信を拒否する http.Client を返します。
+// 攻撃者が指定したURLに接続させられる（SSRF）ことを防ぐため、Slack、S3、短縮URLサービスなど、通信先のホストを
+// 「slack.com,slack-edge.com,*.amazonaws.com,short.example.com」のようにカンマ区切りで指定してください。
+func withEgressAllowlist(client *http.Client) *http.Client {
+	allowlist := egress.ParseAllowlist(os.Getenv("EGRESS_ALLOWLIST"))
+	if len(allowlist) == 0 {
+		return client
+	}
+	return egress.Wrap(client, allowlist)
+}
+
+// withFaultInjection は、FAULT_INJECTION が設定されている場合に、規則に従って障害を注入する http.Client を返します。
+// Slackの500エラー、S3のスロットリング、短縮URLサービスのタイムアウトなどを模擬し、再試行やサーキットブレーカー、DLQの動作を検証するためのものです。
+// 本番環境で誤って有効にならないよう、DEPLOY_ENV に production 以外の環境名が設定されている場合にのみ有効にします。
+func withFaultInjection(client *http.Client) *http.Client {
+	spec := os.Getenv("FAULT_INJECTION")
+	if spec == "" {
+		return client
+	}
+	env := os.Getenv("DEPLOY_ENV")
+	if env == "" || env == "production" {
+		log.Println("本番環境のため FAULT_INJECTION を無視します。")
+		return client
+	}
+	rules, err := faultinject.ParseRules(spec)
+	if err != nil {
+		log.Println("初期設定中にエラーが発生しました。", err)
+		return client
+	}
+	log.Println("障害の注入を有効にしました。", env, len(rules))
+	return faultinject.Wrap(client, rules)
+}
+
+// newSlackClient は、共通の http.Client を使う Slack のクライアントを生成します。
+// SLACK_API_URL が設定されている場合は、Slack API の代わりにそのURLに接続します（結合テスト用）。
+func newSlackClient(token string) *slack.Client {
+	options := []slack.Option{slack.OptionHTTPClient(httpClient)}
+	if apiURL := os.Getenv("SLACK_API_URL"); apiURL != "" {
+		options = append(options, slack.OptionAPIURL(apiURL))
+	}
+	return slack.New(token, options...)
+}
+
+// awsConfigOptions は、AWS のクライアントに共通の設定を返します。
+// AWS_ENDPOINT_URL が設定されている場合は、すべてのサービスをそのURLに接続します（localstack などの結合テスト用）。
+func awsConfigOptions() []func(*config.LoadOptions) error {
+	options := []func(*config.LoadOptions) error{config.WithHTTPClient(httpClient)}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		options = append(options, config.WithEndpointResolverWithOptions(aws.EndpointResolverWithOptionsFunc(
+			func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: endpoint, HostnameImmutable: true, SigningRegion: region}, nil
+			},
+		)))
+	}
+	return options
+}
+
+// loadTLSConfigFromSecret は、Secrets Manager に保存された証明書一式（httpclient.TLSMaterial のJSON）から
+// tls.Config を生成します。
+func loadTLSConfigFromSecret(client *secretsmanager.Client, secretID string) (*tls.Config, error) {
+	out, err := client.GetSecretValue(context.TODO(), &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var material httpclient.TLSMaterial
+	if err := json.Unmarshal([]byte(aws.ToString(out.SecretString)), &material); err != nil {
+		return nil, err
+	}
+	return material.TLSConfig()
+}
+
+// getEnvOrDefault は、環境変数の値を返します。未設定の場合は defaultValue を返します。
+func getEnvOrDefault(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// getEnvInt64 は、環境変数の値を整数として返します。設定されていないか不正な値の場合は defaultValue を返します。
+func getEnvInt64(key string, defaultValue int64) int64 {
+	n, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
+
+// splitEnvList は、カンマ区切りの環境変数を空要素を除いたスライスとして返します。
+func splitEnvList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+type SlackAppMentionEventRequest struct {
+	Event SlackAppMentionEvent `json:"event"`
+}
+
+type SlackAppMentionEvent struct {
+	Files []SlackAppMentionEventFile `json:"files"`
+}
+
+type SlackAppMentionEventFile struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	URLPrivateDownload string `json:"url_private_download"`
+	Size               int64  `json:"size"`
+	User               string `json:"user"`
+	UserTeam           string `json:"user_team"`               // 共有チャンネルでアップロードしたユーザーの所属チーム
+	OriginalName       string `json:"original_name,omitempty"` // name= で名前を変更した場合の、Slackでの元のファイル名
+	OriginalSize       int64  `json:"original_size,omitempty"` // recompress=on で圧縮し直した場合の、圧縮し直す前のサイズ
+	Binary             []byte `json:"-"`                       // Slackからファイルを取得した際、取得したファイルのバイナリデータが格納されます。
+}
+
+// parseAppMentionEventRequest は、AppMentionイベントのリクエストボディを解析し、処理に必要な項目が揃っているか検証します。
+// Slackのペイロードの形が変わった場合に、項目が空のまま処理を進めないよう、欠けている項目をエラーで返します。
+func parseAppMentionEventRequest(body string) (*SlackAppMentionEventRequest, error) {
+	var req *SlackAppMentionEventRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return nil, fmt.Errorf("unable to parse app_mention event, %s", err)
+	}
+	if req == nil {
+		return nil, errors.New("unable to parse app_mention event, body is null")
+	}
+	for i, file := range req.Event.Files {
+		missing := ""
+		switch {
+		case file.ID == "":
+			missing = "id"
+		case file.Name == "":
+			missing = "name"
+		case file.URLPrivateDownload == "":
+			missing = "url_private_download"
+		case file.Size < 0:
+			return nil, fmt.Errorf("invalid app_mention event, event.files[%d].size is negative", i)
+		}
+		if missing != "" {
+			return nil, fmt.Errorf("invalid app_mention event, event.files[%d].%s is missing", i, missing)
+		}
+	}
+	return req, nil
+}
+
+// parseDuration は、time.ParseDuration に加えて「30d」のような日単位の指定を解釈します。
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		// 桁あふれして短い期間や負の期間にならないよう、time.Duration で表せない日数は拒否する。
+		const day = 24 * time.Hour
+		if n > int(math.MaxInt64/day) || n < int(math.MinInt64/day) {
+			return 0, fmt.Errorf("duration %q is out of range", s)
+		}
+		return time.Duration(n) * day, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// verificationError は、リクエストの署名の検証に失敗した理由を表します。
+type verificationError struct {
+	reason  string // メトリクスのラベルに使う理由
+	message string
+}
+
+func (e *verificationError) Error() string {
+	return e.message
+}
+
+// verifyRequest は、SlackAPIからのリクエストが正当なものかどうかを検証します。
+// 検証にはシークレットキーを使用し、正当性を確認します。
+// ・X-Slack-Request-Timestamp と現在時刻の差が SLACK_REQUEST_MAX_SKEW（デフォルト 5m）以内であること
+// ・X-Slack-Signature がシークレットキーで計算した署名と一定時間の比較で一致すること
+// API Gateway はヘッダー名を小文字に変換することがあるため、ヘッダー名の大文字・小文字は区別しません。
+// headers: SlackAPIから受信したリクエストヘッダー
+// body: SlackAPIから受信したリクエストボディ
+// エラーがなければnilを返し、検証に失敗した場合は理由ごとにメトリクスを記録してエラーを返します。
+func verifyRequest(headers map[string]string, body string) error {
+	err := checkRequestSignature(headers, body, time.Now())
+	var verr *verificationError
+	if errors.As(err, &verr) {
+		metrics.ObserveVerificationFailure(verr.reason)
+	}
+	return err
+}
+
+func checkRequestSignature(headers map[string]string, body string, now time.Time) error {
+	timestampHeader := headerValue(headers, "X-Slack-Request-Timestamp")
+	signatureHeader := headerValue(headers, "X-Slack-Signature")
+	if timestampHeader == "" || signatureHeader == "" {
+		return &verificationError{reason: "missing_header", message: "missing signature headers"}
+	}
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return &verificationError{reason: "invalid_timestamp", message: fmt.Sprintf("invalid request timestamp %q", timestampHeader)}
+	}
+	maxSkew, err := parseDuration(getEnvOrDefault("SLACK_REQUEST_MAX_SKEW", "5m"))
+	if err != nil {
+		return err
+	}
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		return &verificationError{reason: "stale_timestamp", message: fmt.Sprintf("request timestamp is off by %s", skew)}
+	}
+
+	if !signature.Verify(os.Getenv("SLACK_SIGHNG_SECRET"), timestamp, []byte(body), signatureHeader) {
+		return &verificationError{reason: "invalid_signature", message: "request signature mismatch"}
+	}
+	return nil
+}
+
+// headerValue は、ヘッダー名の大文字・小文字を区別せずにヘッダーの値を返します。
+// API Gateway の HTTP API ではヘッダー名が小文字に変換されて渡されるためです。
+func headerValue(headers map[string]string, key string) string {
+	if v, ok := headers[key]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// decodeRequestBody は、API Gateway から渡されたリクエストボディを元の文字列に戻します。
+// isBase64Encoded が true の場合は base64 をデコードし、Content-Encoding が gzip または deflate の場合は展開します。
+// Slackの署名は展開後のボディに対して計算されるため、検証の前に呼び出してください。
+func decodeRequestBody(r events.APIGatewayProxyRequest) (string, error) {
+	body := []byte(r.Body)
+	if r.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(r.Body)
+		if err != nil {
+			return "", fmt.Errorf("unable to decode base64 body, %s", err)
+		}
+		body = decoded
+	}
+
+	var reader io.ReadCloser
+	switch encoding := strings.ToLower(strings.TrimSpace(headerValue(r.Headers, "Content-Encoding"))); encoding {
+	case "", "identity":
+		return string(body), nil
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("unable to read gzip body, %s", err)
+		}
+		reader = zr
+	case "deflate":
+		// HTTP の deflate は zlib 形式だが、ヘッダーのない raw deflate を送るクライアントもある。
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			reader = flate.NewReader(bytes.NewReader(body))
+		} else {
+			reader = zr
+		}
+	default:
+		return "", fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("unable to decompress body, %s", err)
+	}
+	return string(decoded), nil
+}
+
+// handleURLVerification は、Slack APIからのURL検証リクエストを処理します。
+// body: SlackAPIから受信したリクエストボディ
+// URL検証リクエストが正常に処理された場合、APIGatewayProxyResponseとnilのエラーを返します。
+// エラーが発生した場合、適切なAPIGatewayProxyResponseとエラーを返します。
+func handleURLVerification(body string) (events.APIGatewayProxyResponse, error) {
+	var cr *slackevents.ChallengeResponse
+	if err := json.Unmarshal([]byte(body), &cr); err != nil {
+		log.Println("SlackAPIからのURL検証用中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: cr.Challenge}, nil
+}
+
+// objectExists は、S3バケットに指定したキーのオブジェクトが存在するかどうかを返します。
+func objectExists(client *s3.Client, bucket, key string) (bool, error) {
+	_, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+	if isObjectNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// isObjectNotFound は、err がオブジェクトが存在しないことを表すかを返します。
+// HeadObject は本文のない 404 を返すため、SDK は types.NotFound ではなくステータスコードだけを持つエラーを返します。
+func isObjectNotFound(err error) bool {
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var status interface{ HTTPStatusCode() int }
+	return errors.As(err, &status) && status.HTTPStatusCode() == http.StatusNotFound
+}
+
+// errObjectAlreadyExists は、COLLISION_STRATEGY が「reject」で同名のオブジェクトが既に存在する場合に返されます。
+var errObjectAlreadyExists = validationError("同名のファイルが既にアップロードされています。ファイル名を変更してください。")
+
+// maxSuffixAttempts は、COLLISION_STRATEGY=suffix で連番を付けて空いているキーを探す回数の上限です。
+// 同名のファイルが大量にある場合に、S3への問い合わせが際限なく続かないようにします。
+const maxSuffixAttempts = 100
+
+// resolveObjectKey は、COLLISION_STRATEGY に従ってアップロード先のS3キーを決定します。
+// ・overwrite（デフォルト）: 同名のオブジェクトを上書きします。
+// ・reject: 同名のオブジェクトが存在する場合は errObjectAlreadyExists を返します。
+// ・version: 上書きします。バケットのバージョニングを有効にして利用します。
+// ・suffix: 同名のオブジェクトが存在する場合は「name-1.zip」のように連番を付けます。maxSuffixAttempts 回試しても空いているキーが見つからない場合は errObjectAlreadyExists を返します。
+func resolveObjectKey(client *s3.Client, bucket, name string) (string, error) {
+	switch os.Getenv("COLLISION_STRATEGY") {
+	case "reject":
+		exists, err := objectExists(client, bucket, name)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return "", errObjectAlreadyExists
+		}
+		return name, nil
+	case "suffix":
+		// 連番は「.tar.gz」のような二重の拡張子の前に付け、アーカイブの形式を変えない。
+		base, _, _ := archive.SplitExt(name)
+		ext := strings.TrimPrefix(name, base)
+		if ext == "" {
+			ext = path.Ext(name)
+			base = strings.TrimSuffix(name, ext)
+		}
+		key := name
+		for i := 1; i <= maxSuffixAttempts; i++ {
+			exists, err := objectExists(client, bucket, key)
+			if err != nil {
+				return "", err
+			}
+			if !exists {
+				return key, nil
+			}
+			key = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		return "", errObjectAlreadyExists
+	default:
+		return name, nil
+	}
+}
+
+// presignExpiry は、署名付きURLの有効期限です。SigV4 の署名付きURLで指定できる最大の期間です。
+const presignExpiry = 7 * 24 * time.Hour
+
+// uploadedObject は、S3にアップロードしたオブジェクトと、その署名付きURLの情報です。
+type uploadedObject struct {
+	Bucket       string
+	Key          string
+	VersionID    string
+	Region       string // アップロードしたバケットのリージョン。空の場合はプライマリのリージョン
+	TeamID       string // チームのIAMロールで操作するためのチーム
+	EnterpriseID string
+	Expiry       time.Duration // 署名付きURLの有効期限。0 の場合は presignExpiry
+	PresignedURL string
+	ExpiresAt    time.Time
+}
+
+// uploadFileToS3AndGetPresignedURL は、Slackから取得したファイルをS3にアップロードし、
+// 署名付きURLを生成して返します。
+// file: アップロードするSlackファイルオブジェクトへのポインタ
+// opts: メンションで指定されたオプション。retain が指定された場合は S3 Object Lock の保持期間を設定します。
+// bucket が指定された場合はそのバケットに、expiry が指定された場合はその有効期限で署名付きURLを生成します。
+// ws にチームのIAMロールが登録されている場合は、そのロールでチームのバケットにアップロードします。
+// 成功時にはアップロードしたオブジェクトの情報とnilのエラーを返します。
+// エラーが発生した場合、nilとエラーを返します。
+func uploadFileToS3AndGetPresignedURL(ws *workspace, file *SlackAppMentionEventFile, opts *mentionOptions) (*uploadedObject, error) {
+	client, bucket := uploadTarget(ws, opts)
+	if err := ensureBucketPrivate(context.TODO(), client, bucket); err != nil {
+		return nil, err
+	}
+	key, err := resolveObjectKey(client, bucket, file.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	putInput := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(file.Binary),
+		ContentType: aws.String(contentTypeOf(file.Name)),
+	}
+	// 連番を付けたキーからも元のファイル名を復元できるよう、キーと異なる場合はメタデータに残す。
+	originalName := file.OriginalName
+	if originalName == "" && key != file.Name {
+		originalName = file.Name
+	}
+	if originalName != "" {
+		// S3のメタデータにはASCII文字しか使えないため、元のファイル名はエスケープして保存する。
+		putInput.Metadata = map[string]string{"original-name": url.PathEscape(originalName)}
+		putInput.ContentDisposition = aws.String(fmt.Sprintf(`attachment; filename="%s"`, file.Name))
+	}
+	applyRetentionClass(opts.Class, &putInput.StorageClass, &putInput.Tagging)
+	if opts.Retain > 0 {
+		// Object Lock を指定する場合は Content-MD5 が必須となる。
+		sum := md5.Sum(file.Binary)
+		putInput.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+		putInput.ObjectLockMode = objectLockMode
+		putInput.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(opts.Retain))
+	}
+
+	// ファイルをS3にアップロードする。
+	// MULTIPART_UPLOAD_THRESHOLD 以上のファイルは、マルチパートアップロードで複数のパートを並列に送信する。
+	// Object Lock を指定する場合はパートごとに Content-MD5 が必要となるため、PutObject で送信する。
+	// プライマリのバケットへのアップロードに失敗した場合は、FAILOVER_BUCKET にアップロードし直す。
+	var versionID *string
+	threshold := getEnvInt64("MULTIPART_UPLOAD_THRESHOLD", 64<<20)
+	bucket, region, err := uploadWithFailover(ws, bucket, opts, func(ctx context.Context, client *s3.Client, bucket string) error {
+		putInput.Bucket = aws.String(bucket)
+		putInput.Body = bytes.NewReader(file.Binary)
+		if threshold > 0 && int64(len(file.Binary)) >= threshold && opts.Retain == 0 {
+			uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+				u.Concurrency = int(getEnvInt64("MULTIPART_UPLOAD_CONCURRENCY", 4))
+				u.PartSize = getEnvInt64("MULTIPART_UPLOAD_PART_SIZE", memoryBudget.PartSize(u.Concurrency))
+			})
+			out, err := uploader.Upload(ctx, putInput)
+			if err != nil {
+				return err
+			}
+			versionID = out.VersionID
+			return nil
+		}
+		out, err := client.PutObject(ctx, putInput)
+		if err != nil {
+			return err
+		}
+		versionID = out.VersionId
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if versionID == nil && os.Getenv("COLLISION_STRATEGY") == "version" {
+		log.Println("バケットのバージョニングが有効ではないため、バージョンを指定せずに署名付きURLを生成します。")
+	}
+
+	uploaded := &uploadedObject{
+		Bucket:       bucket,
+		Key:          key,
+		VersionID:    aws.ToString(versionID),
+		Region:       region,
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+		Expiry:       opts.Expiry,
+	}
+	if err := presignObject(uploaded); err != nil {
+		return nil, err
+	}
+	return uploaded, nil
+}
+
+// presignObject は、アップロード済みのオブジェクトの署名付きURLを生成し、PresignedURL と ExpiresAt を設定します。
+// バケットが公開されている場合は、署名付きURLを生成せずにエラーを返します。
+// バージョニングが有効なバケットでは、後から上書きされても共有済みのURLの内容が変わらないよう、
+// アップロードしたバージョンを指す署名付きURLを生成します。
+func presignObject(uploaded *uploadedObject) error {
+	client, err := s3ClientFor(uploaded.TeamID, uploaded.EnterpriseID, uploaded.Region)
+	if err != nil {
+		return err
+	}
+	// 公開されているバケットのオブジェクトは、URLを知らなくても取得できるため共有しない。
+	if err := ensureBucketPrivate(context.TODO(), client, bucketOrDefault(uploaded.Bucket)); err != nil {
+		return err
+	}
+	// セカンダリのリージョンやチームのバケットにアップロードしたオブジェクトは、そのクライアントで署名する。
+	if client != s3Client {
+		return presignObjectWith(s3.NewPresignClient(client), uploaded)
+	}
+	return presignObjectWith(s3PresignClient, uploaded)
+}
+
+// presignObjectWith は、presignClient で署名付きURLを生成する presignObject です。
+func presignObjectWith(presignClient *s3.PresignClient, uploaded *uploadedObject) error {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketOrDefault(uploaded.Bucket)),
+		Key:    aws.String(uploaded.Key),
+	}
+	if uploaded.VersionID != "" {
+		input.VersionId = aws.String(uploaded.VersionID)
+	}
+
+	expiry := uploaded.Expiry
+	if expiry <= 0 {
+		expiry = presignExpiry
+	}
+	pr, err := presignClient.PresignGetObject(context.TODO(), input, func(opts *s3.PresignOptions) {
+		opts.Expires = expiry
+	})
+	if err != nil {
+		return err
+	}
+
+	uploaded.PresignedURL = pr.URL
+	uploaded.ExpiresAt = time.Now().Add(expiry)
+	return nil
+}
+
+// deleteObject は、アップロード済みのオブジェクトを client で削除します。bucket が空の場合は S3_BUCKET から削除します。
+func deleteObject(client *s3.Client, bucket, key, versionID string) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucketOrDefault(bucket)),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	_, err := client.DeleteObject(context.TODO(), input)
+	return err
+}
+
+// validateFile は、指定された SlackAppMentionEventFile が以下の条件を満たすか確認します。
+// ・ファイルが ARCHIVE_FORMATS で受け付ける形式（デフォルトは zip）であること
+// ・ファイル名が半角英数字であること（POLICY_LOG_ONLY に「file_name」を指定した場合は、違反を記録して続行します）
+// ・ファイル名が maxFileNameLength 以内であること
+// ・内容を取得済みの場合は、アーカイブとして開けて、圧縮爆弾ではないこと
+// 条件を満たさない場合はエラーを返します。ev は規則の違反を記録するときの依頼で、nil でも構いません。
+func validateFile(ev *slackevents.AppMentionEvent, file *SlackAppMentionEventFile) error {
+	// 拡張子を確認してから取り除く。4文字未満のファイル名でも範囲外を参照しないよう、先に拡張子を確認する。
+	base, format, ok := archive.SplitExt(file.Name)
+	if !ok || !archiveFormatAllowed(format) {
+		var names []string
+		for _, f := range allowedArchiveFormats() {
+			names = append(names, string(f))
+		}
+		return fmt.Errorf("ファイルは「%s」形式にしてください。", strings.Join(names, "」「"))
+	}
+
+	if !isValidFileName(base) {
+		if err := enforcePolicy(ev, policyFileName, errors.New("ファイル名は「半角英数字」にしてください。")); err != nil {
+			return err
+		}
+	}
+
+	if len(file.Name) > maxFileNameLength {
+		return fmt.Errorf("ファイル名は%d文字以内にしてください。", maxFileNameLength)
+	}
+
+	// 内容を取得済みの場合は、アーカイブを開けるかと、圧縮爆弾でないかを検査する。
+	if file.Binary != nil {
+		return inspectArchive(file)
+	}
+	return nil
+}
+
+// maxFileNameLength は、ファイル名の最大の長さです。
+// 連番（suffix）や「.manifest.json」などを付けても、S3のキーの上限（1024バイト）を超えない長さにしています。
+const maxFileNameLength = 255
+
+// isValidFileName は、拡張子を除いたファイル名が半角英数字、「_」、「-」のみで構成されているかを返します。
+var isValidFileName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`).MatchString
+
+// scanFileWithDLP は、zipを展開したテキストファイルをDLPで検査し、
+// 確度が DLP_BLOCK_LIKELIHOOD 以上の機密情報が見つかった場合はエラーを返します。
+// 管理者（ADMIN_USER_IDS）が dlp=override を指定した場合は、検出があっても公開を許可します。
+// 暗号化されたエントリや 7z、rar のエントリは検査せずに記録し、DLP_UNINSPECTABLE が「block」の場合のみ公開を拒否します。
+// DLPが設定されていない場合は何もせずにnilを返します。POLICY_LOG_ONLY に「dlp」を指定した場合は、検出を記録して公開を許可します。
+func scanFileWithDLP(ev *slackevents.AppMentionEvent, file *SlackAppMentionEventFile, opts *mentionOptions) error {
+	if dlpInspector == nil {
+		return nil
+	}
+
+	blockLikelihood, err := dlp.ParseLikelihood(getEnvOrDefault("DLP_BLOCK_LIKELIHOOD", "LIKELY"))
+	if err != nil {
+		return err
+	}
+
+	entries, err := archiveEntries(file)
+	if err != nil {
+		return err
+	}
+
+	var findings []dlp.Finding
+	for _, entry := range entries {
+		content, err := entry.ReadAll()
+		if errors.Is(err, archive.ErrContentUnavailable) {
+			if os.Getenv("DLP_UNINSPECTABLE") == "block" {
+				return errContentNotInspectable
+			}
+			log.Println("内容を展開できないため、DLPで検査せずに公開します。", file.ID, entry.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !archive.IsText(content) {
+			continue
+		}
+		fs, err := dlpInspector.Inspect(context.TODO(), entry.Name, content)
+		if err != nil {
+			return err
+		}
+		findings = append(findings, fs...)
+	}
+
+	if dlp.MaxLikelihood(findings) < blockLikelihood {
+		return nil
+	}
+
+	if opts.DLPOverride && isAdminUser(ev.User) {
+		log.Println("管理者の承認によりDLPの検出を無視して公開します。", ev.User, findings)
+		return nil
+	}
+
+	var details []string
+	for _, f := range findings {
+		if f.Likelihood >= blockLikelihood {
+			details = append(details, fmt.Sprintf("・%s（%s）", f.Location, f.InfoType))
+		}
+	}
+	return enforcePolicy(ev, policyDLP, &dlpViolationError{details: details})
+}
+
+// secretRuleUninspectable は、内容を展開できないため検査しなかったエントリを表す secretscan.Finding の RuleID です。
+const secretRuleUninspectable = "uninspectable"
+
+// uninspectableSummaryLimit は、警告に名前を並べる検査しなかったエントリの上限です。
+const uninspectableSummaryLimit = 10
+
+// scanFileForSecrets は、zip内のテキストファイルからAPIキーや秘密鍵などのシークレットの候補を検出します。
+// SECRET_SCAN_MODE が「off」の場合は検査を行いません。
+func scanFileForSecrets(file *SlackAppMentionEventFile) ([]secretscan.Finding, error) {
+	if os.Getenv("SECRET_SCAN_MODE") == "off" {
+		return nil, nil
+	}
+
+	entries, err := archiveEntries(file)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := secretscan.NewScanner()
+	var findings []secretscan.Finding
+	for _, entry := range entries {
+		content, err := entry.ReadAll()
+		if errors.Is(err, archive.ErrContentUnavailable) {
+			// 7z と rar、暗号化された zip のエントリは内容を検査できないため、ブロックする設定の場合は公開を拒否する。
+			// それ以外の場合も、検査せずに通過させたことが分かるよう警告に含める。
+			if os.Getenv("SECRET_SCAN_MODE") == "block" {
+				return nil, errContentNotInspectable
+			}
+			findings = append(findings, secretscan.Finding{RuleID: secretRuleUninspectable, File: entry.Name})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !archive.IsText(content) {
+			continue
+		}
+		findings = append(findings, scanner.Scan(entry.Name, content)...)
+	}
+	return findings, nil
+}
+
+// watermarkPDFs は、zip内のPDFの各ページに「Shared via <team> for <channel> on <date>」をスタンプします。
+// PDF_WATERMARK が「true」の場合のみ処理を行い、file.Binary をスタンプ後のzipに置き換えます。
+func watermarkPDFs(ws *workspace, ev *slackevents.AppMentionEvent, file *SlackAppMentionEventFile) error {
+	// スタンプを押したPDFで置き換えられるのは zip のみ。
+	if os.Getenv("PDF_WATERMARK") != "true" || archiveFormatOf(file.Name) != archive.FormatZip {
+		return nil
+	}
+
+	team, err := ws.Bot.GetTeamInfo()
+	if err != nil {
+		return err
+	}
+	channel, err := cachedConversationInfo(context.TODO(), ws, ev.Channel)
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("Shared via %s for #%s on %s", team.Name, channel.Name, time.Now().Format("2006-01-02"))
+
+	binary, err := archive.Rewrite(file.Binary, func(name string, content []byte) ([]byte, error) {
+		if !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+			return nil, nil
+		}
+		return watermark.StampPDF(content, text)
+	})
+	if err != nil {
+		return err
+	}
+	file.Binary = binary
+	return nil
+}
+
+// dlpViolationError は、DLPの検査で公開を拒否すべき機密情報が見つかったことを表します。
+type dlpViolationError struct {
+	details []string
+}
+
+func (e *dlpViolationError) Error() string {
+	return "機密情報が含まれている可能性があるため公開できません。管理者の承認が必要です。\n" + strings.Join(e.details, "\n")
+}
+
+// formatSizeSummary は、ファイルサイズと料金の目安をSlackに表示する文字列にします。
+// 単価表は PRICING_TABLE（JSON）で変更できます。
+func formatSizeSummary(size int64) string {
+	pricing, err := cost.ParsePricing(os.Getenv("PRICING_TABLE"))
+	if err != nil {
+		log.Println("料金表の読み込み中にエラーが発生しました。デフォルトの料金表を使用します。", err)
+	}
+	storage, egress := pricing.Estimate(size)
+	return fmt.Sprintf(
+		"サイズ: %s / 保管料金の目安: %s/月 / 転送料金の目安: %s/ダウンロード",
+		cost.HumanSize(size), pricing.FormatAmount(storage), pricing.FormatAmount(egress),
+	)
+}
+
+// recordAudit は、発行したURLを監査記録として AUDIT_TABLE に保存します。
+// URLを発行したすべての経路から呼び出されるため、after-publish のフックとチャンネルの「Latest build」の更新もここで実行します。
+// record の ID と CreatedAt はこの関数で設定します。
+// 保存に失敗してもURLは共有済みのため、ログに出力するのみとします。
+func recordAudit(record *audit.Record) {
+	runHooks(hooks.StageAfterPublish, &hooks.Event{
+		TeamID:       record.TeamID,
+		EnterpriseID: record.EnterpriseID,
+		Channel:      record.Channel,
+		User:         record.User,
+		FileName:     record.FileName,
+		Size:         record.Size,
+		Bucket:       record.Bucket,
+		ObjectKey:    record.ObjectKey,
+		VersionID:    record.VersionID,
+		ShortURL:     record.ShortURL,
+		ExpiresAt:    record.ExpiresAt,
+		Note:         record.Note,
+	})
+	if auditStore == nil {
+		updateLatestBuild(record)
+		return
+	}
+
+	// 監査の担当者がリンクから元の会話を辿れるよう、依頼したメッセージのパーマリンクを記録する。
+	if record.Permalink == "" && record.MessageTS != "" {
+		ws, err := resolveWorkspace(record.TeamID, record.EnterpriseID)
+		var permalink string
+		if err == nil {
+			permalink, err = ws.Bot.GetPermalinkContext(context.TODO(), &slack.PermalinkParameters{Channel: record.Channel, Ts: record.MessageTS})
+		}
+		if err != nil {
+			log.Println("メッセージのパーマリンクの取得中にエラーが発生しました。", err)
+		}
+		record.Permalink = permalink
+	}
+
+	// 受取人の確認ページのように、リンクに含めるためIDを先に決めている場合はそのIDで保存する。
+	if record.ID == "" {
+		id, err := audit.NewID()
+		if err != nil {
+			log.Println("監査記録の保存中にエラーが発生しました。", err)
+			return
+		}
+		record.ID = id
+	}
+	record.CreatedAt = time.Now().Unix()
+	if err := auditStore.Put(context.TODO(), record); err != nil {
+		log.Println("監査記録の保存中にエラーが発生しました。", err)
+	}
+	updateLatestBuild(record)
+}
+
+// formatPublishedMessage は、発行した短縮URLをSlackに送信するメッセージにします。
+// warnings: シークレットの検出結果など、依頼者に確認を促す警告（空の場合は含めない）
+func formatPublishedMessage(shortURL string, size int64, warnings string) string {
+	message := shortURL + "\n" + formatSizeSummary(size)
+	if warnings != "" {
+		message += "\n" + warnings
+	}
+	return message
+}
+
+// escapeMrkdwn は、ユーザーが入力した文字列をSlackのmrkdwnでそのまま表示できるようにエスケープします。
+func escapeMrkdwn(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// sendErrorToSlack は、エラーメッセージをSlackのチャンネルに送信します。
+// ws: イベントが発生したワークスペース
+// ev: AppMentionEventオブジェクトへのポインタ。エラーが発生したイベント情報を含む。
+// メンションのステータスメッセージがある場合は、新しく投稿せずにステータスメッセージをエラーメッセージに書き換えます。
+// 関数はエラーの送信成功時と失敗時の両方で、何も返しません。
+func sendErrorToSlack(ws *workspace, ev *slackevents.AppMentionEvent, errorMessage string) {
+	if status := lookupMentionStatus(ev.Channel, ev.TimeStamp); status != nil {
+		status.fail(errorMessage)
+		return
+	}
+	if _, _, err := ws.Bot.PostMessage(
+		ev.Channel,
+		slack.MsgOptionText(errorMessage, false),
+		slack.MsgOptionTS(ev.TimeStamp),
+	); err != nil {
+		log.Println("エラーメッセージをSlackに送信中にエラーが発生しました。", err)
+	}
+}
+
+// publishedFile は、S3へのアップロードまで完了し、短縮URLの発行とSlackへの送信を待つファイルです。
+type publishedFile struct {
+	file           *SlackAppMentionEventFile
+	uploaded       *uploadedObject
+	secretFindings []secretscan.Finding
+}
+
+// warnings は、依頼者への返信に含める警告を返します。
+func (p *publishedFile) warnings() string {
+	var secrets []secretscan.Finding
+	var uninspectable []string
+	for _, f := range p.secretFindings {
+		if f.RuleID == secretRuleUninspectable {
+			uninspectable = append(uninspectable, f.File)
+			continue
+		}
+		secrets = append(secrets, f)
+	}
+
+	var warnings []string
+	if len(secrets) > 0 {
+		warnings = append(warnings, ":warning: シークレットの可能性がある文字列が見つかりました。共有してよい内容か確認してください。\n"+secretscan.Summary(secrets))
+	}
+	if len(uninspectable) > 0 {
+		warnings = append(warnings, fmt.Sprintf(":warning: 暗号化されているか、内容を展開できない形式のため、次の %d 件のファイルはシークレットの検査をしていません。共有してよい内容か確認してください。\n%s", len(uninspectable), summarizeNames(uninspectable, uninspectableSummaryLimit)))
+	}
+	return strings.Join(warnings, "\n")
+}
+
+// summarizeNames は、names を1行ずつ limit 件まで並べ、残りは件数のみを示します。
+func summarizeNames(names []string, limit int) string {
+	var lines []string
+	for i, name := range names {
+		if i == limit {
+			lines = append(lines, fmt.Sprintf("・ほか %d 件", len(names)-limit))
+			break
+		}
+		lines = append(lines, "・"+name)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleAppMentionEvent は、AppMentionイベントを処理します。
+// この関数は、SlackファイルをS3にアップロードし、署名付きURLを生成してSlackチャンネルに送信します。
+// 最後に、アップロードされたファイルをSlackから削除します。
+// ws: イベントが発生したワークスペース
+// ev: AppMentionイベントへのポインタ。イベント情報を含む。
+// body: SlackAPIから受信したリクエストボディ
+// AppMentionイベントが正常に処理された場合、APIGatewayProxyResponseとnilのエラーを返します。
+// エラーが発生した場合、エラーメッセージをSlackチャンネルに送信し、適切なAPIGatewayProxyResponseとエラーを返します。
+func handleAppMentionEvent(ws *workspace, ev *slackevents.AppMentionEvent, body string) (events.APIGatewayProxyResponse, error) {
+	if ev.Channel == "" || ev.User == "" || ev.TimeStamp == "" {
+		err := errors.New("invalid app_mention event, channel, user or ts is missing")
+		log.Println("リクエストの解析中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, err
+	}
+	req, err := parseAppMentionEventRequest(body)
+	if err != nil {
+		log.Println("リクエストの解析中にエラーが発生しました。", err)
+		return errorResponse(ws, ev, validationError("Slackから受信したイベントの形式が不正なため処理できません。"))
+	}
+
+	opts, err := parseMentionOptions(ev.Text)
+	if err != nil {
+		return errorResponse(ws, ev, classify(ErrValidation, err))
+	}
+	if !opts.PublishAt.IsZero() && approvalRequired() {
+		return errorResponse(ws, ev, validationError("publish_at は二人承認が有効な環境では利用できません。"))
+	}
+	// 承認者が設定されていない場合は、ファイルを取得する前に断る。
+	if approvalRequired() && !approversConfigured() {
+		log.Println("二人承認が有効ですが、承認者が設定されていません。")
+		return errorResponse(ws, ev, errApproversNotConfigured)
+	}
+	if opts.Bundle != "" {
+		if err := bundleAllowed(opts); err != nil {
+			return errorResponse(ws, ev, classify(ErrValidation, err))
+		}
+	}
+	if err := zipEntryAllowed(req.Event.Files, opts); err != nil {
+		return errorResponse(ws, ev, classify(ErrValidation, err))
+	}
+	if err := pipelineAllowed(req.Event.Files, opts); err != nil {
+		return errorResponse(ws, ev, classify(ErrValidation, err))
+	}
+	if err := renameFiles(req.Event.Files, opts); err != nil {
+		return errorResponse(ws, ev, classify(ErrValidation, err))
+	}
+	// for= が指定された場合はユーザーグループのメンバーを、password=on のみの場合は notify= のユーザーを受取人とする。
+	var recipients []string
+	if opts.For != "" || opts.Password {
+		if err := recipientsAllowed(opts); err != nil {
+			return errorResponse(ws, ev, classify(ErrValidation, err))
+		}
+		recipients, err = resolveRecipients(ws, opts)
+		if err != nil {
+			log.Println("受取人の取得中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, err)
+		}
+		if len(recipients) == 0 && opts.For != "" {
+			return errorResponse(ws, ev, validationError("for に指定したユーザーグループに、メールアドレスを確認できるメンバーがいません。"))
+		}
+		if len(recipients) == 0 {
+			return errorResponse(ws, ev, validationError("notify に指定したユーザーに、メールアドレスを確認できるユーザーがいません。"))
+		}
+	}
+
+	// Step Functions での実行が有効な場合は、各段階をステートとして実行する。
+	// INLINE_SIZE_THRESHOLD 以下の小さなファイルは、すぐに返信できるようその場で処理する。
+	if os.Getenv("EXECUTION_MODE") == "stepfunctions" && !processInline(req.Event.Files) {
+		if err := startPipelineExecution(ws, ev, req.Event.Files); err != nil {
+			log.Println("Step Functions の実行の開始中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, err)
+		}
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+
+	// STATUS_MESSAGE=on の場合は、1つのステータスメッセージを書き換えて進み具合や結果を知らせる。
+	status := startMentionStatus(ws, ev)
+	defer status.finish()
+
+	// 取得したファイルのバッファは、公開しないことが決まった時点で解放する。
+	// 公開するファイルの内容は、URLの発行やバンドルの作成に使うため、処理を終えるまで保持する。
+	var current membudget.Buffer
+	var retained []membudget.Buffer
+	defer func() {
+		if current != nil {
+			current.Close()
+		}
+		for _, buf := range retained {
+			buf.Close()
+		}
+	}()
+
+	// 途中のファイルや、承認の依頼・送信の予約で失敗した場合は、それまでにアップロードしたオブジェクトを削除し、Slackから削除したファイルを元に戻す。
+	// 誰もURLを知らないオブジェクトがバケットに残らないようにするためのものです。
+	var published []*publishedFile
+	var pending *publishedFile
+	processed := false
+	defer func() {
+		if processed {
+			return
+		}
+		if pending != nil {
+			revertPublished(ws, ev, append(published, pending))
+			return
+		}
+		revertPublished(ws, ev, published)
+	}()
+	for i := range req.Event.Files {
+		file := &req.Event.Files[i]
+
+		// Slack Connect の共有チャンネルで、外部の組織のユーザーがアップロードしたファイルを制限する。
+		if err := checkExternalFilePolicy(ws, ev, file); err != nil {
+			var policyErr *policyError
+			if errors.As(err, &policyErr) {
+				return errorResponse(ws, ev, classify(ErrValidation, err))
+			}
+			log.Println("アップロードしたユーザーの確認中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, err)
+		}
+
+		// Slackからファイルを取得する。
+		status.update(statusValidating, i, len(req.Event.Files))
+		stageStart := time.Now()
+		buf, err := downloadSlackFile(context.TODO(), ws, file)
+		if err != nil {
+			var storageErr *membudget.InsufficientStorageError
+			if errors.As(err, &storageErr) {
+				return errorResponse(ws, ev, validationError("ファイルが大きすぎるため処理できません。"))
+			}
+			log.Println("Slackからファイルを取得中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, classify(ErrSlackDownload, err))
+		}
+		current = buf
+
+		file.Binary, err = buf.Bytes()
+		if err != nil {
+			log.Println("Slackからファイルを取得中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, classify(ErrSlackDownload, err))
+		}
+		metrics.ObserveStage("download", stageStart)
+		metrics.AddBytes("download", len(file.Binary))
+
+		if err := validateFile(ev, file); err != nil {
+			return errorResponse(ws, ev, classify(ErrValidation, err))
+		}
+
+		stageStart = time.Now()
+		if err := scanFileWithDLP(ev, file, opts); err != nil {
+			var violation *dlpViolationError
+			if errors.As(err, &violation) {
+				return errorResponse(ws, ev, classify(ErrValidation, err))
+			}
+			log.Println("DLPによるファイルの検査中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, err)
+		}
+
+		secretFindings, err := scanFileForSecrets(file)
+		if err != nil {
+			log.Println("シークレットの検出中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, err)
+		}
+		metrics.ObserveStage("scan", stageStart)
+		if len(secretFindings) > 0 && os.Getenv("SECRET_SCAN_MODE") == "block" {
+			return errorResponse(ws, ev, validationError("APIキーや秘密鍵などのシークレットが含まれている可能性があるため公開できません。\n"+secretscan.Summary(secretFindings)))
+		}
+
+		runHooks(hooks.StageAfterValidate, fileHookEvent(ws, ev, file, nil))
+
+		stageStart = time.Now()
+		if err := watermarkPDFs(ws, ev, file); err != nil {
+			log.Println("PDFへのスタンプ中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, err)
+		}
+
+		metrics.ObserveStage("watermark", stageStart)
+
+		// file= が指定された場合は、検証したzipファイルから1つのファイルを取り出してアップロードする。
+		if err := extractZipEntry(file, opts); err != nil {
+			if !errors.Is(err, ErrValidation) {
+				log.Println("zipファイルからの取り出し中にエラーが発生しました。", err)
+			}
+			return errorResponse(ws, ev, err)
+		}
+
+		// recompress=on の場合は、転送量を減らすためにファイルを圧縮し直す。
+		recompressFile(file, opts)
+
+		// 公開を拒否する検査をすべて通過してから、Slackからファイルを削除する。
+		// 拒否した場合は、投稿者が修正できるようSlackにファイルを残しておく。
+		if err := deleteSlackFile(context.TODO(), ws, file.ID); err != nil {
+			log.Println("Slackからファイルを削除中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, classify(ErrSlackDownload, err))
+		}
+		pending = &publishedFile{file: file, secretFindings: secretFindings}
+
+		// 同じ内容のファイルが既に公開されていて、そのリンクが有効な場合はアップロードせずに既存のリンクを返す。
+		if dedupEnabled(opts) {
+			duplicate, err := auditStore.FindActiveBySHA256(context.TODO(), ws.TeamID, contentSHA256(file.Binary), time.Now())
+			if err != nil {
+				log.Println("公開済みのファイルの検索中にエラーが発生しました。", err)
+			} else if duplicate != nil {
+				if err := replyWithDuplicate(ws, ev, pending, duplicate, opts); err != nil {
+					log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+					return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+				}
+				pending = nil
+				current.Close()
+				current = nil
+				continue
+			}
+		}
+
+		// bundle=zip の場合は、すべてのファイルを1つのzipファイルにまとめてからアップロードする。
+		if opts.Bundle == bundleModeZip {
+			published = append(published, pending)
+			pending = nil
+			retained = append(retained, current)
+			current = nil
+			continue
+		}
+
+		status.update(statusUploading, i, len(req.Event.Files))
+		stageStart = time.Now()
+		uploaded, err := uploadFileToS3AndGetPresignedURL(ws, file, opts)
+		if errors.Is(err, errObjectAlreadyExists) {
+			return errorResponse(ws, ev, err)
+		}
+		if err != nil {
+			log.Println("ファイルのアップロードと署名付きURLの生成中にエラーが発生しました。", err)
+			return errorResponse(ws, ev, classify(ErrStorage, err))
+		}
+
+		metrics.ObserveStage("upload", stageStart)
+		metrics.AddBytes("upload", len(file.Binary))
+		runHooks(hooks.StageAfterUpload, fileHookEvent(ws, ev, file, uploaded))
+
+		pending.uploaded = uploaded
+		published = append(published, pending)
+		pending = nil
+		retained = append(retained, current)
+		current = nil
+	}
+	if len(published) == 0 {
+		processed = true
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+
+	// 二人承認が有効な場合は、承認者の承認を得てからURLを発行する。
+	// 依頼に失敗した場合は、まだ承認を依頼していないファイルだけを元に戻す。
+	if approvalRequired() {
+		for i, p := range published {
+			if err := requestApproval(ws, newApprovalRequest(ws, ev, p, opts)); err != nil {
+				log.Println("承認の依頼中にエラーが発生しました。", err)
+				published = published[i:]
+				return errorResponse(ws, ev, err)
+			}
+		}
+		processed = true
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+
+	// publish_at が指定された場合は、URLの送信を予約する。
+	// 予約に失敗した場合は、まだ予約していないファイルだけを元に戻す。
+	if !opts.PublishAt.IsZero() {
+		for i, p := range published {
+			if err := schedulePublication(ws, newScheduledPublication(ws, ev, p, opts), opts.PublishAt); err != nil {
+				log.Println("URLの送信の予約中にエラーが発生しました。", err)
+				published = published[i:]
+				return errorResponse(ws, ev, err)
+			}
+		}
+		processed = true
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+
+	// bundle=on または bundle=zip の場合は、複数のファイルを1つの短縮URLにまとめる。
+	// まとめたファイルを作れなかった場合は publishBundle が元に戻し、URLの短縮や通知に失敗した場合は rollbackPublished に任せる。
+	if opts.Bundle == bundleModeZip || (opts.Bundle == bundleModeIndex && len(published) > 1) {
+		processed = true
+		return publishBundle(ws, ev, published, opts)
+	}
+
+	// 以降の短縮や通知の失敗は、rollbackPublished で元に戻す。
+	processed = true
+
+	// 複数のファイルの署名付きURLを、まとめて短縮する。
+	stageStart := time.Now()
+	shortener := shortenerFor(ws.TeamID, ev.Channel)
+	shortURLs, auditIDs, err := shortenPublished(shortener, published, recipients)
+	if err != nil {
+		log.Println("URLの短縮中にエラーが発生しました。", err)
+		rollbackPublished(ws, ev, published)
+		return errorResponse(ws, ev, classify(ErrShortener, err))
+	}
+	metrics.ObserveStage("shorten", stageStart)
+
+	for i, p := range published {
+		shortURL := shortURLs[i]
+		manifestLine, err := manifestMessage(ws, ev, p)
+		if err != nil {
+			log.Println("マニフェストの発行中にエラーが発生しました。", err)
+			// 既にURLを知らせたファイルは元に戻さない。
+			rollbackPublished(ws, ev, published[i:])
+			return errorResponse(ws, ev, classify(ErrStorage, err))
+		}
+		message := formatPublishedMessage(shortURL, int64(len(p.file.Binary)), p.warnings()) + metalinkMessage(shortener, p, opts) + recompressionMessage(p.file) + manifestLine + replicaMessage(shortener, p, opts) + recipientsMessage(opts) + portalMessage(opts, recipients)
+
+		// Slackにメッセージを送信する。
+		stageStart = time.Now()
+		if err := notifyPublished(context.TODO(), ws, ev.Channel, ev.TimeStamp, ev.User, opts.Notify, message, opts.Note); err != nil {
+			log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+			rollbackPublished(ws, ev, published[i:])
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+		}
+		metrics.ObserveStage("notify", stageStart)
+
+		recordAudit(&audit.Record{
+			ID:             auditIDs[i],
+			TeamID:         ws.TeamID,
+			EnterpriseID:   ws.EnterpriseID,
+			Channel:        ev.Channel,
+			MessageTS:      ev.TimeStamp,
+			User:           ev.User,
+			FileName:       p.file.Name,
+			Bucket:         p.uploaded.Bucket,
+			ObjectKey:      p.uploaded.Key,
+			VersionID:      p.uploaded.VersionID,
+			Region:         p.uploaded.Region,
+			Size:           int64(len(p.file.Binary)),
+			ShortURL:       shortURL,
+			ExpiresAt:      p.uploaded.ExpiresAt.Unix(),
+			Note:           opts.Note,
+			SHA256:         contentSHA256(p.file.Binary),
+			RecipientGroup: opts.For,
+			Recipients:     recipients,
+			AllowedGroups:  portalAllowedGroups(opts),
+		})
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+}
+
+// acceptSlackRetries は、Slackのリトライリクエストを処理するかを返します。
+// 重複して処理しないよう、IDEMPOTENCY_TABLE が設定されている場合にのみ有効になります。
+func acceptSlackRetries() bool {
+	if os.Getenv("SLACK_RETRY_MODE") != "process" {
+		return false
+	}
+	if idempotencyStore == nil {
+		log.Println("IDEMPOTENCY_TABLE が設定されていないため、Slackのリトライリクエストを無視します。")
+		return false
+	}
+	return true
+}
+
+func lambdaHandler(r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// DEBUG_CAPTURE=true の場合は、Slackから受信したリクエストと返したレスポンスをS3に保存する。
+	capture := startDebugCapture("")
+	capture.add("request", requestSnapshot(r))
+	res, err := handleSlackRequest(r)
+	capture.add("response", responseSnapshot(res, err))
+	return res, err
+}
+
+// handleSlackRequest は、Slackから受信したリクエストのボディを展開し、ミドルウェアの連鎖（middleware.go）に渡します。
+func handleSlackRequest(r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := r.Headers
+	body, err := decodeRequestBody(r)
+	if err != nil {
+		log.Println("リクエストボディの展開中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, err
+	}
+	log.Println("リクエストヘッダー", logRedactor.Headers(headers))
+	log.Println("リクエストボディ", logRedactor.Body(body))
+
+	return requestChain()(&slackRequest{Headers: headers, Body: body})
+}
+
+// Main は、すべてのハンドラーを含むバイナリ（モジュールのルートの main パッケージ）の入口です。
+// LAMBDA_HANDLER で起動するハンドラーを切り替えます。サブシステムごとのバイナリは cmd 以下にあります。
+func Main() {
+	// Lambda以外（ローカルやコンテナ）で起動した場合は、常駐するHTTPサーバーとして動作する。
+	if !InLambda() {
+		runServer()
+		return
+	}
+
+	// LAMBDA_HANDLER で、同じバイナリから起動するハンドラーを切り替える。
+	switch os.Getenv("LAMBDA_HANDLER") {
+	case "digest":
+		lambda.Start(handleDailyDigest)
+	case "stage":
+		lambda.Start(handleStage)
+	case "worker":
+		lambda.Start(handleWorkerEvent)
+	case "tokens":
+		lambda.Start(handleTokenCommand)
+	case "tokenhealth":
+		lambda.Start(handleTokenHealthCheck)
+	case "bucketguard":
+		lambda.Start(handleBucketGuardCheck)
+	case "intake":
+		lambda.Start(handleInboxUpload)
+	case "verify":
+		lambda.Start(handleVerification)
+	case "portal":
+		lambda.Start(handlePortal)
+	case "redirect":
+		lambda.Start(handleRedirect)
+	case "auditexport":
+		lambda.Start(handleAuditExport)
+	case "cleanup":
+		lambda.Start(handleMessageCleanup)
+	case "s3restore":
+		lambda.Start(handleS3RestoreEvent)
+	case "api":
+		lambda.Start(handleAPI)
+	default:
+		lambda.Start(handleInvocation)
+	}
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/archive"
+	"github.com/kumagai-s/uploader-v2/internal/dlp"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// encryptedZip は、ZipCrypto で暗号化した（汎用フラグのビット0を立てた）エントリを含むzipを返します。
+// 内容は暗号文を模したバイト列で、パスワードなしには展開できません。
+func encryptedZip(t testing.TB, name string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Store,
+		Flags:              0x1,
+		CompressedSize64:   16,
+		UncompressedSize64: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("\x8f\x02\xc1\x7e\x10\x33\xa4\x5b\xee\x01\x77\x9c\x42\xd0\x6b\x18")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// plainZip は、暗号化していないエントリを1つ含むzipを返します。
+func plainZip(t testing.TB, name, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// fakeInspector は、検査したテキストごとに finding を1件返すDLPです。
+type fakeInspector struct {
+	finding   dlp.Finding
+	inspected []string
+}
+
+func (i *fakeInspector) Inspect(ctx context.Context, name string, content []byte) ([]dlp.Finding, error) {
+	i.inspected = append(i.inspected, name)
+	f := i.finding
+	f.Location = name
+	return []dlp.Finding{f}, nil
+}
+
+// withInspector は、テストの間だけ dlpInspector を inspector に置き換えます。
+func withInspector(t *testing.T, inspector dlp.Inspector) {
+	t.Helper()
+	saved := dlpInspector
+	dlpInspector = inspector
+	t.Cleanup(func() { dlpInspector = saved })
+}
+
+func TestScanFileWithDLPEncryptedZip(t *testing.T) {
+	inspector := &fakeInspector{finding: dlp.Finding{InfoType: "CREDIT_CARD_NUMBER", Likelihood: dlp.VeryLikely}}
+	withInspector(t, inspector)
+	ev := &slackevents.AppMentionEvent{User: "U1"}
+	file := &SlackAppMentionEventFile{}
+	file.Name = "cards.zip"
+	file.Binary = encryptedZip(t, "cards.csv")
+
+	if err := scanFileWithDLP(ev, file, &mentionOptions{}); err != nil {
+		t.Fatalf("scanFileWithDLP() error = %v, want nil", err)
+	}
+	if len(inspector.inspected) != 0 {
+		t.Errorf("inspected %v, want nothing", inspector.inspected)
+	}
+
+	t.Setenv("DLP_UNINSPECTABLE", "block")
+	if err := scanFileWithDLP(ev, file, &mentionOptions{}); !errors.Is(err, errContentNotInspectable) {
+		t.Fatalf("scanFileWithDLP() error = %v, want errContentNotInspectable", err)
+	}
+}
+
+func TestScanFileWithDLPOverride(t *testing.T) {
+	withInspector(t, &fakeInspector{finding: dlp.Finding{InfoType: "CREDIT_CARD_NUMBER", Likelihood: dlp.VeryLikely}})
+	t.Setenv("ADMIN_USER_IDS", "UADMIN")
+	file := &SlackAppMentionEventFile{}
+	file.Name = "cards.zip"
+	file.Binary = plainZip(t, "cards.csv", "4111 1111 1111 1111")
+
+	opts, err := parseMentionOptions("<@UBOT> dlp=override")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.DLPOverride {
+		t.Fatal("DLPOverride = false, want true")
+	}
+
+	var violation *dlpViolationError
+	err = scanFileWithDLP(&slackevents.AppMentionEvent{User: "U1", Text: "<@UBOT> dlp=override"}, file, opts)
+	if !errors.As(err, &violation) {
+		t.Fatalf("scanFileWithDLP() by non-admin error = %v, want dlpViolationError", err)
+	}
+	// 本文に含まれていても、オプションとして指定していなければ無視する。
+	err = scanFileWithDLP(&slackevents.AppMentionEvent{User: "UADMIN", Text: `<@UBOT> note="dlp=override"`}, file, &mentionOptions{Note: "dlp=override"})
+	if !errors.As(err, &violation) {
+		t.Fatalf("scanFileWithDLP() without option error = %v, want dlpViolationError", err)
+	}
+	if err := scanFileWithDLP(&slackevents.AppMentionEvent{User: "UADMIN"}, file, opts); err != nil {
+		t.Fatalf("scanFileWithDLP() by admin error = %v, want nil", err)
+	}
+
+	if _, err := parseMentionOptions("dlp=off"); err == nil {
+		t.Error("parseMentionOptions(dlp=off) error = nil, want error")
+	}
+}
+
+func TestScanFileForSecretsEncryptedZip(t *testing.T) {
+	file := &SlackAppMentionEventFile{}
+	file.Name = "secrets.zip"
+	file.Binary = encryptedZip(t, "credentials.txt")
+
+	t.Setenv("SECRET_SCAN_MODE", "warn")
+	findings, err := scanFileForSecrets(file)
+	if err != nil {
+		t.Fatalf("scanFileForSecrets() error = %v, want warning", err)
+	}
+	if len(findings) != 1 || findings[0].RuleID != secretRuleUninspectable || findings[0].File != "credentials.txt" {
+		t.Fatalf("scanFileForSecrets() = %+v, want uninspectable credentials.txt", findings)
+	}
+	p := &publishedFile{file: file, secretFindings: findings}
+	if p.warnings() == "" {
+		t.Error("warnings() is empty, want uninspectable warning")
+	}
+
+	t.Setenv("SECRET_SCAN_MODE", "block")
+	if _, err := scanFileForSecrets(file); !errors.Is(err, errContentNotInspectable) {
+		t.Fatalf("scanFileForSecrets() error = %v, want errContentNotInspectable", err)
+	}
+}
+
+func FuzzDecodeRequestBody(f *testing.F) {
+	f.Add(`{"type":"event_callback"}`, "", false)
+	f.Add(`{"type":"url_verification","challenge":"abc"}`, "gzip", true)
+	f.Add("payload=%7B%7D", "deflate", false)
+	f.Add("", "identity", true)
+	f.Fuzz(func(t *testing.T, body, encoding string, base64Encoded bool) {
+		// 任意のボディを展開しても panic しない。
+		r := events.APIGatewayProxyRequest{Body: body, IsBase64Encoded: base64Encoded, Headers: map[string]string{"content-encoding": encoding}}
+		decodeRequestBody(r)
+
+		// gzip で圧縮し base64 で符号化したボディは、元のボディに戻る。
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(body))
+		zw.Close()
+		r = events.APIGatewayProxyRequest{
+			Body:            base64.StdEncoding.EncodeToString(buf.Bytes()),
+			IsBase64Encoded: true,
+			Headers:         map[string]string{"Content-Encoding": "gzip"},
+		}
+		decoded, err := decodeRequestBody(r)
+		if err != nil || decoded != body {
+			t.Errorf("decodeRequestBody() = %q, %v, want %q", decoded, err, body)
+		}
+	})
+}
+
+func FuzzParseAppMentionEventRequest(f *testing.F) {
+	f.Add(`{"event":{"files":[{"id":"F1","name":"a.zip","url_private_download":"https://files.slack.com/a.zip","size":10}]}}`)
+	f.Add(`{"event":{"files":[{"id":"F1","name":"a.zip","size":-1}]}}`)
+	f.Add(`{"event":{"files":null}}`)
+	f.Add(`null`)
+	f.Add(`{"event":{"files":[{}]}}`)
+	f.Fuzz(func(t *testing.T, body string) {
+		req, err := parseAppMentionEventRequest(body)
+		if err != nil {
+			return
+		}
+		for i, file := range req.Event.Files {
+			if file.ID == "" || file.Name == "" || file.URLPrivateDownload == "" || file.Size < 0 {
+				t.Errorf("event.files[%d] = %+v was accepted", i, file)
+			}
+		}
+	})
+}
+
+func FuzzValidateFile(f *testing.F) {
+	f.Add("release.zip", plainZip(f, "report.txt", "quarterly report"))
+	f.Add("release.zip", encryptedZip(f, "credentials.txt"))
+	f.Add("リリース.zip", plainZip(f, "a.txt", "a"))
+	f.Add(".zip", []byte("PK\x03\x04"))
+	f.Add("release.tar.gz", []byte{0x1f, 0x8b})
+	f.Add(strings.Repeat("a", 252)+".zip", plainZip(f, "a.txt", "a"))
+	f.Fuzz(func(t *testing.T, name string, content []byte) {
+		file := &SlackAppMentionEventFile{ID: "F1", Name: name, Binary: content}
+		if err := validateFile(&slackevents.AppMentionEvent{}, file); err != nil {
+			return
+		}
+		base, format, ok := archive.SplitExt(name)
+		if !ok || format != archive.FormatZip {
+			t.Errorf("validateFile() accepted %q, want only zip files", name)
+		}
+		if !isValidFileName(base) || len(name) > maxFileNameLength {
+			t.Errorf("validateFile() accepted the file name %q", name)
+		}
+		if err := archive.Inspect(format, content, archiveLimits()); err != nil {
+			t.Errorf("validateFile() accepted an archive that fails inspection, %s", err)
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/manifest"
+	"github.com/kumagai-s/uploader-v2/internal/slackfiles"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// createManifest は、アップロードしたファイルの署名付きマニフェスト（manifest.json）をオブジェクトの隣に保存し、
+// その署名付きURLと、署名したマニフェストの内容を返します。
+// マニフェストには、ファイル名、サイズ、SHA-256、URLの有効期限、依頼者を記載し、MANIFEST_KMS_KEY_ID の非対称鍵で署名します。
+func createManifest(ws *workspace, ev *slackevents.AppMentionEvent, p *publishedFile) (*uploadedObject, []byte, error) {
+	sum := sha256.Sum256(p.file.Binary)
+	doc, err := manifestSigner.Sign(context.TODO(), &manifest.Manifest{
+		FileName:  p.file.Name,
+		Size:      int64(len(p.file.Binary)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		ExpiresAt: p.uploaded.ExpiresAt.UTC().Format(time.RFC3339),
+		Requester: ev.User,
+		TeamID:    ws.TeamID,
+		IssuedAt:  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signed := &uploadedObject{
+		Bucket:       p.uploaded.Bucket,
+		Key:          p.uploaded.Key + ".manifest.json",
+		Region:       p.uploaded.Region,
+		TeamID:       p.uploaded.TeamID,
+		EnterpriseID: p.uploaded.EnterpriseID,
+		Expiry:       p.uploaded.Expiry,
+	}
+	client, err := s3ClientFor(p.uploaded.TeamID, p.uploaded.EnterpriseID, p.uploaded.Region)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(signed.Bucket),
+		Key:         aws.String(signed.Key),
+		Body:        bytes.NewReader(doc),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	signed.VersionID = aws.ToString(out.VersionId)
+	if err := presignObject(signed); err != nil {
+		return nil, nil, err
+	}
+	return signed, doc, nil
+}
+
+// manifestMessage は、署名付きマニフェストの短縮URLを生成し、URLを知らせるメッセージに添える行を返します。
+// MANIFEST_KMS_KEY_ID が設定されていない場合は空文字列を返します。
+// 来歴を検証できないままファイルを共有しないよう、生成に失敗した場合はエラーを返します。
+// SLACK_ARTIFACTS に manifest を指定した場合は、マニフェストをスレッドにも投稿します。
+func manifestMessage(ws *workspace, ev *slackevents.AppMentionEvent, p *publishedFile) (string, error) {
+	if manifestSigner == nil {
+		return "", nil
+	}
+	signed, doc, err := createManifest(ws, ev, p)
+	if err != nil {
+		return "", err
+	}
+	if artifactEnabled("manifest") {
+		if err := postArtifact(context.TODO(), ws, ev.Channel, ev.TimeStamp, &slackfiles.File{
+			Name:    p.file.Name + ".manifest.json",
+			Content: doc,
+		}); err != nil {
+			log.Println("マニフェストのSlackへの投稿中にエラーが発生しました。", err)
+		}
+	}
+	shortURL, err := shortenerFor(ws.TeamID, ev.Channel).Shorten(signed.PresignedURL)
+	if err != nil {
+		return "", err
+	}
+	return "\n:lock: 署名付きマニフェスト: " + shortURL, nil
+}
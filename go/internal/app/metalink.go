@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/metalink"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+)
+
+// metalinkWanted は、ファイルのメタリンクを生成するかを返します。
+// metalink=on が指定された場合か、ファイルが METALINK_THRESHOLD（バイト）以上の場合に生成します。
+// メタリンクには署名付きURLをそのまま記載するため、受取人やポータルで保護したリンクには生成しません。
+func metalinkWanted(p *publishedFile, opts *mentionOptions) bool {
+	if linksGated(opts) {
+		return false
+	}
+	if opts.Metalink {
+		return true
+	}
+	threshold := getEnvInt64("METALINK_THRESHOLD", 0)
+	return threshold > 0 && int64(len(p.file.Binary)) >= threshold
+}
+
+// createMetalink は、アップロードしたファイルのメタリンク（Metalink 4.0）をオブジェクトの隣に保存し、
+// その署名付きURLを生成します。
+// メタリンクにはファイルの署名付きURLとピースごとのチェックサムを記載するため、
+// 回線が不安定な環境でも対応したダウンローダーで再開・検証しながらダウンロードできます。
+func createMetalink(p *publishedFile) (*uploadedObject, error) {
+	doc, err := metalink.Generate(&metalink.File{
+		Name:        p.file.Name,
+		Data:        p.file.Binary,
+		URLs:        []string{p.uploaded.PresignedURL},
+		PieceLength: int(getEnvInt64("METALINK_PIECE_LENGTH", metalink.DefaultPieceLength)),
+	}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &uploadedObject{
+		Bucket:       p.uploaded.Bucket,
+		Key:          p.uploaded.Key + ".meta4",
+		Region:       p.uploaded.Region,
+		TeamID:       p.uploaded.TeamID,
+		EnterpriseID: p.uploaded.EnterpriseID,
+		Expiry:       p.uploaded.Expiry,
+	}
+	client, err := s3ClientFor(p.uploaded.TeamID, p.uploaded.EnterpriseID, p.uploaded.Region)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(meta.Bucket),
+		Key:         aws.String(meta.Key),
+		Body:        bytes.NewReader(doc),
+		ContentType: aws.String(metalink.ContentType),
+	})
+	if err != nil {
+		return nil, err
+	}
+	meta.VersionID = aws.ToString(out.VersionId)
+	if err := presignObject(meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// metalinkMessage は、メタリンクの短縮URLを生成し、URLを知らせるメッセージに添える行を返します。
+// メタリンクはダウンロードを補助するためのものなので、生成に失敗してもファイルのURLの送信は続けます。
+func metalinkMessage(shortener urlshortener.URLShortener, p *publishedFile, opts *mentionOptions) string {
+	if !metalinkWanted(p, opts) {
+		return ""
+	}
+	meta, err := createMetalink(p)
+	if err != nil {
+		log.Println("メタリンクの生成中にエラーが発生しました。", err)
+		return ""
+	}
+	shortURL, err := shortener.Shorten(meta.PresignedURL)
+	if err != nil {
+		log.Println("URLの短縮中にエラーが発生しました。", err)
+		return ""
+	}
+	return "\n:link: メタリンク（再開・検証用）: " + shortURL
+}
@@ -0,0 +1,291 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// slackRequest は、Slackから受信したリクエストです。ボディは展開済みです。
+type slackRequest struct {
+	Headers map[string]string
+	Body    string
+}
+
+// slackEvent は、検証済みのコールバックイベントです。Workspace はイベントが発生したワークスペースです。
+type slackEvent struct {
+	API       slackevents.EventsAPIEvent
+	Body      string
+	Workspace *workspace
+}
+
+// requestHandler と eventHandler は、ミドルウェアの連鎖の各段階が呼び出す次の処理です。
+type (
+	requestHandler func(req *slackRequest) (events.APIGatewayProxyResponse, error)
+	eventHandler   func(ev *slackEvent) (events.APIGatewayProxyResponse, error)
+)
+
+// requestMiddleware と eventMiddleware は、next を呼び出す前後に処理を加えるか、next を呼び出さずに応答します。
+type (
+	requestMiddleware func(next requestHandler) requestHandler
+	eventMiddleware   func(next eventHandler) eventHandler
+)
+
+// Slackからのリクエストは、次の順にミドルウェアを通して処理します。
+//
+//	verify → route →（コールバックイベントの場合）dedupe → authorize → rate-limit → 追加のミドルウェア → handle
+//
+// ワーカーで処理するイベントは、検証と振り分けを済ませているため dedupe から始めます。
+// 監査や割り当てなど、すべてのリクエストやイベントに共通する処理は、init で useRequestMiddleware または
+// useEventMiddleware を呼び出して追加してください。追加したミドルウェアは、登録した順に組み込みのミドルウェアの後に実行されます。
+var (
+	extraRequestMiddlewares []requestMiddleware
+	extraEventMiddlewares   []eventMiddleware
+)
+
+// useRequestMiddleware は、署名の検証の後、振り分けの前に実行するミドルウェアを追加します。
+func useRequestMiddleware(m requestMiddleware) {
+	extraRequestMiddlewares = append(extraRequestMiddlewares, m)
+}
+
+// useEventMiddleware は、コールバックイベントをハンドラーに渡す前に実行するミドルウェアを追加します。
+func useEventMiddleware(m eventMiddleware) {
+	extraEventMiddlewares = append(extraEventMiddlewares, m)
+}
+
+// requestChain は、リクエストを処理するミドルウェアの連鎖を組み立てます。
+func requestChain() requestHandler {
+	middlewares := append([]requestMiddleware{verifyMiddleware}, extraRequestMiddlewares...)
+	h := routeRequest
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// eventChain は、コールバックイベントを処理するミドルウェアの連鎖を組み立てます。
+func eventChain() eventHandler {
+	middlewares := append([]eventMiddleware{dedupeMiddleware, authorizeMiddleware, rateLimitMiddleware}, extraEventMiddlewares...)
+	h := handleCallbackEvent
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// verifyMiddleware は、Slackのリトライを除外し、署名を検証します。
+// SLACK_RETRY_MODE=process の場合は、最初の配信が途中で失敗したイベントを処理できるよう、
+// リトライも受け付けて重複の排除を dedupeMiddleware に任せます。
+func verifyMiddleware(next requestHandler) requestHandler {
+	return func(req *slackRequest) (events.APIGatewayProxyResponse, error) {
+		if headerValue(req.Headers, "X-Slack-Retry-Num") != "" && !acceptSlackRetries() {
+			return events.APIGatewayProxyResponse{StatusCode: 200, Body: "No need retry"}, nil
+		}
+		// SlackAPIのシークレットキーを用いて検証する。
+		if err := verifyRequest(req.Headers, req.Body); err != nil {
+			log.Println("リクエストの検証中にエラーが発生しました。", err)
+			return events.APIGatewayProxyResponse{StatusCode: 401, Body: "Unauthorized"}, err
+		}
+		return next(req)
+	}
+}
+
+// routeRequest は、検証済みのリクエストを種類ごとのハンドラーに振り分けます。
+func routeRequest(req *slackRequest) (events.APIGatewayProxyResponse, error) {
+	body := req.Body
+
+	// ボタンなどのインタラクションは、フォーム形式の payload で送信される。
+	if strings.HasPrefix(body, "payload=") {
+		return handleInteraction(body)
+	}
+
+	if values, ok := parseSlashCommand(body); ok {
+		return handleSlashCommand(values)
+	}
+
+	eventsAPIEvent, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		log.Println("リクエストの解析中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+
+	// SlackAPIのURL検証イベントを処理する。
+	if eventsAPIEvent.Type == slackevents.URLVerification {
+		return handleURLVerification(body)
+	}
+
+	// SlackAPIのコールバックイベント処理する。
+	if eventsAPIEvent.Type == slackevents.CallbackEvent {
+		// ワーカーが設定されている場合は、Slackに3秒以内に応答できるよう、処理を非同期の呼び出しに任せてすぐに応答する。
+		// INLINE_SIZE_THRESHOLD 以下の小さなファイルは、すぐに返信できるようその場で処理する。
+		if shouldDispatchToWorker(body) {
+			if err := dispatchToWorker(body); err != nil {
+				log.Println("ワーカーの呼び出し中にエラーが発生しました。", err)
+				return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+			}
+			acknowledgeDispatch(eventsAPIEvent)
+			return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+		}
+		return dispatchCallbackEvent(eventsAPIEvent, body)
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, nil
+}
+
+// dispatchCallbackEvent は、検証済みのコールバックイベントをミドルウェアの連鎖を通して処理します。
+// トークンが登録されていないワークスペースのイベントは無視し、レジストリから取得できなかった場合は Slack に再送させます。
+func dispatchCallbackEvent(eventsAPIEvent slackevents.EventsAPIEvent, body string) (events.APIGatewayProxyResponse, error) {
+	ws, err := resolveWorkspace(eventsAPIEvent.TeamID, eventsAPIEvent.EnterpriseID)
+	if errors.Is(err, errUnknownWorkspace) {
+		log.Println("登録されていないワークスペースのイベントを無視しました。", eventsAPIEvent.TeamID, eventsAPIEvent.EnterpriseID)
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+	if err != nil {
+		log.Println("ワークスペースの確認中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return eventChain()(&slackEvent{
+		API:       eventsAPIEvent,
+		Body:      body,
+		Workspace: ws,
+	})
+}
+
+// dedupeMiddleware は、IDEMPOTENCY_TABLE が設定されている場合に、同じイベントを二度処理しないようにします。
+func dedupeMiddleware(next eventHandler) eventHandler {
+	return func(ev *slackEvent) (events.APIGatewayProxyResponse, error) {
+		cb, ok := ev.API.Data.(*slackevents.EventsAPICallbackEvent)
+		if !ok || idempotencyStore == nil || cb.EventID == "" {
+			return next(ev)
+		}
+		acquired, err := idempotencyStore.Acquire(context.TODO(), cb.EventID)
+		if err != nil {
+			log.Println("イベントの処理状況の確認中にエラーが発生しました。", err)
+			return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+		}
+		if !acquired {
+			return events.APIGatewayProxyResponse{StatusCode: 200, Body: "Already processed"}, nil
+		}
+		defer func() {
+			if err := idempotencyStore.Complete(context.TODO(), cb.EventID); err != nil {
+				log.Println("イベントの処理状況の記録中にエラーが発生しました。", err)
+			}
+		}()
+		return next(ev)
+	}
+}
+
+// authorizeMiddleware は、ALLOWED_TEAM_IDS が設定されている場合に、含まれないワークスペース（または Enterprise Grid の組織）のイベントを無視します。
+func authorizeMiddleware(next eventHandler) eventHandler {
+	return func(ev *slackEvent) (events.APIGatewayProxyResponse, error) {
+		if isAllowedTeam(ev.API.TeamID, ev.API.EnterpriseID) {
+			return next(ev)
+		}
+		log.Println("許可されていないワークスペースのイベントを無視しました。", ev.API.TeamID, ev.API.EnterpriseID)
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+}
+
+// isAllowedTeam は、ワークスペースまたは組織が ALLOWED_TEAM_IDS に含まれるかを返します。空の場合はすべて許可します。
+func isAllowedTeam(teamID, enterpriseID string) bool {
+	allowed := splitEnvList("ALLOWED_TEAM_IDS")
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, id := range allowed {
+		if id == teamID || (enterpriseID != "" && id == enterpriseID) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitMiddleware は、RATE_LIMIT_PER_USER（例: 「10/1h」）が設定されている場合に、
+// ユーザーごとのメンションの回数を制限し、超えた場合はスレッドに知らせて処理しません。
+// 回数は Lambda の実行環境ごとに数えるため、複数の実行環境が動く場合は目安です。
+func rateLimitMiddleware(next eventHandler) eventHandler {
+	return func(ev *slackEvent) (events.APIGatewayProxyResponse, error) {
+		mention, ok := ev.API.InnerEvent.Data.(*slackevents.AppMentionEvent)
+		if !ok || mention.User == "" {
+			return next(ev)
+		}
+		limit, window, err := parseRateLimit(getEnvOrDefault("RATE_LIMIT_PER_USER", ""))
+		if err != nil {
+			log.Println("RATE_LIMIT_PER_USER の設定が不正です。", err)
+			return next(ev)
+		}
+		if limit > 0 && !userRateLimiter.allow(ev.API.TeamID+"/"+mention.User, limit, window, time.Now()) {
+			sendErrorToSlack(ev.Workspace, mention, fmt.Sprintf("リクエストが多すぎます。%sあたり%d回までです。しばらく待ってから再度お試しください。", window, limit))
+			return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+		}
+		return next(ev)
+	}
+}
+
+// handleCallbackEvent は、コールバックイベントをイベントの種類ごとのハンドラーで処理します。
+func handleCallbackEvent(ev *slackEvent) (events.APIGatewayProxyResponse, error) {
+	switch inner := ev.API.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		return handleAppMentionEvent(ev.Workspace, inner, ev.Body)
+	case *slackevents.LinkSharedEvent:
+		return handleLinkSharedEvent(ev.Workspace, inner)
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, nil
+}
+
+// parseRateLimit は、「10/1h」の形式から回数と期間を取り出します。空の場合は制限しません。
+func parseRateLimit(spec string) (int, time.Duration, error) {
+	if spec == "" {
+		return 0, 0, nil
+	}
+	count, period, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid rate limit %q", spec)
+	}
+	var limit int
+	if _, err := fmt.Sscan(count, &limit); err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate limit %q", spec)
+	}
+	window, err := parseDuration(period)
+	if err != nil || window <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate limit %q", spec)
+	}
+	return limit, window, nil
+}
+
+// rateLimiter は、キーごとに固定の期間の回数を数えます。
+type rateLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+var userRateLimiter = &rateLimiter{windows: map[string]*rateWindow{}}
+
+// allow は、key の回数が期間内に limit 未満であれば数えて true を返します。
+func (l *rateLimiter) allow(key string, limit int, window time.Duration, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+)
+
+// shortenerNamespace は、SHORTENER_NAMESPACE に応じて、短縮コードを区切る名前空間を返します。
+//   - team: ワークスペースのチームID
+//   - channel: 送信先のチャンネルのID
+//
+// SHORTENER_NAMESPACE_ALIASES に「{"T012345":"acme"}」のようなJSONを設定すると、IDの代わりに別名を使います。
+// 設定しない場合は空文字列を返し、名前空間を指定しません。
+func shortenerNamespace(teamID, channel string) string {
+	var id string
+	switch os.Getenv("SHORTENER_NAMESPACE") {
+	case "team":
+		id = teamID
+	case "channel":
+		id = channel
+	default:
+		return ""
+	}
+	aliases := map[string]string{}
+	if v := os.Getenv("SHORTENER_NAMESPACE_ALIASES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &aliases); err != nil {
+			log.Println("SHORTENER_NAMESPACE_ALIASES の設定が不正です。", err)
+		}
+	}
+	if alias, ok := aliases[id]; ok {
+		return alias
+	}
+	return strings.ToLower(id)
+}
+
+// shortenerFor は、チームまたはチャンネルの名前空間で短縮URLを発行する URLShortener を返します。
+func shortenerFor(teamID, channel string) urlshortener.URLShortener {
+	return urlShortener.WithNamespace(shortenerNamespace(teamID, channel))
+}
+
+// handleRevokeNamespaceCommand は、/geturl-admin revoke namespace=<名前空間またはチャンネル> を実行し、
+// 名前空間で発行したすべての短縮URLを無効にします。
+func handleRevokeNamespaceCommand(teamID, user, arg string) string {
+	key, value, _ := strings.Cut(arg, "=")
+	if key != "namespace" || value == "" {
+		return adminCommandUsage
+	}
+	namespace := value
+	if kind, id, ok := parseSlackReference(value); ok && kind == "channel" {
+		namespace = shortenerNamespace(teamID, id)
+	}
+	if namespace == "" {
+		return "SHORTENER_NAMESPACE が設定されていないため、実行できません。"
+	}
+	if err := urlShortener.RevokeNamespace(namespace); err != nil {
+		log.Println("名前空間の短縮URLの無効化中にエラーが発生しました。", namespace, err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	log.Println("名前空間の短縮URLを無効にしました。", namespace, user)
+	return fmt.Sprintf("名前空間「%s」のすべての短縮URLを無効にしました。", escapeMrkdwn(namespace))
+}
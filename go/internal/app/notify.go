@@ -0,0 +1,150 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kumagai-s/uploader-v2/internal/notifier"
+	"github.com/slack-go/slack"
+)
+
+// notifyPublished は、発行したリンクを依頼者のスレッドに返信し、notify= の相手と REPLY_NOTIFIERS の通知先にも知らせます。
+// メンションのステータスメッセージがある場合は、スレッドに返信せずにステータスメッセージに書き込みます。
+// スレッドへの返信に失敗した場合は、EventBridge Scheduler で後から送信し直すよう予約します（handleReplyRetry）。
+// 予約にも失敗した場合のみエラーを返し、それ以外の通知先への送信の失敗はログに残します。
+func notifyPublished(ctx context.Context, ws *workspace, channel, threadTS, requester string, recipients []string, message, note string) error {
+	msg := &notifier.Message{Subject: "ダウンロードURLを発行しました", Text: message, Note: note}
+	if status := lookupMentionStatus(channel, threadTS); status != nil {
+		// ステータスメッセージを使う場合は、処理が完了したときにまとめて書き込む。
+		status.addResult(message, note)
+	} else if err := notifier.NewSlackThread(ws.Bot, channel, threadTS).Notify(ctx, msg); err != nil {
+		// アップロード済みのファイルのURLを誰も知らないままにならないよう、返信を保存して後で送信し直す。
+		if qerr := queueReplyRetry(&pendingReply{
+			TeamID:       ws.TeamID,
+			EnterpriseID: ws.EnterpriseID,
+			Channel:      channel,
+			ThreadTS:     threadTS,
+			Requester:    requester,
+			Text:         message,
+			Note:         note,
+		}); qerr != nil {
+			log.Println("返信の再送の予約中にエラーが発生しました。", qerr)
+			return err
+		}
+		log.Println("Slackへの返信に失敗したため、再送を予約しました。", err)
+	}
+	shareWithRecipients(ws, channel, threadTS, requester, recipients, message, note)
+	if err := replyNotifiers(ctx, ws, requester).Notify(ctx, msg); err != nil {
+		log.Println("追加の通知先への送信中にエラーが発生しました。", err)
+	}
+	return nil
+}
+
+// replyNotifiers は、REPLY_NOTIFIERS に「dm,email,teams,webhook」のように指定した追加の通知先を返します。
+//   - dm: 依頼者にSlackのDMを送る
+//   - email: 依頼者のSlackのプロフィールのメールアドレスにメールを送る（SMTPの設定は OTP_SMTP_ADDR などを使用）
+//   - teams: Microsoft Teams の Incoming Webhook（REPLY_TEAMS_WEBHOOK_URL）に投稿する
+//   - webhook: REPLY_WEBHOOK_URL に JSON を POST する
+//
+// teams と webhook のリクエストには、WEBHOOK_SIGNING_SECRET を設定した場合に署名を付けます。
+func replyNotifiers(ctx context.Context, ws *workspace, requester string) notifier.Notifier {
+	var notifiers []notifier.Notifier
+	for _, name := range splitEnvList("REPLY_NOTIFIERS") {
+		switch name {
+		case "dm":
+			if requester != "" {
+				notifiers = append(notifiers, notifier.NewSlackDM(ws.Bot, requester))
+			}
+		case "email":
+			if requester == "" {
+				continue
+			}
+			user, err := cachedUserInfo(ctx, ws, requester)
+			if err != nil || user.Profile.Email == "" {
+				log.Println("依頼者のメールアドレスの取得中にエラーが発生しました。", requester, err)
+				continue
+			}
+			notifiers = append(notifiers, notifier.NewEmail(smtpConfig(), user.Profile.Email))
+		case "teams":
+			if url := os.Getenv("REPLY_TEAMS_WEBHOOK_URL"); url != "" {
+				notifiers = append(notifiers, notifier.NewTeams(webhookHTTPClient, url))
+			}
+		case "webhook":
+			if url := os.Getenv("REPLY_WEBHOOK_URL"); url != "" {
+				notifiers = append(notifiers, notifier.NewWebhook(webhookHTTPClient, url))
+			}
+		default:
+			log.Println("REPLY_NOTIFIERS の通知先が不正です。", name)
+		}
+	}
+	return notifier.Multi(notifiers...)
+}
+
+// parseSlackReference は、<@U...|name>、<#C...|name>、<!subteam^S...|name> の形式から種類とIDを取り出します。
+// 種類は "user"、"channel"、"usergroup" のいずれかです。
+func parseSlackReference(ref string) (kind, id string, ok bool) {
+	if !strings.HasPrefix(ref, "<") || !strings.HasSuffix(ref, ">") {
+		return "", "", false
+	}
+	body := strings.TrimSuffix(strings.TrimPrefix(ref, "<"), ">")
+	body, _, _ = strings.Cut(body, "|")
+	switch {
+	case strings.HasPrefix(body, "@"):
+		return "user", strings.TrimPrefix(body, "@"), true
+	case strings.HasPrefix(body, "#"):
+		return "channel", strings.TrimPrefix(body, "#"), true
+	case strings.HasPrefix(body, "!subteam^"):
+		return "usergroup", strings.TrimPrefix(body, "!subteam^"), true
+	}
+	return "", "", false
+}
+
+// shareWithRecipients は、notify= で指定されたユーザーにはDMで、チャンネルには投稿してリンクを共有します。
+// 共有に失敗した相手がいる場合は、依頼者のスレッドに返信して知らせます。
+// note が指定された場合は、依頼者の説明として添えます。
+func shareWithRecipients(ws *workspace, channel, threadTS, requester string, recipients []string, message, note string) {
+	if len(recipients) == 0 {
+		return
+	}
+
+	text := fmt.Sprintf("<@%s> さんがファイルを共有しました。\n%s", requester, message)
+	var failed []string
+	for _, ref := range recipients {
+		if err := shareWithRecipient(ws, ref, text, note); err != nil {
+			log.Println("リンクの共有中にエラーが発生しました。", ref, err)
+			failed = append(failed, ref)
+		}
+	}
+
+	if len(failed) == 0 {
+		return
+	}
+	if _, _, err := ws.Bot.PostMessage(
+		channel,
+		slack.MsgOptionText(fmt.Sprintf("%s へのリンクの共有に失敗しました。", strings.Join(failed, " ")), false),
+		slack.MsgOptionTS(threadTS),
+	); err != nil {
+		log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+	}
+}
+
+func shareWithRecipient(ws *workspace, ref, text, note string) error {
+	kind, id, ok := parseSlackReference(ref)
+	if !ok {
+		return fmt.Errorf("invalid recipient %q", ref)
+	}
+
+	var n notifier.Notifier
+	switch kind {
+	case "user":
+		n = notifier.NewSlackDM(ws.Bot, id)
+	case "channel":
+		n = notifier.NewSlackThread(ws.Bot, id, "")
+	default:
+		return fmt.Errorf("unsupported recipient %q", ref)
+	}
+	return n.Notify(context.TODO(), &notifier.Message{Text: text, Note: note})
+}
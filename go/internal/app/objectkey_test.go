@@ -0,0 +1,293 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/archive"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// maxObjectKeyLength は、S3のキーの最大の長さ（UTF-8 のバイト数）です。
+const maxObjectKeyLength = 1024
+
+// fakeObjectStore は、ネットワークを使わずにS3の代わりに応答し、アップロードされたオブジェクトのヘッダーを記録する http.RoundTripper です。
+// HeadObject は、記録したキーにのみ 200 を返します。
+type fakeObjectStore struct {
+	mu      sync.Mutex
+	objects map[string]http.Header
+}
+
+func (s *fakeObjectStore) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	key := strings.TrimPrefix(req.URL.Path, "/keys/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := http.StatusOK
+	switch req.Method {
+	case http.MethodHead:
+		if _, ok := s.objects[key]; !ok {
+			status = http.StatusNotFound
+		}
+	case http.MethodPut:
+		s.objects[key] = req.Header.Clone()
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Etag": {`"etag"`}},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// withFakeObjectStore は、テストの間だけ S3 へのリクエストを空の fakeObjectStore に向けます。
+func withFakeObjectStore(t *testing.T) *fakeObjectStore {
+	t.Helper()
+	savedS3Client, savedPresignClient := s3Client, s3PresignClient
+	t.Cleanup(func() { s3Client, s3PresignClient = savedS3Client, savedPresignClient })
+	t.Setenv("S3_BUCKET", "keys")
+	t.Setenv("BUCKET_GUARD", "off")
+
+	store := &fakeObjectStore{objects: map[string]http.Header{}}
+	s3Client = s3.New(s3.Options{
+		Region:       "ap-northeast-1",
+		Credentials:  aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("AKIDKEYS", "secret", "")),
+		HTTPClient:   &http.Client{Transport: store},
+		UsePathStyle: true,
+	})
+	s3PresignClient = s3.NewPresignClient(s3Client)
+	return store
+}
+
+// reset は、記録したオブジェクトをすべて削除します。
+func (s *fakeObjectStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects = map[string]http.Header{}
+}
+
+// unicodeFileName は、さまざまな文字種の拡張子を除いたファイル名に、アーカイブの拡張子を付けたファイル名です。
+type unicodeFileName string
+
+// fileNameRunes は、ファイル名に使う文字の範囲です。ASCIIの記号、日本語、結合文字、右から左に書く文字、絵文字を含みます。
+var fileNameRunes = [][2]rune{
+	{'a', 'z'}, {'A', 'Z'}, {'0', '9'}, {' ', '/'}, {':', '@'},
+	{'ぁ', 'ゖ'}, {'一', '龥'}, {'̀', 'ͯ'}, {'א', 'ת'}, {'\U0001f600', '\U0001f64f'},
+}
+
+// fileNameExtensions は、ファイル名に付ける拡張子です。
+var fileNameExtensions = []string{".zip", ".tar.gz", ".tgz", ".7z", ".rar"}
+
+func (unicodeFileName) Generate(r *rand.Rand, size int) reflect.Value {
+	var b strings.Builder
+	for n := 1 + r.Intn(size+1); n > 0; n-- {
+		rng := fileNameRunes[r.Intn(len(fileNameRunes))]
+		b.WriteRune(rng[0] + rune(r.Intn(int(rng[1]-rng[0]+1))))
+	}
+	b.WriteString(fileNameExtensions[r.Intn(len(fileNameExtensions))])
+	return reflect.ValueOf(unicodeFileName(b.String()))
+}
+
+// allowUnicodeFileNames は、半角英数字以外のファイル名と、すべての形式のアーカイブを受け付けるように設定します。
+func allowUnicodeFileNames(t *testing.T) {
+	t.Helper()
+	t.Setenv("POLICY_LOG_ONLY", policyFileName)
+	t.Setenv("ARCHIVE_FORMATS", "zip,tar.gz,7z,rar")
+	t.Setenv("OPS_CHANNEL", "")
+	t.Setenv("POLICY_REPORT_CHANNEL", "")
+}
+
+// checkObjectKey は、key がS3のキーとして有効で、付属するファイルのキーも上限を超えないかを検査します。
+func checkObjectKey(t *testing.T, key string) {
+	t.Helper()
+	if key == "" || !utf8.ValidString(key) {
+		t.Errorf("key %q is not valid UTF-8", key)
+	}
+	for _, suffix := range []string{"", ".manifest.json", ".meta4", provenanceSuffix} {
+		if len(key+suffix) > maxObjectKeyLength {
+			t.Errorf("key %q is %d bytes, want at most %d", key+suffix, len(key+suffix), maxObjectKeyLength)
+		}
+	}
+}
+
+// originalNameOf は、アップロードしたオブジェクトのメタデータから元のファイル名を復元します。
+func originalNameOf(t *testing.T, header http.Header) (string, bool) {
+	t.Helper()
+	escaped := header.Get("X-Amz-Meta-Original-Name")
+	if escaped == "" {
+		return "", false
+	}
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] >= utf8.RuneSelf {
+			t.Errorf("metadata %q contains non-ASCII bytes", escaped)
+		}
+	}
+	name, err := url.PathUnescape(escaped)
+	if err != nil {
+		t.Errorf("PathUnescape(%q) error = %v", escaped, err)
+	}
+	return name, true
+}
+
+// quickConfig は、プロパティテストで試すファイル名の数と長さです。-short の場合は減らします。
+func quickConfig() *quick.Config {
+	if testing.Short() {
+		return &quick.Config{MaxCount: 50, MaxCountScale: 1}
+	}
+	return &quick.Config{MaxCount: 300}
+}
+
+func TestObjectKeyProperties(t *testing.T) {
+	store := withFakeObjectStore(t)
+	allowUnicodeFileNames(t)
+	ws, err := resolveWorkspace("TKEYS", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func(name string) (*uploadedObject, error) {
+		file := &SlackAppMentionEventFile{ID: "F1", Name: name, Binary: []byte("PK\x05\x06")}
+		return uploadFileToS3AndGetPresignedURL(ws, file, &mentionOptions{})
+	}
+
+	property := func(generated unicodeFileName) bool {
+		name := string(generated)
+		if err := validateFile(&slackevents.AppMentionEvent{}, &SlackAppMentionEventFile{Name: name}); err != nil {
+			return true
+		}
+
+		// 衝突しない場合は、どの戦略でもファイル名をそのままキーにする。
+		for _, strategy := range []string{"", "overwrite", "version", "reject", "suffix"} {
+			t.Setenv("COLLISION_STRATEGY", strategy)
+			store.reset()
+			uploaded, err := upload(name)
+			if err != nil {
+				t.Errorf("COLLISION_STRATEGY=%s: upload(%q) error = %v", strategy, name, err)
+				return false
+			}
+			checkObjectKey(t, uploaded.Key)
+			if uploaded.Key != name {
+				t.Errorf("COLLISION_STRATEGY=%s: key = %q, want %q", strategy, uploaded.Key, name)
+			}
+		}
+
+		// reject は、既存のオブジェクトを上書きしない。
+		t.Setenv("COLLISION_STRATEGY", "reject")
+		if _, err := upload(name); !errors.Is(err, errObjectAlreadyExists) {
+			t.Errorf("COLLISION_STRATEGY=reject: upload(%q) error = %v, want errObjectAlreadyExists", name, err)
+		}
+
+		// suffix は、衝突するたびに別のキーを選び、アーカイブの形式を変えず、元のファイル名をメタデータに残す。
+		t.Setenv("COLLISION_STRATEGY", "suffix")
+		store.reset()
+		_, format, _ := archive.SplitExt(name)
+		keys := map[string]bool{}
+		for i := 0; i < 4; i++ {
+			uploaded, err := upload(name)
+			if err != nil {
+				t.Errorf("COLLISION_STRATEGY=suffix: upload(%q) error = %v", name, err)
+				return false
+			}
+			checkObjectKey(t, uploaded.Key)
+			if keys[uploaded.Key] {
+				t.Errorf("COLLISION_STRATEGY=suffix: key %q was reused", uploaded.Key)
+			}
+			keys[uploaded.Key] = true
+			if _, f, ok := archive.SplitExt(uploaded.Key); !ok || f != format {
+				t.Errorf("COLLISION_STRATEGY=suffix: key %q is not a %s archive", uploaded.Key, format)
+			}
+			original, ok := originalNameOf(t, store.objects[uploaded.Key])
+			if uploaded.Key != name && (!ok || original != name) {
+				t.Errorf("COLLISION_STRATEGY=suffix: original name of %q = %q, want %q", uploaded.Key, original, name)
+			}
+		}
+		return !t.Failed()
+	}
+	if err := quick.Check(property, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRenamedObjectKeepsOriginalName(t *testing.T) {
+	store := withFakeObjectStore(t)
+	allowUnicodeFileNames(t)
+	ws, err := resolveWorkspace("TKEYS", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// name= で変更したファイルは、変更後の名前をキーにし、Slackでの元のファイル名をメタデータから復元できる。
+	property := func(original, renamed unicodeFileName) bool {
+		files := []SlackAppMentionEventFile{{ID: "F1", Name: string(original), Binary: []byte("PK\x05\x06")}}
+		if err := renameFiles(files, &mentionOptions{Name: string(renamed)}); err != nil {
+			t.Errorf("renameFiles() error = %v", err)
+			return false
+		}
+		file := &files[0]
+		if err := validateFile(&slackevents.AppMentionEvent{}, file); err != nil {
+			return true
+		}
+		store.reset()
+		uploaded, err := uploadFileToS3AndGetPresignedURL(ws, file, &mentionOptions{})
+		if err != nil {
+			t.Errorf("upload(%q) error = %v", file.Name, err)
+			return false
+		}
+		checkObjectKey(t, uploaded.Key)
+		if uploaded.Key != string(renamed) {
+			t.Errorf("key = %q, want %q", uploaded.Key, renamed)
+		}
+		got, ok := originalNameOf(t, store.objects[uploaded.Key])
+		if original != renamed && (!ok || got != string(original)) {
+			t.Errorf("original name = %q, want %q", got, original)
+		}
+		return !t.Failed()
+	}
+	if err := quick.Check(property, quickConfig()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLongestFileNameFitsObjectKey(t *testing.T) {
+	store := withFakeObjectStore(t)
+	allowUnicodeFileNames(t)
+	t.Setenv("COLLISION_STRATEGY", "suffix")
+	ws, err := resolveWorkspace("TKEYS", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4バイトの文字で埋めた最長のファイル名に、最大の連番を付けてもキーの上限を超えない。
+	name := strings.Repeat("😀", (maxFileNameLength-len(".tar.gz"))/4) + ".tar.gz"
+	if err := validateFile(&slackevents.AppMentionEvent{}, &SlackAppMentionEventFile{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	store.objects[name] = http.Header{}
+	base, _, _ := archive.SplitExt(name)
+	for i := 1; i < maxSuffixAttempts-1; i++ {
+		store.objects[base+"-"+strconv.Itoa(i)+".tar.gz"] = http.Header{}
+	}
+	file := &SlackAppMentionEventFile{ID: "F1", Name: name, Binary: []byte{0x1f, 0x8b}}
+	uploaded, err := uploadFileToS3AndGetPresignedURL(ws, file, &mentionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkObjectKey(t, uploaded.Key)
+	if want := base + "-" + strconv.Itoa(maxSuffixAttempts-1) + ".tar.gz"; uploaded.Key != want {
+		t.Errorf("key = %q, want %q", uploaded.Key, want)
+	}
+}
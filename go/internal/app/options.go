@@ -0,0 +1,304 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// mentionOptions は、メンション本文で指定されたオプションです。
+// 例: @bot expiry=3d name=release.zip bucket=prod notify=<@U012345> <#C012345|releases> note="RC2 build"
+type mentionOptions struct {
+	Retain      time.Duration   // retain=30d: S3 Object Lock で削除を禁止する期間
+	Expiry      time.Duration   // expiry=3d: 署名付きURLの有効期限（最大7日）
+	Name        string          // name=release.zip: アップロード先のファイル名
+	Password    bool            // password=on: ダウンロードに、受取人に届く確認コードを求める
+	Notify      []string        // notify=<@U...> <#C...>: リンクを共有する相手（Slackのメンション形式）
+	Bucket      string          // bucket=prod: S3_BUCKETS の別名から解決したアップロード先のバケット
+	Note        string          // note="RC2 build": リンクに添える説明
+	PublishAt   time.Time       // publish_at=2024-07-01T09:00+09:00: URLを送信する日時
+	Bundle      string          // bundle=on / bundle=zip: 複数のファイルを1つの短縮URLにまとめる方法
+	Metalink    bool            // metalink=on: 再開・検証できるダウンロード用のメタリンクを添える
+	Class       *retentionClass // class=archive: RETENTION_CLASSES に定義した保存期間の区分
+	Replicate   bool            // replicate=on: 別のリージョンに複製し、予備のリンクを添える
+	For         string          // for=@customers-acme: リンクの受取人とするユーザーグループのID
+	Groups      []string        // groups=eng,security: ポータルでダウンロードを許可するIdPのグループ
+	File        string          // file=docs/manual.pdf: 添付したzipファイルから取り出してアップロードするファイルのパス
+	Recompress  bool            // recompress=on: 転送量を減らすためにファイルを圧縮し直す
+	DLPOverride bool            // dlp=override: 管理者の判断で、DLPの検出があっても公開する
+}
+
+const (
+	bundleModeIndex = "index" // ファイルの一覧のページにまとめる
+	bundleModeZip   = "zip"   // 1つのzipファイルにまとめる
+)
+
+// maxNoteLength は、note に指定できる最大の文字数です。
+const maxNoteLength = 500
+
+// parseMentionOptions は、メンション本文から「key=value」形式のオプションを取り出します。
+// 値に空白を含める場合は「note="RC2 build"」のように引用符で囲みます。
+// notify には、続けて書いたメンションやチャンネルもまとめて指定できます。
+// 不正な値が指定された場合は、Slackにそのまま表示できるメッセージのエラーを返します。
+func parseMentionOptions(text string) (*mentionOptions, error) {
+	opts := &mentionOptions{}
+	fields := splitOptionFields(text)
+	for i := 0; i < len(fields); i++ {
+		key, value, ok := strings.Cut(fields[i], "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "retain":
+			d, err := parseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("retain の値「%s」が不正です。「30d」のように指定してください。", value)
+			}
+			if objectLockMode == "" {
+				return nil, fmt.Errorf("OBJECT_LOCK_MODE の設定が不正なため、retain オプションは利用できません。")
+			}
+			opts.Retain = d
+		case "expiry":
+			d, err := parseDuration(value)
+			if err != nil || d <= 0 || d > presignExpiry {
+				return nil, fmt.Errorf("expiry の値「%s」が不正です。「3d」のように7日以内で指定してください。", value)
+			}
+			opts.Expiry = d
+		case "name":
+			if value == "" {
+				return nil, fmt.Errorf("name の値を指定してください。")
+			}
+			opts.Name = value
+		case "file":
+			if value == "" {
+				return nil, fmt.Errorf("file にはzipファイル内のパスを指定してください。")
+			}
+			opts.File = value
+		case "password":
+			on, err := parseOnOff(key, value)
+			if err != nil {
+				return nil, err
+			}
+			opts.Password = on
+		case "notify":
+			if value != "" {
+				opts.Notify = append(opts.Notify, value)
+			}
+			for i+1 < len(fields) && isSlackReference(fields[i+1]) {
+				i++
+				opts.Notify = append(opts.Notify, fields[i])
+			}
+			if len(opts.Notify) == 0 {
+				return nil, fmt.Errorf("notify にはユーザーまたはチャンネルを指定してください。")
+			}
+		case "note":
+			if len([]rune(value)) > maxNoteLength {
+				return nil, fmt.Errorf("note は%d文字以内で指定してください。", maxNoteLength)
+			}
+			opts.Note = value
+		case "bundle":
+			switch value {
+			case "on", "true", bundleModeIndex:
+				opts.Bundle = bundleModeIndex
+			case bundleModeZip:
+				opts.Bundle = bundleModeZip
+			case "off", "false":
+				opts.Bundle = ""
+			default:
+				return nil, fmt.Errorf("bundle の値「%s」が不正です。「on」「zip」または「off」を指定してください。", value)
+			}
+		case "metalink":
+			on, err := parseOnOff(key, value)
+			if err != nil {
+				return nil, err
+			}
+			opts.Metalink = on
+		case "recompress":
+			on, err := parseOnOff(key, value)
+			if err != nil {
+				return nil, err
+			}
+			opts.Recompress = on
+		case "replicate":
+			on, err := parseOnOff(key, value)
+			if err != nil {
+				return nil, err
+			}
+			if on && replicaS3Client == nil {
+				return nil, fmt.Errorf("replicate オプションはこの環境では利用できません。")
+			}
+			opts.Replicate = on
+		case "publish_at":
+			t, err := parsePublishAt(value)
+			if err != nil {
+				return nil, err
+			}
+			if !t.After(time.Now()) {
+				return nil, fmt.Errorf("publish_at には未来の日時を指定してください。")
+			}
+			opts.PublishAt = t
+		case "for":
+			kind, group, ok := parseSlackReference(value)
+			if !ok || kind != "usergroup" {
+				return nil, fmt.Errorf("for にはユーザーグループ（@customers-acme のようなメンション）を指定してください。")
+			}
+			opts.For = group
+		case "groups":
+			if !portalEnabled() {
+				return nil, fmt.Errorf("groups オプションはこの環境では利用できません。")
+			}
+			for _, g := range strings.Split(value, ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					opts.Groups = append(opts.Groups, g)
+				}
+			}
+			if len(opts.Groups) == 0 {
+				return nil, fmt.Errorf("groups にはダウンロードを許可するグループを指定してください。")
+			}
+		case "dlp":
+			if value != "override" {
+				return nil, fmt.Errorf("dlp の値「%s」が不正です。「override」を指定してください。", value)
+			}
+			opts.DLPOverride = true
+		case "class":
+			class, err := resolveRetentionClass(value)
+			if err != nil {
+				return nil, err
+			}
+			opts.Class = class
+		case "bucket":
+			bucket, err := resolveBucketAlias(value)
+			if err != nil {
+				return nil, err
+			}
+			opts.Bucket = bucket
+		}
+	}
+
+	// パスワードには、受取人の確認ページ（OTP_GATE_URL）で本人に届く確認コードを使う。
+	if opts.Password {
+		if !verificationEnabled() {
+			return nil, fmt.Errorf("password オプションはこの環境では利用できません。")
+		}
+		if opts.For == "" && len(notifiedUsers(opts)) == 0 {
+			return nil, fmt.Errorf("password には、確認コードを受け取る人を for または notify のユーザーで指定してください。")
+		}
+	}
+	// メタリンクには署名付きURLをそのまま記載するため、ダウンロードを制限したリンクには添えられない。
+	if opts.Metalink && linksGated(opts) {
+		return nil, fmt.Errorf("metalink はダウンロードできる人を限定したリンクでは利用できません。")
+	}
+	// 予備のリンクは複製先の署名付きURLのため、確認ページやポータルを経由せずにダウンロードできてしまう。
+	if opts.Replicate && linksGated(opts) {
+		return nil, fmt.Errorf("replicate はダウンロードできる人を限定したリンクでは利用できません。")
+	}
+	// expiry を指定しなかった場合は、区分の有効期限を使う。
+	if opts.Class != nil && opts.Expiry == 0 {
+		d, err := opts.Class.expiry()
+		if err != nil {
+			return nil, err
+		}
+		opts.Expiry = d
+	}
+	return opts, nil
+}
+
+// renameFiles は、name= が指定された場合にファイル名を置き換え、元のファイル名を OriginalName に残します。
+// 変更後のファイル名は、validateFile で元のファイル名と同じ規則で検証されます。
+// bundle=zip の場合、name はまとめたzipファイルの名前になるため、個々のファイル名は変更しません。
+// file= の場合、name は取り出したファイルの名前になるため、extractZipEntry で変更します。
+func renameFiles(files []SlackAppMentionEventFile, opts *mentionOptions) error {
+	if opts.Name == "" || opts.Bundle == bundleModeZip || opts.File != "" {
+		return nil
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("name は1つのファイルをアップロードする場合にのみ指定できます。")
+	}
+	file := &files[0]
+	if file.Name != opts.Name {
+		file.OriginalName = file.Name
+		file.Name = opts.Name
+	}
+	return nil
+}
+
+// parseOnOff は、「on」「off」で指定するオプションの値を解釈します。
+func parseOnOff(key, value string) (bool, error) {
+	switch value {
+	case "on", "true":
+		return true, nil
+	case "off", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s の値「%s」が不正です。「on」または「off」を指定してください。", key, value)
+}
+
+// splitOptionFields は、メンション本文を空白で区切ります。引用符（"..." または “...”）で囲んだ部分は区切らず、引用符は取り除きます。
+func splitOptionFields(text string) []string {
+	var (
+		fields  []string
+		current strings.Builder
+		inQuote bool
+		started bool
+	)
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			inQuote = !inQuote
+			started = true
+		case unicode.IsSpace(r) && !inQuote:
+			if started {
+				fields = append(fields, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// isSlackReference は、Slackのメンション（<@U...>）、チャンネル（<#C...>）、ユーザーグループ（<!subteam^S...>）の形式かを返します。
+func isSlackReference(field string) bool {
+	return strings.HasPrefix(field, "<@") || strings.HasPrefix(field, "<#") || strings.HasPrefix(field, "<!subteam^")
+}
+
+// resolveBucketAlias は、S3_BUCKETS に定義した別名からバケット名を返します。
+// S3_BUCKETS は、別名をキー、バケット名を値とするJSONです（例: {"prod":"example-prod","stg":"example-stg"}）。
+func resolveBucketAlias(alias string) (string, error) {
+	buckets := map[string]string{}
+	if v := os.Getenv("S3_BUCKETS"); v != "" {
+		if err := json.Unmarshal([]byte(v), &buckets); err != nil {
+			return "", fmt.Errorf("S3_BUCKETS の設定が不正です。")
+		}
+	}
+	if bucket, ok := buckets[alias]; ok {
+		return bucket, nil
+	}
+	aliases := make([]string, 0, len(buckets))
+	for a := range buckets {
+		aliases = append(aliases, a)
+	}
+	sort.Strings(aliases)
+	if len(aliases) == 0 {
+		return "", fmt.Errorf("bucket オプションはこの環境では利用できません。")
+	}
+	return "", fmt.Errorf("bucket の値「%s」が不正です。%s のいずれかを指定してください。", alias, strings.Join(aliases, ", "))
+}
+
+// bucketOrDefault は、bucket が空の場合に S3_BUCKET を返します。
+func bucketOrDefault(bucket string) string {
+	if bucket == "" {
+		return os.Getenv("S3_BUCKET")
+	}
+	return bucket
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/otp"
+)
+
+// withVerificationGate は、テストの間だけ受取人の確認ページ（OTP_GATE_URL）を有効にします。
+// ストアは呼び出されない前提の空の実装です。
+func withVerificationGate(t *testing.T) {
+	t.Helper()
+	savedOTP, savedAudit := otpStore, auditStore
+	t.Cleanup(func() { otpStore, auditStore = savedOTP, savedAudit })
+	t.Setenv("OTP_GATE_URL", "https://gate.example/verify")
+	otpStore = struct{ otp.Store }{}
+	auditStore = struct{ audit.Store }{}
+}
+
+func TestParseMentionOptionsObjectLockMode(t *testing.T) {
+	saved := objectLockMode
+	t.Cleanup(func() { objectLockMode = saved })
+	for value, want := range map[string]types.ObjectLockMode{
+		"":           types.ObjectLockModeCompliance,
+		"governance": types.ObjectLockModeGovernance,
+		"COMPLIANCE": types.ObjectLockModeCompliance,
+		"LEGAL_HOLD": "",
+	} {
+		mode, err := parseObjectLockMode(value)
+		if mode != want || (err != nil) != (want == "") {
+			t.Errorf("parseObjectLockMode(%q) = %q, %v, want %q", value, mode, err, want)
+		}
+	}
+
+	// 不正な OBJECT_LOCK_MODE では、意図しないモードで保持しないよう retain を受け付けない。
+	objectLockMode = ""
+	if _, err := parseMentionOptions("<@UBOT> retain=30d"); err == nil {
+		t.Error("retain with an invalid OBJECT_LOCK_MODE error = nil, want error")
+	}
+}
+
+func TestParseMentionOptionsPassword(t *testing.T) {
+	t.Setenv("OTP_GATE_URL", "")
+	if _, err := parseMentionOptions("<@UBOT> password=on notify=<@U012345>"); err == nil {
+		t.Error("password=on without the verification gate error = nil, want error")
+	}
+
+	withVerificationGate(t)
+	opts, err := parseMentionOptions("<@UBOT> password=on notify=<@U012345> <#C012345|releases>")
+	if err != nil {
+		t.Fatalf("parseMentionOptions() error = %v", err)
+	}
+	if !opts.Password || !linksGated(opts) {
+		t.Errorf("opts = %+v, want a gated link with a password", opts)
+	}
+	if got := notifiedUsers(opts); len(got) != 1 || got[0] != "U012345" {
+		t.Errorf("notifiedUsers() = %v, want [U012345]", got)
+	}
+	t.Setenv("DEDUP_MODE", "on")
+	if dedupEnabled(opts) {
+		t.Error("dedupEnabled() = true, want false for a link with a password")
+	}
+	if _, err := parseMentionOptions("<@UBOT> password=on for=<!subteam^S012345|@customers>"); err != nil {
+		t.Errorf("password=on with for error = %v, want nil", err)
+	}
+	// 確認コードを受け取る人がいない場合は、誰もダウンロードできないため拒否する。
+	if _, err := parseMentionOptions("<@UBOT> password=on notify=<#C012345|releases>"); err == nil {
+		t.Error("password=on without recipients error = nil, want error")
+	}
+}
+
+func FuzzParseMentionOptions(f *testing.F) {
+	for _, seed := range []string{
+		"<@UBOT>",
+		"<@UBOT> expiry=3d name=release.zip",
+		`<@UBOT> note="RC2 build" notify=<@U012345> <#C012345|releases>`,
+		"<@UBOT> note=“全角の 引用符” bundle=zip",
+		"<@UBOT> retain=30d metalink=on recompress=off",
+		"<@UBOT> for=<!subteam^S012345|@customers> password=on",
+		"<@UBOT> publish_at=2999-07-01T09:00+09:00 dlp=override",
+		"<@UBOT> expiry=106752d retain=-1d file=docs/manual.pdf",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		opts, err := parseMentionOptions(text)
+		if err != nil {
+			if opts != nil {
+				t.Errorf("parseMentionOptions(%q) returned options with error %v", text, err)
+			}
+			return
+		}
+		if opts.Retain < 0 {
+			t.Errorf("Retain = %v, want positive", opts.Retain)
+		}
+		if opts.Expiry < 0 || opts.Expiry > presignExpiry {
+			t.Errorf("Expiry = %v, want within %v", opts.Expiry, presignExpiry)
+		}
+		if utf8.RuneCountInString(opts.Note) > maxNoteLength {
+			t.Errorf("Note has %d runes, want at most %d", utf8.RuneCountInString(opts.Note), maxNoteLength)
+		}
+		if opts.Password && !verificationEnabled() {
+			t.Error("Password is enabled without the verification gate")
+		}
+		if opts.Password && opts.For == "" && len(notifiedUsers(opts)) == 0 {
+			t.Error("Password is enabled without anyone to receive the code")
+		}
+		if (opts.Metalink || opts.Replicate) && linksGated(opts) {
+			t.Error("metalink or replicate was accepted for a gated link")
+		}
+		if opts.Bundle != "" && opts.Bundle != bundleModeIndex && opts.Bundle != bundleModeZip {
+			t.Errorf("Bundle = %q", opts.Bundle)
+		}
+	})
+}
+
+func FuzzSplitOptionFields(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"a b  c",
+		`note="RC2 build" name=a.zip`,
+		"note=“全角の 引用符”",
+		`unterminated="quote here`,
+		"tab\tand\nnewline　ideographic",
+		`""`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		fields := splitOptionFields(text)
+		for _, field := range fields {
+			if strings.ContainsAny(field, `"“”`) {
+				t.Errorf("field %q still contains a quote", field)
+			}
+		}
+		// 引用符を含まない本文は、strings.Fields と同じように区切る。
+		if utf8.ValidString(text) && !strings.ContainsAny(text, `"“”`) {
+			want := strings.Fields(text)
+			if strings.Join(fields, "\x00") != strings.Join(want, "\x00") || len(fields) != len(want) {
+				t.Errorf("splitOptionFields(%q) = %q, want %q", text, fields, want)
+			}
+		}
+	})
+}
+
+func FuzzParseDuration(f *testing.F) {
+	for _, seed := range []string{"30d", "3d", "1h30m", "0d", "-1d", "106752d", "9223372036854775807d", "d", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		d, err := parseDuration(s)
+		if err != nil || !strings.HasSuffix(s, "d") {
+			return
+		}
+		// 日単位の指定は、桁あふれせずに指定した日数になる。
+		n, _ := strconv.ParseInt(strings.TrimSuffix(s, "d"), 10, 64)
+		if d/(24*time.Hour) != time.Duration(n) || d%(24*time.Hour) != 0 {
+			t.Errorf("parseDuration(%q) = %v, want %d days", s, d, n)
+		}
+	})
+}
@@ -0,0 +1,544 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/kumagai-s/uploader-v2/internal/approval"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/hooks"
+	"github.com/kumagai-s/uploader-v2/internal/membudget"
+	"github.com/kumagai-s/uploader-v2/internal/secretscan"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// pipelineJob は、Step Functions の各ステートの間で受け渡す処理の状態です。
+// ファイルの内容は状態に含められないため、ステージング用のS3キーに保存して受け渡します。
+type pipelineJob struct {
+	ExecutionARN string          `json:"execution_arn,omitempty"`
+	TeamID       string          `json:"team_id"`
+	EnterpriseID string          `json:"enterprise_id,omitempty"`
+	Channel      string          `json:"channel"`
+	ThreadTS     string          `json:"thread_ts"`
+	User         string          `json:"user"`
+	Text         string          `json:"text"`
+	Files        []*pipelineFile `json:"files"`
+	// AwaitingApproval は、二人承認のため承認者に申請し、URLの発行を承認後に行うことを表します。
+	AwaitingApproval bool `json:"awaiting_approval,omitempty"`
+	// Scheduled は、publish_at でURLの送信を予約したことを表します。
+	Scheduled bool           `json:"scheduled,omitempty"`
+	Error     *pipelineError `json:"error,omitempty"` // 失敗したステートの Catch で設定されるエラー
+}
+
+// pipelineFile は、処理中の1ファイルの状態です。
+type pipelineFile struct {
+	SlackAppMentionEventFile
+	StagingKey string `json:"staging_key,omitempty"`
+	Warnings   string `json:"warnings,omitempty"`
+	Bucket     string `json:"bucket,omitempty"`
+	ObjectKey  string `json:"object_key,omitempty"`
+	VersionID  string `json:"version_id,omitempty"`
+	Region     string `json:"region,omitempty"`
+	ShortURL   string `json:"short_url,omitempty"`
+	AuditID    string `json:"audit_id,omitempty"` // ポータルや確認ページのリンクが指す監査記録のID
+	ExpiresAt  int64  `json:"expires_at,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	Manifest   string `json:"manifest,omitempty"` // 署名付きマニフェストの短縮URLを知らせる行
+	// Duplicate は、DEDUP_MODE=on で見つかった同じ内容の公開済みのファイルの監査記録です。アップロードせずにそのリンクを返します。
+	Duplicate *audit.Record `json:"duplicate,omitempty"`
+}
+
+// pipelineError は、Step Functions の Catch が ResultPath に設定するエラーです。
+type pipelineError struct {
+	Error string `json:"Error"`
+	Cause string `json:"Cause"`
+}
+
+// stageInput は、ステート用のLambdaに渡される入力です。
+type stageInput struct {
+	Stage        string      `json:"stage"`
+	ExecutionARN string      `json:"execution_arn"`
+	Job          pipelineJob `json:"job"`
+}
+
+// rejectionError は、検証やポリシーによってファイルの処理を拒否したことを表します。
+// Step Functions ではエラー型の名前で判定し、再試行しません。メッセージはそのままSlackに表示します。
+type rejectionError struct {
+	message string
+}
+
+func (e *rejectionError) Error() string {
+	return e.message
+}
+
+func (e *rejectionError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// event は、ジョブから DLP などの検査に渡す AppMentionEvent を復元します。
+func (job *pipelineJob) event() *slackevents.AppMentionEvent {
+	return &slackevents.AppMentionEvent{
+		User:      job.User,
+		Text:      job.Text,
+		Channel:   job.Channel,
+		TimeStamp: job.ThreadTS,
+	}
+}
+
+// pipelineAllowed は、Step Functions で処理するメンションに、パイプラインでは扱えないオプションが指定されていないかを確認します。
+// 予備のリンクとメタリンクは、その場で処理する場合にのみ添えられます。
+func pipelineAllowed(files []SlackAppMentionEventFile, opts *mentionOptions) error {
+	if os.Getenv("EXECUTION_MODE") != "stepfunctions" || processInline(files) {
+		return nil
+	}
+	switch {
+	case opts.Replicate:
+		return fmt.Errorf("replicate はこのサイズのファイルには指定できません。")
+	case opts.Metalink:
+		return fmt.Errorf("metalink はこのサイズのファイルには指定できません。")
+	}
+	return nil
+}
+
+// startPipelineExecution は、Step Functions（STATE_MACHINE_ARN）の実行を開始し、受け付けたことをSlackに返信します。
+func startPipelineExecution(ws *workspace, ev *slackevents.AppMentionEvent, files []SlackAppMentionEventFile) error {
+	job := pipelineJob{
+		TeamID:       ws.TeamID,
+		EnterpriseID: ws.EnterpriseID,
+		Channel:      ev.Channel,
+		ThreadTS:     ev.TimeStamp,
+		User:         ev.User,
+		Text:         ev.Text,
+	}
+	for _, file := range files {
+		job.Files = append(job.Files, &pipelineFile{SlackAppMentionEventFile: file})
+	}
+	input, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	out, err := sfnClient.StartExecution(context.TODO(), &sfn.StartExecutionInput{
+		StateMachineArn: aws.String(os.Getenv("STATE_MACHINE_ARN")),
+		Input:           aws.String(string(input)),
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("Step Functions の実行を開始しました。", aws.ToString(out.ExecutionArn))
+
+	_, _, err = ws.Bot.PostMessage(
+		ev.Channel,
+		slack.MsgOptionText(acceptedMessage, false),
+		slack.MsgOptionTS(ev.TimeStamp),
+	)
+	return err
+}
+
+// handleStage は、Step Functions の各ステートから呼び出され、パイプラインの1段階を実行します。
+// 処理後のジョブを返し、次のステートの入力とします。
+func handleStage(ctx context.Context, input stageInput) (*pipelineJob, error) {
+	job := &input.Job
+	job.ExecutionARN = input.ExecutionARN
+	ws, err := resolveWorkspace(job.TeamID, job.EnterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	// 同じ実行の各段階を、実行名ごとにまとめて保存する。
+	capture := startDebugCapture(input.ExecutionARN[strings.LastIndex(input.ExecutionARN, ":")+1:])
+	capture.add(input.Stage+"-input", input)
+
+	switch input.Stage {
+	case "download":
+		err = runDownloadStage(ctx, ws, job)
+	case "scan":
+		err = runScanStage(ctx, ws, job)
+	case "upload":
+		err = runUploadStage(ctx, job)
+	case "shorten":
+		err = runShortenStage(ws, job)
+	case "notify":
+		err = runNotifyStage(ws, job)
+	case "fail":
+		err = runFailStage(ws, job)
+	default:
+		err = fmt.Errorf("unknown stage %q", input.Stage)
+	}
+	if err != nil {
+		log.Println("パイプラインの処理中にエラーが発生しました。", input.Stage, err)
+		capture.add(input.Stage+"-error", map[string]string{"error": err.Error()})
+		return nil, err
+	}
+	capture.add(input.Stage+"-output", job)
+	return job, nil
+}
+
+// stagingKey は、処理中のファイルを一時的に保存するS3キーを返します。
+// バケットのライフサイクルルールで STAGING_PREFIX 以下を削除するよう設定してください。
+func stagingKey(job *pipelineJob, file *pipelineFile) string {
+	return path.Join(getEnvOrDefault("STAGING_PREFIX", ".staging"), path.Base(job.ExecutionARN), file.ID, file.Name)
+}
+
+func putStagingObject(ctx context.Context, key string, data []byte) error {
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(os.Getenv("S3_BUCKET")),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func getStagingObject(ctx context.Context, key string) ([]byte, error) {
+	out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("S3_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// runDownloadStage は、ファイルをSlackから取得してステージング用のキーに保存します。
+// Slackからの削除は、検査をすべて通過した後に runScanStage で行います。
+func runDownloadStage(ctx context.Context, ws *workspace, job *pipelineJob) error {
+	ev := job.event()
+	for _, file := range job.Files {
+		if err := checkExternalFilePolicy(ws, ev, &file.SlackAppMentionEventFile); err != nil {
+			var policyErr *policyError
+			if errors.As(err, &policyErr) {
+				return &rejectionError{message: policyErr.Error()}
+			}
+			return err
+		}
+
+		buf, err := downloadSlackFile(ctx, ws, &file.SlackAppMentionEventFile)
+		if err != nil {
+			var storageErr *membudget.InsufficientStorageError
+			if errors.As(err, &storageErr) {
+				return &rejectionError{message: "ファイルが大きすぎるため処理できません。"}
+			}
+			return err
+		}
+		data, err := buf.Bytes()
+		if err == nil {
+			file.StagingKey = stagingKey(job, file)
+			err = putStagingObject(ctx, file.StagingKey, data)
+		}
+		buf.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runScanStage は、ファイルの検証、DLP・シークレットの検査、PDFへのスタンプと、recompress=on の場合の再圧縮を行います。
+// すべてのファイルが検査を通過した場合にのみ、Slackからファイルを削除します。
+func runScanStage(ctx context.Context, ws *workspace, job *pipelineJob) error {
+	ev := job.event()
+	opts, err := parseMentionOptions(job.Text)
+	if err != nil {
+		return &rejectionError{message: err.Error()}
+	}
+	for _, file := range job.Files {
+		binary, err := getStagingObject(ctx, file.StagingKey)
+		if err != nil {
+			return err
+		}
+		f := &file.SlackAppMentionEventFile
+		f.Binary = binary
+
+		if err := validateFile(ev, f); err != nil {
+			return &rejectionError{message: err.Error()}
+		}
+		if err := scanFileWithDLP(ev, f, opts); err != nil {
+			var violation *dlpViolationError
+			if errors.As(err, &violation) {
+				return &rejectionError{message: violation.Error()}
+			}
+			return err
+		}
+		secretFindings, err := scanFileForSecrets(f)
+		if err != nil {
+			return err
+		}
+		if len(secretFindings) > 0 {
+			if os.Getenv("SECRET_SCAN_MODE") == "block" {
+				return &rejectionError{message: "APIキーや秘密鍵などのシークレットが含まれている可能性があるため公開できません。\n" + secretscan.Summary(secretFindings)}
+			}
+			file.Warnings = (&publishedFile{secretFindings: secretFindings}).warnings()
+		}
+
+		runHooks(hooks.StageAfterValidate, fileHookEvent(ws, ev, f, nil))
+
+		if err := watermarkPDFs(ws, ev, f); err != nil {
+			return err
+		}
+		recompressFile(f, opts)
+		if !bytes.Equal(binary, f.Binary) {
+			if err := putStagingObject(ctx, file.StagingKey, f.Binary); err != nil {
+				return err
+			}
+		}
+		f.Binary = nil
+	}
+
+	// 1つでも拒否した場合は、投稿者が修正できるようSlackにファイルを残しておく。
+	for _, file := range job.Files {
+		// 再試行で既に削除済みの場合は、削除できたものとして扱う。
+		if err := deleteSlackFile(ctx, ws, file.ID); err != nil && !strings.Contains(err.Error(), "file_not_found") {
+			return err
+		}
+	}
+	return nil
+}
+
+// runUploadStage は、ステージング用のキーからアップロード先のキーにファイルを保存します。
+// MANIFEST_KMS_KEY_ID が設定されている場合は、ファイルの内容を読み込んでいるこの段階で署名付きマニフェストを発行します。
+// DEDUP_MODE=on で同じ内容のファイルが既に公開されている場合は、アップロードせずに既存のリンクを返します。
+func runUploadStage(ctx context.Context, job *pipelineJob) error {
+	opts, err := parseMentionOptions(job.Text)
+	if err != nil {
+		return &rejectionError{message: err.Error()}
+	}
+	for _, file := range job.Files {
+		// 再試行で既にアップロード済みの場合は、重複してアップロードしない。
+		if file.ObjectKey != "" || file.Duplicate != nil {
+			continue
+		}
+		binary, err := getStagingObject(ctx, file.StagingKey)
+		if err != nil {
+			return err
+		}
+		f := &file.SlackAppMentionEventFile
+		f.Binary = binary
+		file.SHA256 = contentSHA256(binary)
+		ws, err := resolveWorkspace(job.TeamID, job.EnterpriseID)
+		if err != nil {
+			return err
+		}
+
+		if dedupEnabled(opts) {
+			duplicate, err := auditStore.FindActiveBySHA256(ctx, job.TeamID, file.SHA256, time.Now())
+			if err != nil {
+				log.Println("公開済みのファイルの検索中にエラーが発生しました。", err)
+			} else if duplicate != nil {
+				file.Duplicate = duplicate
+				file.Size = int64(len(binary))
+				f.Binary = nil
+				if err := deleteObject(s3Client, "", file.StagingKey, ""); err != nil {
+					log.Println("ステージング用のファイルの削除中にエラーが発生しました。", err)
+				}
+				continue
+			}
+		}
+
+		uploaded, err := uploadFileToS3AndGetPresignedURL(ws, f, opts)
+		if errors.Is(err, errObjectAlreadyExists) {
+			return &rejectionError{message: err.Error()}
+		}
+		if err != nil {
+			return err
+		}
+		file.Bucket = uploaded.Bucket
+		file.ObjectKey = uploaded.Key
+		file.VersionID = uploaded.VersionID
+		file.Region = uploaded.Region
+		file.Size = int64(len(binary))
+		// 来歴を検証できないままファイルを共有しないよう、マニフェストを発行できない場合は失敗させる。
+		file.Manifest, err = manifestMessage(ws, job.event(), &publishedFile{file: f, uploaded: uploaded})
+		if err != nil {
+			return err
+		}
+		f.Binary = nil
+		runHooks(hooks.StageAfterUpload, &hooks.Event{
+			TeamID:       job.TeamID,
+			EnterpriseID: job.EnterpriseID,
+			Channel:      job.Channel,
+			User:         job.User,
+			FileName:     file.Name,
+			Size:         file.Size,
+			Bucket:       file.Bucket,
+			ObjectKey:    file.ObjectKey,
+			VersionID:    file.VersionID,
+		})
+
+		if err := deleteObject(s3Client, "", file.StagingKey, ""); err != nil {
+			log.Println("ステージング用のファイルの削除中にエラーが発生しました。", err)
+		}
+	}
+	return nil
+}
+
+// runShortenStage は、アップロードしたファイルの署名付きURLを生成し、まとめて短縮します。
+// 二人承認が有効な場合は、URLを発行せずに承認者に申請します。
+func runShortenStage(ws *workspace, job *pipelineJob) error {
+	opts, err := parseMentionOptions(job.Text)
+	if err != nil {
+		return &rejectionError{message: err.Error()}
+	}
+	if approvalRequired() {
+		if !approversConfigured() {
+			return &rejectionError{message: errApproversNotConfigured.Error()}
+		}
+		for _, file := range job.Files {
+			if err := requestApproval(ws, &approval.Request{
+				TeamID:        job.TeamID,
+				EnterpriseID:  job.EnterpriseID,
+				Channel:       job.Channel,
+				ThreadTS:      job.ThreadTS,
+				Requester:     job.User,
+				FileName:      file.Name,
+				Bucket:        file.Bucket,
+				ObjectKey:     file.ObjectKey,
+				VersionID:     file.VersionID,
+				Region:        file.Region,
+				Size:          file.Size,
+				Expiry:        int64(opts.Expiry / time.Second),
+				Notify:        opts.Notify,
+				Note:          opts.Note,
+				Warnings:      file.Warnings,
+				AllowedGroups: portalAllowedGroups(opts),
+			}); err != nil {
+				return err
+			}
+		}
+		job.AwaitingApproval = true
+		return nil
+	}
+
+	if !opts.PublishAt.IsZero() {
+		for _, file := range job.Files {
+			if err := schedulePublication(ws, &scheduledPublication{
+				TeamID:        job.TeamID,
+				EnterpriseID:  job.EnterpriseID,
+				Channel:       job.Channel,
+				ThreadTS:      job.ThreadTS,
+				Requester:     job.User,
+				FileName:      file.Name,
+				Bucket:        file.Bucket,
+				ObjectKey:     file.ObjectKey,
+				VersionID:     file.VersionID,
+				Region:        file.Region,
+				Size:          file.Size,
+				Warnings:      file.Warnings,
+				Expiry:        int64(opts.Expiry / time.Second),
+				Notify:        opts.Notify,
+				Note:          opts.Note,
+				AllowedGroups: portalAllowedGroups(opts),
+			}, opts.PublishAt); err != nil {
+				return err
+			}
+		}
+		job.Scheduled = true
+		return nil
+	}
+
+	// 既存のリンクを返すファイルは、URLを発行しない。
+	var files []*pipelineFile
+	for _, file := range job.Files {
+		if file.Duplicate == nil {
+			files = append(files, file)
+		}
+	}
+	longURLs := make([]string, 0, len(files))
+	expiresAt := make([]time.Time, 0, len(files))
+	for _, file := range files {
+		uploaded := &uploadedObject{Bucket: file.Bucket, Key: file.ObjectKey, VersionID: file.VersionID, Region: file.Region, TeamID: job.TeamID, EnterpriseID: job.EnterpriseID, Expiry: opts.Expiry}
+		if err := presignObject(uploaded); err != nil {
+			return err
+		}
+		longURLs = append(longURLs, uploaded.PresignedURL)
+		expiresAt = append(expiresAt, uploaded.ExpiresAt)
+	}
+	shortURLs, auditIDs, err := shortenLinks(shortenerFor(job.TeamID, job.Channel), longURLs, nil)
+	if err != nil {
+		return err
+	}
+	for i, file := range files {
+		file.ShortURL = shortURLs[i]
+		file.AuditID = auditIDs[i]
+		file.ExpiresAt = expiresAt[i].Unix()
+	}
+	return nil
+}
+
+// runNotifyStage は、短縮URLをSlackのスレッドに送信し、実行ARNとともに監査記録を保存します。
+func runNotifyStage(ws *workspace, job *pipelineJob) error {
+	if job.AwaitingApproval || job.Scheduled {
+		return nil
+	}
+	opts, err := parseMentionOptions(job.Text)
+	if err != nil {
+		return &rejectionError{message: err.Error()}
+	}
+	for _, file := range job.Files {
+		if file.Duplicate != nil {
+			message := formatPublishedMessage(file.Duplicate.ShortURL, file.Size, file.Warnings) + duplicateMessage(file.Duplicate)
+			if err := notifyPublished(context.TODO(), ws, job.Channel, job.ThreadTS, job.User, opts.Notify, message, opts.Note); err != nil {
+				return err
+			}
+			continue
+		}
+		message := formatPublishedMessage(file.ShortURL, file.Size, file.Warnings) + recompressionMessage(&file.SlackAppMentionEventFile) + file.Manifest + portalMessage(opts, nil)
+		if err := notifyPublished(context.TODO(), ws, job.Channel, job.ThreadTS, job.User, opts.Notify, message, opts.Note); err != nil {
+			return err
+		}
+		recordAudit(&audit.Record{
+			ID:            file.AuditID,
+			TeamID:        job.TeamID,
+			EnterpriseID:  job.EnterpriseID,
+			Channel:       job.Channel,
+			MessageTS:     job.ThreadTS,
+			User:          job.User,
+			FileName:      file.Name,
+			Bucket:        file.Bucket,
+			ObjectKey:     file.ObjectKey,
+			VersionID:     file.VersionID,
+			Region:        file.Region,
+			Size:          file.Size,
+			ShortURL:      file.ShortURL,
+			ExpiresAt:     file.ExpiresAt,
+			ExecutionARN:  job.ExecutionARN,
+			Note:          opts.Note,
+			SHA256:        file.SHA256,
+			AllowedGroups: portalAllowedGroups(opts),
+		})
+	}
+	return nil
+}
+
+// runFailStage は、いずれかのステートが失敗した場合に、エラーメッセージをSlackのスレッドに送信します。
+func runFailStage(ws *workspace, job *pipelineJob) error {
+	message := "エラーが発生しました。処理を完了できませんでした。"
+	if job.Error != nil && job.Error.Error == "rejectionError" {
+		// Cause には、Lambdaのエラーが {"errorMessage": ..., "errorType": ...} のJSONで格納される。
+		var cause struct {
+			ErrorMessage string `json:"errorMessage"`
+		}
+		if err := json.Unmarshal([]byte(job.Error.Cause), &cause); err == nil && cause.ErrorMessage != "" {
+			message = cause.ErrorMessage
+		}
+	}
+	_, _, err := ws.Bot.PostMessage(
+		job.Channel,
+		slack.MsgOptionText(message, false),
+		slack.MsgOptionTS(job.ThreadTS),
+	)
+	return err
+}
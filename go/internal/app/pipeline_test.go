@@ -0,0 +1,243 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+)
+
+// transferSizes は、ベンチマークで転送するファイルのサイズです。
+var transferSizes = []struct {
+	name string
+	size int64
+}{
+	{"10MB", 10 << 20},
+	{"100MB", 100 << 20},
+	{"1GB", 1 << 30},
+}
+
+// patternBlock は、合成したファイルの内容として繰り返すバイト列です。
+var patternBlock = func() []byte {
+	b := make([]byte, 32<<10)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}()
+
+// patternReader は、n バイトの合成した内容を返す io.Reader です。
+type patternReader struct {
+	n int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], patternBlock)
+	}
+	r.n -= int64(n)
+	return n, nil
+}
+
+// syntheticTransport は、ネットワークを使わずにSlackとS3の代わりに応答する http.RoundTripper です。
+// Slackのファイルは size バイトの合成した内容を返し（Range リクエストに対応）、S3へのアップロードは受け取った内容を読み捨てます。
+type syntheticTransport struct {
+	size int64
+}
+
+func (s *syntheticTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "files.slack.test" {
+		return s.download(req), nil
+	}
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	query := req.URL.Query()
+	var body string
+	switch {
+	case req.Method == http.MethodPost && query.Has("uploads"):
+		body = `<InitiateMultipartUploadResult><Bucket>bench</Bucket><Key>bench.zip</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`
+	case req.Method == http.MethodPost && query.Has("uploadId"):
+		body = `<CompleteMultipartUploadResult><Bucket>bench</Bucket><Key>bench.zip</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Etag": {`"etag"`}, "Content-Type": {"application/xml"}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		Request:       req,
+	}, nil
+}
+
+func (s *syntheticTransport) download(req *http.Request) *http.Response {
+	start, end, status := int64(0), s.size-1, http.StatusOK
+	if r := req.Header.Get("Range"); r != "" {
+		from, to, _ := strings.Cut(strings.TrimPrefix(r, "bytes="), "-")
+		start, _ = strconv.ParseInt(from, 10, 64)
+		if to != "" {
+			end, _ = strconv.ParseInt(to, 10, 64)
+		}
+		status = http.StatusPartialContent
+	}
+	length := end - start + 1
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Content-Length": {strconv.FormatInt(length, 10)}},
+		ContentLength: length,
+		Body:          io.NopCloser(&patternReader{n: length}),
+		Request:       req,
+	}
+}
+
+// withSyntheticTransfer は、Slackからのダウンロードと S3 へのアップロードを syntheticTransport に向けます。
+func withSyntheticTransfer(tb testing.TB, size int64) {
+	tb.Helper()
+	savedHTTPClient, savedS3Client, savedPresignClient := httpClient, s3Client, s3PresignClient
+	tb.Cleanup(func() {
+		httpClient, s3Client, s3PresignClient = savedHTTPClient, savedS3Client, savedPresignClient
+	})
+	tb.Setenv("S3_BUCKET", "bench")
+	tb.Setenv("BUCKET_GUARD", "off")
+
+	client := &http.Client{Transport: &syntheticTransport{size: size}}
+	httpClient = client
+	s3Client = s3.New(s3.Options{
+		Region:       "ap-northeast-1",
+		Credentials:  aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider("AKIDBENCH", "secret", "")),
+		HTTPClient:   client,
+		UsePathStyle: true,
+	})
+	s3PresignClient = s3.NewPresignClient(s3Client)
+}
+
+func TestDownloadHashUploadSynthetic(t *testing.T) {
+	withSyntheticTransfer(t, 1<<20)
+	ws, err := resolveWorkspace("TBENCH", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &SlackAppMentionEventFile{ID: "F1", Name: "bench.zip", URLPrivateDownload: "https://files.slack.test/bench.zip", Size: 1 << 20}
+	buf, err := downloadSlackFile(context.Background(), ws, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Close()
+	if file.Binary, err = buf.Bytes(); err != nil || len(file.Binary) != 1<<20 {
+		t.Fatalf("downloaded %d bytes, %v", len(file.Binary), err)
+	}
+	uploaded, err := uploadFileToS3AndGetPresignedURL(ws, file, &mentionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.Key != "bench.zip" || !strings.Contains(uploaded.PresignedURL, "X-Amz-Signature=") {
+		t.Errorf("uploaded = %+v", uploaded)
+	}
+}
+
+// BenchmarkDownloadHashUpload は、Slackからファイルを取得し、SHA-256 を計算して S3 にアップロードし、署名付きURLを生成するまでを計測します。
+// 64MB 以上のファイルは、並列のRangeリクエストでの取得と、マルチパートアップロードになります。
+// 1GB のファイルはメモリの予算を超えるため、一時ファイルに書き出します。-short の場合は省略します。
+func BenchmarkDownloadHashUpload(b *testing.B) {
+	for _, ts := range transferSizes {
+		b.Run(ts.name, func(b *testing.B) {
+			if testing.Short() && ts.size >= 1<<30 {
+				b.Skip("-short のため 1GB のファイルを省略します。")
+			}
+			withSyntheticTransfer(b, ts.size)
+			ws, err := resolveWorkspace("TBENCH", "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(ts.size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				file := &SlackAppMentionEventFile{ID: "F1", Name: "bench.zip", URLPrivateDownload: "https://files.slack.test/bench.zip", Size: ts.size}
+				buf, err := downloadSlackFile(context.Background(), ws, file)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if file.Binary, err = buf.Bytes(); err != nil {
+					b.Fatal(err)
+				}
+				contentSHA256(file.Binary)
+				if _, err := uploadFileToS3AndGetPresignedURL(ws, file, &mentionOptions{}); err != nil {
+					b.Fatal(err)
+				}
+				buf.Close()
+			}
+		})
+	}
+}
+
+func TestPipelineAllowed(t *testing.T) {
+	t.Setenv("EXECUTION_MODE", "stepfunctions")
+	t.Setenv("INLINE_SIZE_THRESHOLD", "1024")
+	large := []SlackAppMentionEventFile{{ID: "F1", Name: "large.zip", Size: 4096}}
+	small := []SlackAppMentionEventFile{{ID: "F1", Name: "small.zip", Size: 512}}
+
+	for _, opts := range []*mentionOptions{{Replicate: true}, {Metalink: true}} {
+		if err := pipelineAllowed(large, opts); err == nil {
+			t.Errorf("pipelineAllowed(%+v) error = nil, want error", opts)
+		}
+		// その場で処理する小さなファイルでは、すべてのオプションを扱える。
+		if err := pipelineAllowed(small, opts); err != nil {
+			t.Errorf("pipelineAllowed(%+v) for a small file error = %v, want nil", opts, err)
+		}
+	}
+	if err := pipelineAllowed(large, &mentionOptions{Groups: []string{"eng"}, Expiry: presignExpiry}); err != nil {
+		t.Errorf("pipelineAllowed() error = %v, want nil", err)
+	}
+
+	t.Setenv("EXECUTION_MODE", "")
+	if err := pipelineAllowed(large, &mentionOptions{Replicate: true}); err != nil {
+		t.Errorf("pipelineAllowed() without Step Functions error = %v, want nil", err)
+	}
+}
+
+// duplicateStore は、どの内容にも duplicate を返す監査記録のストアです。
+type duplicateStore struct {
+	audit.Store
+	duplicate *audit.Record
+}
+
+func (s *duplicateStore) FindActiveBySHA256(ctx context.Context, teamID, sum string, now time.Time) (*audit.Record, error) {
+	return s.duplicate, nil
+}
+
+func TestRunUploadStageReusesDuplicate(t *testing.T) {
+	withSyntheticTransfer(t, 0)
+	t.Setenv("DEDUP_MODE", "on")
+	saved := auditStore
+	t.Cleanup(func() { auditStore = saved })
+	duplicate := &audit.Record{ShortURL: "https://s.example/abc", User: "U2", CreatedAt: time.Now().Unix()}
+	auditStore = &duplicateStore{duplicate: duplicate}
+
+	job := &pipelineJob{TeamID: "TBENCH", Channel: "C1", ThreadTS: "1.0", User: "U1", Files: []*pipelineFile{{
+		SlackAppMentionEventFile: SlackAppMentionEventFile{ID: "F1", Name: "bench.zip"},
+		StagingKey:               ".staging/exec/F1/bench.zip",
+	}}}
+	if err := runUploadStage(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	file := job.Files[0]
+	if file.Duplicate != duplicate || file.ObjectKey != "" || file.SHA256 != contentSHA256(nil) {
+		t.Errorf("file = %+v, want the duplicate without uploading", file)
+	}
+}
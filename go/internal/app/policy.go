@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// POLICY_LOG_ONLY に指定できる規則の名前です。
+const (
+	policyExternalFile = "external_file" // EXTERNAL_FILE_POLICY による外部のユーザーのファイルの制限
+	policyDLP          = "dlp"           // DLP_BLOCK_LIKELIHOOD 以上の機密情報の検出
+	policyFileName     = "file_name"     // ファイル名を半角英数字に限る規則
+)
+
+// policyError は、ポリシーによって処理を拒否したことを表します。メッセージはそのままSlackに表示します。
+type policyError struct {
+	message string
+}
+
+func (e *policyError) Error() string {
+	return e.message
+}
+
+// isInternalTeam は、チームIDが自組織のものかどうかを判定します。
+// 自組織のチームは、イベントが発生したチームと INTERNAL_TEAM_IDS（Enterprise Grid の他のワークスペースなど）です。
+func isInternalTeam(ws *workspace, teamID string) bool {
+	if teamID == ws.TeamID || (ws.EnterpriseID != "" && teamID == ws.EnterpriseID) {
+		return true
+	}
+	for _, id := range splitEnvList("INTERNAL_TEAM_IDS") {
+		if id == teamID {
+			return true
+		}
+	}
+	return false
+}
+
+// isExternalUploader は、ファイルをアップロードしたユーザーが自組織の外（Slack Connect のユーザーなど）かどうかを判定します。
+// ファイルの user_team に加えて、users.info でユーザーの所属チームと外部ユーザーのフラグを確認します。
+func isExternalUploader(ws *workspace, file *SlackAppMentionEventFile) (bool, error) {
+	if file.UserTeam != "" && !isInternalTeam(ws, file.UserTeam) {
+		return true, nil
+	}
+	if file.User == "" {
+		return false, nil
+	}
+
+	user, err := cachedUserInfo(context.TODO(), ws, file.User)
+	if err != nil {
+		return false, err
+	}
+	if user.IsStranger {
+		return true, nil
+	}
+	// Enterprise Grid では、同じ Enterprise に所属するユーザーは自組織とみなす。
+	if ws.EnterpriseID != "" && user.Enterprise.EnterpriseID == ws.EnterpriseID {
+		return false, nil
+	}
+	return user.TeamID != "" && !isInternalTeam(ws, user.TeamID), nil
+}
+
+// isAdminUser は、ユーザーが ADMIN_USER_IDS に含まれる管理者かどうかを判定します。
+func isAdminUser(userID string) bool {
+	for _, id := range splitEnvList("ADMIN_USER_IDS") {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// externalFilePolicy は、EXTERNAL_FILE_POLICY（デフォルト refuse）を返します。
+func externalFilePolicy() string {
+	return getEnvOrDefault("EXTERNAL_FILE_POLICY", "refuse")
+}
+
+// checkExternalFilePolicy は、EXTERNAL_FILE_POLICY に従って外部のユーザーがアップロードしたファイルの処理を判定します。
+// ・refuse（デフォルト）: 処理を拒否します。
+// ・approval: 管理者がメンションに「external=approve」を含めた場合のみ処理します。
+// ・allow: 処理します。
+// refuse と approval では、アップロードしたユーザーの組織を users.info で確認するため、ボットのトークンに users:read のスコープが必要です。
+// 処理を拒否する場合は policyError を返します。
+func checkExternalFilePolicy(ws *workspace, ev *slackevents.AppMentionEvent, file *SlackAppMentionEventFile) error {
+	policy := externalFilePolicy()
+	if policy == "allow" {
+		return nil
+	}
+
+	external, err := isExternalUploader(ws, file)
+	if err != nil {
+		return err
+	}
+	if !external {
+		return nil
+	}
+
+	if policy == "approval" {
+		if isAdminUser(ev.User) && strings.Contains(ev.Text, "external=approve") {
+			return nil
+		}
+		return enforcePolicy(ev, policyExternalFile, &policyError{message: "外部の組織のユーザーがアップロードしたファイルです。処理するには管理者の承認（external=approve）が必要です。"})
+	}
+	return enforcePolicy(ev, policyExternalFile, &policyError{message: "外部の組織のユーザーがアップロードしたファイルは処理できません。"})
+}
+
+// policyLogOnly は、規則 rule を POLICY_LOG_ONLY でログのみの状態にしているかを返します。
+// 「all」を指定した場合は、すべての規則をログのみにします。
+func policyLogOnly(rule string) bool {
+	for _, name := range splitEnvList("POLICY_LOG_ONLY") {
+		if name == rule || name == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// enforcePolicy は、規則 rule の違反 violation を処理します。violation が nil の場合は nil を返します。
+// 規則がログのみの状態の場合は、違反を記録して管理者に知らせたうえで nil を返し、処理を続行します。
+// 厳しい規則を導入する前に、実際にどれだけの依頼が拒否されるかを確かめるためのものです。
+func enforcePolicy(ev *slackevents.AppMentionEvent, rule string, violation error) error {
+	if violation == nil {
+		return nil
+	}
+	if !policyLogOnly(rule) {
+		metrics.ObservePolicyViolation(rule, "enforced")
+		return violation
+	}
+	metrics.ObservePolicyViolation(rule, "log_only")
+	reportPolicyViolation(ev, rule, violation)
+	return nil
+}
+
+// reportPolicyViolation は、ログのみの規則の違反をログに出力し、POLICY_REPORT_CHANNEL（デフォルトは OPS_CHANNEL）に通知します。
+// ev が nil の場合は、依頼したユーザーとチャンネルを含めずに通知します。
+func reportPolicyViolation(ev *slackevents.AppMentionEvent, rule string, violation error) {
+	var user, channel string
+	if ev != nil {
+		user, channel = ev.User, ev.Channel
+	}
+	log.Println("ログのみの規則の違反を記録しました。処理は続行します。", rule, user, channel, violation)
+
+	reportChannel := getEnvOrDefault("POLICY_REPORT_CHANNEL", os.Getenv("OPS_CHANNEL"))
+	if reportChannel == "" {
+		return
+	}
+	message := fmt.Sprintf(":mag: ログのみの規則「%s」に違反する依頼がありました。処理は続行しました。\n%s", rule, violation)
+	if ev != nil {
+		message += fmt.Sprintf("\n依頼者: <@%s> チャンネル: <#%s>", user, channel)
+	}
+	if _, _, err := slackClientAsBot.PostMessage(reportChannel, slack.MsgOptionText(message, false)); err != nil {
+		log.Println("規則の違反をSlackに通知中にエラーが発生しました。", err)
+	}
+}
@@ -0,0 +1,29 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/slack-go/slack/slackevents"
+)
+
+func TestCheckExternalFilePolicyDefaultsToRefuse(t *testing.T) {
+	t.Setenv("EXTERNAL_FILE_POLICY", "")
+	t.Setenv("POLICY_LOG_ONLY", "")
+	ws := &workspace{TeamID: "T1"}
+	ev := &slackevents.AppMentionEvent{User: "U1"}
+	external := &SlackAppMentionEventFile{ID: "F1", Name: "a.zip", UserTeam: "TEXTERNAL"}
+
+	var policyErr *policyError
+	if err := checkExternalFilePolicy(ws, ev, external); !errors.As(err, &policyErr) {
+		t.Errorf("checkExternalFilePolicy() error = %v, want policyError", err)
+	}
+	if err := checkExternalFilePolicy(ws, ev, &SlackAppMentionEventFile{ID: "F2", Name: "b.zip", UserTeam: "T1"}); err != nil {
+		t.Errorf("checkExternalFilePolicy() for an internal file error = %v, want nil", err)
+	}
+
+	t.Setenv("EXTERNAL_FILE_POLICY", "allow")
+	if err := checkExternalFilePolicy(ws, ev, external); err != nil {
+		t.Errorf("checkExternalFilePolicy() with allow error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/oidc"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+)
+
+const (
+	portalStateCookie   = "portal_state"
+	portalSessionCookie = "portal_session"
+)
+
+// portalState は、IdP にリダイレクトしてから戻ってくるまで Cookie に保持する値です。
+type portalState struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	ID        string `json:"id"` // ログイン後に開く監査記録のID
+	ExpiresAt int64  `json:"exp"`
+}
+
+// portalSession は、ログインしたユーザーの Cookie に保持する値です。
+type portalSession struct {
+	Subject   string   `json:"sub"`
+	Email     string   `json:"email"`
+	Groups    []string `json:"groups"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+var (
+	portalProviderOnce sync.Once
+	portalProvider     oidc.Provider
+	portalProviderErr  error
+)
+
+// portalEnabled は、リンクをIdPで保護したポータル（PORTAL_URL）に向けるかを返します。
+func portalEnabled() bool {
+	return os.Getenv("PORTAL_URL") != "" && auditStore != nil
+}
+
+// portalURL は、監査記録 id のファイルを開くポータルのURLです。
+func portalURL(id string) string {
+	return strings.TrimSuffix(os.Getenv("PORTAL_URL"), "/") + "/" + id
+}
+
+// portalAllowedGroups は、リンクのダウンロードを許可するIdPのグループです。
+// groups= が指定されなかった場合は PORTAL_ALLOWED_GROUPS です。空の場合はログインしたすべてのユーザーに許可します。
+func portalAllowedGroups(opts *mentionOptions) []string {
+	if len(opts.Groups) > 0 {
+		return opts.Groups
+	}
+	return splitEnvList("PORTAL_ALLOWED_GROUPS")
+}
+
+// portalMessage は、ポータルのリンクの場合に、URLを知らせるメッセージに添える説明を返します。
+func portalMessage(opts *mentionOptions, recipients []string) string {
+	if len(recipients) > 0 {
+		return ""
+	}
+	return portalGroupsMessage(portalAllowedGroups(opts))
+}
+
+// portalGroupsMessage は、ポータルのリンクの場合に、groups のメンバーのみダウンロードできることを知らせる説明を返します。
+func portalGroupsMessage(groups []string) string {
+	if !portalEnabled() {
+		return ""
+	}
+	if len(groups) > 0 {
+		return fmt.Sprintf("\n:office: 社内のアカウントでログインした %s のメンバーのみダウンロードできます。", strings.Join(groups, ", "))
+	}
+	return "\n:office: 社内のアカウントでログインしてダウンロードできます。"
+}
+
+// getPortalProvider は、初めて呼ばれたときに OIDC_ISSUER の設定を取得して Provider を生成します。
+func getPortalProvider() (oidc.Provider, error) {
+	portalProviderOnce.Do(func() {
+		portalProvider, portalProviderErr = oidc.NewProvider(context.TODO(), httpClient, oidc.Config{
+			Issuer:       os.Getenv("OIDC_ISSUER"),
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  portalURL("callback"),
+			GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		})
+	})
+	return portalProvider, portalProviderErr
+}
+
+// handlePortal は、LAMBDA_HANDLER=portal で起動したときのハンドラーです。
+// 社内向けのリンクは「PORTAL_URL/監査記録のID」を指し、IdP（OIDC_ISSUER）でログインしたユーザーが
+// 監査記録に保存した許可グループに属する場合にのみ、OTP_DOWNLOAD_EXPIRY だけ有効な署名付きURLにリダイレクトします。
+// ログインの状態は PORTAL_SESSION_SECRET で署名した Cookie に PORTAL_SESSION_TTL（デフォルト 8h）保持します。
+// 無効にされたリンクは、その旨を知らせるページを表示します。
+func handlePortal(r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	secret := []byte(os.Getenv("PORTAL_SESSION_SECRET"))
+	if !portalEnabled() || len(secret) == 0 {
+		return events.APIGatewayProxyResponse{StatusCode: 404, Body: "Not Found"}, nil
+	}
+	provider, err := getPortalProvider()
+	if err != nil {
+		log.Println("IdPの設定の取得中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	now := time.Now()
+
+	id := path.Base(r.Path)
+	if id == "callback" {
+		return handlePortalCallback(r, provider, secret, now)
+	}
+
+	var session portalSession
+	cookie, ok := requestCookie(r, portalSessionCookie)
+	if !ok || oidc.DecodeCookie(secret, cookie, &session) != nil || now.Unix() >= session.ExpiresAt {
+		return startPortalLogin(provider, secret, id, now)
+	}
+
+	record, err := auditStore.Get(context.TODO(), id)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	if record == nil || now.Unix() >= record.ExpiresAt {
+		return events.APIGatewayProxyResponse{StatusCode: 404, Body: "Not Found"}, nil
+	}
+	if record.RevokedAt != 0 {
+		return statusPageResponse(http.StatusGone, urlshortener.StatusRevoked, pageLanguage(r))
+	}
+	if !inAllowedGroups(record, session.Groups) {
+		log.Println("ポータルでのダウンロードを拒否しました。", record.ID, session.Email)
+		return events.APIGatewayProxyResponse{
+			StatusCode: 403,
+			Headers:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			Body:       "このファイルをダウンロードする権限がありません。",
+		}, nil
+	}
+	log.Println("ポータルからダウンロードします。", record.ID, session.Email)
+	return redirectToObject(record, downloadExpiry())
+}
+
+// startPortalLogin は、state と nonce を Cookie に保存して、ユーザーを IdP にリダイレクトします。
+func startPortalLogin(provider oidc.Provider, secret []byte, id string, now time.Time) (events.APIGatewayProxyResponse, error) {
+	state, err := randomToken()
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	value, err := oidc.EncodeCookie(secret, &portalState{State: state, Nonce: nonce, ID: id, ExpiresAt: now.Add(10 * time.Minute).Unix()})
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"Location":      provider.AuthCodeURL(state, nonce),
+			"Set-Cookie":    portalCookie(portalStateCookie, value, 10*time.Minute),
+			"Cache-Control": "no-store",
+		},
+	}, nil
+}
+
+// handlePortalCallback は、IdP から戻ってきたユーザーの認可コードを検証し、ログインの Cookie を発行して元のリンクに戻します。
+func handlePortalCallback(r events.APIGatewayProxyRequest, provider oidc.Provider, secret []byte, now time.Time) (events.APIGatewayProxyResponse, error) {
+	var state portalState
+	cookie, ok := requestCookie(r, portalStateCookie)
+	if !ok || oidc.DecodeCookie(secret, cookie, &state) != nil || now.Unix() >= state.ExpiresAt ||
+		state.State == "" || state.State != r.QueryStringParameters["state"] {
+		return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, nil
+	}
+	claims, err := provider.Exchange(context.TODO(), r.QueryStringParameters["code"], state.Nonce, now)
+	if err != nil {
+		log.Println("IdPでのログインの検証中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 401, Body: "Unauthorized"}, nil
+	}
+
+	ttl, err := parseDuration(getEnvOrDefault("PORTAL_SESSION_TTL", "8h"))
+	if err != nil || ttl <= 0 {
+		ttl = 8 * time.Hour
+	}
+	value, err := oidc.EncodeCookie(secret, &portalSession{
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		Groups:    claims.Groups,
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"Location":      portalURL(state.ID),
+			"Set-Cookie":    portalCookie(portalSessionCookie, value, ttl),
+			"Cache-Control": "no-store",
+		},
+	}, nil
+}
+
+// inAllowedGroups は、ユーザーのグループに監査記録の許可グループのいずれかが含まれるかを返します。
+// 許可グループがない記録は、ログインしたすべてのユーザーに許可します。
+func inAllowedGroups(record *audit.Record, groups []string) bool {
+	if len(record.AllowedGroups) == 0 {
+		return true
+	}
+	for _, allowed := range record.AllowedGroups {
+		for _, g := range groups {
+			if g == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestCookie は、リクエストの Cookie ヘッダーから name の値を取り出します。
+func requestCookie(r events.APIGatewayProxyRequest, name string) (string, bool) {
+	header := http.Header{"Cookie": {headerValue(r.Headers, "Cookie")}}
+	c, err := (&http.Request{Header: header}).Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return c.Value, true
+}
+
+// portalCookie は、ポータルのパスでのみ送信される Cookie の Set-Cookie ヘッダーの値を返します。
+func portalCookie(name, value string, ttl time.Duration) string {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if u, err := url.Parse(os.Getenv("PORTAL_URL")); err == nil && u.Path != "" {
+		c.Path = u.Path
+	}
+	return c.String()
+}
+
+// randomToken は、state や nonce に使うランダムな文字列を生成します。
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
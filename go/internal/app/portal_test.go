@@ -0,0 +1,47 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/oidc"
+)
+
+// withPortal は、テストの間だけポータル（PORTAL_URL）を有効にし、ログイン済みの Cookie を返します。
+// IdP には接続しないため、Provider は呼び出されない前提の空の実装です。
+func withPortal(t *testing.T, store audit.Store) string {
+	t.Helper()
+	secret := "portal-secret"
+	t.Setenv("PORTAL_URL", "https://portal.example/p")
+	t.Setenv("PORTAL_SESSION_SECRET", secret)
+	saved := auditStore
+	t.Cleanup(func() { auditStore = saved })
+	auditStore = store
+	portalProviderOnce.Do(func() { portalProvider = struct{ oidc.Provider }{} })
+
+	value, err := oidc.EncodeCookie([]byte(secret), &portalSession{Subject: "u1", Email: "alice@example.com", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatalf("unable to encode session, %s", err)
+	}
+	return portalSessionCookie + "=" + value
+}
+
+func TestPortalRejectsRevokedLinks(t *testing.T) {
+	cookie := withPortal(t, &recordStore{records: map[string]*audit.Record{
+		"r1": {ID: "r1", ObjectKey: "report.zip", ExpiresAt: time.Now().Add(time.Hour).Unix(), RevokedAt: time.Now().Unix()},
+	}})
+
+	res, err := handlePortal(events.APIGatewayProxyRequest{Path: "/p/r1", Headers: map[string]string{"Cookie": cookie}})
+	if err != nil {
+		t.Fatalf("handlePortal() error = %v", err)
+	}
+	if res.StatusCode != http.StatusGone {
+		t.Errorf("StatusCode = %d, want %d", res.StatusCode, http.StatusGone)
+	}
+	if location := res.Headers["Location"]; location != "" {
+		t.Errorf("Location = %q, want no redirect to the object", location)
+	}
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/provenance"
+)
+
+// provenanceSuffix は、来歴を保存するオブジェクトのキーの接尾辞です。
+const provenanceSuffix = ".intoto.json"
+
+// attachProvenance は、APIでアップロードしたファイルの in-toto の来歴（SLSA provenance v1）をオブジェクトの隣に保存し、
+// その短縮URLを返します。来歴には、CIが申告したリポジトリ、コミット、ワークフローの実行と、認証した呼び出し元を記載します。
+func attachProvenance(ws *workspace, uploaded *uploadedObject, file *SlackAppMentionEventFile, build *provenance.Build, principal string) (string, error) {
+	doc, err := provenance.NewStatement(file.Name, contentSHA256(file.Binary), build, principal, time.Now()).Marshal()
+	if err != nil {
+		return "", err
+	}
+
+	attestation := &uploadedObject{
+		Bucket:       uploaded.Bucket,
+		Key:          uploaded.Key + provenanceSuffix,
+		Region:       uploaded.Region,
+		TeamID:       uploaded.TeamID,
+		EnterpriseID: uploaded.EnterpriseID,
+		Expiry:       uploaded.Expiry,
+	}
+	client, err := s3ClientFor(uploaded.TeamID, uploaded.EnterpriseID, uploaded.Region)
+	if err != nil {
+		return "", err
+	}
+	out, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(attestation.Bucket),
+		Key:         aws.String(attestation.Key),
+		Body:        bytes.NewReader(doc),
+		ContentType: aws.String("application/vnd.in-toto+json"),
+	})
+	if err != nil {
+		return "", err
+	}
+	attestation.VersionID = aws.ToString(out.VersionId)
+	if err := presignObject(attestation); err != nil {
+		return "", err
+	}
+	return shortenerFor(ws.TeamID, "").Shorten(attestation.PresignedURL)
+}
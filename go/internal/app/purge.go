@@ -0,0 +1,156 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+)
+
+// purgeTombstone は、データの削除を実施したことを示す記録です。削除したファイル名などの個人データは含めません。
+type purgeTombstone struct {
+	TeamID      string `json:"team_id"`
+	User        string `json:"user"`         // 削除の対象となったユーザー
+	RequestedBy string `json:"requested_by"` // 削除を実行した管理者
+	PurgedAt    int64  `json:"purged_at"`    // UNIX時間（秒）
+	Records     int    `json:"records"`      // 削除した監査記録の件数
+	Failed      int    `json:"failed"`       // 削除できなかったオブジェクトの件数
+	Held        int    `json:"held"`         // リーガルホールド中のため削除しなかった記録の件数
+}
+
+// adminCommandUsage は、/geturl-admin の使い方です。
+const adminCommandUsage = "使い方:\n/geturl-admin purge user=<@U012345>\n/geturl-admin hold <短縮URL> [理由]\n/geturl-admin release <短縮URL>\n/geturl-admin revoke namespace=<名前空間または #チャンネル>"
+
+// handleAdminCommand は、/geturl-admin を処理します。ADMIN_USER_IDS の管理者のみ実行できます。
+// ・purge user=<@U...>: ユーザーがアップロードしたオブジェクトと監査記録をすべて削除します。
+// ・hold / release: リンクのオブジェクトのリーガルホールドを設定・解除します。
+// ・revoke namespace=...: 名前空間（SHORTENER_NAMESPACE）で発行したすべての短縮URLを無効にします。
+func handleAdminCommand(teamID, user, text string) string {
+	if !isAdminUser(user) {
+		return "このコマンドは管理者のみ実行できます。"
+	}
+	fields := splitOptionFields(text)
+	if len(fields) < 2 {
+		return adminCommandUsage
+	}
+	if fields[0] == "revoke" && len(fields) == 2 {
+		return handleRevokeNamespaceCommand(teamID, user, fields[1])
+	}
+	if auditStore == nil {
+		return "AUDIT_TABLE が設定されていないため、実行できません。"
+	}
+	switch fields[0] {
+	case "purge":
+		key, value, _ := strings.Cut(fields[1], "=")
+		kind, target, ok := parseSlackReference(value)
+		if len(fields) != 2 || key != "user" || !ok || kind != "user" {
+			return adminCommandUsage
+		}
+		return handlePurgeCommand(teamID, target, user)
+	case "hold":
+		return handleLegalHoldCommand(teamID, user, fields[1], strings.Join(fields[2:], " "), true)
+	case "release":
+		return handleLegalHoldCommand(teamID, user, fields[1], "", false)
+	}
+	return adminCommandUsage
+}
+
+// handlePurgeCommand は、/geturl-admin purge を実行し、応答のテキストを返します。
+func handlePurgeCommand(teamID, target, user string) string {
+	tombstone, err := purgeUserData(context.TODO(), teamID, target, user)
+	if err != nil {
+		log.Println("ユーザーのデータの削除中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	message := fmt.Sprintf("<@%s> のデータを削除しました。監査記録: %d件", target, tombstone.Records)
+	if tombstone.Held > 0 {
+		message += fmt.Sprintf("\n:lock: リーガルホールド中の%d件は削除していません。", tombstone.Held)
+	}
+	if tombstone.Failed > 0 {
+		message += fmt.Sprintf("\n:warning: %d件のオブジェクトを削除できませんでした（Object Lock で保護されている可能性があります）。該当する監査記録は残しています。", tombstone.Failed)
+	}
+	return message
+}
+
+// purgeUserData は、ユーザーが依頼したすべてのリンクについて、S3のオブジェクト（メタリンク、マニフェスト、来歴を含む）と監査記録を削除し、
+// PURGE_LOG_PREFIX（デフォルト tombstones）に削除の記録を残します。
+// オブジェクトを削除できなかったリンクは、再実行できるよう監査記録を残します。リーガルホールド中のリンクは削除しません。
+func purgeUserData(ctx context.Context, teamID, target, requestedBy string) (*purgeTombstone, error) {
+	records, err := auditStore.ListByUser(ctx, teamID, target)
+	if err != nil {
+		return nil, err
+	}
+	tombstone := &purgeTombstone{
+		TeamID:      teamID,
+		User:        target,
+		RequestedBy: requestedBy,
+		PurgedAt:    time.Now().Unix(),
+	}
+	for _, r := range records {
+		if r.LegalHold {
+			tombstone.Held++
+			continue
+		}
+		if err := deleteRecordObjects(ctx, r); err != nil {
+			log.Println("オブジェクトの削除中にエラーが発生しました。", r.ID, err)
+			tombstone.Failed++
+			continue
+		}
+		if err := auditStore.Delete(ctx, r.ID); err != nil {
+			return nil, err
+		}
+		tombstone.Records++
+	}
+
+	body, err := json.Marshal(tombstone)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%s/%s-%d.json", getEnvOrDefault("PURGE_LOG_PREFIX", "tombstones"), teamID, target, tombstone.PurgedAt)
+	if _, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucketOrDefault("")),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return nil, err
+	}
+	log.Println("ユーザーのデータを削除しました。", string(body))
+	return tombstone, nil
+}
+
+// deleteRecordObjects は、監査記録のオブジェクトと、その隣に保存したメタリンク、マニフェスト、来歴を削除します。
+// バージョンIDがある場合は、そのバージョンを完全に削除します。
+func deleteRecordObjects(ctx context.Context, r *audit.Record) error {
+	bucket := bucketOrDefault(r.Bucket)
+	client, err := s3ClientFor(r.TeamID, r.EnterpriseID, r.Region)
+	if err != nil {
+		return err
+	}
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(r.ObjectKey),
+	}
+	if r.VersionID != "" {
+		input.VersionId = aws.String(r.VersionID)
+	}
+	if _, err := client.DeleteObject(ctx, input); err != nil {
+		return err
+	}
+	for _, suffix := range []string{".meta4", ".manifest.json", provenanceSuffix} {
+		if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(r.ObjectKey + suffix),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
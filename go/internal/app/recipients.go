@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+)
+
+// recipientsAllowed は、for= や password=on で受取人を限定できる設定かを返します。
+// 二人承認、publish_at、バンドル、Step Functions での実行では、受取人を限定した短縮URLを発行しないため併用できません。
+func recipientsAllowed(opts *mentionOptions) error {
+	key := "for"
+	if opts.For == "" {
+		key = "password"
+	}
+	switch {
+	case approvalRequired():
+		return fmt.Errorf("%s は二人承認が有効な環境では利用できません。", key)
+	case !opts.PublishAt.IsZero():
+		return fmt.Errorf("%s と publish_at は同時に指定できません。", key)
+	case opts.Bundle != "":
+		return fmt.Errorf("%s と bundle は同時に指定できません。", key)
+	case os.Getenv("EXECUTION_MODE") == "stepfunctions":
+		return fmt.Errorf("%s はこの環境では利用できません。", key)
+	}
+	return nil
+}
+
+// linksGated は、リンクを受取人の確認ページやIdPで保護したポータルに向けるかを返します。
+// 保護したリンクに署名付きURLをそのまま添えると、スレッドの誰でもダウンロードできてしまいます。
+func linksGated(opts *mentionOptions) bool {
+	return opts.For != "" || len(opts.Groups) > 0 || os.Getenv("OTP_GATE_URL") != "" || os.Getenv("PORTAL_URL") != ""
+}
+
+// resolveRecipients は、受取人のメールアドレスを返します。
+// for= が指定された場合はユーザーグループのメンバーを、password=on のみの場合は notify= で指定したユーザーを受取人とします。
+// メールアドレスを取得できないメンバー（ボットなど）は除きます。
+func resolveRecipients(ws *workspace, opts *mentionOptions) ([]string, error) {
+	members := notifiedUsers(opts)
+	if opts.For != "" {
+		var err error
+		members, err = ws.Bot.GetUserGroupMembers(opts.For)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	users, err := ws.Bot.GetUsersInfo(members...)
+	if err != nil {
+		return nil, err
+	}
+	var emails []string
+	for _, u := range *users {
+		if u.Deleted || u.IsBot || u.Profile.Email == "" {
+			continue
+		}
+		emails = append(emails, u.Profile.Email)
+	}
+	return emails, nil
+}
+
+// notifiedUsers は、notify= で指定したユーザーのIDを返します。
+func notifiedUsers(opts *mentionOptions) []string {
+	var users []string
+	for _, ref := range opts.Notify {
+		if kind, id, ok := parseSlackReference(ref); ok && kind == "user" {
+			users = append(users, id)
+		}
+	}
+	return users
+}
+
+// recipientsMessage は、受取人を限定したリンクの場合に、URLを知らせるメッセージに添える説明を返します。
+func recipientsMessage(opts *mentionOptions) string {
+	switch {
+	case opts.For != "":
+		return fmt.Sprintf("\n:lock: <!subteam^%s> のメンバーのみ、本人に届く確認コードを入力してダウンロードできます。", opts.For)
+	case opts.Password:
+		var mentions []string
+		for _, user := range notifiedUsers(opts) {
+			mentions = append(mentions, "<@"+user+">")
+		}
+		return fmt.Sprintf("\n:lock: %s のみ、本人に届く確認コードを入力してダウンロードできます。", strings.Join(mentions, " "))
+	}
+	return ""
+}
+
+// shortenPublished は、アップロードしたファイルの署名付きURLを shortenLinks でまとめて短縮します。
+func shortenPublished(shortener urlshortener.URLShortener, published []*publishedFile, recipients []string) ([]string, []string, error) {
+	longURLs := make([]string, 0, len(published))
+	for _, p := range published {
+		longURLs = append(longURLs, p.uploaded.PresignedURL)
+	}
+	return shortenLinks(shortener, longURLs, recipients)
+}
+
+// shortenLinks は、署名付きURLをまとめて短縮し、短縮URLと監査記録のIDを返します。
+// 受取人が指定された場合は、受取人を限定した短縮URLを1件ずつ発行します。
+// OTP_GATE_URL が設定されている場合は受取人を確認するページを、受取人の指定がなく PORTAL_URL が設定されている場合は
+// IdPで保護したポータルを、署名付きURLの代わりに短縮します。ページから監査記録を辿れるよう、監査記録のIDを先に決めます。
+// それ以外の場合、IDは空です。
+// 承認後や予約した時刻の送信でもリンクの保護が外れないよう、ファイルのURLを発行する経路はすべてこの関数で短縮します。
+func shortenLinks(shortener urlshortener.URLShortener, longURLs []string, recipients []string) ([]string, []string, error) {
+	longURLs = append([]string(nil), longURLs...)
+	auditIDs := make([]string, len(longURLs))
+
+	var pageURL func(id string) string
+	switch {
+	case len(recipients) > 0 && verificationEnabled():
+		pageURL = verificationURL
+	case len(recipients) > 0:
+		shortURLs := make([]string, 0, len(longURLs))
+		for _, longURL := range longURLs {
+			shortURL, err := shortener.ShortenForRecipients(longURL, recipients)
+			if err != nil {
+				return nil, nil, err
+			}
+			shortURLs = append(shortURLs, shortURL)
+		}
+		return shortURLs, auditIDs, nil
+	case portalEnabled():
+		pageURL = portalURL
+	}
+	if pageURL != nil {
+		for i := range longURLs {
+			id, err := audit.NewID()
+			if err != nil {
+				return nil, nil, err
+			}
+			auditIDs[i] = id
+			longURLs[i] = pageURL(id)
+		}
+	}
+	shortURLs, err := shortener.ShortenBatch(longURLs)
+	return shortURLs, auditIDs, err
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/kumagai-s/uploader-v2/internal/archive"
+	"github.com/kumagai-s/uploader-v2/internal/cost"
+)
+
+// recompressFile は、recompress=on が指定された場合に、ファイルを最も高い圧縮率で圧縮し直します。
+// zip と tar.gz はアーカイブの形式のまま圧縮し直し、file= で取り出したファイルは gzip で圧縮して名前に「.gz」を付けます。
+// RECOMPRESS_MIN_SAVINGS（デフォルト 10）% 以上小さくなる場合のみ置き換え、元のサイズを OriginalSize に残します。
+// 圧縮し直せない場合は、元のファイルのままアップロードします。
+func recompressFile(file *SlackAppMentionEventFile, opts *mentionOptions) {
+	if !opts.Recompress {
+		return
+	}
+
+	format := archiveFormatOf(file.Name)
+	var (
+		binary []byte
+		err    error
+	)
+	if format == "" {
+		binary, err = archive.Gzip(file.Binary)
+	} else {
+		binary, err = archive.Recompress(format, file.Binary)
+	}
+	if err != nil {
+		log.Println("ファイルの再圧縮中にエラーが発生しました。元のファイルのままアップロードします。", file.ID, err)
+		return
+	}
+
+	before, after := int64(len(file.Binary)), int64(len(binary))
+	if (before-after)*100 < before*getEnvInt64("RECOMPRESS_MIN_SAVINGS", 10) {
+		return
+	}
+	if format == "" {
+		file.Name += ".gz"
+	}
+	file.OriginalSize = before
+	file.Binary = binary
+	file.Size = after
+}
+
+// recompressionMessage は、圧縮し直した場合に、依頼者への返信に添える圧縮前後のサイズを返します。
+func recompressionMessage(file *SlackAppMentionEventFile) string {
+	if file.OriginalSize == 0 {
+		return ""
+	}
+	saved := (file.OriginalSize - file.Size) * 100 / file.OriginalSize
+	return fmt.Sprintf("\n:compression: 圧縮し直してサイズを %s から %s に減らしました（%d%%削減）。",
+		cost.HumanSize(file.OriginalSize), cost.HumanSize(file.Size), saved)
+}
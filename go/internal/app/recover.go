@@ -0,0 +1,51 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/slack-go/slack"
+)
+
+// recoverPanic は、ハンドラーの先頭で defer して呼び出し、ハンドラーで発生したパニックを回復します。
+// パニックが発生した場合は、スタックトレースをログに出力し、メトリクスを記録して OPS_CHANNEL に通知したうえで onPanic を呼び出します。
+// Lambda のランタイムにパニックを伝えると、Slackや非同期の呼び出しが同じイベントを再送し続けるため、回復して正常に終了させます。
+func recoverPanic(handler string, onPanic func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Println("パニックが発生しました。", handler, r, "\n"+string(debug.Stack()))
+	metrics.ObservePanic(handler)
+	notifyPanic(handler, r)
+	if onPanic != nil {
+		onPanic()
+	}
+}
+
+// notifyPanic は、パニックの発生を OPS_CHANNEL に通知します。OPS_CHANNEL が設定されていない場合は何もしません。
+func notifyPanic(handler string, r interface{}) {
+	channel := os.Getenv("OPS_CHANNEL")
+	if channel == "" {
+		return
+	}
+	text := fmt.Sprintf(":rotating_light: %s でパニックが発生しました。\n```%v```\n詳細は %s のログを確認してください。",
+		handler, r, getEnvOrDefault("AWS_LAMBDA_FUNCTION_NAME", "アプリケーション"))
+	if _, _, err := slackClientAsBot.PostMessage(channel, slack.MsgOptionText(text, false)); err != nil {
+		log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+	}
+}
+
+// recoverer は、HTTPサーバーのハンドラーで発生したパニックを回復し、Slackが再送しないよう200を返します。
+func recoverer(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer recoverPanic("http", func() {
+			w.WriteHeader(http.StatusOK)
+		})
+		next(w, r)
+	}
+}
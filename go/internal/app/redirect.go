@@ -0,0 +1,242 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/throttle"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+)
+
+// shortenerGrace は、内部の短縮URLサービス（SHORTENER_BACKEND=internal）で、署名付きURLの有効期限が過ぎてから
+// 短縮コードの対応を削除するまでの猶予です（SHORTENER_TTL_GRACE、デフォルト 7d）。
+// 猶予の間は、リダイレクトの代わりに有効期限が切れたことを知らせるページを表示します。
+func shortenerGrace() time.Duration {
+	d, err := parseDuration(getEnvOrDefault("SHORTENER_TTL_GRACE", "7d"))
+	if err != nil || d < 0 {
+		return 7 * 24 * time.Hour
+	}
+	return d
+}
+
+// shortCodeOptions は、内部の短縮URLサービスで短縮コードを生成する方法を返します。
+// SHORTENER_CODE_LENGTH（デフォルト 8）、SHORTENER_CODE_ALPHABET（デフォルト 英数字）、SHORTENER_CODE_ATTEMPTS（デフォルト 3）で変更できます。
+// 受取人の確認（OTP_GATE_URL）でリンクを保護しない場合は、短縮URLを知っているだけでダウンロードできるため、
+// エントロピーが SHORTENER_MIN_ENTROPY（デフォルト 44）ビット未満にならないよう文字数を増やします。
+func shortCodeOptions() urlshortener.CodeOptions {
+	codes := urlshortener.DefaultCodeOptions()
+	codes.Length = int(getEnvInt64("SHORTENER_CODE_LENGTH", int64(codes.Length)))
+	codes.Alphabet = getEnvOrDefault("SHORTENER_CODE_ALPHABET", codes.Alphabet)
+	codes.MaxAttempts = int(getEnvInt64("SHORTENER_CODE_ATTEMPTS", int64(codes.MaxAttempts)))
+	if os.Getenv("OTP_GATE_URL") == "" {
+		minEntropy := float64(getEnvInt64("SHORTENER_MIN_ENTROPY", 44))
+		if codes.Entropy() < minEntropy {
+			codes = codes.WithMinEntropy(minEntropy)
+			log.Println("短縮コードのエントロピーが不足しているため、文字数を増やしました。", codes.Length)
+		}
+	}
+	return codes
+}
+
+// shortenerBasePath は、SHORTENER_BASE_URL のパスです（「https://example.com/s」の場合は「/s」）。
+func shortenerBasePath() string {
+	u, err := url.Parse(os.Getenv("SHORTENER_BASE_URL"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// newStatusPages は、リダイレクトできない場合に表示するページを、ロゴ（STATUS_PAGE_LOGO_URL）、組織名（STATUS_PAGE_ORGANIZATION）、
+// 問い合わせ先（STATUS_PAGE_CONTACT、メールアドレスまたはURL）で組織に合わせて生成する StatusPages を返します。
+// STATUS_PAGE_TEMPLATE にデプロイパッケージ内の html/template のファイルを指定した場合は、既定のページの代わりに使います。
+// テンプレートを読み込めない場合は、既定のページを使います。
+func newStatusPages() urlshortener.StatusPages {
+	branding := urlshortener.Branding{
+		Organization: os.Getenv("STATUS_PAGE_ORGANIZATION"),
+		LogoURL:      os.Getenv("STATUS_PAGE_LOGO_URL"),
+		Contact:      os.Getenv("STATUS_PAGE_CONTACT"),
+	}
+	var text string
+	if name := os.Getenv("STATUS_PAGE_TEMPLATE"); name != "" {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			log.Println("初期設定中にエラーが発生しました。", err)
+		}
+		text = string(b)
+	}
+	pages, err := urlshortener.NewStatusPages(text, branding)
+	if err != nil {
+		log.Println("初期設定中にエラーが発生しました。", err)
+		pages, _ = urlshortener.NewStatusPages("", branding)
+	}
+	return pages
+}
+
+// pageLanguage は、ページを表示する言語を返します。
+// STATUS_PAGE_LANGUAGE（ja または en）が設定されている場合はその言語で、設定されていない場合は
+// ブラウザの Accept-Language で最初に対応している言語で表示します。どちらにも対応していない場合は日本語で表示します。
+func pageLanguage(r events.APIGatewayProxyRequest) string {
+	if lang := os.Getenv("STATUS_PAGE_LANGUAGE"); lang != "" {
+		return lang
+	}
+	header := r.Headers["Accept-Language"]
+	if header == "" {
+		header = r.Headers["accept-language"]
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.ToLower(strings.TrimSpace(strings.SplitN(tag, ";", 2)[0]))
+		switch strings.SplitN(tag, "-", 2)[0] {
+		case "ja":
+			return "ja"
+		case "en":
+			return "en"
+		}
+	}
+	return "ja"
+}
+
+// newRedirectBuckets は、REDIRECT_RATE_LIMIT_PER_IP や REDIRECT_RATE_LIMIT_PER_LINK（例: 「60/1m」）の設定 key から、
+// 短縮URLのリダイレクトの回数を制限する Buckets を返します。設定されていない場合は nil を返します。
+// REDIRECT_RATE_LIMIT_TABLE が設定されている場合はすべての実行環境を合わせて、設定されていない場合は実行環境ごとに数えます。
+func newRedirectBuckets(client *dynamodb.Client, key, prefix string) throttle.Buckets {
+	limit, window, err := parseRateLimit(os.Getenv(key))
+	if err != nil {
+		log.Println("初期設定中にエラーが発生しました。", err)
+		return nil
+	}
+	if limit == 0 {
+		return nil
+	}
+	if table := os.Getenv("REDIRECT_RATE_LIMIT_TABLE"); table != "" {
+		return throttle.NewDynamoBuckets(client, table, prefix, int64(limit), window)
+	}
+	return throttle.NewLocalBuckets(int64(limit), window)
+}
+
+// allowRedirect は、key のリクエストが回数の制限を超えていないかを返します。
+// 制限の確認に失敗した場合は、リンクを開けなくならないよう許可します。
+func allowRedirect(buckets throttle.Buckets, key string) bool {
+	if buckets == nil {
+		return true
+	}
+	ok, err := buckets.Allow(context.TODO(), key)
+	if err != nil {
+		log.Println("リクエストの回数の確認中にエラーが発生しました。", err)
+		return true
+	}
+	return ok
+}
+
+// rateLimitedResponse は、回数の制限を超えたことを知らせるページを、再試行までの秒数（Retry-After）とともに返します。
+func rateLimitedResponse(lang string) (events.APIGatewayProxyResponse, error) {
+	res, err := statusPageResponse(http.StatusTooManyRequests, urlshortener.StatusRateLimited, lang)
+	if err == nil {
+		res.Headers["Retry-After"] = getEnvOrDefault("REDIRECT_RETRY_AFTER", "60")
+	}
+	return res, err
+}
+
+// handleRedirect は、LAMBDA_HANDLER=redirect で起動したときのハンドラーです。
+// 内部の短縮URLサービスの短縮URL（SHORTENER_BASE_URL/短縮コード）を、保存した長いURLにリダイレクトします。
+// 有効期限が切れたリンクや無効にされたリンク、エラーの場合は、ステータスコードだけを返す代わりにその旨を知らせるページを返します。
+// 短縮コードの総当たりや一括取得を防ぐため、送信元のIPアドレスごと、リンクごとにリダイレクトの回数を制限します。
+func handleRedirect(r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	lang := pageLanguage(r)
+	if shortLinkResolver == nil {
+		return statusPageResponse(http.StatusNotFound, urlshortener.StatusNotFound, lang)
+	}
+	// 存在しない短縮コードも数えるよう、短縮コードを調べる前にIPアドレスごとの回数を確認する。
+	if !allowRedirect(redirectIPBuckets, r.RequestContext.Identity.SourceIP) {
+		return rateLimitedResponse(lang)
+	}
+	code := strings.Trim(strings.TrimPrefix(r.Path, shortenerBasePath()), "/")
+	if code == "" {
+		return statusPageResponse(http.StatusNotFound, urlshortener.StatusNotFound, lang)
+	}
+
+	m, err := shortLinkResolver.Resolve(context.TODO(), code)
+	if err != nil {
+		log.Println("短縮コードの取得中にエラーが発生しました。", err)
+		return statusPageResponse(http.StatusInternalServerError, urlshortener.StatusError, lang)
+	}
+	switch {
+	case m == nil:
+		return statusPageResponse(http.StatusNotFound, urlshortener.StatusNotFound, lang)
+	case m.Revoked:
+		return statusPageResponse(http.StatusGone, urlshortener.StatusRevoked, lang)
+	case m.Expired(time.Now()):
+		return statusPageResponse(http.StatusGone, urlshortener.StatusExpired, lang)
+	}
+	if !allowRedirect(redirectLinkBuckets, code) {
+		return rateLimitedResponse(lang)
+	}
+	countRedirect(code, m.URL)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"Location":      m.URL,
+			"Cache-Control": "no-store",
+		},
+	}, nil
+}
+
+// countRedirect は、短縮コード code のリダイレクトがファイルのダウンロードの場合に、監査記録のダウンロード回数を数えます。
+// 確認ページやポータルへのリダイレクトは、そこで署名付きURLにリダイレクトしたときに数えます。
+func countRedirect(code, longURL string) {
+	if auditStore == nil || isGatePage(longURL) {
+		return
+	}
+	shortURL := strings.TrimSuffix(os.Getenv("SHORTENER_BASE_URL"), "/") + "/" + code
+	record, err := auditStore.FindByShortURL(context.TODO(), shortURL)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return
+	}
+	if record != nil {
+		countDownload(record)
+	}
+}
+
+// isGatePage は、longURL が確認ページ（OTP_GATE_URL）かポータル（PORTAL_URL）のURLかを返します。
+func isGatePage(longURL string) bool {
+	for _, key := range []string{"OTP_GATE_URL", "PORTAL_URL"} {
+		if base := os.Getenv(key); base != "" && strings.HasPrefix(longURL, strings.TrimSuffix(base, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// countDownload は、監査記録のダウンロード回数に1を加えます。
+// 数えられなくてもダウンロードはできるため、ログに出力するのみとします。
+func countDownload(record *audit.Record) {
+	if err := auditStore.CountDownload(context.TODO(), record.ID); err != nil {
+		log.Println("ダウンロード回数の記録中にエラーが発生しました。", record.ID, err)
+	}
+}
+
+// statusPageResponse は、リダイレクトできない理由を知らせるページを言語 lang で返します。
+func statusPageResponse(statusCode int, status, lang string) (events.APIGatewayProxyResponse, error) {
+	html, err := statusPages.Render(status, lang)
+	if err != nil {
+		log.Println("ページの作成中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type":  "text/html; charset=utf-8",
+			"Cache-Control": "no-store",
+		},
+		Body: string(html),
+	}, nil
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+)
+
+// fakeResolver は、登録した短縮コードの対応を返す urlshortener.Resolver です。
+type fakeResolver map[string]string
+
+func (r fakeResolver) Resolve(ctx context.Context, code string) (*urlshortener.Mapping, error) {
+	longURL, ok := r[code]
+	if !ok {
+		return nil, nil
+	}
+	return &urlshortener.Mapping{Code: code, URL: longURL}, nil
+}
+
+// downloadStore は、短縮URLで監査記録を検索し、数えたダウンロードを記録する audit.Store です。
+type downloadStore struct {
+	audit.Store
+	records   []*audit.Record
+	downloads map[string]int
+}
+
+func (s *downloadStore) FindByShortURL(ctx context.Context, shortURL string) (*audit.Record, error) {
+	for _, record := range s.records {
+		if record.ShortURL == shortURL {
+			return record, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *downloadStore) CountDownload(ctx context.Context, id string) error {
+	s.downloads[id]++
+	return nil
+}
+
+func TestRedirectCountsDownloads(t *testing.T) {
+	t.Setenv("SHORTENER_BASE_URL", "https://s.example/s/")
+	t.Setenv("OTP_GATE_URL", "https://verify.example")
+	t.Setenv("PORTAL_URL", "")
+	savedResolver, savedAudit := shortLinkResolver, auditStore
+	t.Cleanup(func() { shortLinkResolver, auditStore = savedResolver, savedAudit })
+	shortLinkResolver = fakeResolver{
+		"acme/direct": "https://bucket.s3.example/report.zip?X-Amz-Signature=x",
+		"acme/gated":  "https://verify.example/r2",
+	}
+	store := &downloadStore{
+		records: []*audit.Record{
+			{ID: "r1", ShortURL: "https://s.example/s/acme/direct"},
+			{ID: "r2", ShortURL: "https://s.example/s/acme/gated"},
+		},
+		downloads: map[string]int{},
+	}
+	auditStore = store
+
+	for _, path := range []string{"/s/acme/direct", "/s/acme/direct", "/s/acme/gated", "/s/acme/unknown"} {
+		if _, err := handleRedirect(events.APIGatewayProxyRequest{Path: path}); err != nil {
+			t.Fatalf("handleRedirect(%q) error = %v", path, err)
+		}
+	}
+	// 確認ページへのリダイレクトは、受取人を確認してダウンロードしたときに数える。
+	if store.downloads["r1"] != 2 || store.downloads["r2"] != 0 {
+		t.Errorf("downloads = %v, want r1 counted twice and r2 not counted", store.downloads)
+	}
+}
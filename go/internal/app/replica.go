@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+)
+
+// replicateObject は、アップロードしたオブジェクトを REPLICA_REGION の REPLICA_BUCKET に複製し、
+// 複製先の署名付きURLを生成します。
+func replicateObject(uploaded *uploadedObject) (*uploadedObject, error) {
+	source := uploaded.Bucket + "/" + url.PathEscape(uploaded.Key)
+	if uploaded.VersionID != "" {
+		source += "?versionId=" + url.QueryEscape(uploaded.VersionID)
+	}
+	replica := &uploadedObject{
+		Bucket: os.Getenv("REPLICA_BUCKET"),
+		Key:    uploaded.Key,
+		Expiry: uploaded.Expiry,
+	}
+	out, err := replicaS3Client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+		Bucket:     aws.String(replica.Bucket),
+		Key:        aws.String(replica.Key),
+		CopySource: aws.String(source),
+	})
+	if err != nil {
+		return nil, err
+	}
+	replica.VersionID = aws.ToString(out.VersionId)
+
+	if err := presignObjectWith(s3.NewPresignClient(replicaS3Client), replica); err != nil {
+		return nil, err
+	}
+	return replica, nil
+}
+
+// replicaMessage は、replicate=on の場合にオブジェクトを別のリージョンに複製し、予備のリンクの行を返します。
+// 元のリンクは利用できるため、複製に失敗してもURLの送信は続けます。
+// 予備のリンクは確認ページやポータルを経由しないため、受取人やポータルで保護したリンクには添えません。
+func replicaMessage(shortener urlshortener.URLShortener, p *publishedFile, opts *mentionOptions) string {
+	if !opts.Replicate || linksGated(opts) {
+		return ""
+	}
+	replica, err := replicateObject(p.uploaded)
+	if err != nil {
+		log.Println("オブジェクトの複製中にエラーが発生しました。", err)
+		return ""
+	}
+	shortURL, err := shortener.Shorten(replica.PresignedURL)
+	if err != nil {
+		log.Println("URLの短縮中にエラーが発生しました。", err)
+		return ""
+	}
+	return "\n:globe_with_meridians: 予備のリンク（" + os.Getenv("REPLICA_REGION") + "）: " + shortURL
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/notifier"
+)
+
+// pendingReply は、アップロードは完了したものの、URLを知らせるスレッドへの返信に失敗したメッセージです。
+// EventBridge Scheduler のスケジュールの入力として保存し、届くまで送信をやり直します。
+type pendingReply struct {
+	TeamID       string `json:"team_id"`
+	EnterpriseID string `json:"enterprise_id,omitempty"`
+	Channel      string `json:"channel"`
+	ThreadTS     string `json:"thread_ts"`
+	Requester    string `json:"requester,omitempty"`
+	Text         string `json:"text"`
+	Note         string `json:"note,omitempty"`
+	Attempt      int    `json:"attempt"` // これまでにやり直した回数
+	ScheduleName string `json:"schedule_name"`
+}
+
+// replyRetryEvent は、返信をやり直すために EventBridge Scheduler から呼び出されたときのペイロードです。
+type replyRetryEvent struct {
+	Reply *pendingReply `json:"pending_reply"`
+}
+
+// queueReplyRetry は、REPLY_RETRY_DELAY（デフォルト 1m）の 2^Attempt 倍の時間が経ってから返信をやり直すよう予約します。
+func queueReplyRetry(reply *pendingReply) error {
+	delay, err := parseDuration(getEnvOrDefault("REPLY_RETRY_DELAY", "1m"))
+	if err != nil || delay <= 0 {
+		delay = time.Minute
+	}
+	id, err := audit.NewID()
+	if err != nil {
+		return err
+	}
+	reply.ScheduleName = "reply-" + id
+
+	input, err := json.Marshal(&replyRetryEvent{Reply: reply})
+	if err != nil {
+		return err
+	}
+	return createOneTimeSchedule(context.TODO(), reply.ScheduleName, time.Now().Add(delay<<reply.Attempt), input)
+}
+
+// handleReplyRetry は、予約した返信を送信します。
+// 送信に失敗した場合は REPLY_MAX_ATTEMPTS（デフォルト 5）回までやり直し、それでも届かない場合は
+// 依頼者にURLを伝えられるよう、メッセージを OPS_CHANNEL に転送します。
+func handleReplyRetry(ctx context.Context, reply *pendingReply) error {
+	deleteSchedule(ctx, reply.ScheduleName)
+
+	ws, err := resolveWorkspace(reply.TeamID, reply.EnterpriseID)
+	if err != nil {
+		return err
+	}
+	err = notifier.NewSlackThread(ws.Bot, reply.Channel, reply.ThreadTS).Notify(ctx, &notifier.Message{Text: reply.Text, Note: reply.Note})
+	if err == nil {
+		log.Println("Slackへの返信を再送しました。", reply.Channel, reply.ThreadTS, reply.Attempt)
+		return nil
+	}
+	log.Println("Slackへの返信の再送中にエラーが発生しました。", reply.Channel, reply.ThreadTS, reply.Attempt, err)
+
+	reply.Attempt++
+	if int64(reply.Attempt) < getEnvInt64("REPLY_MAX_ATTEMPTS", 5) {
+		qerr := queueReplyRetry(reply)
+		if qerr == nil {
+			return nil
+		}
+		log.Println("返信の再送の予約中にエラーが発生しました。", qerr)
+	}
+	escalateReply(ws, reply)
+	// 非同期の呼び出しはエラーを返すと再実行されるが、OPS_CHANNEL に引き継いだため再実行しない。
+	return nil
+}
+
+// escalateReply は、届けられなかった返信を OPS_CHANNEL に転送します。OPS_CHANNEL が設定されていない場合はログに出力するのみです。
+func escalateReply(ws *workspace, reply *pendingReply) {
+	channel := os.Getenv("OPS_CHANNEL")
+	if channel == "" {
+		log.Println("Slackへの返信を届けられませんでした。", reply.Channel, reply.ThreadTS)
+		return
+	}
+	text := fmt.Sprintf(":rotating_light: <#%s> のスレッドへの返信を%d回送信できませんでした。", reply.Channel, reply.Attempt)
+	if reply.Requester != "" {
+		text += fmt.Sprintf("依頼者 <@%s> に", reply.Requester)
+	}
+	text += "次の内容を伝えてください。\n" + reply.Text
+	if _, _, err := ws.Bot.PostMessage(channel, notifier.SlackMessageOptions(text, reply.Note)...); err != nil {
+		log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+	}
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	lambdaservice "github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/glacier"
+	"github.com/kumagai-s/uploader-v2/internal/slackfiles"
+	"github.com/slack-go/slack"
+)
+
+// restoreCommandUsage は、/geturl-restore の使い方です。
+const restoreCommandUsage = "使い方: /geturl-restore <監査記録のIDまたは短縮URL>"
+
+// restoreRequest は、/geturl-restore で依頼されたファイルの復元を、ワーカーに渡すときのペイロードです。
+type restoreRequest struct {
+	RecordID string `json:"record_id"`
+	User     string `json:"user"` // 復元を依頼したユーザー
+}
+
+// restoreEvent は、ファイルを復元するためにワーカーとして呼び出されたときのペイロードです。
+type restoreEvent struct {
+	Restore *restoreRequest `json:"restore_request"`
+}
+
+// handleRestoreCommand は、/geturl-restore を処理します。
+// 公開したファイルをS3から取得し、URLを発行したチャンネルのスレッドにSlackのファイルとしてアップロードし直します。
+// Slackのファイルは公開時に削除しているため、Slackで再び必要になった場合に使います。
+// 依頼者本人と ADMIN_USER_IDS の管理者のみ実行できます。
+// オブジェクトが S3 Glacier にアーカイブされている場合は、アーカイブからの復元を開始し、完了したらスレッドにリンクを投稿します。
+// スラッシュコマンドは3秒以内に応答する必要があるため、ASYNC_WORKER_FUNCTION が設定されている場合はワーカーで処理します。
+func handleRestoreCommand(teamID, user, text string) string {
+	if auditStore == nil {
+		return "AUDIT_TABLE が設定されていないため、実行できません。"
+	}
+	target := strings.TrimSpace(text)
+	if target == "" || strings.ContainsAny(target, " \t") {
+		return restoreCommandUsage
+	}
+
+	var (
+		record *audit.Record
+		err    error
+	)
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		record, err = auditStore.FindByShortURL(context.TODO(), target)
+	} else {
+		record, err = auditStore.Get(context.TODO(), target)
+	}
+	if err != nil {
+		log.Println("監査記録の検索中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	if record == nil || record.TeamID != teamID {
+		return "指定したリンクが見つかりません。"
+	}
+	if record.User != user && !isAdminUser(user) {
+		return "ファイルを復元できるのは、URLの発行を依頼したユーザーと管理者のみです。"
+	}
+
+	// アーカイブされている場合は、復元を開始し、完了したらスレッドにリンクを投稿する。
+	state, storageClass, err := objectArchiveState(context.TODO(), record)
+	if err != nil {
+		log.Println("オブジェクトの状態の取得中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	if state != glacier.StateAvailable {
+		return restoreFromArchive(record, user, state, storageClass)
+	}
+	if max := getEnvInt64("RESTORE_MAX_SIZE", 1<<30); record.Size > max {
+		return "ファイルが大きすぎるため、Slackに復元できません。"
+	}
+
+	req := &restoreRequest{RecordID: record.ID, User: user}
+	if asyncWorkerFunction() == "" {
+		if err := restoreToSlack(context.TODO(), record, req); err != nil {
+			log.Println("ファイルの復元中にエラーが発生しました。", record.ID, err)
+			return "ファイルを復元できませんでした。"
+		}
+		return fmt.Sprintf("%s を <#%s> に復元しました。", escapeMrkdwn(record.FileName), record.Channel)
+	}
+	payload, err := json.Marshal(&restoreEvent{Restore: req})
+	if err != nil {
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	if _, err := lambdaClient.Invoke(context.TODO(), &lambdaservice.InvokeInput{
+		FunctionName:   aws.String(asyncWorkerFunction()),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	}); err != nil {
+		log.Println("ワーカーの呼び出し中にエラーが発生しました。", err)
+		return "エラーが発生しました。処理を完了できませんでした。"
+	}
+	return fmt.Sprintf("%s を <#%s> に復元しています。完了するとスレッドにファイルが投稿されます。", escapeMrkdwn(record.FileName), record.Channel)
+}
+
+// restoreFromArchive は、アーカイブされたオブジェクトの復元を開始し、おおよその所要時間をスレッドに知らせます。
+func restoreFromArchive(record *audit.Record, user, state string, storageClass types.StorageClass) string {
+	eta, err := restoreArchivedObject(context.TODO(), record, state, storageClass, &glacier.Waiter{
+		RecordID:     record.ID,
+		TeamID:       record.TeamID,
+		EnterpriseID: record.EnterpriseID,
+		Channel:      record.Channel,
+		ThreadTS:     record.MessageTS,
+		User:         user,
+	})
+	if err != nil {
+		log.Println("アーカイブからの復元の開始中にエラーが発生しました。", record.ID, err)
+		return "ファイルがアーカイブされているため、復元できませんでした。"
+	}
+	ws, err := resolveWorkspace(record.TeamID, record.EnterpriseID)
+	if err != nil {
+		log.Println("ワークスペースの確認中にエラーが発生しました。", record.ID, err)
+		return fmt.Sprintf("%s はアーカイブされているため、復元を開始しました（%s）。", escapeMrkdwn(record.FileName), eta)
+	}
+	message := fmt.Sprintf(":hourglass_flowing_sand: %s はアーカイブされているため、復元しています（%s）。完了したらこのスレッドにリンクを投稿します。", escapeMrkdwn(record.FileName), eta)
+	options := []slack.MsgOption{slack.MsgOptionText(message, false)}
+	if record.MessageTS != "" {
+		options = append(options, slack.MsgOptionTS(record.MessageTS))
+	}
+	if _, _, err := ws.Bot.PostMessage(record.Channel, options...); err != nil {
+		log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+	}
+	return fmt.Sprintf("%s はアーカイブされているため、復元を開始しました（%s）。完了したら <#%s> にリンクを投稿します。", escapeMrkdwn(record.FileName), eta, record.Channel)
+}
+
+// handleRestoreEvent は、ワーカーとして呼び出されたときにファイルを復元します。
+// 復元できなかった場合は、依頼したユーザーにだけ表示されるメッセージで知らせます。
+func handleRestoreEvent(ctx context.Context, req *restoreRequest) error {
+	record, err := auditStore.Get(ctx, req.RecordID)
+	if err != nil || record == nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", req.RecordID, err)
+		return nil
+	}
+	if err := restoreToSlack(ctx, record, req); err != nil {
+		log.Println("ファイルの復元中にエラーが発生しました。", record.ID, err)
+		ws, err := resolveWorkspace(record.TeamID, record.EnterpriseID)
+		if err != nil {
+			log.Println("ワークスペースの確認中にエラーが発生しました。", record.ID, err)
+			return nil
+		}
+		text := fmt.Sprintf("%s を復元できませんでした。", escapeMrkdwn(record.FileName))
+		if _, err := ws.Bot.PostEphemeralContext(ctx, record.Channel, req.User, slack.MsgOptionText(text, false)); err != nil {
+			log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+		}
+	}
+	// 非同期の呼び出しはエラーを返すと再実行されるが、依頼したユーザーに知らせたため再実行しない。
+	return nil
+}
+
+// restoreToSlack は、監査記録のオブジェクトをS3から取得し、URLの発行を依頼したメッセージのスレッドにアップロードします。
+func restoreToSlack(ctx context.Context, record *audit.Record, req *restoreRequest) error {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucketOrDefault(record.Bucket)),
+		Key:    aws.String(record.ObjectKey),
+	}
+	if record.VersionID != "" {
+		input.VersionId = aws.String(record.VersionID)
+	}
+
+	buf, err := memoryBudget.NewBuffer(record.Size)
+	if err != nil {
+		return err
+	}
+	defer buf.Close()
+	client, err := s3ClientFor(record.TeamID, record.EnterpriseID, record.Region)
+	if err != nil {
+		return err
+	}
+	out, err := client.GetObject(ctx, input)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(buf, out.Body)
+	out.Body.Close()
+	if err != nil {
+		return err
+	}
+	content, err := buf.Bytes()
+	if err != nil {
+		return err
+	}
+
+	ws, err := resolveWorkspace(record.TeamID, record.EnterpriseID)
+	if err != nil {
+		return err
+	}
+	comment := fmt.Sprintf("<@%s> の依頼で、%s をSlackに復元しました。", req.User, escapeMrkdwn(record.FileName))
+	_, err = slackUploader(ws).Upload(ctx, record.Channel, record.MessageTS, comment, &slackfiles.File{Name: record.FileName, Content: content})
+	return err
+}
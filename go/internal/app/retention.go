@@ -0,0 +1,86 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// retentionClass は、class= で選択できる保存期間の区分です。
+// 管理者が RETENTION_CLASSES にJSONで定義します。
+// 例: {"short":{"expiry":"24h"},"archive":{"expiry":"7d","storage_class":"GLACIER_IR"}}
+type retentionClass struct {
+	Name         string `json:"-"`
+	Expiry       string `json:"expiry"`        // 署名付きURLの有効期限（最大7日）
+	StorageClass string `json:"storage_class"` // S3のストレージクラス。空の場合はバケットの既定値
+}
+
+// retentionClassTagKey は、区分の名前を設定するオブジェクトタグのキーです。
+// 区分ごとの削除や移行は、このタグで絞り込んだS3のライフサイクルルールで設定します。
+const retentionClassTagKey = "retention-class"
+
+// parseObjectLockMode は、retain= で削除を禁止する Object Lock のモード（OBJECT_LOCK_MODE、デフォルト COMPLIANCE）を返します。
+// GOVERNANCE は権限のあるユーザーが保持期間を解除できるため、明示的に指定した場合のみ使用します。
+func parseObjectLockMode(value string) (types.ObjectLockMode, error) {
+	if value == "" {
+		return types.ObjectLockModeCompliance, nil
+	}
+	switch mode := types.ObjectLockMode(strings.ToUpper(value)); mode {
+	case types.ObjectLockModeGovernance, types.ObjectLockModeCompliance:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid OBJECT_LOCK_MODE %q, must be GOVERNANCE or COMPLIANCE", value)
+}
+
+// resolveRetentionClass は、RETENTION_CLASSES に定義した区分を返します。
+func resolveRetentionClass(name string) (*retentionClass, error) {
+	classes := map[string]*retentionClass{}
+	if v := os.Getenv("RETENTION_CLASSES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &classes); err != nil {
+			return nil, fmt.Errorf("RETENTION_CLASSES の設定が不正です。")
+		}
+	}
+	if class, ok := classes[name]; ok && class != nil {
+		class.Name = name
+		return class, nil
+	}
+	names := make([]string, 0, len(classes))
+	for n := range classes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("class オプションはこの環境では利用できません。")
+	}
+	return nil, fmt.Errorf("class の値「%s」が不正です。%s のいずれかを指定してください。", name, strings.Join(names, ", "))
+}
+
+// expiry は、区分の署名付きURLの有効期限を返します。
+func (c *retentionClass) expiry() (time.Duration, error) {
+	if c.Expiry == "" {
+		return 0, nil
+	}
+	d, err := parseDuration(c.Expiry)
+	if err != nil || d <= 0 || d > presignExpiry {
+		return 0, fmt.Errorf("RETENTION_CLASSES の %s の expiry が不正です。", c.Name)
+	}
+	return d, nil
+}
+
+// applyRetentionClass は、アップロードするオブジェクトに区分のストレージクラスとタグを設定します。
+func applyRetentionClass(class *retentionClass, storageClass *types.StorageClass, tagging **string) {
+	if class == nil {
+		return
+	}
+	if class.StorageClass != "" {
+		*storageClass = types.StorageClass(class.StorageClass)
+	}
+	tag := url.Values{retentionClassTagKey: {class.Name}}.Encode()
+	*tagging = &tag
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/slackfiles"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// rollbackMessage は、URLを発行できなかったファイルをスレッドに戻すときに添えるメッセージです。
+const rollbackMessage = "URLを発行できなかったため、アップロードしたファイルを削除し、Slackのファイルを元に戻しました。"
+
+// rollbackPublished は、ROLLBACK_ON_FAILURE=on の場合に、URLの短縮や通知に失敗して依頼者にURLを知らせられなかったファイルを元に戻します。
+// extra には、バンドルのインデックスページのように、ファイルとは別にアップロードしたオブジェクトを渡します。
+func rollbackPublished(ws *workspace, ev *slackevents.AppMentionEvent, published []*publishedFile, extra ...*uploadedObject) {
+	if os.Getenv("ROLLBACK_ON_FAILURE") != "on" {
+		return
+	}
+	revertPublished(ws, ev, published, extra...)
+}
+
+// revertPublished は、依頼者にURLを知らせられなかったファイルを元に戻します。
+// 誰もURLを知らないオブジェクトがバケットに残らないよう、アップロードしたオブジェクトと、隣に保存したメタリンクとマニフェストを削除します。
+// Slackのファイルは検査を通過した時点で削除しているため、ファイルの内容を依頼者のスレッドにアップロードし直します。
+// ウォーターマークや再圧縮をした場合は、処理した後の内容になります。
+func revertPublished(ws *workspace, ev *slackevents.AppMentionEvent, published []*publishedFile, extra ...*uploadedObject) {
+	if len(published) == 0 {
+		return
+	}
+	ctx := context.TODO()
+	uploaded := extra
+	files := make([]*slackfiles.File, 0, len(published))
+	for _, p := range published {
+		if p.uploaded != nil {
+			uploaded = append(uploaded, p.uploaded)
+		}
+		files = append(files, &slackfiles.File{Name: p.file.Name, Content: p.file.Binary})
+	}
+
+	for _, u := range uploaded {
+		if u == nil {
+			continue
+		}
+		if err := deleteRecordObjects(ctx, &audit.Record{Bucket: u.Bucket, ObjectKey: u.Key, VersionID: u.VersionID, Region: u.Region, TeamID: u.TeamID, EnterpriseID: u.EnterpriseID}); err != nil {
+			log.Println("発行できなかったファイルの削除中にエラーが発生しました。", u.Key, err)
+		}
+	}
+	if _, err := slackUploader(ws).Upload(ctx, ev.Channel, ev.TimeStamp, rollbackMessage, files...); err != nil {
+		log.Println("Slackのファイルを元に戻す処理中にエラーが発生しました。", err)
+		return
+	}
+	log.Println("URLを発行できなかったファイルを元に戻しました。", len(files))
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// acceptedMessage は、非同期に処理するファイルを受け付けたことを依頼者に知らせるメッセージです。
+const acceptedMessage = "ファイルを受け付けました。処理が完了したらこのスレッドにURLを送信します。"
+
+// inlineSizeThreshold は、添付ファイルの合計サイズがこの値（バイト）以下の場合に、
+// ワーカーや Step Functions に任せずにその場で処理するしきい値です。
+// INLINE_SIZE_THRESHOLD が設定されていない場合は 0 を返し、サイズによる振り分けを行いません。
+func inlineSizeThreshold() int64 {
+	return getEnvInt64("INLINE_SIZE_THRESHOLD", 0)
+}
+
+// totalAttachmentSize は、添付ファイルの合計サイズを返します。
+func totalAttachmentSize(files []SlackAppMentionEventFile) int64 {
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	return total
+}
+
+// processInline は、添付ファイルが小さく、その場で処理してすぐに返信するべきかを返します。
+func processInline(files []SlackAppMentionEventFile) bool {
+	threshold := inlineSizeThreshold()
+	return threshold > 0 && totalAttachmentSize(files) <= threshold
+}
+
+// shouldDispatchToWorker は、コールバックイベントをワーカーに任せるかを返します。
+// INLINE_SIZE_THRESHOLD 以下の添付ファイルのメンションは、ワーカーを経由せずに処理します。
+// 解析できないリクエストは、通常どおりワーカーに任せます。
+func shouldDispatchToWorker(body string) bool {
+	if asyncWorkerFunction() == "" {
+		return false
+	}
+	var req SlackAppMentionEventRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return true
+	}
+	return !processInline(req.Event.Files)
+}
+
+// acknowledgeDispatch は、サイズによってワーカーに任せたメンションに、処理中であることを返信します。
+// Step Functions で処理する場合は実行を開始したときに、ステータスメッセージを使う場合はワーカーが受け付けたときに返信するため、ここでは返信しません。
+func acknowledgeDispatch(eventsAPIEvent slackevents.EventsAPIEvent) {
+	if inlineSizeThreshold() <= 0 || os.Getenv("EXECUTION_MODE") == "stepfunctions" || statusMessageEnabled() {
+		return
+	}
+	ev, ok := eventsAPIEvent.InnerEvent.Data.(*slackevents.AppMentionEvent)
+	if !ok {
+		return
+	}
+	ws, err := resolveWorkspace(eventsAPIEvent.TeamID, eventsAPIEvent.EnterpriseID)
+	if err != nil {
+		log.Println("ワークスペースの確認中にエラーが発生しました。", err)
+		return
+	}
+	if _, _, err := ws.Bot.PostMessage(ev.Channel, slack.MsgOptionText(acceptedMessage, false), slack.MsgOptionTS(ev.TimeStamp)); err != nil {
+		log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+	}
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/scheduler"
+	schedulertypes "github.com/aws/aws-sdk-go-v2/service/scheduler/types"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// scheduledPublication は、publish_at で予約したURLの送信です。
+// アップロードは予約時に済ませ、署名付きURLは送信時に生成するため、有効期限は送信時から数えます。
+type scheduledPublication struct {
+	TeamID       string   `json:"team_id"`
+	EnterpriseID string   `json:"enterprise_id,omitempty"`
+	Channel      string   `json:"channel"`
+	ThreadTS     string   `json:"thread_ts"`
+	Requester    string   `json:"requester"`
+	FileName     string   `json:"file_name"`
+	Bucket       string   `json:"bucket,omitempty"`
+	ObjectKey    string   `json:"object_key"`
+	VersionID    string   `json:"version_id,omitempty"`
+	Region       string   `json:"region,omitempty"`
+	Size         int64    `json:"size"`
+	Warnings     string   `json:"warnings,omitempty"`
+	Expiry       int64    `json:"expiry,omitempty"` // 署名付きURLの有効期限（秒）
+	Notify       []string `json:"notify,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	ScheduleName string   `json:"schedule_name"`
+	// AllowedGroups は、ポータルのリンクでダウンロードを許可するIdPのグループです（groups=）。
+	AllowedGroups []string `json:"allowed_groups,omitempty"`
+}
+
+// schedulerEvent は、EventBridge Scheduler から呼び出されたときのペイロードです。
+type schedulerEvent struct {
+	Publication *scheduledPublication `json:"scheduled_publication"`
+}
+
+// newScheduledPublication は、アップロード済みのファイルのURLの送信の予約を生成します。
+func newScheduledPublication(ws *workspace, ev *slackevents.AppMentionEvent, p *publishedFile, opts *mentionOptions) *scheduledPublication {
+	return &scheduledPublication{
+		TeamID:        ws.TeamID,
+		EnterpriseID:  ws.EnterpriseID,
+		Channel:       ev.Channel,
+		ThreadTS:      ev.TimeStamp,
+		Requester:     ev.User,
+		FileName:      p.file.Name,
+		Bucket:        p.uploaded.Bucket,
+		ObjectKey:     p.uploaded.Key,
+		VersionID:     p.uploaded.VersionID,
+		Region:        p.uploaded.Region,
+		Size:          int64(len(p.file.Binary)),
+		Warnings:      p.warnings(),
+		Expiry:        int64(opts.Expiry / time.Second),
+		Notify:        opts.Notify,
+		Note:          opts.Note,
+		AllowedGroups: portalAllowedGroups(opts),
+	}
+}
+
+// parsePublishAt は、publish_at の値を解釈します。「2024-07-01T09:00+09:00」のように時差を含めて指定します。
+func parsePublishAt(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04Z07:00", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("publish_at の値「%s」が不正です。「2024-07-01T09:00+09:00」のように指定してください。", value)
+}
+
+// schedulePublication は、EventBridge Scheduler に1回限りのスケジュールを作成し、publishAt に URL を送信するよう予約します。
+// スケジュールは SCHEDULER_TARGET_ARN の Lambda を SCHEDULER_ROLE_ARN のロールで呼び出します。
+func schedulePublication(ws *workspace, pub *scheduledPublication, publishAt time.Time) error {
+	id, err := audit.NewID()
+	if err != nil {
+		return err
+	}
+	pub.ScheduleName = "publish-" + id
+
+	input, err := json.Marshal(&schedulerEvent{Publication: pub})
+	if err != nil {
+		return err
+	}
+	if _, err := schedulerClient.CreateSchedule(context.TODO(), &scheduler.CreateScheduleInput{
+		Name:                       aws.String(pub.ScheduleName),
+		GroupName:                  aws.String(getEnvOrDefault("SCHEDULE_GROUP", "default")),
+		ScheduleExpression:         aws.String("at(" + publishAt.UTC().Format("2006-01-02T15:04:05") + ")"),
+		ScheduleExpressionTimezone: aws.String("UTC"),
+		FlexibleTimeWindow:         &schedulertypes.FlexibleTimeWindow{Mode: schedulertypes.FlexibleTimeWindowModeOff},
+		Target: &schedulertypes.Target{
+			Arn:     aws.String(os.Getenv("SCHEDULER_TARGET_ARN")),
+			RoleArn: aws.String(os.Getenv("SCHEDULER_ROLE_ARN")),
+			Input:   aws.String(string(input)),
+		},
+	}); err != nil {
+		return err
+	}
+
+	_, _, err = ws.Bot.PostMessage(
+		pub.Channel,
+		slack.MsgOptionText(fmt.Sprintf("「%s」のURLを %s にこのスレッドに送信します。", pub.FileName, publishAt.Format("2006-01-02 15:04 MST")), false),
+		slack.MsgOptionTS(pub.ThreadTS),
+	)
+	return err
+}
+
+// handleScheduledPublication は、予約した時刻に署名付きURLを生成して短縮し、依頼者のスレッドに送信します。
+func handleScheduledPublication(ctx context.Context, pub *scheduledPublication) error {
+	ws, err := resolveWorkspace(pub.TeamID, pub.EnterpriseID)
+	if err != nil {
+		return err
+	}
+
+	uploaded := &uploadedObject{
+		Bucket:       pub.Bucket,
+		Key:          pub.ObjectKey,
+		VersionID:    pub.VersionID,
+		Region:       pub.Region,
+		TeamID:       pub.TeamID,
+		EnterpriseID: pub.EnterpriseID,
+		Expiry:       time.Duration(pub.Expiry) * time.Second,
+	}
+	if err := presignObject(uploaded); err != nil {
+		return err
+	}
+	shortURLs, auditIDs, err := shortenLinks(shortenerFor(pub.TeamID, pub.Channel), []string{uploaded.PresignedURL}, nil)
+	if err != nil {
+		return err
+	}
+	shortURL := shortURLs[0]
+
+	message := formatPublishedMessage(shortURL, pub.Size, pub.Warnings) + portalGroupsMessage(pub.AllowedGroups)
+	if err := notifyPublished(ctx, ws, pub.Channel, pub.ThreadTS, pub.Requester, pub.Notify, message, pub.Note); err != nil {
+		return err
+	}
+
+	recordAudit(&audit.Record{
+		ID:            auditIDs[0],
+		TeamID:        pub.TeamID,
+		EnterpriseID:  pub.EnterpriseID,
+		Channel:       pub.Channel,
+		MessageTS:     pub.ThreadTS,
+		User:          pub.Requester,
+		FileName:      pub.FileName,
+		Bucket:        pub.Bucket,
+		ObjectKey:     pub.ObjectKey,
+		VersionID:     pub.VersionID,
+		Region:        pub.Region,
+		Size:          pub.Size,
+		ShortURL:      shortURL,
+		ExpiresAt:     uploaded.ExpiresAt.Unix(),
+		Note:          pub.Note,
+		AllowedGroups: pub.AllowedGroups,
+	})
+
+	deleteSchedule(ctx, pub.ScheduleName)
+	return nil
+}
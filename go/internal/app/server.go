@@ -0,0 +1,95 @@
+package app
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+)
+
+// serveAPIGateway は、HTTPリクエストを API Gateway のリクエストに変換して handler で処理します。
+func serveAPIGateway(handler func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		headers := map[string]string{}
+		for key := range r.Header {
+			headers[key] = r.Header.Get(key)
+		}
+
+		query := map[string]string{}
+		for key := range r.URL.Query() {
+			query[key] = r.URL.Query().Get(key)
+		}
+
+		res, _ := handler(events.APIGatewayProxyRequest{
+			HTTPMethod:            r.Method,
+			Path:                  r.URL.Path,
+			Headers:               headers,
+			QueryStringParameters: query,
+			Body:                  string(body),
+			RequestContext: events.APIGatewayProxyRequestContext{
+				Identity: events.APIGatewayRequestIdentity{SourceIP: sourceIP(r)},
+			},
+		})
+		for key, value := range res.Headers {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(res.StatusCode)
+		io.WriteString(w, res.Body)
+	}
+}
+
+// sourceIP は、リクエストの送信元のIPアドレスを返します。
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// instrument は、レスポンスのステータスコードごとにリクエスト数を数えます。
+func instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(sw, r)
+		metrics.ObserveRequest(strconv.Itoa(sw.status))
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// runServer は、常駐するHTTPサーバーとして起動し、Slackのイベント、受取人の確認ページ（/verify/）、ポータル（/portal/）、API（/api/）と /metrics を提供します。
+// 内部の短縮URLサービスを使う場合は、SHORTENER_BASE_URL のパス（例: /s/）で短縮URLのリダイレクトも提供します。
+func runServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/verify/", instrument(recoverer(serveAPIGateway(handleVerification))))
+	mux.HandleFunc("/portal/", instrument(recoverer(serveAPIGateway(handlePortal))))
+	mux.HandleFunc("/api/", instrument(recoverer(serveAPIGateway(handleAPI))))
+	if base := shortenerBasePath(); base != "" && shortLinkResolver != nil {
+		mux.HandleFunc(base+"/", instrument(recoverer(serveAPIGateway(handleRedirect))))
+	}
+	mux.HandleFunc("/", instrument(recoverer(serveAPIGateway(lambdaHandler))))
+
+	addr := ":" + getEnvOrDefault("PORT", "8080")
+	log.Println("HTTPサーバーを起動します。", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/slack-go/slack"
+)
+
+// slackMetadataCacheTTL は、users.info と conversations.info の結果をキャッシュする時間です（SLACK_METADATA_CACHE_TTL、デフォルト 10m）。
+func slackMetadataCacheTTL() time.Duration {
+	d, err := parseDuration(getEnvOrDefault("SLACK_METADATA_CACHE_TTL", "10m"))
+	if err != nil || d <= 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+// cachedUserInfo は、users.info でユーザーの情報を取得します。
+// 監査や認可、テンプレートのたびに呼び出すと Tier 3 のレート制限に達するため、結果は slackMetadataCache に保持します。
+func cachedUserInfo(ctx context.Context, ws *workspace, userID string) (*slack.User, error) {
+	key := "user/" + ws.TeamID + "/" + userID
+	if v, ok := slackMetadataCache.Get(key); ok {
+		metrics.ObserveCache("slack_user", true)
+		return v.(*slack.User), nil
+	}
+	metrics.ObserveCache("slack_user", false)
+	user, err := ws.Bot.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	slackMetadataCache.Set(key, user)
+	return user, nil
+}
+
+// cachedConversationInfo は、conversations.info でチャンネルの情報を取得します。結果は slackMetadataCache に保持します。
+func cachedConversationInfo(ctx context.Context, ws *workspace, channelID string) (*slack.Channel, error) {
+	key := "channel/" + ws.TeamID + "/" + channelID
+	if v, ok := slackMetadataCache.Get(key); ok {
+		metrics.ObserveCache("slack_channel", true)
+		return v.(*slack.Channel), nil
+	}
+	metrics.ObserveCache("slack_channel", false)
+	channel, err := ws.Bot.GetConversationInfoContext(ctx, &slack.GetConversationInfoInput{ChannelID: channelID})
+	if err != nil {
+		return nil, err
+	}
+	slackMetadataCache.Set(key, channel)
+	return channel, nil
+}
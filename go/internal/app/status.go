@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/kumagai-s/uploader-v2/internal/notifier"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// ステータスメッセージに表示する処理の状態です。
+const (
+	statusReceived   = "received"
+	statusValidating = "validating"
+	statusUploading  = "uploading"
+)
+
+// mentionStatuses は、処理中のメンションのステータスメッセージです。キーは「チャンネル/メンションのts」です。
+var mentionStatuses sync.Map
+
+// mentionStatus は、メンション1件につき1つだけ投稿し、処理の進み具合に合わせて書き換えるステータスメッセージです。
+// エラーや発行したURLも新しく投稿せずにこのメッセージに書き込み、複数のファイルを送ったときのスレッドの投稿を減らします。
+type mentionStatus struct {
+	ws       *workspace
+	channel  string
+	threadTS string
+	ts       string // ステータスメッセージのts
+
+	mu      sync.Mutex
+	results []string // 発行したURLを知らせるメッセージ
+	note    string
+}
+
+// statusMessageEnabled は、STATUS_MESSAGE=on の場合に、ステータスメッセージを書き換えて進み具合を知らせるかを返します。
+func statusMessageEnabled() bool {
+	return os.Getenv("STATUS_MESSAGE") == "on"
+}
+
+func mentionStatusKey(channel, threadTS string) string {
+	return channel + "/" + threadTS
+}
+
+// startMentionStatus は、「受け付けました」のステータスメッセージを投稿します。
+// 無効な場合や投稿に失敗した場合は nil を返し、従来どおりメッセージごとに投稿します。
+func startMentionStatus(ws *workspace, ev *slackevents.AppMentionEvent) *mentionStatus {
+	if !statusMessageEnabled() {
+		return nil
+	}
+	_, ts, err := ws.Bot.PostMessage(ev.Channel, slack.MsgOptionText(statusText(statusReceived, 0, 0), false), slack.MsgOptionTS(ev.TimeStamp))
+	if err != nil {
+		log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+		return nil
+	}
+	s := &mentionStatus{ws: ws, channel: ev.Channel, threadTS: ev.TimeStamp, ts: ts}
+	mentionStatuses.Store(mentionStatusKey(ev.Channel, ev.TimeStamp), s)
+	return s
+}
+
+// lookupMentionStatus は、スレッドのメンションを処理中であれば、そのステータスメッセージを返します。
+func lookupMentionStatus(channel, threadTS string) *mentionStatus {
+	v, ok := mentionStatuses.Load(mentionStatusKey(channel, threadTS))
+	if !ok {
+		return nil
+	}
+	return v.(*mentionStatus)
+}
+
+// statusText は、状態を表すステータスメッセージの本文です。total が2以上の場合は何件目のファイルかを添えます。
+func statusText(state string, i, total int) string {
+	var text string
+	switch state {
+	case statusReceived:
+		text = ":inbox_tray: ファイルを受け付けました。"
+	case statusValidating:
+		text = ":mag: ファイルを検証しています。"
+	case statusUploading:
+		text = ":arrow_up: ファイルをアップロードしています。"
+	}
+	if total > 1 {
+		text += fmt.Sprintf("（%d/%d）", i+1, total)
+	}
+	return text
+}
+
+// update は、ステータスメッセージを i 件目のファイルの state に書き換えます。s が nil の場合は何もしません。
+func (s *mentionStatus) update(state string, i, total int) {
+	if s == nil {
+		return
+	}
+	s.edit(statusText(state, i, total), "")
+}
+
+// addResult は、発行したURLを知らせるメッセージを、完了したときに表示するよう保持します。
+func (s *mentionStatus) addResult(message, note string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, message)
+	if note != "" {
+		s.note = note
+	}
+}
+
+// fail は、ステータスメッセージをエラーメッセージに書き換えて、処理を終えます。
+func (s *mentionStatus) fail(message string) {
+	mentionStatuses.Delete(mentionStatusKey(s.channel, s.threadTS))
+	s.edit(":x: "+message, "")
+}
+
+// finish は、ステータスメッセージを発行したURLの一覧に書き換えて、処理を終えます。
+// 既に fail で終えている場合は何もしません。s が nil の場合も何もしません。
+func (s *mentionStatus) finish() {
+	if s == nil {
+		return
+	}
+	if _, loaded := mentionStatuses.LoadAndDelete(mentionStatusKey(s.channel, s.threadTS)); !loaded {
+		return
+	}
+	s.mu.Lock()
+	text := ":white_check_mark: 処理が完了しました。"
+	if len(s.results) > 0 {
+		text += "\n" + strings.Join(s.results, "\n\n")
+	}
+	note := s.note
+	s.mu.Unlock()
+	if !s.edit(text, note) && len(s.results) > 0 {
+		// 書き換えられなかった場合も、URLは確実に届ける。
+		if err := notifier.NewSlackThread(s.ws.Bot, s.channel, s.threadTS).Notify(context.TODO(), &notifier.Message{Text: text, Note: note}); err != nil {
+			log.Println("Slackにメッセージを送信中にエラーが発生しました。", err)
+		}
+	}
+}
+
+// edit は、ステータスメッセージを書き換え、成功したかを返します。
+func (s *mentionStatus) edit(text, note string) bool {
+	if _, _, _, err := s.ws.Bot.UpdateMessage(s.channel, s.ts, notifier.SlackMessageOptions(text, note)...); err != nil {
+		log.Println("ステータスメッセージの更新中にエラーが発生しました。", err)
+		return false
+	}
+	return true
+}
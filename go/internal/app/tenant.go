@@ -0,0 +1,45 @@
+package app
+
+import (
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tenantStorage は、チームごとのIAMロールで操作するS3のクライアントと、チームのファイルを保存するバケットです。
+// ワークスペースごとにロールとバケットポリシーを分け、トークンが漏洩した場合でも影響を受けるファイルをそのチームに限定します。
+type tenantStorage struct {
+	Client *s3.Client
+	Bucket string // 空の場合は S3_BUCKET
+}
+
+// newTenantStorage は、roleARN のロールを引き受けてS3を操作する tenantStorage を返します。
+// 一時的な認証情報は、期限が切れる前に自動的に取得し直します。
+// TENANT_ROLE_EXTERNAL_ID が設定されている場合は、ロールを引き受けるときに外部IDとして指定します。
+func newTenantStorage(teamID, roleARN, bucket string) *tenantStorage {
+	provider := stscreds.NewAssumeRoleProvider(stsClient, roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "geturl-" + teamID
+		if externalID := os.Getenv("TENANT_ROLE_EXTERNAL_ID"); externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+	client := s3.NewFromConfig(s3Config, func(o *s3.Options) {
+		o.UsePathStyle = true
+		o.Credentials = aws.NewCredentialsCache(provider)
+	})
+	return &tenantStorage{Client: client, Bucket: bucket}
+}
+
+// uploadTarget は、ws のファイルをアップロードするS3のクライアントとバケットを返します。
+// bucket= を指定した場合はそのバケットに、チームのバケットが登録されている場合はそのバケットに、それ以外は S3_BUCKET にアップロードします。
+func uploadTarget(ws *workspace, opts *mentionOptions) (*s3.Client, string) {
+	if ws.Storage == nil {
+		return s3Client, bucketOrDefault(opts.Bucket)
+	}
+	if opts.Bucket == "" && ws.Storage.Bucket != "" {
+		return ws.Storage.Client, ws.Storage.Bucket
+	}
+	return ws.Storage.Client, bucketOrDefault(opts.Bucket)
+}
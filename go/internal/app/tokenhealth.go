@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/metrics"
+	"github.com/slack-go/slack"
+)
+
+// tokenAuthErrors は、auth.test がトークンの失効や期限切れを示すエラーです。
+var tokenAuthErrors = map[string]bool{
+	"invalid_auth":       true,
+	"not_authed":         true,
+	"token_revoked":      true,
+	"token_expired":      true,
+	"account_inactive":   true,
+	"org_login_required": true,
+}
+
+// tokenCheck は、確認するトークンとその名前です。
+type tokenCheck struct {
+	Name   string // 「default/bot」や「T012345/user」など
+	Client *slack.Client
+}
+
+// tokenChecks は、デフォルトのトークンと SLACK_WORKSPACE_TOKENS に設定したトークンの一覧を返します。
+func tokenChecks() []tokenCheck {
+	checks := []tokenCheck{
+		{Name: "default/bot", Client: slackClientAsBot},
+		{Name: "default/user", Client: slackClientAsUser},
+	}
+	if token := os.Getenv("SLACK_ADMIN_OAUTH_TOKEN"); token != "" {
+		checks = append(checks, tokenCheck{Name: "default/admin", Client: newSlackClient(token)})
+	}
+	lookupWorkspaceTokens("", "")
+	ids := make([]string, 0, len(workspaceTokensByID))
+	for id := range workspaceTokensByID {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		tokens := workspaceTokensByID[id]
+		checks = append(checks,
+			tokenCheck{Name: id + "/bot", Client: newSlackClient(tokens.Bot)},
+			tokenCheck{Name: id + "/user", Client: newSlackClient(tokens.User)},
+		)
+		if tokens.Admin != "" {
+			checks = append(checks, tokenCheck{Name: id + "/admin", Client: newSlackClient(tokens.Admin)})
+		}
+	}
+	return checks
+}
+
+// handleTokenHealthCheck は、EventBridge のスケジュールから定期的に呼び出され、各トークンで auth.test を実行します。
+// 失効や期限切れのトークンが見つかった場合は OPS_CHANNEL に通知します。
+// ユーザートークンが失効すると、ファイルの削除だけが失敗し続けて気付きにくいためです。
+func handleTokenHealthCheck(ctx context.Context, _ events.CloudWatchEvent) error {
+	var unhealthy []string
+	for _, check := range tokenChecks() {
+		_, err := check.Client.AuthTestContext(ctx)
+		if err == nil {
+			metrics.SetTokenHealth(check.Name, true)
+			continue
+		}
+
+		var slackErr slack.SlackErrorResponse
+		if errors.As(err, &slackErr) && tokenAuthErrors[slackErr.Err] {
+			metrics.SetTokenHealth(check.Name, false)
+			unhealthy = append(unhealthy, fmt.Sprintf("・%s: %s", check.Name, err))
+			continue
+		}
+		// 通信エラーなどトークンの状態が分からない場合は、状態を変えずに次回の確認に任せる。
+		log.Println("トークンの確認中にエラーが発生しました。", check.Name, err)
+	}
+
+	if len(unhealthy) == 0 {
+		return nil
+	}
+	message := "*Slackのトークンが失効または期限切れになっています。*\n" + strings.Join(unhealthy, "\n")
+	log.Println(message)
+
+	channel := os.Getenv("OPS_CHANNEL")
+	if channel == "" {
+		return nil
+	}
+	if _, _, err := slackClientAsBot.PostMessageContext(ctx, channel, slack.MsgOptionText(message, false)); err != nil {
+		// ボットのトークン自体が失効している場合は通知できないため、ログとメトリクスで検知する。
+		log.Println("トークンの失効をSlackに通知中にエラーが発生しました。", err)
+	}
+	return nil
+}
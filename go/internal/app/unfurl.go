@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/cost"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// handleLinkSharedEvent は、短縮URLのドメイン（SHORT_LINK_DOMAIN）のリンクが投稿された際に、
+// 監査記録からファイル名、サイズ、有効期限、依頼者を取得してプレビューを表示します。
+// ws: イベントが発生したワークスペース
+// ev: LinkSharedイベントへのポインタ。投稿されたリンクを含む。
+// プレビューの表示に失敗しても、Slackに再送させないよう200を返します。
+func handleLinkSharedEvent(ws *workspace, ev *slackevents.LinkSharedEvent) (events.APIGatewayProxyResponse, error) {
+	domain := os.Getenv("SHORT_LINK_DOMAIN")
+	if auditStore == nil || domain == "" {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+	// 入力中のメッセージ（message_ts がUUID）のプレビューには対応しない。
+	if !strings.Contains(ev.MessageTimeStamp, ".") {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+
+	unfurls := map[string]slack.Attachment{}
+	for _, link := range ev.Links {
+		if link.Domain != domain {
+			continue
+		}
+		record, err := auditStore.FindByShortURL(context.TODO(), link.URL)
+		if err != nil {
+			log.Println("監査記録の取得中にエラーが発生しました。", err)
+			continue
+		}
+		if record == nil {
+			continue
+		}
+
+		expiresAt := time.Unix(record.ExpiresAt, 0)
+		status := "有効期限: " + expiresAt.Format("2006-01-02 15:04")
+		if time.Now().After(expiresAt) {
+			status = "期限切れ（" + expiresAt.Format("2006-01-02 15:04") + "）"
+		}
+		text := fmt.Sprintf(
+			"サイズ: %s\n%s\n依頼者: <@%s>",
+			cost.HumanSize(record.Size), status, record.User,
+		)
+		if record.Note != "" {
+			text += "\n:memo: " + escapeMrkdwn(record.Note)
+		}
+		unfurls[link.URL] = slack.Attachment{
+			Title: record.FileName,
+			Text:  text,
+		}
+	}
+	if len(unfurls) == 0 {
+		return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	}
+
+	if _, _, _, err := ws.Bot.UnfurlMessage(ev.Channel, ev.MessageTimeStamp, unfurls); err != nil {
+		log.Println("リンクのプレビューの送信中にエラーが発生しました。", err)
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/notifier"
+	"github.com/kumagai-s/uploader-v2/internal/otp"
+	"github.com/kumagai-s/uploader-v2/internal/urlshortener"
+	"github.com/slack-go/slack"
+)
+
+// verificationEnabled は、受取人を限定したリンクで、このアプリの確認ページ（OTP_GATE_URL）を使うかを返します。
+// 無効な場合は、受取人の確認を短縮URLサービスに任せます。
+func verificationEnabled() bool {
+	return os.Getenv("OTP_GATE_URL") != "" && otpStore != nil && auditStore != nil
+}
+
+// verificationURL は、監査記録 id の確認ページのURLです。
+func verificationURL(id string) string {
+	return strings.TrimSuffix(os.Getenv("OTP_GATE_URL"), "/") + "/" + id
+}
+
+// handleVerification は、LAMBDA_HANDLER=verify で起動したときのハンドラーで、受取人を確認してからダウンロードさせます。
+// 受取人がメールアドレスを入力すると、OTP_DELIVERY（「email」または「slack」）の方法で確認コードを送り、
+// 正しいコードが入力された場合にのみ、OTP_DOWNLOAD_EXPIRY（デフォルト 5m）だけ有効な署名付きURLにリダイレクトします。
+// 受取人ではないメールアドレスが入力された場合も同じ画面を表示し、受取人かどうかを推測できないようにします。
+// 無効にされたリンクは、コードを送らずにその旨を知らせるページを表示します。
+// 確認コードの総当たりを防ぐため、短縮URLのリダイレクトと同じく REDIRECT_RATE_LIMIT_PER_IP で回数を制限します。
+func handleVerification(r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !verificationEnabled() {
+		return events.APIGatewayProxyResponse{StatusCode: 404, Body: "Not Found"}, nil
+	}
+	if !allowRedirect(redirectIPBuckets, r.RequestContext.Identity.SourceIP) {
+		return rateLimitedResponse(pageLanguage(r))
+	}
+	id := path.Base(r.Path)
+	record, err := auditStore.Get(context.TODO(), id)
+	if err != nil {
+		log.Println("監査記録の取得中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	now := time.Now()
+	if record == nil || len(record.Recipients) == 0 || now.Unix() >= record.ExpiresAt {
+		return events.APIGatewayProxyResponse{StatusCode: 404, Body: "Not Found"}, nil
+	}
+	if record.RevokedAt != 0 {
+		return statusPageResponse(http.StatusGone, urlshortener.StatusRevoked, pageLanguage(r))
+	}
+
+	page := &otp.Page{FileName: record.FileName, Step: otp.StepEmail}
+	if r.HTTPMethod == "POST" {
+		body, err := decodeRequestBody(r)
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, nil
+		}
+		values, err := url.ParseQuery(body)
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 400, Body: "Bad Request"}, nil
+		}
+		email := strings.ToLower(strings.TrimSpace(values.Get("email")))
+		key := record.ID + "/" + email
+
+		switch values.Get("action") {
+		case "send":
+			if isRecipient(record, email) {
+				code, err := otpStore.Issue(context.TODO(), key, otpCodeExpiry(), now)
+				switch {
+				case errors.Is(err, otp.ErrTooManyAttempts):
+					// 受取人ではない場合と区別できないよう、コードを送らずに同じ画面を表示する。
+					log.Println("確認コードの入力に続けて失敗したため、発行しませんでした。", record.ID, email)
+				case err != nil:
+					log.Println("確認コードの発行中にエラーが発生しました。", err)
+					return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+				default:
+					if err := deliverCode(record, email, code); err != nil {
+						log.Println("確認コードの送信中にエラーが発生しました。", err)
+						return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+					}
+				}
+			}
+			page.Step, page.Email = otp.StepCode, email
+		case "verify":
+			ok, err := otpStore.Verify(context.TODO(), key, strings.TrimSpace(values.Get("code")), now)
+			if err != nil {
+				log.Println("確認コードの検証中にエラーが発生しました。", err)
+				return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+			}
+			if ok {
+				log.Println("受取人を確認しました。", record.ID, email)
+				return redirectToObject(record, downloadExpiry())
+			}
+			page.Step, page.Email = otp.StepCode, email
+			page.Message = "確認コードが正しくないか、有効期限が切れています。"
+		}
+	}
+
+	html, err := otp.RenderPage(page)
+	if err != nil {
+		log.Println("確認ページの作成中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":  "text/html; charset=utf-8",
+			"Cache-Control": "no-store",
+		},
+		Body: string(html),
+	}, nil
+}
+
+// isRecipient は、email がリンクの受取人かを返します。
+func isRecipient(record *audit.Record, email string) bool {
+	for _, r := range record.Recipients {
+		if strings.EqualFold(r, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// otpCodeExpiry は、確認コードの有効期限です（OTP_CODE_EXPIRY、デフォルト 10m）。
+func otpCodeExpiry() time.Duration {
+	d, err := parseDuration(getEnvOrDefault("OTP_CODE_EXPIRY", "10m"))
+	if err != nil || d <= 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+// redirectToObject は、確認できたユーザーを expiry だけ有効な署名付きURLにリダイレクトし、ダウンロード回数を数えます。
+func redirectToObject(record *audit.Record, expiry time.Duration) (events.APIGatewayProxyResponse, error) {
+	uploaded := &uploadedObject{
+		Bucket:       record.Bucket,
+		Key:          record.ObjectKey,
+		VersionID:    record.VersionID,
+		Region:       record.Region,
+		TeamID:       record.TeamID,
+		EnterpriseID: record.EnterpriseID,
+		Expiry:       expiry,
+	}
+	if err := presignObject(uploaded); err != nil {
+		log.Println("署名付きURLの生成中にエラーが発生しました。", err)
+		return events.APIGatewayProxyResponse{StatusCode: 500, Body: "Internal Server Error"}, err
+	}
+	countDownload(record)
+	return events.APIGatewayProxyResponse{
+		StatusCode: 302,
+		Headers: map[string]string{
+			"Location":      uploaded.PresignedURL,
+			"Cache-Control": "no-store",
+		},
+	}, nil
+}
+
+// downloadExpiry は、確認後にリダイレクトする署名付きURLの有効期限です（OTP_DOWNLOAD_EXPIRY、デフォルト 5m）。
+func downloadExpiry() time.Duration {
+	d, err := parseDuration(getEnvOrDefault("OTP_DOWNLOAD_EXPIRY", "5m"))
+	if err != nil || d <= 0 || d > presignExpiry {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+// deliverCode は、確認コードを受取人に送ります。
+// OTP_DELIVERY=slack の場合は、メールアドレスからSlackのユーザーを探してDMで送ります。
+// それ以外の場合は、Amazon SES のSMTPインターフェイス（OTP_SMTP_ADDR）からメールで送ります。
+func deliverCode(record *audit.Record, email, code string) error {
+	text := fmt.Sprintf("「%s」をダウンロードするための確認コードは %s です。有効期限は%sです。", record.FileName, code, otpCodeExpiry())
+	if os.Getenv("OTP_DELIVERY") == "slack" {
+		ws, err := resolveWorkspace(record.TeamID, record.EnterpriseID)
+		if err != nil {
+			return err
+		}
+		user, err := ws.Bot.GetUserByEmail(email)
+		if err != nil {
+			return err
+		}
+		_, _, err = ws.Bot.PostMessage(user.ID, slack.MsgOptionText(text, false))
+		return err
+	}
+	return notifier.SendMail(smtpConfig(), email, "ダウンロードの確認コード", text)
+}
+
+// smtpConfig は、メールを送るSMTPサーバーの設定です。
+// OTP_SMTP_ADDR は「email-smtp.ap-northeast-1.amazonaws.com:587」のように指定し、
+// SES のSMTP認証情報を OTP_SMTP_USERNAME と OTP_SMTP_PASSWORD に設定してください。送信元は OTP_MAIL_FROM です。
+func smtpConfig() notifier.SMTPConfig {
+	return notifier.SMTPConfig{
+		Addr:     os.Getenv("OTP_SMTP_ADDR"),
+		Username: os.Getenv("OTP_SMTP_USERNAME"),
+		Password: os.Getenv("OTP_SMTP_PASSWORD"),
+		From:     os.Getenv("OTP_MAIL_FROM"),
+	}
+}
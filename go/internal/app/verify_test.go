@@ -0,0 +1,61 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/kumagai-s/uploader-v2/internal/audit"
+	"github.com/kumagai-s/uploader-v2/internal/otp"
+)
+
+// lockedOTPStore は、発行を求められた key を記録し、locked の key には otp.ErrTooManyAttempts を返す otp.Store です。
+type lockedOTPStore struct {
+	otp.Store
+	locked map[string]bool
+	issued []string
+}
+
+func (s *lockedOTPStore) Issue(ctx context.Context, key string, ttl time.Duration, now time.Time) (string, error) {
+	s.issued = append(s.issued, key)
+	if s.locked[key] {
+		return "", otp.ErrTooManyAttempts
+	}
+	return "123456", nil
+}
+
+func TestVerificationRejectsRevokedAndLockedRecipients(t *testing.T) {
+	withVerificationGate(t)
+	store := &lockedOTPStore{locked: map[string]bool{"r2/alice@example.com": true}}
+	otpStore = store
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	auditStore = &recordStore{records: map[string]*audit.Record{
+		"r1": {ID: "r1", Recipients: []string{"alice@example.com"}, ExpiresAt: expiresAt, RevokedAt: time.Now().Unix()},
+		"r2": {ID: "r2", Recipients: []string{"alice@example.com"}, ExpiresAt: expiresAt},
+	}}
+	send := func(id string) events.APIGatewayProxyResponse {
+		t.Helper()
+		res, err := handleVerification(events.APIGatewayProxyRequest{
+			HTTPMethod: http.MethodPost,
+			Path:       "/verify/" + id,
+			Body:       "action=send&email=alice%40example.com",
+		})
+		if err != nil {
+			t.Fatalf("handleVerification(%q) error = %v", id, err)
+		}
+		return res
+	}
+
+	if res := send("r1"); res.StatusCode != http.StatusGone {
+		t.Errorf("revoked link StatusCode = %d, want %d", res.StatusCode, http.StatusGone)
+	}
+	// 入力に続けて失敗した受取人にはコードを送らず、受取人ではない場合と同じ画面を表示する。
+	if res := send("r2"); res.StatusCode != http.StatusOK {
+		t.Errorf("locked recipient StatusCode = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if len(store.issued) != 1 || store.issued[0] != "r2/alice@example.com" {
+		t.Errorf("issued = %v, want only the unrevoked link", store.issued)
+	}
+}
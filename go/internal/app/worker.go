@@ -0,0 +1,141 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	lambdaservice "github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// workerEvent は、検証済みのイベントをワーカーに渡すときのペイロードです。
+type workerEvent struct {
+	Body          string `json:"worker_body"`
+	QueueSchedule string `json:"queue_schedule,omitempty"` // 順番待ちのために EventBridge Scheduler で予約した場合のスケジュールの名前
+}
+
+// asyncWorkerFunction は、イベントを非同期に処理するワーカーの関数名を返します。
+// ASYNC_WORKER_FUNCTION=self の場合は、新しい関数を用意せずに自分自身を呼び出します。
+func asyncWorkerFunction() string {
+	name := os.Getenv("ASYNC_WORKER_FUNCTION")
+	if name == "self" {
+		return os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	}
+	return name
+}
+
+// dispatchToWorker は、検証済みのリクエストボディをワーカーに渡し、応答を待たずに戻ります。
+func dispatchToWorker(body string) error {
+	payload, err := json.Marshal(&workerEvent{Body: body})
+	if err != nil {
+		return err
+	}
+	_, err = lambdaClient.Invoke(context.TODO(), &lambdaservice.InvokeInput{
+		FunctionName:   aws.String(asyncWorkerFunction()),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	})
+	return err
+}
+
+// handleWorkerEvent は、dispatchToWorker から渡されたイベントを処理します。
+// 署名は呼び出し元で検証済みのため、ここでは検証しません。
+func handleWorkerEvent(ctx context.Context, ev workerEvent) error {
+	defer recoverPanic("worker", nil)
+	eventsAPIEvent, err := slackevents.ParseEvent(json.RawMessage(ev.Body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		log.Println("リクエストの解析中にエラーが発生しました。", err)
+		return err
+	}
+	if eventsAPIEvent.Type != slackevents.CallbackEvent {
+		return errors.New("worker received non-callback event")
+	}
+	if ev.QueueSchedule != "" {
+		deleteSchedule(ctx, ev.QueueSchedule)
+	}
+
+	// 大きなファイルは、組織全体で同時に処理する数を制限し、空きがない場合は順番待ちにする。
+	release, queued := acquireLargeFileSlot(ev, eventsAPIEvent)
+	if queued {
+		return nil
+	}
+	defer release()
+
+	res, err := dispatchCallbackEvent(eventsAPIEvent, ev.Body)
+	if err != nil {
+		log.Println("ワーカーでのイベントの処理中にエラーが発生しました。", res.StatusCode, err)
+	}
+	// 非同期の呼び出しはエラーを返すと再実行されるが、ユーザーには既にエラーを通知しているため再実行しない。
+	return nil
+}
+
+// handleInvocation は、API Gateway からのリクエストと、自分自身をワーカーとして呼び出したイベント、
+// EventBridge Scheduler で予約したURLの送信や返信の再送、/geturl-restore の復元を振り分けます。
+func handleInvocation(ctx context.Context, payload json.RawMessage) (res interface{}, err error) {
+	defer recoverPanic("invocation", func() {
+		res, err = events.APIGatewayProxyResponse{StatusCode: 200, Body: "OK"}, nil
+	})
+	if handled, err := handleAsyncPayload(ctx, payload); handled {
+		return nil, err
+	}
+
+	var r events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return nil, err
+	}
+	return lambdaHandler(r)
+}
+
+// handleWorkerInvocation は、ワーカーのみのバイナリ（cmd/worker）のハンドラーです。
+// ASYNC_WORKER_FUNCTION や SCHEDULER_TARGET_ARN にこの関数を指定すると、非同期の処理を受付の関数から切り離せます。
+func handleWorkerInvocation(ctx context.Context, payload json.RawMessage) error {
+	defer recoverPanic("worker", nil)
+	handled, err := handleAsyncPayload(ctx, payload)
+	if !handled {
+		return errors.New("worker received unknown payload")
+	}
+	return err
+}
+
+// handleAsyncPayload は、非同期に呼び出されたイベントを種類ごとのハンドラーに振り分けます。
+// 該当するイベントでない場合は false を返します。
+func handleAsyncPayload(ctx context.Context, payload json.RawMessage) (bool, error) {
+	if bytes.Contains(payload, []byte(`"scheduled_publication"`)) {
+		var ev schedulerEvent
+		if err := json.Unmarshal(payload, &ev); err == nil && ev.Publication != nil {
+			return true, handleScheduledPublication(ctx, ev.Publication)
+		}
+	}
+	if bytes.Contains(payload, []byte(`"restore_request"`)) {
+		var ev restoreEvent
+		if err := json.Unmarshal(payload, &ev); err == nil && ev.Restore != nil {
+			return true, handleRestoreEvent(ctx, ev.Restore)
+		}
+	}
+	if bytes.Contains(payload, []byte(`"doctor_request"`)) {
+		var ev doctorEvent
+		if err := json.Unmarshal(payload, &ev); err == nil && ev.Doctor != nil {
+			return true, handleDoctorEvent(ctx, ev.Doctor)
+		}
+	}
+	if bytes.Contains(payload, []byte(`"pending_reply"`)) {
+		var ev replyRetryEvent
+		if err := json.Unmarshal(payload, &ev); err == nil && ev.Reply != nil {
+			return true, handleReplyRetry(ctx, ev.Reply)
+		}
+	}
+	if bytes.Contains(payload, []byte(`"worker_body"`)) {
+		var ev workerEvent
+		if err := json.Unmarshal(payload, &ev); err == nil && ev.Body != "" {
+			return true, handleWorkerEvent(ctx, ev)
+		}
+	}
+	return false, nil
+}
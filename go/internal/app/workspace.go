@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/kumagai-s/uploader-v2/internal/membudget"
+	"github.com/kumagai-s/uploader-v2/internal/slackdownload"
+	"github.com/kumagai-s/uploader-v2/internal/throttle"
+	"github.com/kumagai-s/uploader-v2/internal/tokenstore"
+	"github.com/slack-go/slack"
+)
+
+// workspace は、イベントが発生したワークスペースと、そのワークスペースで使うSlackクライアントです。
+type workspace struct {
+	TeamID       string
+	EnterpriseID string
+	Bot          *slack.Client
+	User         *slack.Client
+	BotToken     string         // ファイルのダウンロードに使うボットのトークン
+	AdminToken   string         // DELETE_MODE=admin でファイルの削除に使う組織の管理者のトークン
+	Storage      *tenantStorage // チームのIAMロールが登録されている場合のS3。nil の場合は共有のS3のクライアントを使う
+}
+
+// workspaceTokens は、SLACK_WORKSPACE_TOKENS に設定するワークスペースごとのトークンです。
+// SLACK_WORKSPACE_TOKENS は、チームID（T...）またはEnterprise GridのID（E...）をキーとするJSONです。
+type workspaceTokens struct {
+	Bot   string `json:"bot"`
+	User  string `json:"user"`
+	Admin string `json:"admin,omitempty"` // Enterprise Grid の組織の管理者のトークン（DELETE_MODE=admin）
+	// RoleARN と Bucket は、チームのファイルをS3に保存するときに引き受けるIAMロールとバケットです。
+	RoleARN string `json:"role_arn,omitempty"`
+	Bucket  string `json:"bucket,omitempty"`
+}
+
+var (
+	workspaceTokensOnce sync.Once
+	workspaceTokensByID map[string]workspaceTokens
+
+	workspacesMu sync.Mutex
+	workspaces   = map[string]*workspace{}
+)
+
+// errUnknownWorkspace は、TOKEN_REGISTRY_TABLE を設定した環境で、トークンが登録されていないチームのイベントを受け取った場合に返されます。
+var errUnknownWorkspace = errors.New("workspace is not registered")
+
+// lookupWorkspaceTokens は、チーム、Enterprise の順にトークンを探します。
+// Enterprise Grid の組織全体へのインストールでは、Enterprise のトークンをすべてのワークスペースで使用します。
+// レジストリからの取得に失敗した場合は、別のチームのトークンやバケットで処理しないようエラーを返します。
+func lookupWorkspaceTokens(teamID, enterpriseID string) (workspaceTokens, bool, error) {
+	workspaceTokensOnce.Do(func() {
+		workspaceTokensByID = map[string]workspaceTokens{}
+		if v := os.Getenv("SLACK_WORKSPACE_TOKENS"); v != "" {
+			if err := json.Unmarshal([]byte(v), &workspaceTokensByID); err != nil {
+				log.Println("SLACK_WORKSPACE_TOKENS の読み込み中にエラーが発生しました。", err)
+			}
+		}
+	})
+	if tokens, ok := workspaceTokensByID[teamID]; ok && teamID != "" {
+		return tokens, true, nil
+	}
+	if tokens, ok := workspaceTokensByID[enterpriseID]; ok && enterpriseID != "" {
+		return tokens, true, nil
+	}
+
+	// TOKEN_REGISTRY_TABLE が設定されている場合は、暗号化して保存したトークンを探す。
+	if tokenRegistry != nil {
+		for _, id := range []string{teamID, enterpriseID} {
+			if id == "" {
+				continue
+			}
+			tokens, err := tokenRegistry.Get(context.TODO(), id)
+			if err != nil {
+				return workspaceTokens{}, false, fmt.Errorf("unable to get tokens of %s, %w", id, err)
+			}
+			if tokens != nil {
+				return workspaceTokens{Bot: tokens.Bot, User: tokens.User, Admin: tokens.Admin, RoleARN: tokens.RoleARN, Bucket: tokens.Bucket}, true, nil
+			}
+		}
+	}
+	return workspaceTokens{}, false, nil
+}
+
+// tokenCommand は、LAMBDA_HANDLER=tokens で起動したときの入力です。
+// action が "put" の場合は id のトークン（bot、user と、Enterprise Grid の管理者の admin）と、S3に保存するときに引き受けるIAMロール（role_arn）とバケット（bucket）を登録し、"rotate" の場合は
+// TOKEN_DATA_KEY_MAX_AGE（デフォルト 30d）より前に生成したデータキーを新しいデータキーに入れ替えます。
+// "rotate" は EventBridge のスケジュールから定期的に呼び出してください。
+type tokenCommand struct {
+	Action  string `json:"action"`
+	ID      string `json:"id"`
+	Bot     string `json:"bot"`
+	User    string `json:"user"`
+	Admin   string `json:"admin,omitempty"`
+	RoleARN string `json:"role_arn,omitempty"`
+	Bucket  string `json:"bucket,omitempty"`
+}
+
+// handleTokenCommand は、暗号化して保存するトークンを登録、またはデータキーをローテーションします。
+func handleTokenCommand(ctx context.Context, cmd tokenCommand) error {
+	if tokenRegistry == nil {
+		return errors.New("TOKEN_REGISTRY_TABLE is not configured")
+	}
+	switch cmd.Action {
+	case "put":
+		if cmd.ID == "" || cmd.Bot == "" {
+			return errors.New("id and bot token are required")
+		}
+		return tokenRegistry.Put(ctx, cmd.ID, tokenstore.Tokens{Bot: cmd.Bot, User: cmd.User, Admin: cmd.Admin, RoleARN: cmd.RoleARN, Bucket: cmd.Bucket})
+	case "rotate":
+		maxAge, err := parseDuration(getEnvOrDefault("TOKEN_DATA_KEY_MAX_AGE", "30d"))
+		if err != nil {
+			return err
+		}
+		rotated, err := tokenRegistry.Rotate(ctx, time.Now().Add(-maxAge))
+		log.Println("データキーをローテーションしました。", rotated)
+		return err
+	default:
+		return fmt.Errorf("unknown token command %q", cmd.Action)
+	}
+}
+
+// resolveWorkspace は、イベントの team_id と enterprise_id から使用するSlackクライアントを決定します。
+// 個別のトークンが設定されていない場合は、SLACK_BOT_OAUTH_TOKEN と SLACK_USER_OAUTH_TOKEN を使用します。
+// TOKEN_REGISTRY_TABLE を設定した環境では、登録されていないチームは errUnknownWorkspace で拒否します。
+// レジストリからの取得に失敗した場合や登録されていない場合は、次の呼び出しで改めて探せるよう結果を保持しません。
+func resolveWorkspace(teamID, enterpriseID string) (*workspace, error) {
+	workspacesMu.Lock()
+	defer workspacesMu.Unlock()
+
+	key := enterpriseID + "/" + teamID
+	if ws, ok := workspaces[key]; ok {
+		return ws, nil
+	}
+
+	tokens, ok, err := lookupWorkspaceTokens(teamID, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok && tokenRegistry != nil {
+		return nil, fmt.Errorf("%w, team %q, enterprise %q", errUnknownWorkspace, teamID, enterpriseID)
+	}
+
+	ws := &workspace{
+		TeamID:       teamID,
+		EnterpriseID: enterpriseID,
+		Bot:          slackClientAsBot,
+		User:         slackClientAsUser,
+		BotToken:     os.Getenv("SLACK_BOT_OAUTH_TOKEN"),
+		AdminToken:   os.Getenv("SLACK_ADMIN_OAUTH_TOKEN"),
+	}
+	if ok {
+		ws.BotToken = tokens.Bot
+		ws.Bot = newSlackClient(tokens.Bot)
+		ws.User = newSlackClient(tokens.User)
+		if tokens.Admin != "" {
+			ws.AdminToken = tokens.Admin
+		}
+		// チームのIAMロールが登録されている場合は、S3の操作をそのロールに限定する。
+		if tokens.RoleARN != "" {
+			ws.Storage = newTenantStorage(teamID, tokens.RoleARN, tokens.Bucket)
+		}
+	}
+	workspaces[key] = ws
+	return ws, nil
+}
+
+// newDownloadLimiter は、Slackからファイルを受信する速さの上限を返します。上限を設定しない場合は nil を返します。
+// 大きなファイルが続けて送られても、Lambda の通信を中継する NAT ゲートウェイの帯域を使い切らないようにします。
+//   - SLACK_DOWNLOAD_RATE_LIMIT: 1つの実行環境での1秒あたりのバイト数。実行環境は同時に1つの呼び出しのみを処理するため、呼び出しごとの上限になる
+//   - SLACK_DOWNLOAD_GLOBAL_RATE_LIMIT: すべての実行環境を合わせた1秒あたりのバイト数。THROTTLE_TABLE のトークンバケットで共有する
+func newDownloadLimiter(client *dynamodb.Client) throttle.Limiter {
+	var limiters []throttle.Limiter
+	if rate := getEnvInt64("SLACK_DOWNLOAD_RATE_LIMIT", 0); rate > 0 {
+		limiters = append(limiters, throttle.NewLocal(rate))
+	}
+	if rate := getEnvInt64("SLACK_DOWNLOAD_GLOBAL_RATE_LIMIT", 0); rate > 0 {
+		if table := os.Getenv("THROTTLE_TABLE"); table != "" {
+			limiters = append(limiters, throttle.NewDynamoLimiter(client, table, "slack-download", rate))
+		} else {
+			log.Println("THROTTLE_TABLE が設定されていないため、SLACK_DOWNLOAD_GLOBAL_RATE_LIMIT を無視します。")
+		}
+	}
+	if len(limiters) == 0 {
+		return nil
+	}
+	return throttle.Multi(limiters...)
+}
+
+// downloadSlackFile は、Slackのファイルを取得してバッファに書き込みます。
+// SLACK_DOWNLOAD_RATE_LIMIT などを設定した場合は、受信する速さを newDownloadLimiter の上限に抑えます。
+// バッファはメモリの予算に応じてメモリか一時ファイルに確保されるため、使い終わったら Close してください。
+// 大きなファイルの転送が途中で切れた場合は、取得済みのバイトの続きから再開します（SLACK_DOWNLOAD_MAX_RETRIES 回まで）。
+// PARALLEL_DOWNLOAD_THRESHOLD 以上のファイルは、PARALLEL_DOWNLOAD_PART_SIZE（デフォルトはメモリの予算から決定）ごとの範囲を
+// PARALLEL_DOWNLOAD_CONCURRENCY 件ずつ並列に取得します。
+func downloadSlackFile(ctx context.Context, ws *workspace, file *SlackAppMentionEventFile) (membudget.Buffer, error) {
+	maxRetries, err := strconv.Atoi(getEnvOrDefault("SLACK_DOWNLOAD_MAX_RETRIES", "5"))
+	if err != nil {
+		maxRetries = 5
+	}
+	downloader := slackdownload.NewDownloader(httpClient, maxRetries, time.Second)
+	downloader.Limiter = downloadLimiter
+
+	buf, err := memoryBudget.NewBuffer(file.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := getEnvInt64("PARALLEL_DOWNLOAD_THRESHOLD", 64<<20)
+	if threshold > 0 && file.Size >= threshold {
+		concurrency := int(getEnvInt64("PARALLEL_DOWNLOAD_CONCURRENCY", 4))
+		partSize := getEnvInt64("PARALLEL_DOWNLOAD_PART_SIZE", memoryBudget.PartSize(concurrency))
+		err = downloader.DownloadParallel(ctx, file.URLPrivateDownload, ws.BotToken, file.Size, partSize, concurrency, buf)
+	} else {
+		_, err = downloader.Download(ctx, file.URLPrivateDownload, ws.BotToken, buf)
+	}
+	if err != nil {
+		buf.Close()
+		return nil, err
+	}
+	return buf, nil
+}
+
+// deleteSlackFile は、Slackからファイルを削除します。
+// DELETE_MODE=admin の場合は、アップロードしたユーザーになりすますのではなく、
+// Enterprise Grid の組織の管理者のトークン（SLACK_ADMIN_OAUTH_TOKEN）で admin.files.delete を呼び出して削除します。
+// slack-go は admin.files.delete に対応していないため、直接呼び出します。
+func deleteSlackFile(ctx context.Context, ws *workspace, fileID string) error {
+	if os.Getenv("DELETE_MODE") != "admin" {
+		return ws.User.DeleteFileContext(ctx, fileID)
+	}
+	if ws.AdminToken == "" {
+		return errors.New("DELETE_MODE=admin requires SLACK_ADMIN_OAUTH_TOKEN")
+	}
+	var response slack.SlackResponse
+	return callSlackAPI(ctx, ws.AdminToken, "admin.files.delete", map[string]interface{}{"file_id": fileID}, &response)
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kumagai-s/uploader-v2/internal/archive"
+)
+
+// zipEntryAllowed は、file= を他のオプションやファイルの数と組み合わせられるかを確認します。
+func zipEntryAllowed(files []SlackAppMentionEventFile, opts *mentionOptions) error {
+	if opts.File == "" {
+		return nil
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("file は1つのzipファイルをアップロードする場合にのみ指定できます。")
+	}
+	if opts.Bundle != "" {
+		return fmt.Errorf("file と bundle は同時に指定できません。")
+	}
+	if os.Getenv("EXECUTION_MODE") == "stepfunctions" && !processInline(files) {
+		return fmt.Errorf("file はこのサイズのファイルには指定できません。")
+	}
+	return nil
+}
+
+// extractZipEntry は、file= が指定された場合に、検証済みのzipファイルから指定したエントリを取り出して、ファイルの内容と置き換えます。
+// アップロードするファイル名は、name= が指定された場合はその名前、それ以外の場合はエントリのファイル名です。
+// zipファイルの名前は OriginalName に残します。
+func extractZipEntry(file *SlackAppMentionEventFile, opts *mentionOptions) error {
+	if opts.File == "" {
+		return nil
+	}
+	if archiveFormatOf(file.Name) != archive.FormatZip {
+		return validationError("file は zip ファイルにのみ指定できます。")
+	}
+	entry, err := archive.Find(file.Binary, opts.File)
+	if err != nil {
+		return validationError("zipファイルを開けないため、file のファイルを取り出せません。")
+	}
+	if entry == nil {
+		return validationError(fmt.Sprintf("zipファイルに「%s」が見つかりません。", escapeMrkdwn(opts.File)))
+	}
+	if !memoryBudget.Fits(int64(entry.UncompressedSize)) {
+		return validationError("file に指定したファイルが大きすぎるため処理できません。")
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = path.Base(entry.Name)
+	}
+	if err := validateEntryName(name); err != nil {
+		return classify(ErrValidation, err)
+	}
+	content, err := entry.ReadAll()
+	if err != nil {
+		return err
+	}
+	if file.OriginalName == "" {
+		file.OriginalName = file.Name
+	}
+	file.Name = name
+	file.Binary = content
+	file.Size = int64(len(content))
+	return nil
+}
+
+// validateEntryName は、zipファイルから取り出したファイルの名前を、zipファイルと同じ規則（拡張子を除いて半角英数字）で検証します。
+func validateEntryName(name string) error {
+	ext := path.Ext(name)
+	if ext == "" || !isValidFileName(strings.TrimSuffix(name, ext)) || !isValidFileName(strings.TrimPrefix(ext, ".")) {
+		return fmt.Errorf("取り出すファイルの名前は「半角英数字」と拡張子にしてください。name で名前を指定することもできます。")
+	}
+	if len(name) > maxFileNameLength {
+		return fmt.Errorf("ファイル名は%d文字以内にしてください。", maxFileNameLength)
+	}
+	return nil
+}
+
+// contentTypeOf は、S3に保存するファイルの Content-Type です。アーカイブ以外は拡張子から判断します。
+func contentTypeOf(name string) string {
+	switch archiveFormatOf(name) {
+	case archive.FormatZip:
+		return "application/zip"
+	case archive.FormatTarGz:
+		return "application/gzip"
+	case archive.Format7z:
+		return "application/x-7z-compressed"
+	case archive.FormatRar:
+		return "application/vnd.rar"
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,128 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// ErrAlreadyDecided は、既に承認または却下された申請を再度判断しようとした場合に返されます。
+var ErrAlreadyDecided = errors.New("approval request is already decided")
+
+// Request は、URLの発行を承認者に求める申請です。
+type Request struct {
+	ID           string   `dynamodbav:"id"`
+	Status       string   `dynamodbav:"status"`
+	TeamID       string   `dynamodbav:"team_id"`
+	EnterpriseID string   `dynamodbav:"enterprise_id,omitempty"`
+	Channel      string   `dynamodbav:"channel"`   // 依頼者がメンションしたチャンネル
+	ThreadTS     string   `dynamodbav:"thread_ts"` // 依頼者のメンションのタイムスタンプ
+	Requester    string   `dynamodbav:"requester"`
+	Approver     string   `dynamodbav:"approver,omitempty"`
+	FileName     string   `dynamodbav:"file_name"`
+	Bucket       string   `dynamodbav:"bucket,omitempty"`
+	ObjectKey    string   `dynamodbav:"object_key"`
+	VersionID    string   `dynamodbav:"version_id,omitempty"`
+	Region       string   `dynamodbav:"region,omitempty"`
+	Size         int64    `dynamodbav:"size"`
+	Expiry       int64    `dynamodbav:"expiry,omitempty"`   // 依頼者が指定した署名付きURLの有効期限（秒）
+	Warnings     string   `dynamodbav:"warnings,omitempty"` // 依頼者への返信に含める警告
+	Notify       []string `dynamodbav:"notify,omitempty"`   // 承認後にリンクを共有する相手（notify=）
+	Note         string   `dynamodbav:"note,omitempty"`     // 依頼者がリンクに添えた説明（note=）
+	// AllowedGroups は、承認後に発行するポータルのリンクでダウンロードを許可するIdPのグループです（groups=）。
+	AllowedGroups []string `dynamodbav:"allowed_groups,omitempty,stringset"`
+	CreatedAt     int64    `dynamodbav:"created_at"`
+	TTL           int64    `dynamodbav:"ttl"` // 承認されなかった申請を自動で削除する日時（UNIX時間）
+}
+
+// Store は、承認待ちの申請を保存します。
+type Store interface {
+	Put(ctx context.Context, request *Request) error
+	Get(ctx context.Context, id string) (*Request, error)
+	// Decide は、承認待ちの申請を承認または却下し、更新後の申請を返します。
+	// 既に判断済みの場合は ErrAlreadyDecided を返します。
+	Decide(ctx context.Context, id, status, approver string) (*Request, error)
+}
+
+type dynamoStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func (s *dynamoStore) Put(ctx context.Context, request *Request) error {
+	item, err := attributevalue.MarshalMap(request)
+	if err != nil {
+		return fmt.Errorf("unable to marshal approval request, %s", err)
+	}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("unable to put approval request, %s", err)
+	}
+	return nil
+}
+
+func (s *dynamoStore) Get(ctx context.Context, id string) (*Request, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get approval request, %s", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var request Request
+	if err := attributevalue.UnmarshalMap(out.Item, &request); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal approval request, %s", err)
+	}
+	return &request, nil
+}
+
+func (s *dynamoStore) Decide(ctx context.Context, id, status, approver string) (*Request, error) {
+	out, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(s.table),
+		Key:                 map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		UpdateExpression:    aws.String("SET #status = :status, approver = :approver"),
+		ConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":   &types.AttributeValueMemberS{Value: status},
+			":approver": &types.AttributeValueMemberS{Value: approver},
+			":pending":  &types.AttributeValueMemberS{Value: StatusPending},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, ErrAlreadyDecided
+		}
+		return nil, fmt.Errorf("unable to update approval request, %s", err)
+	}
+	var request Request
+	if err := attributevalue.UnmarshalMap(out.Attributes, &request); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal approval request, %s", err)
+	}
+	return &request, nil
+}
+
+func NewStore(client *dynamodb.Client, table string) Store {
+	return &dynamoStore{client: client, table: table}
+}
@@ -0,0 +1,157 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrContentUnavailable は、エントリの内容を展開できない形式（7z、rar）や、暗号化された zip のエントリで
+// ReadAll を呼び出したときのエラーです。
+var ErrContentUnavailable = errors.New("archive entry content is not available for this format")
+
+// Entry は、アーカイブ内の1つのファイルを表します。
+type Entry struct {
+	Name             string
+	UncompressedSize uint64
+	open             func() (io.ReadCloser, error)
+}
+
+func unavailableContent() (io.ReadCloser, error) {
+	return nil, ErrContentUnavailable
+}
+
+// zipContent は、zip のエントリを展開する関数を返します。
+// 暗号化されたエントリはパスワードがないと展開できず、そのまま読むと意味のないバイト列になるため ErrContentUnavailable を返します。
+func zipContent(f *zip.File) func() (io.ReadCloser, error) {
+	if f.Flags&0x1 != 0 {
+		return unavailableContent
+	}
+	return f.Open
+}
+
+// ReadAll は、エントリを展開した内容をすべて読み込んで返します。
+// 内容を展開できない形式の場合は ErrContentUnavailable を返します。
+func (e *Entry) ReadAll() ([]byte, error) {
+	rc, err := e.open()
+	if errors.Is(err, ErrContentUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open entry %s, %s", e.Name, err)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read entry %s, %s", e.Name, err)
+	}
+	return b, nil
+}
+
+// List は、zipのバイナリデータからディレクトリを除いたエントリの一覧を返します。
+func List(data []byte) ([]*Entry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open zip archive, %s", err)
+	}
+
+	var entries []*Entry
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		entries = append(entries, &Entry{
+			Name:             f.Name,
+			UncompressedSize: f.UncompressedSize64,
+			open:             zipContent(f),
+		})
+	}
+	return entries, nil
+}
+
+// IsText は、内容がUTF-8のテキストとして扱えるかどうかを判定します。
+// 先頭の一部のみを調べ、NULバイトを含む場合はバイナリとみなします。
+func IsText(b []byte) bool {
+	head := b
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	// 途中で切ったマルチバイト文字を誤検知しないよう、末尾の数バイトは許容する。
+	for i := 0; i < utf8.UTFMax && len(head) > 0; i++ {
+		if utf8.Valid(head) {
+			return true
+		}
+		head = head[:len(head)-1]
+	}
+	return false
+}
+
+// Rewrite は、zipの各エントリを transform で変換した新しいzipを返します。
+// transform が nil を返したエントリは元の内容のまま書き込みます。
+func Rewrite(data []byte, transform func(name string, content []byte) ([]byte, error)) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open zip archive, %s", err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		header := f.FileHeader
+		if f.FileInfo().IsDir() {
+			if _, err := zw.CreateHeader(&header); err != nil {
+				return nil, fmt.Errorf("unable to write entry %s, %s", f.Name, err)
+			}
+			continue
+		}
+
+		entry := &Entry{Name: f.Name, UncompressedSize: f.UncompressedSize64, open: f.Open}
+		content, err := entry.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		transformed, err := transform(f.Name, content)
+		if err != nil {
+			return nil, err
+		}
+		if transformed != nil {
+			content = transformed
+		}
+
+		w, err := zw.CreateHeader(&header)
+		if err != nil {
+			return nil, fmt.Errorf("unable to write entry %s, %s", f.Name, err)
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, fmt.Errorf("unable to write entry %s, %s", f.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to close zip archive, %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Find は、zipのバイナリデータから name のエントリを返します。見つからない場合は nil を返します。
+// name の先頭の「/」や「./」は無視します。
+func Find(data []byte, name string) (*Entry, error) {
+	entries, err := List(data)
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "./"), "/")
+	for _, e := range entries {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,153 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format は、アーカイブの形式です。
+type Format string
+
+// 対応するアーカイブの形式です。
+const (
+	FormatZip   Format = "zip"
+	FormatTarGz Format = "tar.gz"
+	Format7z    Format = "7z"
+	FormatRar   Format = "rar"
+)
+
+// extensions は、形式ごとのファイル名の拡張子です。長い拡張子から順に判定します。
+var extensions = []struct {
+	ext    string
+	format Format
+}{
+	{".tar.gz", FormatTarGz},
+	{".tgz", FormatTarGz},
+	{".zip", FormatZip},
+	{".7z", Format7z},
+	{".rar", FormatRar},
+}
+
+// ParseFormat は、「zip」「tar.gz」「tgz」「7z」「rar」のいずれかの名前から形式を返します。
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "zip":
+		return FormatZip, nil
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	case "7z":
+		return Format7z, nil
+	case "rar":
+		return FormatRar, nil
+	}
+	return "", fmt.Errorf("unsupported archive format %q", name)
+}
+
+// SplitExt は、ファイル名を拡張子を除いた部分と形式に分けます。対応する拡張子でない場合は ok が false です。
+func SplitExt(name string) (base string, format Format, ok bool) {
+	for _, e := range extensions {
+		if strings.HasSuffix(name, e.ext) {
+			return strings.TrimSuffix(name, e.ext), e.format, true
+		}
+	}
+	return name, "", false
+}
+
+// Limits は、圧縮爆弾を検出するための、展開後のサイズとエントリの数の上限です。0 の項目は制限しません。
+type Limits struct {
+	MaxEntries   int
+	MaxTotalSize int64   // 展開後の合計サイズ（バイト）
+	MaxRatio     float64 // 展開後の合計サイズとアーカイブのサイズの比
+}
+
+// BombError は、アーカイブが Limits を超えたときのエラーです。
+type BombError struct {
+	Reason string
+}
+
+func (e *BombError) Error() string {
+	return "archive looks like a decompression bomb, " + e.Reason
+}
+
+// Open は、形式に応じてアーカイブからディレクトリを除いたエントリの一覧を返します。
+// tar.gz は内容を展開して読み込むため、展開後のサイズの上限 maxSize（0 の場合は無制限）を指定します。
+// 7z と rar はヘッダーのみを読み取るため、エントリの ReadAll は ErrContentUnavailable を返します。
+func Open(format Format, data []byte, maxSize int64) ([]*Entry, error) {
+	switch format {
+	case FormatZip:
+		return List(data)
+	case FormatTarGz:
+		return listTarGz(data, maxSize)
+	case Format7z:
+		return listSevenZip(data)
+	case FormatRar:
+		return listRar(data)
+	}
+	return nil, fmt.Errorf("unsupported archive format %q", format)
+}
+
+// Inspect は、アーカイブを開けるかを検証し、エントリの数や展開後のサイズが limits を超える場合は BombError を返します。
+// zip、7z、rar はヘッダーに記録された展開後のサイズで、tar.gz は実際に展開したサイズで判定します。
+func Inspect(format Format, data []byte, limits Limits) error {
+	var (
+		count int
+		total uint64
+	)
+	if format == FormatTarGz {
+		err := walkTarGz(data, limits.MaxTotalSize, func(h *tar.Header, r io.Reader) error {
+			count++
+			if limits.MaxEntries > 0 && count > limits.MaxEntries {
+				return &BombError{Reason: fmt.Sprintf("more than %d entries", limits.MaxEntries)}
+			}
+			n, err := io.Copy(io.Discard, r)
+			total += uint64(n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := checkSignature(format, data); err != nil {
+			return err
+		}
+		entries, err := Open(format, data, 0)
+		if err != nil {
+			return err
+		}
+		count = len(entries)
+		for _, e := range entries {
+			total += e.UncompressedSize
+		}
+	}
+
+	switch {
+	case limits.MaxEntries > 0 && count > limits.MaxEntries:
+		return &BombError{Reason: fmt.Sprintf("more than %d entries", limits.MaxEntries)}
+	case limits.MaxTotalSize > 0 && total > uint64(limits.MaxTotalSize):
+		return &BombError{Reason: fmt.Sprintf("total uncompressed size exceeds %d bytes", limits.MaxTotalSize)}
+	case limits.MaxRatio > 0 && len(data) > 0 && float64(total)/float64(len(data)) > limits.MaxRatio:
+		return &BombError{Reason: fmt.Sprintf("compression ratio exceeds %.0f", limits.MaxRatio)}
+	}
+	return nil
+}
+
+// checkSignature は、拡張子の形式と内容の先頭のシグネチャが一致するかを確認します。
+// zip は先頭に別のデータを含むことがあるため、zip.NewReader での検証に任せます。
+func checkSignature(format Format, data []byte) error {
+	var ok bool
+	switch format {
+	case Format7z:
+		ok = bytes.HasPrefix(data, sevenZipSignature)
+	case FormatRar:
+		ok = bytes.HasPrefix(data, rar4Signature) || bytes.HasPrefix(data, rar5Signature)
+	default:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("content does not match the %s format", format)
+	}
+	return nil
+}
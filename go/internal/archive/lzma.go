@@ -0,0 +1,420 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 7z のヘッダーは LZMA で圧縮されていることが多いため、エントリの一覧を得るための最小限の LZMA デコーダーを持つ。
+
+const (
+	lzmaNumStates          = 12
+	lzmaNumPosBitsMax      = 4
+	lzmaNumLenToPosStates  = 4
+	lzmaNumAlignBits       = 4
+	lzmaStartPosModelIndex = 4
+	lzmaEndPosModelIndex   = 14
+	lzmaNumFullDistances   = 1 << (lzmaEndPosModelIndex >> 1)
+	lzmaMatchMinLen        = 2
+	lzmaProbInit           = 1 << 10
+)
+
+var errLZMACorrupt = errors.New("corrupt lzma stream")
+
+type lzmaRangeDecoder struct {
+	data []byte
+	pos  int
+	rng  uint32
+	code uint32
+}
+
+func newLZMARangeDecoder(data []byte) (*lzmaRangeDecoder, error) {
+	if len(data) < 5 || data[0] != 0 {
+		return nil, errLZMACorrupt
+	}
+	rc := &lzmaRangeDecoder{data: data, pos: 5, rng: 0xFFFFFFFF}
+	for _, b := range data[1:5] {
+		rc.code = rc.code<<8 | uint32(b)
+	}
+	return rc, nil
+}
+
+func (rc *lzmaRangeDecoder) next() byte {
+	if rc.pos >= len(rc.data) {
+		// 入力の終わりを超えて読んだ場合は 0 を補い、出力の長さで終了を判定する。
+		rc.pos++
+		return 0
+	}
+	b := rc.data[rc.pos]
+	rc.pos++
+	return b
+}
+
+func (rc *lzmaRangeDecoder) normalize() {
+	if rc.rng < 1<<24 {
+		rc.rng <<= 8
+		rc.code = rc.code<<8 | uint32(rc.next())
+	}
+}
+
+func (rc *lzmaRangeDecoder) bit(p *uint16) uint32 {
+	bound := (rc.rng >> 11) * uint32(*p)
+	var b uint32
+	if rc.code < bound {
+		rc.rng = bound
+		*p += (1<<11 - *p) >> 5
+	} else {
+		rc.rng -= bound
+		rc.code -= bound
+		*p -= *p >> 5
+		b = 1
+	}
+	rc.normalize()
+	return b
+}
+
+func (rc *lzmaRangeDecoder) direct(n int) uint32 {
+	var res uint32
+	for ; n > 0; n-- {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		res = res<<1 + t + 1
+		rc.normalize()
+	}
+	return res
+}
+
+func (rc *lzmaRangeDecoder) bitTree(probs []uint16, numBits int) uint32 {
+	m := uint32(1)
+	for i := 0; i < numBits; i++ {
+		m = m<<1 + rc.bit(&probs[m])
+	}
+	return m - 1<<numBits
+}
+
+func (rc *lzmaRangeDecoder) reverseBitTree(probs []uint16, numBits int) uint32 {
+	m, sym := uint32(1), uint32(0)
+	for i := 0; i < numBits; i++ {
+		b := rc.bit(&probs[m])
+		m = m<<1 + b
+		sym |= b << i
+	}
+	return sym
+}
+
+type lzmaLenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << lzmaNumPosBitsMax][1 << 3]uint16
+	mid     [1 << lzmaNumPosBitsMax][1 << 3]uint16
+	high    [1 << 8]uint16
+}
+
+func newLZMALenDecoder() *lzmaLenDecoder {
+	d := &lzmaLenDecoder{choice: lzmaProbInit, choice2: lzmaProbInit}
+	for i := range d.low {
+		for j := range d.low[i] {
+			d.low[i][j] = lzmaProbInit
+			d.mid[i][j] = lzmaProbInit
+		}
+	}
+	for i := range d.high {
+		d.high[i] = lzmaProbInit
+	}
+	return d
+}
+
+func (d *lzmaLenDecoder) decode(rc *lzmaRangeDecoder, posState uint32) uint32 {
+	if rc.bit(&d.choice) == 0 {
+		return rc.bitTree(d.low[posState][:], 3)
+	}
+	if rc.bit(&d.choice2) == 0 {
+		return 8 + rc.bitTree(d.mid[posState][:], 3)
+	}
+	return 16 + rc.bitTree(d.high[:], 8)
+}
+
+func newLZMAProbs(n int) []uint16 {
+	p := make([]uint16, n)
+	for i := range p {
+		p[i] = lzmaProbInit
+	}
+	return p
+}
+
+// lzmaDecoder は、LZMA の確率モデルと、展開済みのデータを辞書として保持します。
+type lzmaDecoder struct {
+	lc, lp, pb uint32
+
+	isMatch    []uint16
+	isRep      []uint16
+	isRepG0    []uint16
+	isRepG1    []uint16
+	isRepG2    []uint16
+	isRep0Long []uint16
+	literals   []uint16
+	posSlot    []uint16
+	posSpecial []uint16
+	align      []uint16
+	lenDec     *lzmaLenDecoder
+	repLenDec  *lzmaLenDecoder
+
+	state, rep0, rep1, rep2, rep3 uint32
+
+	out       []byte
+	dictStart int // 辞書をリセットした位置。これより前のデータは参照できない
+}
+
+func (d *lzmaDecoder) setProperties(b byte) error {
+	if b >= 9*5*5 {
+		return fmt.Errorf("invalid lzma properties")
+	}
+	v := uint32(b)
+	d.lc, d.lp, d.pb = v%9, (v/9)%5, v/45
+	return nil
+}
+
+// resetState は、確率モデルと状態を初期化します。辞書は保持します。
+func (d *lzmaDecoder) resetState() {
+	d.isMatch = newLZMAProbs(lzmaNumStates << lzmaNumPosBitsMax)
+	d.isRep = newLZMAProbs(lzmaNumStates)
+	d.isRepG0 = newLZMAProbs(lzmaNumStates)
+	d.isRepG1 = newLZMAProbs(lzmaNumStates)
+	d.isRepG2 = newLZMAProbs(lzmaNumStates)
+	d.isRep0Long = newLZMAProbs(lzmaNumStates << lzmaNumPosBitsMax)
+	d.literals = newLZMAProbs(0x300 << (d.lc + d.lp))
+	d.posSlot = newLZMAProbs(lzmaNumLenToPosStates << 6)
+	d.posSpecial = newLZMAProbs(1 + lzmaNumFullDistances - lzmaEndPosModelIndex)
+	d.align = newLZMAProbs(1 << lzmaNumAlignBits)
+	d.lenDec = newLZMALenDecoder()
+	d.repLenDec = newLZMALenDecoder()
+	d.state, d.rep0, d.rep1, d.rep2, d.rep3 = 0, 0, 0, 0, 0
+}
+
+// decode は、出力が end バイトになるまで展開します。end が負の場合は終端マーカーまで展開します。
+func (d *lzmaDecoder) decode(rc *lzmaRangeDecoder, end int64) error {
+	pbMask, lpMask := uint32(1)<<d.pb-1, uint32(1)<<d.lp-1
+
+	for end < 0 || int64(len(d.out)) < end {
+		if rc.pos > len(rc.data)+4 {
+			return errLZMACorrupt
+		}
+		pos := uint32(len(d.out) - d.dictStart)
+		posState := pos & pbMask
+		state := d.state
+
+		if rc.bit(&d.isMatch[state<<lzmaNumPosBitsMax+posState]) == 0 {
+			var prev byte
+			if pos > 0 {
+				prev = d.out[len(d.out)-1]
+			}
+			base := 0x300 * ((pos&lpMask)<<d.lc + uint32(prev)>>(8-d.lc))
+			probs := d.literals[base : base+0x300]
+			symbol := uint32(1)
+			if state >= 7 {
+				matchByte := uint32(d.out[len(d.out)-int(d.rep0)-1])
+				for symbol < 0x100 {
+					matchBit := (matchByte >> 7) & 1
+					matchByte <<= 1
+					b := rc.bit(&probs[(1+matchBit)<<8+symbol])
+					symbol = symbol<<1 | b
+					if matchBit != b {
+						break
+					}
+				}
+			}
+			for symbol < 0x100 {
+				symbol = symbol<<1 | rc.bit(&probs[symbol])
+			}
+			d.out = append(d.out, byte(symbol))
+			switch {
+			case state < 4:
+				d.state = 0
+			case state < 10:
+				d.state = state - 3
+			default:
+				d.state = state - 6
+			}
+			continue
+		}
+
+		var length uint32
+		if rc.bit(&d.isRep[state]) != 0 {
+			if pos == 0 {
+				return errLZMACorrupt
+			}
+			if rc.bit(&d.isRepG0[state]) == 0 {
+				if rc.bit(&d.isRep0Long[state<<lzmaNumPosBitsMax+posState]) == 0 {
+					if state < 7 {
+						d.state = 9
+					} else {
+						d.state = 11
+					}
+					d.out = append(d.out, d.out[len(d.out)-int(d.rep0)-1])
+					continue
+				}
+			} else {
+				var dist uint32
+				if rc.bit(&d.isRepG1[state]) == 0 {
+					dist = d.rep1
+				} else {
+					if rc.bit(&d.isRepG2[state]) == 0 {
+						dist = d.rep2
+					} else {
+						dist = d.rep3
+						d.rep3 = d.rep2
+					}
+					d.rep2 = d.rep1
+				}
+				d.rep1 = d.rep0
+				d.rep0 = dist
+			}
+			length = d.repLenDec.decode(rc, posState)
+			if state < 7 {
+				d.state = 8
+			} else {
+				d.state = 11
+			}
+		} else {
+			d.rep3, d.rep2, d.rep1 = d.rep2, d.rep1, d.rep0
+			length = d.lenDec.decode(rc, posState)
+			if state < 7 {
+				d.state = 7
+			} else {
+				d.state = 10
+			}
+
+			lenState := length
+			if lenState > lzmaNumLenToPosStates-1 {
+				lenState = lzmaNumLenToPosStates - 1
+			}
+			slot := rc.bitTree(d.posSlot[lenState<<6:(lenState+1)<<6], 6)
+			if slot < lzmaStartPosModelIndex {
+				d.rep0 = slot
+			} else {
+				numDirectBits := int(slot>>1) - 1
+				dist := (2 | slot&1) << numDirectBits
+				if slot < lzmaEndPosModelIndex {
+					dist += rc.reverseBitTree(d.posSpecial[dist-slot:], numDirectBits)
+				} else {
+					dist += rc.direct(numDirectBits-lzmaNumAlignBits) << lzmaNumAlignBits
+					dist += rc.reverseBitTree(d.align, lzmaNumAlignBits)
+				}
+				d.rep0 = dist
+			}
+			if d.rep0 == 0xFFFFFFFF {
+				// 終端マーカー
+				return nil
+			}
+		}
+
+		length += lzmaMatchMinLen
+		if d.rep0 >= pos {
+			return errLZMACorrupt
+		}
+		for ; length > 0; length-- {
+			if end >= 0 && int64(len(d.out)) >= end {
+				break
+			}
+			d.out = append(d.out, d.out[len(d.out)-int(d.rep0)-1])
+		}
+	}
+	return nil
+}
+
+// decodeLZMA は、5バイトのプロパティ（lc/lp/pb と辞書サイズ）を持つ LZMA のデータを unpackSize バイトに展開します。
+// unpackSize が負の場合は、終端マーカーまで展開します。
+func decodeLZMA(props, data []byte, unpackSize int64) ([]byte, error) {
+	if len(props) < 5 {
+		return nil, fmt.Errorf("invalid lzma properties")
+	}
+	d := &lzmaDecoder{}
+	if err := d.setProperties(props[0]); err != nil {
+		return nil, err
+	}
+	d.resetState()
+	if unpackSize >= 0 {
+		d.out = make([]byte, 0, unpackSize)
+	}
+	rc, err := newLZMARangeDecoder(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.decode(rc, unpackSize); err != nil {
+		return nil, err
+	}
+	return d.out, nil
+}
+
+// decodeLZMA2 は、LZMA2 のデータを展開します。unpackSize は展開後のサイズの上限です。
+func decodeLZMA2(data []byte, unpackSize int64) ([]byte, error) {
+	d := &lzmaDecoder{}
+	pos := 0
+	needProps := true
+	for {
+		if pos >= len(data) {
+			return nil, errLZMACorrupt
+		}
+		control := data[pos]
+		pos++
+		if control == 0x00 {
+			return d.out, nil
+		}
+		if control == 0x01 || control == 0x02 {
+			// 圧縮されていないチャンク
+			if pos+2 > len(data) {
+				return nil, errLZMACorrupt
+			}
+			size := int(data[pos])<<8 | int(data[pos+1]) + 1
+			pos += 2
+			if pos+size > len(data) || int64(len(d.out)+size) > unpackSize {
+				return nil, errLZMACorrupt
+			}
+			if control == 0x01 {
+				d.dictStart = len(d.out)
+			}
+			d.out = append(d.out, data[pos:pos+size]...)
+			pos += size
+			continue
+		}
+		if control < 0x80 || pos+4 > len(data) {
+			return nil, errLZMACorrupt
+		}
+		size := int(control&0x1F)<<16 | int(data[pos])<<8 | int(data[pos+1]) + 1
+		packed := int(data[pos+2])<<8 | int(data[pos+3]) + 1
+		pos += 4
+		mode := (control >> 5) & 0x03
+		if mode == 3 {
+			d.dictStart = len(d.out)
+		}
+		if mode >= 2 {
+			if pos >= len(data) {
+				return nil, errLZMACorrupt
+			}
+			if err := d.setProperties(data[pos]); err != nil {
+				return nil, err
+			}
+			pos++
+			needProps = false
+		}
+		if needProps {
+			return nil, errLZMACorrupt
+		}
+		if mode >= 1 {
+			d.resetState()
+		}
+		if pos+packed > len(data) || int64(len(d.out)+size) > unpackSize {
+			return nil, errLZMACorrupt
+		}
+		rc, err := newLZMARangeDecoder(data[pos : pos+packed])
+		if err != nil {
+			return nil, err
+		}
+		if err := d.decode(rc, int64(len(d.out)+size)); err != nil {
+			return nil, err
+		}
+		pos += packed
+	}
+}
@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"bytes"
+	"testing"
+)
+
+// lzma2Stored は、content を圧縮しないチャンクに格納した LZMA2 のデータを返します。
+func lzma2Stored(content []byte) []byte {
+	size := len(content) - 1
+	data := append([]byte{0x01, byte(size >> 8), byte(size)}, content...)
+	return append(data, 0x00)
+}
+
+func TestDecodeLZMA2Stored(t *testing.T) {
+	content := []byte("hello, lzma2")
+	out, err := decodeLZMA2(lzma2Stored(content), int64(len(content)))
+	if err != nil || !bytes.Equal(out, content) {
+		t.Fatalf("decodeLZMA2() = %q, %v", out, err)
+	}
+	if _, err := decodeLZMA2(lzma2Stored(content), int64(len(content))-1); err == nil {
+		t.Error("decodeLZMA2() exceeded unpackSize")
+	}
+}
+
+// maxFuzzUnpackSize は、ファズテストで展開するサイズの上限です。7z のヘッダーと同様に、入力に応じた上限を与えます。
+const maxFuzzUnpackSize = 1 << 20
+
+func FuzzDecodeLZMA(f *testing.F) {
+	f.Add([]byte{0x5D, 0, 0, 1, 0}, []byte{0, 0, 0, 0, 0, 0}, int64(16))
+	f.Add([]byte{0x5D, 0, 0, 1, 0}, []byte{0, 0x41, 0xFE, 0xFB, 0xF8, 0xB4, 0x80, 0}, int64(-1))
+	f.Add([]byte{0xE1, 0, 0, 0, 0}, []byte{0}, int64(0))
+	f.Fuzz(func(t *testing.T, props, data []byte, unpackSize int64) {
+		if unpackSize > maxFuzzUnpackSize {
+			unpackSize %= maxFuzzUnpackSize
+		}
+		if unpackSize < -1 {
+			unpackSize = -1
+		}
+		out, err := decodeLZMA(props, data, unpackSize)
+		if err != nil {
+			return
+		}
+		if unpackSize >= 0 && int64(len(out)) != unpackSize {
+			t.Errorf("decodeLZMA() returned %d bytes, want %d", len(out), unpackSize)
+		}
+	})
+}
+
+func FuzzDecodeLZMA2(f *testing.F) {
+	f.Add(lzma2Stored([]byte("hello")), int64(5))
+	f.Add(append(lzma2Stored([]byte("abc"))[:6], lzma2Stored([]byte("def"))...), int64(6))
+	f.Add([]byte{0xE0, 0, 0, 0, 5, 0x5D, 0, 0, 0, 0, 0, 0}, int64(1))
+	f.Add([]byte{0x00}, int64(0))
+	f.Fuzz(func(t *testing.T, data []byte, unpackSize int64) {
+		if unpackSize < 0 || unpackSize > maxFuzzUnpackSize {
+			unpackSize = maxFuzzUnpackSize
+		}
+		out, err := decodeLZMA2(data, unpackSize)
+		if err != nil {
+			return
+		}
+		if int64(len(out)) > unpackSize {
+			t.Errorf("decodeLZMA2() returned %d bytes, more than %d", len(out), unpackSize)
+		}
+	})
+}
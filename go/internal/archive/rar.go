@@ -0,0 +1,260 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+var (
+	rar4Signature = []byte{'R', 'a', 'r', '!', 0x1A, 0x07, 0x00}
+	rar5Signature = []byte{'R', 'a', 'r', '!', 0x1A, 0x07, 0x01, 0x00}
+
+	errRarCorrupt = errors.New("corrupt rar archive")
+)
+
+// listRar は、RAR4 または RAR5 のヘッダーからディレクトリを除いたエントリの一覧を返します。
+// エントリの内容は展開できないため、ReadAll は ErrContentUnavailable を返します。
+func listRar(data []byte) ([]*Entry, error) {
+	var (
+		entries []*Entry
+		err     error
+	)
+	switch {
+	case bytes.HasPrefix(data, rar5Signature):
+		entries, err = listRar5(data[len(rar5Signature):])
+	case bytes.HasPrefix(data, rar4Signature):
+		entries, err = listRar4(data[len(rar4Signature):])
+	default:
+		err = fmt.Errorf("invalid signature")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open rar archive, %s", err)
+	}
+	return entries, nil
+}
+
+// rar5Reader は、RAR5 の可変長の整数を読み取ります。
+type rar5Reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *rar5Reader) vint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if r.pos >= len(r.data) {
+			return 0, errRarCorrupt
+		}
+		b := r.data[r.pos]
+		r.pos++
+		v |= uint64(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errRarCorrupt
+}
+
+func (r *rar5Reader) skip(n uint64) error {
+	if n > uint64(len(r.data)-r.pos) {
+		return errRarCorrupt
+	}
+	r.pos += int(n)
+	return nil
+}
+
+// RAR5 のヘッダーの種類です。
+const (
+	rar5HeaderFile       = 2
+	rar5HeaderEncryption = 4
+	rar5HeaderEnd        = 5
+)
+
+func listRar5(data []byte) ([]*Entry, error) {
+	var entries []*Entry
+	pos := 0
+	for pos < len(data) {
+		if pos+4 > len(data) {
+			return nil, errRarCorrupt
+		}
+		crc := binary.LittleEndian.Uint32(data[pos:])
+		r := &rar5Reader{data: data, pos: pos + 4}
+		size, err := r.vint()
+		if err != nil || size == 0 || size > uint64(len(data)-r.pos) {
+			return nil, errRarCorrupt
+		}
+		headerEnd := r.pos + int(size)
+		if crc32.ChecksumIEEE(data[pos+4:headerEnd]) != crc {
+			return nil, errRarCorrupt
+		}
+		h := &rar5Reader{data: data[:headerEnd], pos: r.pos}
+		typ, err := h.vint()
+		if err != nil {
+			return nil, err
+		}
+		flags, err := h.vint()
+		if err != nil {
+			return nil, err
+		}
+		if flags&0x01 != 0 {
+			if _, err := h.vint(); err != nil {
+				return nil, err
+			}
+		}
+		var dataSize uint64
+		if flags&0x02 != 0 {
+			if dataSize, err = h.vint(); err != nil {
+				return nil, err
+			}
+		}
+
+		switch typ {
+		case rar5HeaderEncryption:
+			return nil, fmt.Errorf("archives with encrypted headers cannot be inspected")
+		case rar5HeaderEnd:
+			return entries, nil
+		case rar5HeaderFile:
+			entry, dir, err := rar5File(h)
+			if err != nil {
+				return nil, err
+			}
+			if !dir {
+				entries = append(entries, entry)
+			}
+		}
+
+		if dataSize > uint64(len(data)-headerEnd) {
+			return nil, errRarCorrupt
+		}
+		pos = headerEnd + int(dataSize)
+	}
+	// 終端のヘッダーがない（途中で切れている）アーカイブ
+	return nil, errRarCorrupt
+}
+
+func rar5File(h *rar5Reader) (*Entry, bool, error) {
+	fileFlags, err := h.vint()
+	if err != nil {
+		return nil, false, err
+	}
+	unpacked, err := h.vint()
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := h.vint(); err != nil { // 属性
+		return nil, false, err
+	}
+	if fileFlags&0x02 != 0 { // 更新日時
+		if err := h.skip(4); err != nil {
+			return nil, false, err
+		}
+	}
+	if fileFlags&0x04 != 0 { // CRC32
+		if err := h.skip(4); err != nil {
+			return nil, false, err
+		}
+	}
+	if _, err := h.vint(); err != nil { // 圧縮の情報
+		return nil, false, err
+	}
+	if _, err := h.vint(); err != nil { // OS
+		return nil, false, err
+	}
+	nameLen, err := h.vint()
+	if err != nil {
+		return nil, false, err
+	}
+	start := h.pos
+	if err := h.skip(nameLen); err != nil {
+		return nil, false, err
+	}
+	if fileFlags&0x08 != 0 {
+		// 展開後のサイズが不明なエントリは、圧縮爆弾かどうかを判断できないため拒否する。
+		return nil, false, fmt.Errorf("entry with unknown unpacked size")
+	}
+	entry := &Entry{Name: string(h.data[start:h.pos]), UncompressedSize: unpacked, open: unavailableContent}
+	return entry, fileFlags&0x01 != 0, nil
+}
+
+// RAR4 のブロックの種類とフラグです。
+const (
+	rar4BlockArchive = 0x73
+	rar4BlockFile    = 0x74
+	rar4BlockEnd     = 0x7B
+
+	rar4ArchiveEncrypted = 0x0080
+	rar4FileLarge        = 0x0100
+	rar4FileDirectory    = 0x00E0
+	rar4HasAddSize       = 0x8000
+)
+
+func listRar4(data []byte) ([]*Entry, error) {
+	var entries []*Entry
+	pos := 0
+	for pos < len(data) {
+		if pos+7 > len(data) {
+			return nil, errRarCorrupt
+		}
+		typ := data[pos+2]
+		flags := binary.LittleEndian.Uint16(data[pos+3:])
+		size := int(binary.LittleEndian.Uint16(data[pos+5:]))
+		if size < 7 || pos+size > len(data) {
+			return nil, errRarCorrupt
+		}
+		header := data[pos : pos+size]
+		next := uint64(pos + size)
+		if flags&rar4HasAddSize != 0 {
+			if size < 11 {
+				return nil, errRarCorrupt
+			}
+			next += uint64(binary.LittleEndian.Uint32(header[7:]))
+		}
+
+		switch typ {
+		case rar4BlockArchive:
+			if flags&rar4ArchiveEncrypted != 0 {
+				return nil, fmt.Errorf("archives with encrypted headers cannot be inspected")
+			}
+		case rar4BlockEnd:
+			return entries, nil
+		case rar4BlockFile:
+			if size < 32 {
+				return nil, errRarCorrupt
+			}
+			unpacked := uint64(binary.LittleEndian.Uint32(header[11:]))
+			nameSize := int(binary.LittleEndian.Uint16(header[26:]))
+			nameStart := 32
+			if flags&rar4FileLarge != 0 {
+				if size < 40 {
+					return nil, errRarCorrupt
+				}
+				next += uint64(binary.LittleEndian.Uint32(header[32:])) << 32
+				unpacked |= uint64(binary.LittleEndian.Uint32(header[36:])) << 32
+				nameStart = 40
+			}
+			if nameStart+nameSize > size {
+				return nil, errRarCorrupt
+			}
+			name := header[nameStart : nameStart+nameSize]
+			// Unicode のファイル名は、ASCIIの名前の後に NUL で区切って格納される。
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			if flags&rar4FileDirectory != rar4FileDirectory {
+				// RAR4 はパスの区切りに「\」を使う。
+				entries = append(entries, &Entry{Name: strings.ReplaceAll(string(name), "\\", "/"), UncompressedSize: unpacked, open: unavailableContent})
+			}
+		}
+
+		if next > uint64(len(data)) {
+			return nil, errRarCorrupt
+		}
+		pos = int(next)
+	}
+	// 終端のブロックがない古い形式のアーカイブもあるため、最後まで読めた場合は受け入れる。
+	return entries, nil
+}
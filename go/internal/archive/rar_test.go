@@ -0,0 +1,88 @@
+package archive
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// rar5Vint は、RAR5 の可変長の整数を返します。
+func rar5Vint(v uint64) []byte {
+	var b []byte
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// rar5Block は、body を内容とする RAR5 のヘッダーを返します。
+func rar5Block(body ...[]byte) []byte {
+	var content []byte
+	for _, b := range body {
+		content = append(content, b...)
+	}
+	sized := append(rar5Vint(uint64(len(content))), content...)
+	return append(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(sized)), sized...)
+}
+
+// rar5Archive は、names のファイルを size バイトとして記録した RAR5 のアーカイブを返します。
+func rar5Archive(size uint64, names ...string) []byte {
+	data := append([]byte(nil), rar5Signature...)
+	for _, name := range names {
+		data = append(data, rar5Block(rar5Vint(rar5HeaderFile), rar5Vint(0), rar5Vint(0), rar5Vint(size), rar5Vint(0), rar5Vint(0), rar5Vint(0), rar5Vint(uint64(len(name))), []byte(name))...)
+	}
+	return append(data, rar5Block(rar5Vint(rar5HeaderEnd), rar5Vint(0))...)
+}
+
+// rar4Archive は、names のファイルを size バイトとして記録した RAR4 のアーカイブを返します。
+func rar4Archive(size uint32, names ...string) []byte {
+	data := append([]byte(nil), rar4Signature...)
+	data = append(data, 0, 0, rar4BlockArchive, 0, 0, 13, 0, 0, 0, 0, 0, 0, 0)
+	for _, name := range names {
+		block := make([]byte, 32, 32+len(name))
+		block[2] = rar4BlockFile
+		binary.LittleEndian.PutUint16(block[3:], rar4HasAddSize)
+		binary.LittleEndian.PutUint16(block[5:], uint16(32+len(name)))
+		binary.LittleEndian.PutUint32(block[11:], size)
+		binary.LittleEndian.PutUint16(block[26:], uint16(len(name)))
+		data = append(data, append(block, name...)...)
+	}
+	return append(data, 0, 0, rar4BlockEnd, 0, 0, 7, 0)
+}
+
+func TestListRar(t *testing.T) {
+	for name, data := range map[string][]byte{"rar5": rar5Archive(1024, "a.txt", "b/c.txt"), "rar4": rar4Archive(1024, "a.txt", `b\c.txt`)} {
+		t.Run(name, func(t *testing.T) {
+			entries, err := listRar(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 || entries[0].Name != "a.txt" || entries[1].Name != "b/c.txt" || entries[1].UncompressedSize != 1024 {
+				t.Fatalf("listRar() = %v", entries)
+			}
+		})
+	}
+}
+
+func FuzzListRar(f *testing.F) {
+	f.Add(rar5Archive(0))
+	f.Add(rar5Archive(5, "a.txt"))
+	f.Add(rar5Archive(1<<40, "a.txt", "b/c.txt"))
+	f.Add(rar4Archive(5, "a.txt"))
+	f.Add(rar4Archive(1<<31, "a.txt", `b\c.txt`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, err := listRar(data)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e == nil {
+				t.Fatal("listRar() returned a nil entry")
+			}
+			if _, err := e.ReadAll(); err != ErrContentUnavailable {
+				t.Errorf("ReadAll() = %v, want ErrContentUnavailable", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Recompress は、アーカイブの形式のまま、最も高い圧縮率で圧縮し直したデータを返します。
+// zip は各エントリを Deflate で、tar.gz は gzip で圧縮し直します。
+// 7z と rar は内容を展開できないため ErrContentUnavailable を返します。
+func Recompress(format Format, data []byte) ([]byte, error) {
+	switch format {
+	case FormatZip:
+		return recompressZip(data)
+	case FormatTarGz:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("unable to open gzip stream, %s", err)
+		}
+		defer zr.Close()
+		return gzipFrom(zr)
+	}
+	return nil, ErrContentUnavailable
+}
+
+// Gzip は、data を最も高い圧縮率の gzip で圧縮します。
+func Gzip(data []byte) ([]byte, error) {
+	return gzipFrom(bytes.NewReader(data))
+}
+
+func gzipFrom(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(zw, r); err != nil {
+		return nil, fmt.Errorf("unable to compress data, %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to compress data, %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// recompressZip は、zipの各エントリを、ファイル名や更新日時を保ったまま Deflate で圧縮し直します。
+func recompressZip(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open zip archive, %s", err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestCompression)
+	})
+	for _, f := range zr.File {
+		// 暗号化されたエントリは展開できないため、圧縮し直さない。
+		if f.Flags&0x1 != 0 {
+			return nil, errors.New("unable to recompress encrypted zip archive")
+		}
+		header := f.FileHeader
+		if f.FileInfo().IsDir() {
+			if _, err := zw.CreateHeader(&header); err != nil {
+				return nil, fmt.Errorf("unable to write entry %s, %s", f.Name, err)
+			}
+			continue
+		}
+
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(&header)
+		if err != nil {
+			return nil, fmt.Errorf("unable to write entry %s, %s", f.Name, err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("unable to open entry %s, %s", f.Name, err)
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to recompress entry %s, %s", f.Name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to close zip archive, %s", err)
+	}
+	return buf.Bytes(), nil
+}
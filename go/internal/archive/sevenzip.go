@@ -0,0 +1,654 @@
+package archive
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"unicode/utf16"
+)
+
+// 7z のヘッダーのプロパティIDです。
+const (
+	sevenZipEnd                = 0x00
+	sevenZipHeader             = 0x01
+	sevenZipArchiveProperties  = 0x02
+	sevenZipAdditionalStreams  = 0x03
+	sevenZipMainStreamsInfo    = 0x04
+	sevenZipFilesInfo          = 0x05
+	sevenZipPackInfo           = 0x06
+	sevenZipUnpackInfo         = 0x07
+	sevenZipSubStreamsInfo     = 0x08
+	sevenZipSize               = 0x09
+	sevenZipCRC                = 0x0A
+	sevenZipFolderID           = 0x0B
+	sevenZipCodersUnpackSize   = 0x0C
+	sevenZipNumUnpackStream    = 0x0D
+	sevenZipEmptyStream        = 0x0E
+	sevenZipEmptyFile          = 0x0F
+	sevenZipName               = 0x11
+	sevenZipEncodedHeader      = 0x17
+	sevenZipSignatureHeaderLen = 32
+)
+
+var sevenZipSignature = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+
+var errSevenZipCorrupt = errors.New("corrupt 7z archive")
+
+type sevenZipReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *sevenZipReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errSevenZipCorrupt
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *sevenZipReader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errSevenZipCorrupt
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// number は、7z の可変長の整数を読み取ります。
+func (r *sevenZipReader) number() (uint64, error) {
+	first, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	mask := byte(0x80)
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			high := uint64(first & (mask - 1))
+			return value | high<<(8*i), nil
+		}
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b) << (8 * i)
+		mask >>= 1
+	}
+	return value, nil
+}
+
+// count は、要素の数として読み取り、残りのデータに収まらない値を拒否します。
+func (r *sevenZipReader) count() (int, error) {
+	n, err := r.number()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(r.data)) {
+		return 0, errSevenZipCorrupt
+	}
+	return int(n), nil
+}
+
+func (r *sevenZipReader) bits(n int) ([]bool, error) {
+	v := make([]bool, n)
+	var b byte
+	var err error
+	for i := 0; i < n; i++ {
+		if i%8 == 0 {
+			if b, err = r.byte(); err != nil {
+				return nil, err
+			}
+		}
+		v[i] = b&(0x80>>(i%8)) != 0
+	}
+	return v, nil
+}
+
+// digests は、n 個の CRC を読み飛ばし、それぞれが定義されているかを返します。
+func (r *sevenZipReader) digests(n int) ([]bool, error) {
+	allDefined, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	defined := make([]bool, n)
+	if allDefined == 0 {
+		if defined, err = r.bits(n); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := range defined {
+			defined[i] = true
+		}
+	}
+	for _, d := range defined {
+		if d {
+			if _, err := r.bytes(4); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return defined, nil
+}
+
+type sevenZipCoder struct {
+	id         []byte
+	numIn      int
+	numOut     int
+	properties []byte
+}
+
+type sevenZipFolder struct {
+	coders      []sevenZipCoder
+	bindOut     []int // 他のコーダーの入力につながる出力ストリームの番号
+	unpackSizes []uint64
+}
+
+// unpackSize は、フォルダーの最終的な出力ストリームのサイズです。
+func (f *sevenZipFolder) unpackSize() uint64 {
+	for i := len(f.unpackSizes) - 1; i >= 0; i-- {
+		bound := false
+		for _, o := range f.bindOut {
+			if o == i {
+				bound = true
+			}
+		}
+		if !bound {
+			return f.unpackSizes[i]
+		}
+	}
+	return 0
+}
+
+type sevenZipStreams struct {
+	packPos    uint64
+	packSizes  []uint64
+	folders    []*sevenZipFolder
+	folderCRCs []bool   // フォルダーごとの CRC が定義されているか
+	numStreams []int    // フォルダーごとのファイルの数
+	sizes      []uint64 // ファイルごとの展開後のサイズ
+}
+
+func (r *sevenZipReader) packInfo(s *sevenZipStreams) error {
+	var err error
+	if s.packPos, err = r.number(); err != nil {
+		return err
+	}
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for {
+		id, err := r.number()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case sevenZipEnd:
+			return nil
+		case sevenZipSize:
+			s.packSizes = make([]uint64, n)
+			for i := range s.packSizes {
+				if s.packSizes[i], err = r.number(); err != nil {
+					return err
+				}
+			}
+		case sevenZipCRC:
+			if _, err := r.digests(n); err != nil {
+				return err
+			}
+		default:
+			return errSevenZipCorrupt
+		}
+	}
+}
+
+func (r *sevenZipReader) folder() (*sevenZipFolder, error) {
+	numCoders, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	f := &sevenZipFolder{}
+	var totalIn, totalOut int
+	for i := 0; i < numCoders; i++ {
+		flags, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if flags&0x80 != 0 {
+			return nil, fmt.Errorf("7z alternative coder methods are not supported")
+		}
+		c := sevenZipCoder{numIn: 1, numOut: 1}
+		if c.id, err = r.bytes(uint64(flags & 0x0F)); err != nil {
+			return nil, err
+		}
+		if flags&0x10 != 0 {
+			if c.numIn, err = r.count(); err != nil {
+				return nil, err
+			}
+			if c.numOut, err = r.count(); err != nil {
+				return nil, err
+			}
+		}
+		if flags&0x20 != 0 {
+			size, err := r.number()
+			if err != nil {
+				return nil, err
+			}
+			if c.properties, err = r.bytes(size); err != nil {
+				return nil, err
+			}
+		}
+		totalIn += c.numIn
+		totalOut += c.numOut
+		f.coders = append(f.coders, c)
+	}
+	if totalOut == 0 || totalIn < totalOut-1 {
+		return nil, errSevenZipCorrupt
+	}
+	for i := 0; i < totalOut-1; i++ {
+		if _, err := r.number(); err != nil {
+			return nil, err
+		}
+		out, err := r.count()
+		if err != nil {
+			return nil, err
+		}
+		f.bindOut = append(f.bindOut, out)
+	}
+	if numPacked := totalIn - (totalOut - 1); numPacked > 1 {
+		for i := 0; i < numPacked; i++ {
+			if _, err := r.number(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	f.unpackSizes = make([]uint64, totalOut)
+	return f, nil
+}
+
+func (r *sevenZipReader) unpackInfo(s *sevenZipStreams) error {
+	if id, err := r.number(); err != nil || id != sevenZipFolderID {
+		return errSevenZipCorrupt
+	}
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	if external, err := r.byte(); err != nil || external != 0 {
+		return fmt.Errorf("7z external folders are not supported")
+	}
+	for i := 0; i < n; i++ {
+		f, err := r.folder()
+		if err != nil {
+			return err
+		}
+		s.folders = append(s.folders, f)
+	}
+	if id, err := r.number(); err != nil || id != sevenZipCodersUnpackSize {
+		return errSevenZipCorrupt
+	}
+	for _, f := range s.folders {
+		for i := range f.unpackSizes {
+			if f.unpackSizes[i], err = r.number(); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		id, err := r.number()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case sevenZipEnd:
+			return nil
+		case sevenZipCRC:
+			if s.folderCRCs, err = r.digests(n); err != nil {
+				return err
+			}
+		default:
+			return errSevenZipCorrupt
+		}
+	}
+}
+
+func (r *sevenZipReader) subStreamsInfo(s *sevenZipStreams) error {
+	s.numStreams = make([]int, len(s.folders))
+	for i := range s.numStreams {
+		s.numStreams[i] = 1
+	}
+	id, err := r.number()
+	if err != nil {
+		return err
+	}
+	if id == sevenZipNumUnpackStream {
+		for i := range s.numStreams {
+			if s.numStreams[i], err = r.count(); err != nil {
+				return err
+			}
+		}
+		if id, err = r.number(); err != nil {
+			return err
+		}
+	}
+	hasSizes := id == sevenZipSize
+	s.sizes = nil
+	for i, f := range s.folders {
+		if s.numStreams[i] == 0 {
+			continue
+		}
+		var sum uint64
+		if hasSizes {
+			for j := 1; j < s.numStreams[i]; j++ {
+				size, err := r.number()
+				if err != nil {
+					return err
+				}
+				sum += size
+				s.sizes = append(s.sizes, size)
+			}
+		}
+		total := f.unpackSize()
+		if sum > total {
+			return errSevenZipCorrupt
+		}
+		s.sizes = append(s.sizes, total-sum)
+	}
+	if hasSizes {
+		if id, err = r.number(); err != nil {
+			return err
+		}
+	}
+	for id != sevenZipEnd {
+		if id != sevenZipCRC {
+			return errSevenZipCorrupt
+		}
+		// CRC が既知のフォルダー（ファイルが1つ）を除いたストリームの分だけ並ぶ。
+		n := 0
+		for i, c := range s.numStreams {
+			if c == 1 && i < len(s.folderCRCs) && s.folderCRCs[i] {
+				continue
+			}
+			n += c
+		}
+		if _, err := r.digests(n); err != nil {
+			return err
+		}
+		if id, err = r.number(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *sevenZipReader) streamsInfo() (*sevenZipStreams, error) {
+	s := &sevenZipStreams{}
+	for {
+		id, err := r.number()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case sevenZipEnd:
+			if s.numStreams == nil {
+				// SubStreamsInfo がない場合は、フォルダーごとに1つのファイルとする。
+				for _, f := range s.folders {
+					s.numStreams = append(s.numStreams, 1)
+					s.sizes = append(s.sizes, f.unpackSize())
+				}
+			}
+			return s, nil
+		case sevenZipPackInfo:
+			err = r.packInfo(s)
+		case sevenZipUnpackInfo:
+			err = r.unpackInfo(s)
+		case sevenZipSubStreamsInfo:
+			err = r.subStreamsInfo(s)
+		default:
+			return nil, errSevenZipCorrupt
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *sevenZipReader) skipArchiveProperties() error {
+	for {
+		t, err := r.number()
+		if err != nil {
+			return err
+		}
+		if t == sevenZipEnd {
+			return nil
+		}
+		size, err := r.number()
+		if err != nil {
+			return err
+		}
+		if _, err := r.bytes(size); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeSevenZipHeader は、圧縮されたヘッダーを展開します。コピー、LZMA、LZMA2、Deflate、BZip2 に対応します。
+func decodeSevenZipHeader(data []byte, s *sevenZipStreams) ([]byte, error) {
+	if len(s.folders) != 1 || len(s.packSizes) != 1 || len(s.folders[0].coders) != 1 {
+		return nil, fmt.Errorf("unsupported 7z encoded header")
+	}
+	start := uint64(sevenZipSignatureHeaderLen) + s.packPos
+	if start > uint64(len(data)) || s.packSizes[0] > uint64(len(data))-start {
+		return nil, errSevenZipCorrupt
+	}
+	packed := data[start : start+s.packSizes[0]]
+	f := s.folders[0]
+	size := f.unpackSize()
+	if size > uint64(len(packed))*1024+1<<20 {
+		return nil, errSevenZipCorrupt
+	}
+	coder := f.coders[0]
+	switch {
+	case bytes.Equal(coder.id, []byte{0x00}):
+		return packed, nil
+	case bytes.Equal(coder.id, []byte{0x03, 0x01, 0x01}):
+		return decodeLZMA(coder.properties, packed, int64(size))
+	case bytes.Equal(coder.id, []byte{0x21}):
+		return decodeLZMA2(packed, int64(size))
+	case bytes.Equal(coder.id, []byte{0x04, 0x01, 0x08}):
+		return readLimited(flate.NewReader(bytes.NewReader(packed)), size)
+	case bytes.Equal(coder.id, []byte{0x04, 0x02, 0x02}):
+		return readLimited(bzip2.NewReader(bytes.NewReader(packed)), size)
+	case bytes.Equal(coder.id, []byte{0x06, 0xF1, 0x07, 0x01}):
+		return nil, fmt.Errorf("7z archives with encrypted headers cannot be inspected")
+	}
+	return nil, fmt.Errorf("unsupported 7z header coder %x", coder.id)
+}
+
+// readLimited は、r から size バイトを読み込みます。
+func readLimited(r io.Reader, size uint64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) != size {
+		return nil, errSevenZipCorrupt
+	}
+	return b, nil
+}
+
+// filesInfo は、ファイルの名前と、空のストリーム（ディレクトリまたは空のファイル）の情報を読み取り、エントリの一覧を返します。
+func (r *sevenZipReader) filesInfo(s *sevenZipStreams) ([]*Entry, error) {
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, n)
+	emptyStream := make([]bool, n)
+	var emptyFile []bool
+	for {
+		id, err := r.number()
+		if err != nil {
+			return nil, err
+		}
+		if id == sevenZipEnd {
+			break
+		}
+		size, err := r.number()
+		if err != nil {
+			return nil, err
+		}
+		prop, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+		pr := &sevenZipReader{data: prop}
+		switch id {
+		case sevenZipEmptyStream:
+			if emptyStream, err = pr.bits(n); err != nil {
+				return nil, err
+			}
+		case sevenZipEmptyFile:
+			numEmpty := 0
+			for _, e := range emptyStream {
+				if e {
+					numEmpty++
+				}
+			}
+			if emptyFile, err = pr.bits(numEmpty); err != nil {
+				return nil, err
+			}
+		case sevenZipName:
+			if external, err := pr.byte(); err != nil || external != 0 {
+				return nil, fmt.Errorf("7z external names are not supported")
+			}
+			if names, err = decodeSevenZipNames(prop[1:], n); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var entries []*Entry
+	stream, empty := 0, 0
+	for i := 0; i < n; i++ {
+		if emptyStream[i] {
+			isFile := empty < len(emptyFile) && emptyFile[empty]
+			empty++
+			if isFile {
+				entries = append(entries, &Entry{Name: names[i], open: unavailableContent})
+			}
+			continue
+		}
+		if stream >= len(s.sizes) {
+			return nil, errSevenZipCorrupt
+		}
+		entries = append(entries, &Entry{Name: names[i], UncompressedSize: s.sizes[stream], open: unavailableContent})
+		stream++
+	}
+	return entries, nil
+}
+
+func decodeSevenZipNames(b []byte, n int) ([]string, error) {
+	names := make([]string, 0, n)
+	var u []uint16
+	for i := 0; i+1 < len(b) && len(names) < n; i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			names = append(names, string(utf16.Decode(u)))
+			u = u[:0]
+			continue
+		}
+		u = append(u, c)
+	}
+	if len(names) != n {
+		return nil, errSevenZipCorrupt
+	}
+	return names, nil
+}
+
+// listSevenZip は、7z のヘッダーからディレクトリを除いたエントリの一覧を返します。
+// エントリの内容は展開できないため、ReadAll は ErrContentUnavailable を返します。
+func listSevenZip(data []byte) ([]*Entry, error) {
+	if len(data) < sevenZipSignatureHeaderLen || !bytes.HasPrefix(data, sevenZipSignature) {
+		return nil, fmt.Errorf("unable to open 7z archive, invalid signature")
+	}
+	if crc32.ChecksumIEEE(data[12:32]) != binary.LittleEndian.Uint32(data[8:12]) {
+		return nil, fmt.Errorf("unable to open 7z archive, %s", errSevenZipCorrupt)
+	}
+	offset := binary.LittleEndian.Uint64(data[12:20])
+	size := binary.LittleEndian.Uint64(data[20:28])
+	start := uint64(sevenZipSignatureHeaderLen) + offset
+	if offset > uint64(len(data)) || start > uint64(len(data)) || size > uint64(len(data))-start {
+		return nil, fmt.Errorf("unable to open 7z archive, %s", errSevenZipCorrupt)
+	}
+	header := data[start : start+size]
+	if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(data[28:32]) {
+		return nil, fmt.Errorf("unable to open 7z archive, %s", errSevenZipCorrupt)
+	}
+	if size == 0 {
+		// 空のアーカイブ
+		return nil, nil
+	}
+
+	r := &sevenZipReader{data: header}
+	id, err := r.number()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+	}
+	if id == sevenZipEncodedHeader {
+		s, err := r.streamsInfo()
+		if err != nil {
+			return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+		}
+		if header, err = decodeSevenZipHeader(data, s); err != nil {
+			return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+		}
+		r = &sevenZipReader{data: header}
+		if id, err = r.number(); err != nil {
+			return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+		}
+	}
+	if id != sevenZipHeader {
+		return nil, fmt.Errorf("unable to open 7z archive, %s", errSevenZipCorrupt)
+	}
+
+	s := &sevenZipStreams{}
+	for {
+		id, err := r.number()
+		if err != nil {
+			return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+		}
+		switch id {
+		case sevenZipEnd:
+			return nil, nil
+		case sevenZipArchiveProperties:
+			if err := r.skipArchiveProperties(); err != nil {
+				return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+			}
+		case sevenZipAdditionalStreams:
+			if _, err := r.streamsInfo(); err != nil {
+				return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+			}
+		case sevenZipMainStreamsInfo:
+			if s, err = r.streamsInfo(); err != nil {
+				return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+			}
+		case sevenZipFilesInfo:
+			entries, err := r.filesInfo(s)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open 7z archive, %s", err)
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("unable to open 7z archive, %s", errSevenZipCorrupt)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package archive
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"unicode/utf16"
+)
+
+// sevenZipArchive は、header を次のヘッダーとする 7z のアーカイブを返します。
+func sevenZipArchive(header []byte) []byte {
+	data := make([]byte, sevenZipSignatureHeaderLen, sevenZipSignatureHeaderLen+len(header))
+	copy(data, sevenZipSignature)
+	data[7] = 4
+	binary.LittleEndian.PutUint64(data[20:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(data[28:], crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(data[8:], crc32.ChecksumIEEE(data[12:32]))
+	return append(data, header...)
+}
+
+// sevenZipEmptyFiles は、空のファイル names だけを含むヘッダーを返します。
+func sevenZipEmptyFiles(names ...string) []byte {
+	n := byte(len(names))
+	bits := make([]byte, (len(names)+7)/8)
+	for i := range names {
+		bits[i/8] |= 0x80 >> (i % 8)
+	}
+	encoded := []byte{0}
+	for _, name := range names {
+		for _, c := range utf16.Encode([]rune(name)) {
+			encoded = append(encoded, byte(c), byte(c>>8))
+		}
+		encoded = append(encoded, 0, 0)
+	}
+	header := []byte{sevenZipHeader, sevenZipFilesInfo, n}
+	header = append(header, sevenZipEmptyStream, byte(len(bits)))
+	header = append(header, bits...)
+	header = append(header, sevenZipEmptyFile, byte(len(bits)))
+	header = append(header, bits...)
+	header = append(header, sevenZipName, byte(len(encoded)))
+	header = append(header, encoded...)
+	return append(header, sevenZipEnd, sevenZipEnd)
+}
+
+func TestListSevenZip(t *testing.T) {
+	entries, err := listSevenZip(sevenZipArchive(sevenZipEmptyFiles("a.txt", "ディレクトリ/b.txt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "a.txt" || entries[1].Name != "ディレクトリ/b.txt" {
+		t.Fatalf("listSevenZip() = %v", entries)
+	}
+	if _, err := entries[0].ReadAll(); err != ErrContentUnavailable {
+		t.Errorf("ReadAll() = %v, want ErrContentUnavailable", err)
+	}
+}
+
+func FuzzListSevenZip(f *testing.F) {
+	f.Add(sevenZipArchive(nil))
+	f.Add(sevenZipArchive(sevenZipEmptyFiles("a.txt")))
+	f.Add(sevenZipArchive(sevenZipEmptyFiles("a.txt", "b/c.txt")))
+	f.Add(sevenZipArchive([]byte{sevenZipEncodedHeader, sevenZipPackInfo, 0, 1, sevenZipSize, 4, sevenZipEnd, sevenZipUnpackInfo, sevenZipFolderID, 1, 0, 1, 0x21, 1, 0x18, sevenZipCodersUnpackSize, 4, sevenZipEnd, sevenZipEnd}))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entries, err := listSevenZip(data)
+		if err != nil {
+			return
+		}
+		for _, e := range entries {
+			if e == nil {
+				t.Fatal("listSevenZip() returned a nil entry")
+			}
+		}
+		// ヘッダーの先頭（シグネチャとCRC）を壊したアーカイブは開けない。
+		corrupt := append([]byte(nil), data...)
+		corrupt[8] ^= 0xFF
+		if _, err := listSevenZip(corrupt); err == nil {
+			t.Error("listSevenZip() accepted a start header with a wrong CRC")
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errLimitExceeded は、展開したデータが上限を超えたときのエラーです。
+var errLimitExceeded = errors.New("limit exceeded")
+
+// limitedReader は、max バイトを超えて読み込むと errLimitExceeded を返します。
+type limitedReader struct {
+	r    io.Reader
+	n    int64
+	max  int64
+	over bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.max > 0 && l.n > l.max {
+		l.over = true
+		return n, errLimitExceeded
+	}
+	return n, err
+}
+
+// walkTarGz は、tar.gz を先頭から展開し、ディレクトリを除く各エントリについて fn を呼び出します。
+// 展開したデータの合計が maxSize（0 の場合は無制限）を超えた場合は BombError を返します。
+func walkTarGz(data []byte, maxSize int64, fn func(h *tar.Header, r io.Reader) error) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to open tar.gz archive, %s", err)
+	}
+	defer zr.Close()
+	lr := &limitedReader{r: zr, max: maxSize}
+	tr := tar.NewReader(lr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if lr.over {
+				return &BombError{Reason: fmt.Sprintf("total uncompressed size exceeds %d bytes", maxSize)}
+			}
+			return fmt.Errorf("unable to read tar.gz archive, %s", err)
+		}
+		if h.Typeflag == tar.TypeDir || strings.HasSuffix(h.Name, "/") {
+			continue
+		}
+		if err := fn(h, tr); err != nil {
+			if lr.over {
+				return &BombError{Reason: fmt.Sprintf("total uncompressed size exceeds %d bytes", maxSize)}
+			}
+			return err
+		}
+	}
+}
+
+// listTarGz は、tar.gz のエントリを内容とともに読み込んで返します。
+func listTarGz(data []byte, maxSize int64) ([]*Entry, error) {
+	var entries []*Entry
+	err := walkTarGz(data, maxSize, func(h *tar.Header, r io.Reader) error {
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			// シンボリックリンクなどは内容を持たない。
+			entries = append(entries, &Entry{Name: h.Name, open: emptyContent})
+			return nil
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("unable to read entry %s, %s", h.Name, err)
+		}
+		entries = append(entries, &Entry{
+			Name:             h.Name,
+			UncompressedSize: uint64(len(content)),
+			open: func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(content)), nil
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func emptyContent() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(nil)), nil
+}
@@ -0,0 +1,318 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// Record は、発行したダウンロードURL1件分の監査記録です。
+type Record struct {
+	ID              string   `dynamodbav:"id"`
+	TeamID          string   `dynamodbav:"team_id"`
+	EnterpriseID    string   `dynamodbav:"enterprise_id,omitempty"`
+	Channel         string   `dynamodbav:"channel"`
+	MessageTS       string   `dynamodbav:"message_ts,omitempty"` // URLの発行を依頼したメッセージのタイムスタンプ
+	Permalink       string   `dynamodbav:"permalink,omitempty"`  // URLの発行を依頼したメッセージのパーマリンク
+	User            string   `dynamodbav:"user"`
+	Approver        string   `dynamodbav:"approver,omitempty"` // 二人承認で発行を承認したユーザー
+	FileName        string   `dynamodbav:"file_name"`
+	Bucket          string   `dynamodbav:"bucket,omitempty"` // S3_BUCKET 以外にアップロードした場合のバケット
+	ObjectKey       string   `dynamodbav:"object_key"`
+	VersionID       string   `dynamodbav:"version_id,omitempty"`
+	Region          string   `dynamodbav:"region,omitempty"` // フェイルオーバーでセカンダリのリージョンにアップロードした場合のリージョン
+	Size            int64    `dynamodbav:"size"`
+	ShortURL        string   `dynamodbav:"short_url"`
+	CreatedAt       int64    `dynamodbav:"created_at"`                         // UNIX時間（秒）
+	ExpiresAt       int64    `dynamodbav:"expires_at"`                         // UNIX時間（秒）
+	DownloadCount   int64    `dynamodbav:"download_count"`                     // 短縮URL、確認ページ、ポータルからダウンロードされた回数
+	ExecutionARN    string   `dynamodbav:"execution_arn,omitempty"`            // Step Functions で処理した場合の実行ARN
+	Note            string   `dynamodbav:"note,omitempty"`                     // 依頼者がリンクに添えた説明（note=）
+	SHA256          string   `dynamodbav:"sha256,omitempty"`                   // 公開したファイルの SHA-256（16進数）
+	RecipientGroup  string   `dynamodbav:"recipient_group,omitempty"`          // for= で受取人に指定したユーザーグループのID
+	Recipients      []string `dynamodbav:"recipients,omitempty,stringset"`     // ダウンロードを許可した受取人のメールアドレス
+	AllowedGroups   []string `dynamodbav:"allowed_groups,omitempty,stringset"` // ポータルでダウンロードを許可するIdPのグループ
+	LegalHold       bool     `dynamodbav:"legal_hold,omitempty"`               // 管理者が設定したリーガルホールド。解除するまで削除できない
+	LegalHoldBy     string   `dynamodbav:"legal_hold_by,omitempty"`            // リーガルホールドを設定した管理者
+	LegalHoldReason string   `dynamodbav:"legal_hold_reason,omitempty"`
+	RevokedAt       int64    `dynamodbav:"revoked_at,omitempty"` // APIでリンクを無効にした日時（UNIX時間）
+	RevokedBy       string   `dynamodbav:"revoked_by,omitempty"` // リンクを無効にした呼び出し元
+	Provenance      string   `dynamodbav:"provenance,omitempty"` // APIでCIが申告したビルドの来歴（in-toto）の短縮URL
+}
+
+// NewID は、監査記録のIDとして使うランダムな文字列を生成します。
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate id, %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Store は、監査記録を保存・検索します。
+type Store interface {
+	Put(ctx context.Context, record *Record) error
+	// ListActiveBetween は、期間 [from, to) に作成されたか期限切れになった記録を返します。
+	ListActiveBetween(ctx context.Context, from, to time.Time) ([]*Record, error)
+	// Get は、IDから記録を取得します。見つからない場合は nil を返します。
+	Get(ctx context.Context, id string) (*Record, error)
+	// FindByShortURL は、短縮URLから記録を検索します。見つからない場合は nil を返します。
+	FindByShortURL(ctx context.Context, shortURL string) (*Record, error)
+	// FindActiveBySHA256 は、ワークスペースで同じ内容のファイルを公開した有効期限内の記録を検索します。
+	// 無効にされた記録と、受取人やグループを限定した記録は除きます。見つからない場合は nil を返します。
+	FindActiveBySHA256(ctx context.Context, teamID, sum string, now time.Time) (*Record, error)
+	// Search は、ワークスペースの有効期限内の記録から、条件に一致する記録を返します。無効にされた記録は除きます。
+	Search(ctx context.Context, teamID string, query *Query, now time.Time) ([]*Record, error)
+	// ListByUser は、ワークスペースでユーザーが依頼したすべての記録を、有効期限に関わらず返します。
+	ListByUser(ctx context.Context, teamID, user string) ([]*Record, error)
+	// Delete は、記録を削除します。
+	Delete(ctx context.Context, id string) error
+	// CountDownload は、記録のダウンロード回数に1を加えます。
+	CountDownload(ctx context.Context, id string) error
+}
+
+// Query は、監査記録の検索条件です。指定した条件はすべて満たす必要があります。
+type Query struct {
+	Keyword string // ファイル名または説明（note）に含まれる文字列
+	User    string // 依頼者のユーザーID
+	Channel string // URLを送信したチャンネルのID
+}
+
+type dynamoStore struct {
+	client *dynamodb.Client
+	table  string
+	// shortURLIndex は、short_url をパーティションキーとするグローバルセカンダリインデックスの名前です。
+	shortURLIndex string
+	// sha256Index は、sha256 をパーティションキーとするグローバルセカンダリインデックスの名前です。
+	sha256Index string
+}
+
+func (s *dynamoStore) Put(ctx context.Context, record *Record) error {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("unable to marshal audit record, %s", err)
+	}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item:      item,
+	}); err != nil {
+		return fmt.Errorf("unable to put audit record, %s", err)
+	}
+	return nil
+}
+
+func (s *dynamoStore) ListActiveBetween(ctx context.Context, from, to time.Time) ([]*Record, error) {
+	values, err := attributevalue.MarshalMap(map[string]int64{
+		":from": from.Unix(),
+		":to":   to.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+	return s.scan(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(s.table),
+		FilterExpression:          aws.String("(created_at >= :from AND created_at < :to) OR (expires_at >= :from AND expires_at < :to)"),
+		ExpressionAttributeValues: values,
+	})
+}
+
+func (s *dynamoStore) Get(ctx context.Context, id string) (*Record, error) {
+	key, err := attributevalue.MarshalMap(map[string]string{"id": id})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal key, %s", err)
+	}
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key:       key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get audit record, %s", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	var record Record
+	if err := attributevalue.UnmarshalMap(out.Item, &record); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal audit record, %s", err)
+	}
+	return &record, nil
+}
+
+func (s *dynamoStore) FindByShortURL(ctx context.Context, shortURL string) (*Record, error) {
+	values, err := attributevalue.MarshalMap(map[string]string{":short_url": shortURL})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+	out, err := s.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		IndexName:                 aws.String(s.shortURLIndex),
+		KeyConditionExpression:    aws.String("short_url = :short_url"),
+		ExpressionAttributeValues: values,
+		Limit:                     aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to query audit records, %s", err)
+	}
+	if len(out.Items) == 0 {
+		return nil, nil
+	}
+	var record Record
+	if err := attributevalue.UnmarshalMap(out.Items[0], &record); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal audit record, %s", err)
+	}
+	return &record, nil
+}
+
+func (s *dynamoStore) FindActiveBySHA256(ctx context.Context, teamID, sum string, now time.Time) (*Record, error) {
+	values, err := attributevalue.MarshalMap(map[string]interface{}{
+		":sha256":  sum,
+		":team_id": teamID,
+		":now":     now.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+	// 無効にされたリンクや、受取人・グループを限定したリンクを返すと、その制限を経ずにダウンロードできてしまうため除く。
+	filter := "team_id = :team_id AND expires_at > :now AND attribute_not_exists(revoked_at)" +
+		" AND attribute_not_exists(recipients) AND attribute_not_exists(allowed_groups)"
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(s.table),
+		IndexName:                 aws.String(s.sha256Index),
+		KeyConditionExpression:    aws.String("sha256 = :sha256"),
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeValues: values,
+	})
+	// 同じ内容のファイルが複数回公開されている場合は、最も長く有効な記録を返す。
+	var found *Record
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query audit records, %s", err)
+		}
+		var items []*Record
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal audit records, %s", err)
+		}
+		for _, item := range items {
+			if found == nil || item.ExpiresAt > found.ExpiresAt {
+				found = item
+			}
+		}
+	}
+	return found, nil
+}
+
+func (s *dynamoStore) Search(ctx context.Context, teamID string, query *Query, now time.Time) ([]*Record, error) {
+	// 無効にされたリンクは、検索結果や「Latest build」から辿れないよう除く。
+	conditions := []string{"team_id = :team_id", "expires_at > :now", "attribute_not_exists(revoked_at)"}
+	values := map[string]interface{}{
+		":team_id": teamID,
+		":now":     now.Unix(),
+	}
+	if query.Keyword != "" {
+		conditions = append(conditions, "(contains(file_name, :keyword) OR contains(note, :keyword))")
+		values[":keyword"] = query.Keyword
+	}
+	if query.User != "" {
+		conditions = append(conditions, "#user = :user")
+		values[":user"] = query.User
+	}
+	if query.Channel != "" {
+		conditions = append(conditions, "channel = :channel")
+		values[":channel"] = query.Channel
+	}
+	expressionValues, err := attributevalue.MarshalMap(values)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(s.table),
+		FilterExpression:          aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeValues: expressionValues,
+	}
+	// user は DynamoDB の予約語のため、属性名をプレースホルダーで指定する。
+	if query.User != "" {
+		input.ExpressionAttributeNames = map[string]string{"#user": "user"}
+	}
+	return s.scan(ctx, input)
+}
+
+func (s *dynamoStore) ListByUser(ctx context.Context, teamID, user string) ([]*Record, error) {
+	values, err := attributevalue.MarshalMap(map[string]string{
+		":team_id": teamID,
+		":user":    user,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+	return s.scan(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(s.table),
+		FilterExpression:          aws.String("team_id = :team_id AND #user = :user"),
+		ExpressionAttributeNames:  map[string]string{"#user": "user"},
+		ExpressionAttributeValues: values,
+	})
+}
+
+func (s *dynamoStore) Delete(ctx context.Context, id string) error {
+	key, err := attributevalue.MarshalMap(map[string]string{"id": id})
+	if err != nil {
+		return fmt.Errorf("unable to marshal key, %s", err)
+	}
+	if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key:       key,
+	}); err != nil {
+		return fmt.Errorf("unable to delete audit record, %s", err)
+	}
+	return nil
+}
+
+func (s *dynamoStore) CountDownload(ctx context.Context, id string) error {
+	key, err := attributevalue.MarshalMap(map[string]string{"id": id})
+	if err != nil {
+		return fmt.Errorf("unable to marshal key, %s", err)
+	}
+	values, err := attributevalue.MarshalMap(map[string]int64{":one": 1})
+	if err != nil {
+		return fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+	// 削除された記録を download_count だけの項目として作り直さないよう、存在する記録のみ更新する。
+	if _, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       key,
+		UpdateExpression:          aws.String("ADD download_count :one"),
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: values,
+	}); err != nil {
+		return fmt.Errorf("unable to count download, %s", err)
+	}
+	return nil
+}
+
+func (s *dynamoStore) scan(ctx context.Context, input *dynamodb.ScanInput) ([]*Record, error) {
+	var records []*Record
+	paginator := dynamodb.NewScanPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan audit records, %s", err)
+		}
+		var items []*Record
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal audit records, %s", err)
+		}
+		records = append(records, items...)
+	}
+	return records, nil
+}
+
+func NewStore(client *dynamodb.Client, table, shortURLIndex, sha256Index string) Store {
+	return &dynamoStore{client: client, table: table, shortURLIndex: shortURLIndex, sha256Index: sha256Index}
+}
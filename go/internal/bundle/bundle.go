@@ -0,0 +1,79 @@
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/kumagai-s/uploader-v2/internal/cost"
+)
+
+// Entry は、バンドルに含める1ファイルです。
+type Entry struct {
+	Name   string
+	Size   int64
+	SHA256 string // ダウンロードしたファイルを検証するためのチェックサム（16進数）
+	URL    string // ファイルをダウンロードするURL（短縮URL）
+}
+
+// Index は、インデックスページに表示する内容です。
+type Index struct {
+	Title     string
+	Note      string
+	ExpiresAt time.Time
+	Entries   []Entry
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"humanSize": cost.HumanSize,
+}).Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Hiragino Sans", "Noto Sans JP", sans-serif; margin: 0; background: #f6f7f9; color: #1d1c1d; }
+main { max-width: 880px; margin: 40px auto; padding: 0 16px; }
+h1 { font-size: 1.4rem; margin-bottom: 4px; }
+.meta { color: #616061; font-size: .9rem; margin: 0 0 20px; }
+.note { background: #fff; border-left: 4px solid #1264a3; padding: 8px 12px; margin-bottom: 20px; }
+table { width: 100%; border-collapse: collapse; background: #fff; box-shadow: 0 1px 3px rgba(0,0,0,.08); }
+th, td { text-align: left; padding: 10px 12px; border-bottom: 1px solid #e8e8e8; vertical-align: top; }
+th { background: #fafafa; font-weight: 600; font-size: .85rem; color: #616061; }
+td.size { white-space: nowrap; }
+code { font-size: .75rem; word-break: break-all; color: #616061; }
+a { color: #1264a3; text-decoration: none; font-weight: 600; }
+a:hover { text-decoration: underline; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+<p class="meta">有効期限: {{.ExpiresAt.Format "2006-01-02 15:04 MST"}}</p>
+{{- if .Note}}
+<p class="note">{{.Note}}</p>
+{{- end}}
+<table>
+<thead><tr><th>ファイル</th><th>サイズ</th><th>SHA-256</th></tr></thead>
+<tbody>
+{{- range .Entries}}
+<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td class="size">{{humanSize .Size}}</td><td><code>{{.SHA256}}</code></td></tr>
+{{- end}}
+</tbody>
+</table>
+</main>
+</body>
+</html>
+`))
+
+// RenderIndex は、複数のファイルへのリンク、サイズ、チェックサムを並べたHTMLのインデックスページを生成します。
+func RenderIndex(index *Index) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := indexTemplate.Execute(&buf, index); err != nil {
+		return nil, fmt.Errorf("unable to render bundle index, %s", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,68 @@
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Pricing は、料金の目安を計算するための単価表です。
+type Pricing struct {
+	StoragePerGBMonth float64 `json:"storage_per_gb_month"` // 1GBを1か月保管する料金
+	EgressPerGB       float64 `json:"egress_per_gb"`        // インターネットへ1GB転送する料金
+	Currency          string  `json:"currency"`
+}
+
+// DefaultPricing は、東京リージョンの S3 Standard の料金を単価表として返します。
+func DefaultPricing() Pricing {
+	return Pricing{
+		StoragePerGBMonth: 0.025,
+		EgressPerGB:       0.114,
+		Currency:          "USD",
+	}
+}
+
+// ParsePricing は、JSON形式の単価表を解釈します。指定されなかった項目はデフォルトの単価を使用します。
+func ParsePricing(s string) (Pricing, error) {
+	p := DefaultPricing()
+	if s == "" {
+		return p, nil
+	}
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return p, fmt.Errorf("unable to unmarshal pricing table, %s", err)
+	}
+	return p, nil
+}
+
+const gb = 1024 * 1024 * 1024
+
+// Estimate は、サイズ size バイトのファイルの保管料金（1か月）と、1回のダウンロードあたりの転送料金を返します。
+func (p Pricing) Estimate(size int64) (storagePerMonth, egressPerDownload float64) {
+	gigabytes := float64(size) / gb
+	return gigabytes * p.StoragePerGBMonth, gigabytes * p.EgressPerGB
+}
+
+// FormatAmount は、金額を通貨記号付きの文字列にします。
+func (p Pricing) FormatAmount(amount float64) string {
+	switch p.Currency {
+	case "USD":
+		return fmt.Sprintf("$%.4f", amount)
+	case "JPY":
+		return fmt.Sprintf("¥%.2f", amount)
+	default:
+		return fmt.Sprintf("%.4f %s", amount, p.Currency)
+	}
+}
+
+// HumanSize は、バイト数を「1.2 MB」のような読みやすい文字列にします。
+func HumanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
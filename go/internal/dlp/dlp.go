@@ -0,0 +1,199 @@
+package dlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Likelihood は、検出結果の確度を表します。値が大きいほど確度が高くなります。
+type Likelihood int
+
+const (
+	LikelihoodUnspecified Likelihood = iota
+	VeryUnlikely
+	Unlikely
+	Possible
+	Likely
+	VeryLikely
+)
+
+var likelihoodNames = map[string]Likelihood{
+	"LIKELIHOOD_UNSPECIFIED": LikelihoodUnspecified,
+	"VERY_UNLIKELY":          VeryUnlikely,
+	"UNLIKELY":               Unlikely,
+	"POSSIBLE":               Possible,
+	"LIKELY":                 Likely,
+	"VERY_LIKELY":            VeryLikely,
+}
+
+// ParseLikelihood は、"LIKELY" のような文字列を Likelihood に変換します。
+func ParseLikelihood(s string) (Likelihood, error) {
+	l, ok := likelihoodNames[strings.ToUpper(strings.TrimSpace(s))]
+	if !ok {
+		return LikelihoodUnspecified, fmt.Errorf("unknown likelihood %q", s)
+	}
+	return l, nil
+}
+
+func (l Likelihood) String() string {
+	for name, v := range likelihoodNames {
+		if v == l {
+			return name
+		}
+	}
+	return "LIKELIHOOD_UNSPECIFIED"
+}
+
+// Finding は、検査で見つかった機密情報の1件を表します。
+type Finding struct {
+	InfoType   string
+	Likelihood Likelihood
+	Location   string // 見つかったアーカイブ内のファイル名
+}
+
+// Inspector は、テキストに含まれる機密情報（クレジットカード番号、鍵、個人情報など）を検査します。
+type Inspector interface {
+	Inspect(ctx context.Context, name string, content []byte) ([]Finding, error)
+}
+
+// Google DLP の content:inspect は1リクエストあたり0.5MBまでのため、分割して送信する。
+const maxRequestBytes = 500 * 1000
+
+var defaultInfoTypes = []string{
+	"CREDIT_CARD_NUMBER",
+	"EMAIL_ADDRESS",
+	"PHONE_NUMBER",
+	"JAPAN_INDIVIDUAL_NUMBER",
+	"PASSPORT",
+	"GCP_CREDENTIALS",
+	"AWS_CREDENTIALS",
+	"AUTH_TOKEN",
+	"ENCRYPTION_KEY",
+}
+
+type googleInspector struct {
+	client        *http.Client
+	projectID     string
+	apiKey        string
+	minLikelihood Likelihood
+	infoTypes     []string
+}
+
+type googleInfoType struct {
+	Name string `json:"name"`
+}
+
+type googleInspectRequest struct {
+	Item struct {
+		Value string `json:"value"`
+	} `json:"item"`
+	InspectConfig struct {
+		InfoTypes     []googleInfoType `json:"infoTypes"`
+		MinLikelihood string           `json:"minLikelihood"`
+	} `json:"inspectConfig"`
+}
+
+type googleInspectResponse struct {
+	Result struct {
+		Findings []struct {
+			InfoType   googleInfoType `json:"infoType"`
+			Likelihood string         `json:"likelihood"`
+		} `json:"findings"`
+	} `json:"result"`
+}
+
+func (g *googleInspector) Inspect(ctx context.Context, name string, content []byte) ([]Finding, error) {
+	var findings []Finding
+	for start := 0; start < len(content); start += maxRequestBytes {
+		end := start + maxRequestBytes
+		if end > len(content) {
+			end = len(content)
+		}
+		fs, err := g.inspectChunk(ctx, name, content[start:end])
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, fs...)
+	}
+	return findings, nil
+}
+
+func (g *googleInspector) inspectChunk(ctx context.Context, name string, chunk []byte) ([]Finding, error) {
+	var requestBody googleInspectRequest
+	requestBody.Item.Value = string(chunk)
+	requestBody.InspectConfig.MinLikelihood = g.minLikelihood.String()
+	for _, t := range g.infoTypes {
+		requestBody.InspectConfig.InfoTypes = append(requestBody.InspectConfig.InfoTypes, googleInfoType{Name: t})
+	}
+	requestBodyBytes, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal request body, %s", err)
+	}
+
+	endpoint := fmt.Sprintf(
+		"https://dlp.googleapis.com/v2/projects/%s/content:inspect?key=%s",
+		url.PathEscape(g.projectID), url.QueryEscape(g.apiKey),
+	)
+	request, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(requestBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create new request, %s", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := g.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("unable to send request, %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed with status code %d", response.StatusCode)
+	}
+
+	responseBodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response body, %s", err)
+	}
+
+	var responseBody googleInspectResponse
+	if err := json.Unmarshal(responseBodyBytes, &responseBody); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response body, %s", err)
+	}
+
+	var findings []Finding
+	for _, f := range responseBody.Result.Findings {
+		l, _ := ParseLikelihood(f.Likelihood)
+		findings = append(findings, Finding{InfoType: f.InfoType.Name, Likelihood: l, Location: name})
+	}
+	return findings, nil
+}
+
+// NewGoogleInspector は、Google Cloud DLP の content:inspect API を利用する Inspector を生成します。
+// Amazon Macie は S3 上のオブジェクトを非同期ジョブで検査する仕組みのため、
+// アップロード前の同期的な検査には Google DLP を利用します。
+func NewGoogleInspector(client *http.Client, projectID, apiKey string, minLikelihood Likelihood) Inspector {
+	return &googleInspector{
+		client:        client,
+		projectID:     projectID,
+		apiKey:        apiKey,
+		minLikelihood: minLikelihood,
+		infoTypes:     defaultInfoTypes,
+	}
+}
+
+// MaxLikelihood は、検出結果のうち最も高い確度を返します。
+func MaxLikelihood(findings []Finding) Likelihood {
+	max := LikelihoodUnspecified
+	for _, f := range findings {
+		if f.Likelihood > max {
+			max = f.Likelihood
+		}
+	}
+	return max
+}
@@ -0,0 +1,79 @@
+package egress
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// HostNotAllowedError は、許可リストにないホストへの通信を拒否したときのエラーです。
+type HostNotAllowedError struct {
+	Host string
+}
+
+func (e *HostNotAllowedError) Error() string {
+	return fmt.Sprintf("outbound request to %s is not allowed", e.Host)
+}
+
+// Allowlist は、通信を許可するホストの一覧です。
+// 「slack.com」のようなホスト名はそのホストとサブドメインに一致し、「*.amazonaws.com」はサブドメインにのみ一致します。
+type Allowlist []string
+
+// ParseAllowlist は、カンマ区切りのホストの一覧を解釈します。
+func ParseAllowlist(spec string) Allowlist {
+	var list Allowlist
+	for _, h := range strings.Split(spec, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			list = append(list, h)
+		}
+	}
+	return list
+}
+
+// Allows は、host（ポートを含んでもよい）への通信を許可するかを返します。
+func (l Allowlist) Allows(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range l {
+		if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+type transport struct {
+	next      http.RoundTripper
+	allowlist Allowlist
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.allowlist.Allows(req.URL.Host) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, &HostNotAllowedError{Host: req.URL.Host}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// Wrap は、許可リストにないホストへのリクエストを送信せずにエラーにする http.Client を返します。client 自体は変更しません。
+// リダイレクト先も同じ規則で検証します。
+func Wrap(client *http.Client, allowlist Allowlist) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = &transport{next: next, allowlist: allowlist}
+	return &wrapped
+}
@@ -0,0 +1,107 @@
+package faultinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Rule は、宛先のホストに一致するリクエストに注入する障害です。
+// 例: [{"host":"slack.com","status":500,"probability":0.1},
+//
+//	{"host":"amazonaws.com","status":503,"code":"SlowDown","probability":0.05},
+//	{"host":"short.example.com","timeout":"30s","probability":0.2}]
+type Rule struct {
+	Host        string  `json:"host"`        // リクエストのホストに含まれる文字列
+	Probability float64 `json:"probability"` // 障害を注入する確率（0〜1）
+	Status      int     `json:"status"`      // 返すステータスコード。0 の場合はタイムアウトを模擬する
+	Code        string  `json:"code"`        // S3 のようにエラーコードを返すサービス向けの <Code> の値
+	Timeout     string  `json:"timeout"`     // タイムアウトを模擬するまで待つ時間（例: 30s）。リクエストの期限が先に来た場合はそこで終了する
+}
+
+// ParseRules は、JSON の配列で指定した規則を解釈します。
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal([]byte(spec), &rules); err != nil {
+		return nil, fmt.Errorf("unable to parse fault injection rules, %s", err)
+	}
+	for _, r := range rules {
+		if r.Host == "" || r.Probability < 0 || r.Probability > 1 {
+			return nil, fmt.Errorf("invalid fault injection rule for host %q", r.Host)
+		}
+		if r.Timeout != "" {
+			if _, err := time.ParseDuration(r.Timeout); err != nil {
+				return nil, fmt.Errorf("invalid fault injection timeout %q, %s", r.Timeout, err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// timeoutError は、タイムアウトを模擬するエラーです。net.Error と同じく Timeout で true を返します。
+type timeoutError struct {
+	host string
+}
+
+func (e *timeoutError) Error() string   { return "injected timeout for " + e.host }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+type transport struct {
+	next  http.RoundTripper
+	rules []Rule
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, r := range t.rules {
+		if !strings.Contains(req.URL.Host, r.Host) || rand.Float64() >= r.Probability {
+			continue
+		}
+		log.Println("障害を注入します。", req.Method, req.URL.Host, r.Status, r.Code)
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		if r.Status == 0 {
+			d, _ := time.ParseDuration(r.Timeout)
+			select {
+			case <-time.After(d):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			return nil, &timeoutError{host: req.URL.Host}
+		}
+		body := http.StatusText(r.Status)
+		if r.Code != "" {
+			body = fmt.Sprintf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<Error><Code>%s</Code><Message>injected fault</Message></Error>", r.Code)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+			StatusCode:    r.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/xml"}, "Retry-After": {"1"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return t.next.RoundTrip(req)
+}
+
+// Wrap は、規則に従って障害を注入する http.Client を返します。client 自体は変更しません。
+// 耐障害性の検証用のため、本番環境では使用しないでください。
+func Wrap(client *http.Client, rules []Rule) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = &transport{next: next, rules: rules}
+	return &wrapped
+}
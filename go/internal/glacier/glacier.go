@@ -0,0 +1,136 @@
+package glacier
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	StateAvailable = "available" // そのままダウンロードできる
+	StateArchived  = "archived"  // アーカイブされていて、復元が必要
+	StateRestoring = "restoring" // 復元中
+)
+
+// State は、オブジェクトのストレージクラスと x-amz-restore ヘッダー（HeadObject の Restore）から、
+// ダウンロードできる状態かを返します。
+// S3 Glacier Instant Retrieval は復元せずにダウンロードできるため、StateAvailable を返します。
+func State(storageClass s3types.StorageClass, restore *string) string {
+	if storageClass != s3types.StorageClassGlacier && storageClass != s3types.StorageClassDeepArchive {
+		return StateAvailable
+	}
+	switch {
+	case restore == nil:
+		return StateArchived
+	case strings.Contains(*restore, `ongoing-request="true"`):
+		return StateRestoring
+	case strings.Contains(*restore, `ongoing-request="false"`):
+		return StateAvailable
+	}
+	return StateArchived
+}
+
+// ETA は、ストレージクラスと取り出し階層から、復元にかかるおおよその時間を返します。
+func ETA(storageClass s3types.StorageClass, tier s3types.Tier) string {
+	if storageClass == s3types.StorageClassDeepArchive {
+		if tier == s3types.TierBulk {
+			return "約48時間"
+		}
+		return "約12時間"
+	}
+	switch tier {
+	case s3types.TierExpedited:
+		return "約5分"
+	case s3types.TierBulk:
+		return "約12時間"
+	}
+	return "約3時間"
+}
+
+// ObjectID は、復元を待つ依頼をまとめるオブジェクトの識別子です。バージョンを指定した場合はバージョンごとに区別します。
+func ObjectID(bucket, key, versionID string) string {
+	id := bucket + "/" + key
+	if versionID != "" {
+		id += "#" + versionID
+	}
+	return id
+}
+
+// Waiter は、オブジェクトの復元が完了したらリンクを投稿する依頼です。
+type Waiter struct {
+	RecordID     string `dynamodbav:"record_id"` // 復元したオブジェクトの監査記録のID
+	TeamID       string `dynamodbav:"team_id"`
+	EnterpriseID string `dynamodbav:"enterprise_id,omitempty"`
+	Channel      string `dynamodbav:"channel"`
+	ThreadTS     string `dynamodbav:"thread_ts,omitempty"`
+	User         string `dynamodbav:"user"` // 復元を依頼したユーザー
+}
+
+// Store は、オブジェクトの復元の完了を待っている依頼を保存します。
+type Store interface {
+	// Add は、object の復元を待つ依頼を追加します。完了の通知が届かない場合は expiresAt に削除されます。
+	Add(ctx context.Context, object string, waiter *Waiter, expiresAt time.Time) error
+	// Take は、object の復元を待っている依頼をすべて取り出して削除します。依頼がない場合は nil を返します。
+	Take(ctx context.Context, object string) ([]*Waiter, error)
+}
+
+type dynamoStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func (s *dynamoStore) Add(ctx context.Context, object string, waiter *Waiter, expiresAt time.Time) error {
+	item, err := attributevalue.Marshal(waiter)
+	if err != nil {
+		return fmt.Errorf("unable to marshal restore waiter, %s", err)
+	}
+	if _, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.table),
+		Key:              map[string]types.AttributeValue{"object": &types.AttributeValueMemberS{Value: object}},
+		UpdateExpression: aws.String("SET waiters = list_append(if_not_exists(waiters, :empty), :waiter), #ttl = :ttl"),
+		ExpressionAttributeNames: map[string]string{
+			"#ttl": "ttl",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty":  &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":waiter": &types.AttributeValueMemberL{Value: []types.AttributeValue{item}},
+			":ttl":    &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to add restore waiter, %s", err)
+	}
+	return nil
+}
+
+func (s *dynamoStore) Take(ctx context.Context, object string) ([]*Waiter, error) {
+	out, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:    aws.String(s.table),
+		Key:          map[string]types.AttributeValue{"object": &types.AttributeValueMemberS{Value: object}},
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to take restore waiters, %s", err)
+	}
+	if out.Attributes == nil {
+		return nil, nil
+	}
+	var waiters []*Waiter
+	if err := attributevalue.Unmarshal(out.Attributes["waiters"], &waiters); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal restore waiters, %s", err)
+	}
+	return waiters, nil
+}
+
+// NewStore は、DynamoDB のテーブル table に依頼を保存する Store を返します。
+// テーブルは文字列のパーティションキー「object」を持ち、「ttl」属性で TTL を有効にしてください。
+func NewStore(client *dynamodb.Client, table string) Store {
+	return &dynamoStore{client: client, table: table}
+}
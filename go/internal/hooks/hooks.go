@@ -0,0 +1,105 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	lambdaservice "github.com/aws/aws-sdk-go-v2/service/lambda"
+)
+
+// フックを実行する段階です。
+const (
+	StageAfterValidate = "after-validate" // ファイルの検証が完了した後
+	StageAfterUpload   = "after-upload"   // S3へのアップロードが完了した後
+	StageAfterPublish  = "after-publish"  // 短縮URLを依頼者に送信した後
+)
+
+// Event は、フックに渡すファイルの情報です。段階によっては未確定の項目は空になります。
+type Event struct {
+	Stage        string `json:"stage"`
+	TeamID       string `json:"team_id"`
+	EnterpriseID string `json:"enterprise_id,omitempty"`
+	Channel      string `json:"channel"`
+	User         string `json:"user"`
+	FileName     string `json:"file_name"`
+	Size         int64  `json:"size"`
+	Bucket       string `json:"bucket,omitempty"`
+	ObjectKey    string `json:"object_key,omitempty"`
+	VersionID    string `json:"version_id,omitempty"`
+	ShortURL     string `json:"short_url,omitempty"`
+	ExpiresAt    int64  `json:"expires_at,omitempty"` // UNIX時間（秒）
+	Note         string `json:"note,omitempty"`
+}
+
+// Hook は、処理の各段階でファイルの情報を外部に通知します。
+type Hook interface {
+	// Run は、フックを実行します。
+	Run(ctx context.Context, ev *Event) error
+}
+
+// lambdaHook は、Lambda 関数を同期的に呼び出すフックです。
+type lambdaHook struct {
+	client   *lambdaservice.Client
+	function string
+}
+
+func (h *lambdaHook) Run(ctx context.Context, ev *Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("unable to marshal hook event, %s", err)
+	}
+	out, err := h.client.Invoke(ctx, &lambdaservice.InvokeInput{
+		FunctionName: aws.String(h.function),
+		Payload:      payload,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to invoke hook function, %s", err)
+	}
+	if out.FunctionError != nil {
+		return fmt.Errorf("hook function failed, %s: %s", aws.ToString(out.FunctionError), out.Payload)
+	}
+	return nil
+}
+
+// webhook は、JSON を POST する Webhook のフックです。
+type webhook struct {
+	client *http.Client
+	url    string
+}
+
+func (h *webhook) Run(ctx context.Context, ev *Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("unable to marshal hook event, %s", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("unable to create new request, %s", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := h.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to send request, %s", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("request failed with status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// New は、target に応じたフックを生成します。
+// target が「http://」または「https://」で始まる場合は Webhook、それ以外は Lambda の関数名またはARNとして扱います。
+func New(target string, httpClient *http.Client, lambdaClient *lambdaservice.Client) Hook {
+	if strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://") {
+		return &webhook{client: httpClient, url: target}
+	}
+	return &lambdaHook{client: lambdaClient, function: target}
+}
@@ -0,0 +1,79 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config は、外部サービスとの通信に使う http.Client の設定です。
+type Config struct {
+	Timeout               time.Duration // リクエスト全体のタイムアウト。0の場合は無制限（大きなファイルの転送用）
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	DisableKeepAlives     bool
+	TLSConfig             *tls.Config
+}
+
+// ConfigFromEnv は、環境変数 HTTP_CLIENT_* から設定を読み込みます。未設定の項目はデフォルト値を使用します。
+func ConfigFromEnv() Config {
+	return Config{
+		Timeout:               durationFromEnv("HTTP_CLIENT_TIMEOUT", 0),
+		DialTimeout:           durationFromEnv("HTTP_CLIENT_DIAL_TIMEOUT", 10*time.Second),
+		TLSHandshakeTimeout:   durationFromEnv("HTTP_CLIENT_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		ResponseHeaderTimeout: durationFromEnv("HTTP_CLIENT_RESPONSE_HEADER_TIMEOUT", 30*time.Second),
+		IdleConnTimeout:       durationFromEnv("HTTP_CLIENT_IDLE_CONN_TIMEOUT", 90*time.Second),
+		MaxIdleConns:          intFromEnv("HTTP_CLIENT_MAX_IDLE_CONNS", 100),
+		MaxIdleConnsPerHost:   intFromEnv("HTTP_CLIENT_MAX_IDLE_CONNS_PER_HOST", 10),
+		DisableKeepAlives:     os.Getenv("HTTP_CLIENT_DISABLE_KEEP_ALIVES") == "true",
+	}
+}
+
+// New は、設定に従ってコネクションを使い回す http.Client を生成します。
+// プロキシは環境変数 HTTPS_PROXY / HTTP_PROXY / NO_PROXY に従います。
+func New(cfg Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       cfg.TLSConfig,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+	}
+}
+
+func durationFromEnv(key string, defaultValue time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return d
+}
+
+func intFromEnv(key string, defaultValue int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
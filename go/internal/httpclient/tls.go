@@ -0,0 +1,43 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// TLSMaterial は、相互TLS（mTLS）と独自の認証局のためのPEM形式の証明書一式です。
+// Secrets Manager には、このJSON形式で保存します。
+type TLSMaterial struct {
+	Cert string `json:"cert"` // クライアント証明書
+	Key  string `json:"key"`  // クライアント証明書の秘密鍵
+	CA   string `json:"ca"`   // 接続先のサーバー証明書を検証する認証局の証明書（複数可）
+}
+
+// TLSConfig は、証明書一式から tls.Config を生成します。
+// CA を指定した場合は、システムの認証局に加えて指定した認証局を信頼します。
+func (m TLSMaterial) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if m.Cert != "" || m.Key != "" {
+		cert, err := tls.X509KeyPair([]byte(m.Cert), []byte(m.Key))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate, %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if m.CA != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(m.CA)) {
+			return nil, errors.New("unable to parse ca bundle")
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
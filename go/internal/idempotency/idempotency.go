@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	statusInProgress = "in_progress"
+	statusCompleted  = "completed"
+)
+
+// Store は、同じイベントを重複して処理しないよう、イベントごとの処理状況を記録します。
+type Store interface {
+	// Acquire は、key のイベントの処理を始めてよいかを返します。
+	// 処理を終えたイベントや、他の実行が処理中（リースの期限内）のイベントには false を返します。
+	// 処理中のままリースの期限が過ぎたイベントは、途中で失敗したものとみなして再度 true を返します。
+	Acquire(ctx context.Context, key string) (bool, error)
+	// Complete は、key のイベントの処理を終えたことを記録します。
+	Complete(ctx context.Context, key string) error
+}
+
+type dynamoStore struct {
+	client    *dynamodb.Client
+	table     string
+	lease     time.Duration
+	retention time.Duration
+}
+
+func (s *dynamoStore) Acquire(ctx context.Context, key string) (bool, error) {
+	now := time.Now()
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			"key":         &types.AttributeValueMemberS{Value: key},
+			"status":      &types.AttributeValueMemberS{Value: statusInProgress},
+			"lease_until": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(s.lease).Unix(), 10)},
+			"ttl":         &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(s.retention).Unix(), 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR (#status = :in_progress AND lease_until < :now)"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":    "key",
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":in_progress": &types.AttributeValueMemberS{Value: statusInProgress},
+			":now":         &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to acquire idempotency key, %s", err)
+	}
+	return true, nil
+}
+
+func (s *dynamoStore) Complete(ctx context.Context, key string) error {
+	if _, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(s.table),
+		Key:              map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: key}},
+		UpdateExpression: aws.String("SET #status = :completed"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":completed": &types.AttributeValueMemberS{Value: statusCompleted},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to complete idempotency key, %s", err)
+	}
+	return nil
+}
+
+// NewStore は、DynamoDB のテーブル table に処理状況を記録する Store を返します。
+// lease は1回の処理にかかる最大の時間（Lambda のタイムアウト）、retention は記録を残す期間です。
+// テーブルは文字列のパーティションキー「key」を持ち、「ttl」属性で TTL を有効にしてください。
+func NewStore(client *dynamodb.Client, table string, lease, retention time.Duration) Store {
+	return &dynamoStore{client: client, table: table, lease: lease, retention: retention}
+}
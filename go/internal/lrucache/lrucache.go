@@ -0,0 +1,91 @@
+package lrucache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache は、件数の上限と有効期限のあるメモリ上のキャッシュです。
+// 上限を超えると、最も長く使われていない値から削除します。Lambdaのウォームスタート間で共有されます。
+type Cache interface {
+	// Get は、key の値を返します。ない場合や有効期限が過ぎた場合は false を返します。
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Remove(key string)
+	Len() int
+}
+
+type entry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // 先頭が最も新しく使われた値
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *lruCache) Set(key string, value interface{}) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expiresAt = value, expiresAt
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *lruCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// New は、最大 size 件の値を ttl の間保持する Cache を生成します。size が 0 以下の場合は何も保持しません。
+func New(size int, ttl time.Duration) Cache {
+	return &lruCache{size: size, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}, now: time.Now}
+}
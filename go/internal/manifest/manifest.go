@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// Manifest は、公開したファイルの来歴を受け取った人が検証するための情報です。
+type Manifest struct {
+	FileName  string `json:"filename"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	ExpiresAt string `json:"expires_at"` // 署名付きURLの有効期限（RFC 3339）
+	Requester string `json:"requester"`  // URLの発行を依頼したSlackのユーザーID
+	TeamID    string `json:"team_id"`
+	IssuedAt  string `json:"issued_at"` // マニフェストを発行した日時（RFC 3339）
+}
+
+// Signature は、マニフェストの署名です。
+type Signature struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"` // 署名（Base64）
+}
+
+// Signed は、manifest.json として公開する署名付きのマニフェストです。
+// 署名の対象は、manifest の値としてファイルに書かれているバイト列そのものです。
+// 検証する場合は、manifest を再度エンコードせずにそのまま KMS の公開鍵で検証してください。
+type Signed struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature Signature       `json:"signature"`
+}
+
+// Signer は、マニフェストに署名します。
+type Signer interface {
+	// Sign は、マニフェストに署名し、manifest.json の内容を返します。
+	Sign(ctx context.Context, m *Manifest) ([]byte, error)
+}
+
+// kmsSigner は、KMS の非対称鍵で署名する Signer の実装です。
+type kmsSigner struct {
+	client    *kms.Client
+	keyID     string
+	algorithm kmstypes.SigningAlgorithmSpec
+}
+
+func (s *kmsSigner) Sign(ctx context.Context, m *Manifest) ([]byte, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal manifest, %s", err)
+	}
+	out, err := s.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		Message:          payload,
+		MessageType:      kmstypes.MessageTypeRaw,
+		SigningAlgorithm: s.algorithm,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign manifest, %s", err)
+	}
+
+	// 整形すると manifest のバイト列が変わり署名を検証できなくなるため、整形せずに出力する。
+	signed, err := json.Marshal(&Signed{
+		Manifest: payload,
+		Signature: Signature{
+			KeyID:     aws.ToString(out.KeyId),
+			Algorithm: string(out.SigningAlgorithm),
+			Value:     base64.StdEncoding.EncodeToString(out.Signature),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal signed manifest, %s", err)
+	}
+	return signed, nil
+}
+
+// NewSigner は、KMS の非対称鍵で署名する Signer を生成します。
+// algorithm: KMS の署名アルゴリズム（例: ECDSA_SHA_256、RSASSA_PSS_SHA_256）
+func NewSigner(client *kms.Client, keyID, algorithm string) Signer {
+	return &kmsSigner{client: client, keyID: keyID, algorithm: kmstypes.SigningAlgorithmSpec(algorithm)}
+}
@@ -0,0 +1,238 @@
+package membudget
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	mb = 1024 * 1024
+
+	// minPartSize は、S3 のマルチパートアップロードで使えるパートの最小サイズです。
+	minPartSize = 5 * mb
+	maxPartSize = 64 * mb
+)
+
+// Budget は、ファイルの処理に使えるメモリの量を表します。
+type Budget struct {
+	Total    int64  // 関数に割り当てられたメモリ
+	Limit    int64  // ファイルの保持に使ってよいメモリ
+	SpillDir string // メモリに収まらないファイルを書き出すディレクトリ
+	// AlwaysSpill が true の場合は、予算に関わらずファイルを一時ファイルに書き出します。
+	AlwaysSpill bool
+}
+
+// FromEnv は、Lambda に割り当てられたメモリ（AWS_LAMBDA_FUNCTION_MEMORY_SIZE）から予算を求めます。
+// ランタイムや検査の処理にも使うため、ファイルの保持には MEMORY_BUDGET_PERCENT（デフォルト 50）% までを使います。
+// Lambda 以外で実行する場合は、MEMORY_BUDGET_MB（デフォルト 1024）を割り当てられたメモリとみなします。
+// SPILL_TO_TMP=true の場合は、すべてのファイルを SPILL_DIR（デフォルトは /tmp）の一時ファイルに書き出します。
+func FromEnv() *Budget {
+	total := envInt("AWS_LAMBDA_FUNCTION_MEMORY_SIZE", 0)
+	if total <= 0 {
+		total = envInt("MEMORY_BUDGET_MB", 1024)
+	}
+	percent := envInt("MEMORY_BUDGET_PERCENT", 50)
+	if percent <= 0 || percent > 100 {
+		percent = 50
+	}
+	spillDir := os.Getenv("SPILL_DIR")
+	if spillDir == "" {
+		spillDir = os.TempDir()
+	}
+	return &Budget{
+		Total:       total * mb,
+		Limit:       total * mb * percent / 100,
+		SpillDir:    spillDir,
+		AlwaysSpill: os.Getenv("SPILL_TO_TMP") == "true",
+	}
+}
+
+// Fits は、size バイトのファイルをメモリに保持できるかを返します。
+func (b *Budget) Fits(size int64) bool {
+	return size <= b.Limit
+}
+
+// PartSize は、concurrency 件の範囲を並列に転送するときの1件あたりのサイズを返します。
+// 送受信のバッファを考慮して予算の半分を並列数で割り、5MB から 64MB の範囲に収めます。
+func (b *Budget) PartSize(concurrency int) int64 {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	size := b.Limit / 2 / int64(concurrency)
+	size -= size % mb
+	if size < minPartSize {
+		return minPartSize
+	}
+	if size > maxPartSize {
+		return maxPartSize
+	}
+	return size
+}
+
+// Buffer は、ダウンロードしたファイルを保持するバッファです。
+// 予算に収まらないファイルは SpillDir の一時ファイルに書き出されます。
+type Buffer interface {
+	io.Writer
+	io.WriterAt
+	// Truncate は、書き込み済みの内容を n バイトまでに切り詰めます。
+	Truncate(n int)
+	// Bytes は、書き込んだ内容を返します。一時ファイルに書き出した場合は、ファイルをメモリにマップして返します。
+	Bytes() ([]byte, error)
+	// Spilled は、一時ファイルに書き出しているかを返します。
+	Spilled() bool
+	// Close は、一時ファイルやマップした領域を解放します。Bytes で返した内容は使えなくなります。
+	Close() error
+}
+
+// NewBuffer は、size バイトのファイルを保持するバッファを返します。
+// size が予算に収まる場合はメモリに、収まらない場合は一時ファイルに保持します。
+// 一時ファイルに書き出す場合は、エフェメラルストレージの空き容量が足りるかを先に確認します。
+func (b *Budget) NewBuffer(size int64) (Buffer, error) {
+	if !b.AlwaysSpill && b.Fits(size) {
+		return newMemoryBuffer(size), nil
+	}
+	free, err := freeSpace(b.SpillDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get free space of %s, %s", b.SpillDir, err)
+	}
+	if size > free {
+		return nil, &InsufficientStorageError{Size: size, Free: free}
+	}
+	return NewFileBuffer(b.SpillDir)
+}
+
+// InsufficientStorageError は、ファイルを書き出すのにエフェメラルストレージの空き容量が足りない場合のエラーです。
+type InsufficientStorageError struct {
+	Size int64
+	Free int64
+}
+
+func (e *InsufficientStorageError) Error() string {
+	return fmt.Sprintf("insufficient ephemeral storage, need %d bytes but %d bytes free", e.Size, e.Free)
+}
+
+type memoryBuffer struct {
+	buf []byte
+	off int
+}
+
+func newMemoryBuffer(size int64) *memoryBuffer {
+	return &memoryBuffer{buf: make([]byte, 0, size)}
+}
+
+func (m *memoryBuffer) Write(p []byte) (int, error) {
+	n, err := m.WriteAt(p, int64(m.off))
+	m.off += n
+	return n, err
+}
+
+func (m *memoryBuffer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	end := int(off) + len(p)
+	if end > cap(m.buf) {
+		grown := make([]byte, len(m.buf), end*2)
+		copy(grown, m.buf)
+		m.buf = grown
+	}
+	if end > len(m.buf) {
+		m.buf = m.buf[:end]
+	}
+	copy(m.buf[off:], p)
+	return len(p), nil
+}
+
+func (m *memoryBuffer) Truncate(n int) {
+	m.buf = m.buf[:n]
+	m.off = n
+}
+
+func (m *memoryBuffer) Bytes() ([]byte, error) {
+	return m.buf, nil
+}
+
+func (m *memoryBuffer) Spilled() bool {
+	return false
+}
+
+func (m *memoryBuffer) Close() error {
+	m.buf = nil
+	return nil
+}
+
+type fileBuffer struct {
+	f      *os.File
+	off    int64
+	mapped []byte
+}
+
+// NewFileBuffer は、dir に作成した一時ファイルに書き込むバッファを返します。
+func NewFileBuffer(dir string) (Buffer, error) {
+	f, err := os.CreateTemp(dir, "spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temporary file, %s", err)
+	}
+	return &fileBuffer{f: f}, nil
+}
+
+func (fb *fileBuffer) Write(p []byte) (int, error) {
+	n, err := fb.f.WriteAt(p, fb.off)
+	fb.off += int64(n)
+	return n, err
+}
+
+func (fb *fileBuffer) WriteAt(p []byte, off int64) (int, error) {
+	return fb.f.WriteAt(p, off)
+}
+
+func (fb *fileBuffer) Truncate(n int) {
+	if err := fb.f.Truncate(int64(n)); err == nil {
+		fb.off = int64(n)
+	}
+}
+
+func (fb *fileBuffer) Bytes() ([]byte, error) {
+	if fb.mapped != nil {
+		return fb.mapped, nil
+	}
+	info, err := fb.f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat temporary file, %s", err)
+	}
+	if info.Size() == 0 {
+		return []byte{}, nil
+	}
+	mapped, err := mapFile(fb.f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("unable to map temporary file, %s", err)
+	}
+	fb.mapped = mapped
+	return mapped, nil
+}
+
+func (fb *fileBuffer) Spilled() bool {
+	return true
+}
+
+func (fb *fileBuffer) Close() error {
+	if fb.mapped != nil {
+		if err := unmapFile(fb.mapped); err != nil {
+			return fmt.Errorf("unable to unmap temporary file, %s", err)
+		}
+		fb.mapped = nil
+	}
+	fb.f.Close()
+	return os.Remove(fb.f.Name())
+}
+
+func envInt(key string, defaultValue int64) int64 {
+	n, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
@@ -0,0 +1,113 @@
+package membudget
+
+import (
+	"bytes"
+	"testing"
+)
+
+// benchmarkSizes は、ベンチマークで保持するファイルのサイズです。
+var benchmarkSizes = []struct {
+	name string
+	size int64
+}{
+	{"10MB", 10 << 20},
+	{"100MB", 100 << 20},
+	{"1GB", 1 << 30},
+}
+
+// chunk は、HTTPのレスポンスボディから io.Copy で書き込む単位（32KB）の内容です。
+var chunk = bytes.Repeat([]byte{0xA5}, 32<<10)
+
+// fill は、size バイトになるまで chunk を buf に書き込みます。
+func fill(tb testing.TB, buf Buffer, size int64) {
+	tb.Helper()
+	for written := int64(0); written < size; written += int64(len(chunk)) {
+		p := chunk
+		if size-written < int64(len(p)) {
+			p = p[:size-written]
+		}
+		if _, err := buf.Write(p); err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
+
+func TestNewBufferSpillsOverBudget(t *testing.T) {
+	budget := &Budget{Limit: 1 << 20, SpillDir: t.TempDir()}
+	for _, tt := range []struct {
+		size    int64
+		spilled bool
+	}{{1 << 20, false}, {1<<20 + 1, true}} {
+		buf, err := budget.NewBuffer(tt.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fill(t, buf, tt.size)
+		b, err := buf.Bytes()
+		if err != nil || int64(len(b)) != tt.size || buf.Spilled() != tt.spilled {
+			t.Errorf("NewBuffer(%d) = %d bytes, spilled=%v, %v", tt.size, len(b), buf.Spilled(), err)
+		}
+		buf.Close()
+	}
+}
+
+func TestPartSize(t *testing.T) {
+	for _, tt := range []struct {
+		limit       int64
+		concurrency int
+		want        int64
+	}{
+		{512 * mb, 4, maxPartSize},
+		{128 * mb, 4, 16 * mb},
+		{16 * mb, 4, minPartSize},
+		{512 * mb, 0, maxPartSize},
+	} {
+		if got := (&Budget{Limit: tt.limit}).PartSize(tt.concurrency); got != tt.want {
+			t.Errorf("PartSize(%d) with limit %d = %d, want %d", tt.concurrency, tt.limit, got, tt.want)
+		}
+	}
+}
+
+// benchmarkBuffer は、budget で size バイトのファイルを受信して内容を取り出すまでを計測します。
+func benchmarkBuffer(b *testing.B, budget *Budget) {
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			b.SetBytes(bs.size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf, err := budget.NewBuffer(bs.size)
+				if err != nil {
+					b.Fatal(err)
+				}
+				fill(b, buf, bs.size)
+				if _, err := buf.Bytes(); err != nil {
+					b.Fatal(err)
+				}
+				buf.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkMemoryBuffer(b *testing.B) {
+	benchmarkBuffer(b, &Budget{Limit: 2 << 30})
+}
+
+func BenchmarkFileBuffer(b *testing.B) {
+	benchmarkBuffer(b, &Budget{SpillDir: b.TempDir(), AlwaysSpill: true})
+}
+
+// BenchmarkMemoryBufferUnknownSize は、サイズが分からず容量を確保せずに書き込んだ場合の再確保の量を計測します。
+func BenchmarkMemoryBufferUnknownSize(b *testing.B) {
+	for _, bs := range benchmarkSizes[:2] {
+		b.Run(bs.name, func(b *testing.B) {
+			b.SetBytes(bs.size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf := newMemoryBuffer(0)
+				fill(b, buf, bs.size)
+				buf.Close()
+			}
+		})
+	}
+}
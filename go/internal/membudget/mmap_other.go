@@ -0,0 +1,27 @@
+//go:build !unix
+
+package membudget
+
+import (
+	"io"
+	"math"
+	"os"
+)
+
+// mapFile は、メモリマップを使えない環境ではファイルの内容を読み込んで返します。
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, size), b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func unmapFile(b []byte) error {
+	return nil
+}
+
+// freeSpace は、空き容量を取得できない環境では上限なしとして扱います。
+func freeSpace(dir string) (int64, error) {
+	return math.MaxInt64, nil
+}
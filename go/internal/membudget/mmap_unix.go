@@ -0,0 +1,27 @@
+//go:build unix
+
+package membudget
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile は、ファイルを読み取り専用でメモリにマップします。
+// マップした領域はページキャッシュとして扱われるため、メモリが不足すると必要に応じてディスクから読み直されます。
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func unmapFile(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// freeSpace は、dir があるファイルシステムの空き容量を返します。
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_requests_total",
+		Help: "Number of requests handled, by response status code.",
+	}, []string{"status"})
+
+	stageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "uploader_stage_duration_seconds",
+		Help:    "Processing duration of each pipeline stage.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"stage"})
+
+	bytesTransferred = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_bytes_transferred_total",
+		Help: "Bytes downloaded from Slack and uploaded to S3.",
+	}, []string{"direction"})
+
+	verificationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_verification_failures_total",
+		Help: "Number of requests rejected by signature verification, by reason.",
+	}, []string{"reason"})
+
+	tokenHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "uploader_slack_token_healthy",
+		Help: "Whether the Slack token passed auth.test (1) or was revoked or expired (0).",
+	}, []string{"token"})
+
+	bucketPrivate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "uploader_bucket_private",
+		Help: "Whether the bucket blocks all public access and has no public policy (1) or not (0).",
+	}, []string{"bucket"})
+
+	policyViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_policy_violations_total",
+		Help: "Number of policy violations, by rule and whether the rule was enforced or log-only.",
+	}, []string{"rule", "mode"})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_errors_total",
+		Help: "Number of failed requests, by error class.",
+	}, []string{"class"})
+
+	panicsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_panics_total",
+		Help: "Number of panics recovered, by handler.",
+	}, []string{"handler"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "uploader_cache_lookups_total",
+		Help: "Number of cache lookups, by cache name and result (hit or miss).",
+	}, []string{"cache", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, stageDuration, bytesTransferred, verificationFailures, tokenHealthy, bucketPrivate, policyViolations, errorsTotal, panicsTotal, cacheLookups)
+}
+
+// ObserveRequest は、処理したリクエストをレスポンスのステータスコードごとに数えます。
+func ObserveRequest(status string) {
+	requestsTotal.WithLabelValues(status).Inc()
+}
+
+// ObserveStage は、start から現在までを処理段階 stage の所要時間として記録します。
+func ObserveStage(stage string, start time.Time) {
+	stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// AddBytes は、転送したバイト数を方向（"download" または "upload"）ごとに加算します。
+func AddBytes(direction string, n int) {
+	bytesTransferred.WithLabelValues(direction).Add(float64(n))
+}
+
+// ObserveVerificationFailure は、署名の検証で拒否したリクエストを理由ごとに数えます。
+func ObserveVerificationFailure(reason string) {
+	verificationFailures.WithLabelValues(reason).Inc()
+}
+
+// ObserveError は、処理に失敗したリクエストをエラーの分類ごとに数えます。
+func ObserveError(class string) {
+	errorsTotal.WithLabelValues(class).Inc()
+}
+
+// ObservePanic は、ハンドラー handler で回復したパニックを数えます。
+func ObservePanic(handler string) {
+	panicsTotal.WithLabelValues(handler).Inc()
+}
+
+// ObserveCache は、キャッシュ name の参照を、値があったかどうかごとに数えます。
+func ObserveCache(name string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(name, result).Inc()
+}
+
+// SetTokenHealth は、Slackのトークン token が有効かどうかを記録します。
+func SetTokenHealth(token string, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	tokenHealthy.WithLabelValues(token).Set(v)
+}
+
+// SetBucketPrivate は、バケット bucket が公開されていないことを確認できたかどうかを記録します。
+func SetBucketPrivate(bucket string, private bool) {
+	v := 0.0
+	if private {
+		v = 1
+	}
+	bucketPrivate.WithLabelValues(bucket).Set(v)
+}
+
+// ObservePolicyViolation は、規則 rule の違反を、拒否した（enforced）かログのみ（log_only）かごとに数えます。
+func ObservePolicyViolation(rule, mode string) {
+	policyViolations.WithLabelValues(rule, mode).Inc()
+}
+
+// Handler は、Prometheus 形式でメトリクスを返す http.Handler を返します。
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
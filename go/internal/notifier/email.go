@@ -0,0 +1,58 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPConfig は、メールを送信するSMTPサーバーの設定です。
+// Amazon SES の場合、Addr は「email-smtp.ap-northeast-1.amazonaws.com:587」のように指定し、SMTP認証情報を Username と Password に設定します。
+type SMTPConfig struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// email は、メールを送る通知先です。
+type email struct {
+	config SMTPConfig
+	to     string
+}
+
+func (n *email) Notify(ctx context.Context, msg *Message) error {
+	text := msg.Text
+	if msg.Note != "" {
+		text += "\n\n" + msg.Note
+	}
+	return SendMail(n.config, n.to, msg.Subject, text)
+}
+
+// NewEmail は、to にメールを送る Notifier を生成します。
+func NewEmail(config SMTPConfig, to string) Notifier {
+	return &email{config: config, to: to}
+}
+
+// SendMail は、config.From から to にテキストのメールを送ります。
+func SendMail(config SMTPConfig, to, subject, text string) error {
+	if config.Addr == "" || config.From == "" {
+		return fmt.Errorf("smtp address and sender are required")
+	}
+	host, _, _ := strings.Cut(config.Addr, ":")
+	auth := smtp.PlainAuth("", config.Username, config.Password, host)
+
+	message := "From: " + config.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.BEncoding.Encode("UTF-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" + text + "\r\n"
+	if err := smtp.SendMail(config.Addr, auth, config.From, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("unable to send mail to %s, %s", to, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Message は、通知する内容です。
+type Message struct {
+	Subject string `json:"subject"`        // メールの件名など、件名を持つ通知先で使う見出し
+	Text    string `json:"text"`           // 本文（Slackのmrkdwn）
+	Note    string `json:"note,omitempty"` // 依頼者が添えた説明
+}
+
+// Notifier は、URLの発行などを通知先に送ります。
+type Notifier interface {
+	// Notify は、メッセージを送信します。
+	Notify(ctx context.Context, msg *Message) error
+}
+
+// multi は、複数の通知先に順に送信します。
+type multi []Notifier
+
+func (m multi) Notify(ctx context.Context, msg *Message) error {
+	var errs []string
+	for _, n := range m {
+		if err := n.Notify(ctx, msg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to notify, %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Multi は、すべての通知先に送信する Notifier を返します。
+// 送信に失敗した通知先があっても残りの通知先には送信し、失敗をまとめたエラーを返します。
+func Multi(notifiers ...Notifier) Notifier {
+	return multi(notifiers)
+}
@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// slackThread は、チャンネル（またはそのスレッド）に投稿する通知先です。
+type slackThread struct {
+	client   *slack.Client
+	channel  string
+	threadTS string
+}
+
+func (n *slackThread) Notify(ctx context.Context, msg *Message) error {
+	options := SlackMessageOptions(msg.Text, msg.Note)
+	if n.threadTS != "" {
+		options = append(options, slack.MsgOptionTS(n.threadTS))
+	}
+	if _, _, err := n.client.PostMessageContext(ctx, n.channel, options...); err != nil {
+		return fmt.Errorf("unable to post message to %s, %s", n.channel, err)
+	}
+	return nil
+}
+
+// NewSlackThread は、channel に投稿する Notifier を生成します。threadTS を指定した場合はそのスレッドに返信します。
+func NewSlackThread(client *slack.Client, channel, threadTS string) Notifier {
+	return &slackThread{client: client, channel: channel, threadTS: threadTS}
+}
+
+// slackDM は、ユーザーにDMを送る通知先です。
+type slackDM struct {
+	client *slack.Client
+	user   string
+}
+
+func (n *slackDM) Notify(ctx context.Context, msg *Message) error {
+	dm, _, _, err := n.client.OpenConversationContext(ctx, &slack.OpenConversationParameters{Users: []string{n.user}})
+	if err != nil {
+		return fmt.Errorf("unable to open conversation with %s, %s", n.user, err)
+	}
+	return (&slackThread{client: n.client, channel: dm.ID}).Notify(ctx, msg)
+}
+
+// NewSlackDM は、user にDMを送る Notifier を生成します。
+func NewSlackDM(client *slack.Client, user string) Notifier {
+	return &slackDM{client: client, user: user}
+}
+
+// SlackMessageOptions は、Slackに投稿するメッセージの送信オプションを返します。
+// note が指定された場合は、メッセージの下に説明を表示するブロックを追加します。
+func SlackMessageOptions(text, note string) []slack.MsgOption {
+	options := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if note == "" {
+		return options
+	}
+	return append(options, slack.MsgOptionBlocks(
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		slack.NewContextBlock("note", slack.NewTextBlockObject(slack.MarkdownType, ":memo: "+escapeMrkdwn(note), false, false)),
+	))
+}
+
+func escapeMrkdwn(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
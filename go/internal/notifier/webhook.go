@@ -0,0 +1,58 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// webhook は、JSON を POST する通知先です。
+type webhook struct {
+	client *http.Client
+	url    string
+	teams  bool
+}
+
+func (n *webhook) Notify(ctx context.Context, msg *Message) error {
+	var body interface{} = msg
+	if n.teams {
+		// Microsoft Teams の Incoming Webhook は、text を Markdown として表示する。
+		text := msg.Text
+		if msg.Note != "" {
+			text += "\n\n> " + msg.Note
+		}
+		body = map[string]string{"title": msg.Subject, "text": text}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("unable to marshal notification, %s", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("unable to create new request, %s", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := n.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to send request, %s", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("request failed with status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// NewWebhook は、メッセージを {"subject":...,"text":...,"note":...} の JSON で url に POST する Notifier を生成します。
+func NewWebhook(client *http.Client, url string) Notifier {
+	return &webhook{client: client, url: url}
+}
+
+// NewTeams は、Microsoft Teams の Incoming Webhook の url に投稿する Notifier を生成します。
+func NewTeams(client *http.Client, url string) Notifier {
+	return &webhook{client: client, url: url, teams: true}
+}
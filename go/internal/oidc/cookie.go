@@ -0,0 +1,46 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EncodeCookie は、v をJSONにして HMAC-SHA256 で署名し、Cookie に保存できる文字列にします。
+func EncodeCookie(secret []byte, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal cookie, %s", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload)), nil
+}
+
+// DecodeCookie は、EncodeCookie で作成した文字列の署名を検証し、v に取り出します。
+func DecodeCookie(secret []byte, s string, v interface{}) error {
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return fmt.Errorf("malformed cookie")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, sign(secret, payload)) {
+		return fmt.Errorf("invalid cookie signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return fmt.Errorf("unable to decode cookie, %s", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unable to unmarshal cookie, %s", err)
+	}
+	return nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,269 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config は、IdP に登録したクライアントの設定です。
+type Config struct {
+	Issuer       string // 例: https://login.example.com
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // 認可コードを受け取るURL
+	GroupsClaim  string // グループを含むIDトークンのクレーム。空の場合は "groups"
+}
+
+// Claims は、検証済みのIDトークンから取り出したユーザーの情報です。
+type Claims struct {
+	Subject string
+	Email   string
+	Groups  []string
+}
+
+// Provider は、OpenID Connect の認可コードフローでユーザーを認証します。
+type Provider interface {
+	// AuthCodeURL は、ユーザーをリダイレクトするIdPの認可エンドポイントのURLを返します。
+	AuthCodeURL(state, nonce string) string
+	// Exchange は、認可コードをIDトークンと交換し、署名、発行者、対象、有効期限、nonce を検証します。
+	Exchange(ctx context.Context, code, nonce string, now time.Time) (*Claims, error)
+}
+
+type discovery struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type provider struct {
+	client    *http.Client
+	config    Config
+	endpoints discovery
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+func (p *provider) AuthCodeURL(state, nonce string) string {
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.config.ClientID},
+		"redirect_uri":  {p.config.RedirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(p.endpoints.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.endpoints.AuthorizationEndpoint + sep + v.Encode()
+}
+
+func (p *provider) Exchange(ctx context.Context, code, nonce string, now time.Time) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create token request, %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return nil, fmt.Errorf("unable to exchange code, %s", err)
+	}
+	if token.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	return p.verify(ctx, token.IDToken, nonce, now)
+}
+
+// verify は、RS256 で署名されたIDトークンを検証してクレームを返します。
+func (p *provider) verify(ctx context.Context, idToken, nonce string, now time.Time) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %s", header.Alg)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode signature, %s", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("invalid id token signature, %s", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, err
+	}
+	var standard struct {
+		Issuer    string          `json:"iss"`
+		Subject   string          `json:"sub"`
+		Audience  json.RawMessage `json:"aud"`
+		ExpiresAt int64           `json:"exp"`
+		Nonce     string          `json:"nonce"`
+		Email     string          `json:"email"`
+	}
+	if err := decodeSegment(parts[1], &standard); err != nil {
+		return nil, err
+	}
+	switch {
+	case standard.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("unexpected issuer %s", standard.Issuer)
+	case !hasAudience(standard.Audience, p.config.ClientID):
+		return nil, fmt.Errorf("id token is not issued for this client")
+	case now.Unix() >= standard.ExpiresAt:
+		return nil, fmt.Errorf("id token is expired")
+	case standard.Nonce != nonce:
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+
+	claims := &Claims{Subject: standard.Subject, Email: standard.Email}
+	groupsClaim := p.config.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	if v, ok := raw[groupsClaim]; ok {
+		if err := json.Unmarshal(v, &claims.Groups); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal %s claim, %s", groupsClaim, err)
+		}
+	}
+	return claims, nil
+}
+
+// key は、kid の公開鍵を返します。鍵がローテーションされた場合に備え、見つからなければJWKSを取得し直します。
+func (p *provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.endpoints.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create jwks request, %s", err)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.do(req, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to fetch jwks, %s", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %s", kid)
+}
+
+func (p *provider) do(req *http.Request, v interface{}) error {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status code %d", res.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("unable to decode id token, %s", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unable to unmarshal id token, %s", err)
+	}
+	return nil
+}
+
+// hasAudience は、aud クレーム（文字列または配列）に clientID が含まれるかを返します。
+func hasAudience(aud json.RawMessage, clientID string) bool {
+	var single string
+	if err := json.Unmarshal(aud, &single); err == nil {
+		return single == clientID
+	}
+	var list []string
+	if err := json.Unmarshal(aud, &list); err != nil {
+		return false
+	}
+	for _, a := range list {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// NewProvider は、発行者の /.well-known/openid-configuration からエンドポイントを取得して Provider を生成します。
+func NewProvider(ctx context.Context, client *http.Client, config Config) (Provider, error) {
+	p := &provider{client: client, config: config}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create discovery request, %s", err)
+	}
+	if err := p.do(req, &p.endpoints); err != nil {
+		return nil, fmt.Errorf("unable to discover provider, %s", err)
+	}
+	return p, nil
+}
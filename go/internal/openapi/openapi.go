@@ -0,0 +1,252 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parameter は、操作のパスまたはクエリのパラメーターです。
+type Parameter struct {
+	Name        string
+	In          string // path または query
+	Description string
+	Required    bool
+}
+
+// Operation は、APIの1つの操作です。
+type Operation struct {
+	Method      string
+	Path        string // 「/api/links/{id}」のように、パスのパラメーターを波括弧で囲みます
+	ID          string
+	Summary     string
+	Parameters  []Parameter
+	RequestType string      // リクエストボディの Content-Type。空の場合はボディなし
+	Response    interface{} // 成功した場合に返すJSONの型の値。nil の場合は 204 No Content
+	Status      int         // 成功した場合のステータスコード。0 の場合は 200
+	Errors      []int       // 返しうるエラーのステータスコード
+	Public      bool        // 認証なしで呼び出せる操作
+}
+
+// SecurityScheme は、APIの認証方式です。
+type SecurityScheme struct {
+	Name        string
+	Type        string // apiKey または http
+	Scheme      string // Type が http の場合の方式（bearer など）
+	In          string // Type が apiKey の場合のキーの場所（header など）
+	Header      string // Type が apiKey の場合のヘッダー名
+	AuthType    string // API Gateway の x-amazon-apigateway-authtype（awsSigv4 など）
+	Description string
+}
+
+// Spec は、OpenAPI の文書にする内容です。
+type Spec struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+	Security    []SecurityScheme
+	Operations  []Operation
+	ErrorType   interface{} // エラーの場合に返すJSONの型の値
+}
+
+// Generate は、spec から OpenAPI 3.0 の文書をJSONで生成します。
+// レスポンスのスキーマは、Operation.Response の型の json タグから求めます。
+func Generate(spec *Spec) ([]byte, error) {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+	security := make([]map[string][]string, 0, len(spec.Security))
+	for _, s := range spec.Security {
+		security = append(security, map[string][]string{s.Name: {}})
+	}
+
+	var errorRef map[string]interface{}
+	if spec.ErrorType != nil {
+		errorRef = schemaRef(reflect.TypeOf(spec.ErrorType), schemas)
+	}
+	for _, op := range spec.Operations {
+		method := strings.ToLower(op.Method)
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		if _, ok := paths[op.Path][method]; ok {
+			return nil, fmt.Errorf("duplicate operation %s %s", op.Method, op.Path)
+		}
+
+		responses := map[string]interface{}{}
+		if op.Response == nil {
+			responses["204"] = map[string]interface{}{"description": http.StatusText(http.StatusNoContent)}
+		} else {
+			status := op.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     jsonContent(schemaRef(reflect.TypeOf(op.Response), schemas)),
+			}
+		}
+		for _, code := range op.Errors {
+			res := map[string]interface{}{"description": http.StatusText(code)}
+			if errorRef != nil {
+				res["content"] = jsonContent(errorRef)
+			}
+			responses[strconv.Itoa(code)] = res
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"responses":   responses,
+		}
+		if len(op.Parameters) > 0 {
+			params := make([]interface{}, 0, len(op.Parameters))
+			for _, p := range op.Parameters {
+				params = append(params, map[string]interface{}{
+					"name":        p.Name,
+					"in":          p.In,
+					"description": p.Description,
+					"required":    p.Required || p.In == "path",
+					"schema":      map[string]string{"type": "string"},
+				})
+			}
+			operation["parameters"] = params
+		}
+		if op.RequestType != "" {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					op.RequestType: map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}},
+				},
+			}
+		}
+		if op.Public {
+			operation["security"] = []interface{}{}
+		}
+		paths[op.Path][method] = operation
+	}
+
+	schemes := map[string]interface{}{}
+	for _, s := range spec.Security {
+		scheme := map[string]interface{}{"type": s.Type}
+		if s.Description != "" {
+			scheme["description"] = s.Description
+		}
+		if s.Scheme != "" {
+			scheme["scheme"] = s.Scheme
+		}
+		if s.Type == "apiKey" {
+			scheme["in"] = s.In
+			scheme["name"] = s.Header
+		}
+		if s.AuthType != "" {
+			scheme["x-amazon-apigateway-authtype"] = s.AuthType
+		}
+		schemes[s.Name] = scheme
+	}
+
+	servers := make([]map[string]string, 0, len(spec.Servers))
+	for _, url := range spec.Servers {
+		servers = append(servers, map[string]string{"url": url})
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":       spec.Title,
+			"version":     spec.Version,
+			"description": spec.Description,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas":         schemas,
+			"securitySchemes": schemes,
+		},
+		"security": security,
+	}
+	if len(servers) > 0 {
+		doc["servers"] = servers
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal openapi document, %s", err)
+	}
+	return b, nil
+}
+
+// schemaRef は、型 t のスキーマを返します。構造体は schemas に登録し、その参照を返します。
+func schemaRef(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		name := exportedName(t.Name())
+		if _, ok := schemas[name]; !ok {
+			// 再帰的な型でも無限に辿らないよう、先に登録しておく。
+			schemas[name] = nil
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaRef(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaRef(t.Elem(), schemas)}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema は、構造体の型 t の json タグからスキーマを求めます。omitempty でないフィールドは必須とします。
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaRef(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// exportedName は、スキーマの名前にするため、型の名前の先頭を大文字にします。
+func exportedName(name string) string {
+	if name == "" {
+		return "Object"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
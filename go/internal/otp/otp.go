@@ -0,0 +1,204 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MaxAttempts は、1つのコードに対して入力を受け付ける回数です。
+const MaxAttempts = 5
+
+// CodeLength は、ワンタイムコードの桁数です。
+const CodeLength = 6
+
+// challenge は、発行したワンタイムコードです。コードそのものは保存せず、SHA-256 のハッシュだけを保存します。
+type challenge struct {
+	Key       string `dynamodbav:"id"`
+	CodeHash  string `dynamodbav:"code_hash"`
+	Attempts  int    `dynamodbav:"attempts"`
+	ExpiresAt int64  `dynamodbav:"ttl"` // UNIX時間（秒）。DynamoDB の TTL で自動的に削除される
+}
+
+// ErrTooManyAttempts は、入力に MaxAttempts 回失敗した key に、有効期限が切れるまでコードを発行し直さないことを表します。
+var ErrTooManyAttempts = errors.New("too many attempts")
+
+// Store は、ワンタイムコードを発行・検証します。
+type Store interface {
+	// Issue は、key（リンクと受取人の組み合わせ）に対する新しいコードを発行し、以前のコードを無効にします。
+	// 発行し直しても失敗した回数は引き継ぎ、MaxAttempts 回失敗した key には、以前のコードの有効期限が切れるまで
+	// ErrTooManyAttempts を返します。
+	Issue(ctx context.Context, key string, ttl time.Duration, now time.Time) (string, error)
+	// Verify は、コードが正しく有効期限内であれば true を返します。検証に成功したコードは再利用できません。
+	Verify(ctx context.Context, key, code string, now time.Time) (bool, error)
+}
+
+type dynamoStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func (s *dynamoStore) Issue(ctx context.Context, key string, ttl time.Duration, now time.Time) (string, error) {
+	code, err := newCode()
+	if err != nil {
+		return "", err
+	}
+	c := &challenge{
+		Key:       key,
+		CodeHash:  hashCode(code),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+	item, err := attributevalue.MarshalMap(c)
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal challenge, %s", err)
+	}
+	values, err := attributevalue.MarshalMap(map[string]interface{}{
+		":code_hash": c.CodeHash,
+		":ttl":       c.ExpiresAt,
+		":now":       now.Unix(),
+		":max":       MaxAttempts,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+
+	// 新しい key か、以前のコードの有効期限が切れた key の場合は、失敗した回数を 0 から数え直す。
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(s.table),
+		Item:                      item,
+		ConditionExpression:       aws.String("attribute_not_exists(id) OR #ttl <= :now"),
+		ExpressionAttributeNames:  map[string]string{"#ttl": "ttl"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":now": values[":now"]},
+	})
+	if err == nil {
+		return code, nil
+	}
+	var conditionErr *types.ConditionalCheckFailedException
+	if !errors.As(err, &conditionErr) {
+		return "", fmt.Errorf("unable to put challenge, %s", err)
+	}
+
+	// 有効なコードがある場合は、発行し直して失敗した回数が戻らないよう、コードと有効期限だけを置き換える。
+	keyItem, err := attributevalue.MarshalMap(map[string]string{"id": key})
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal key, %s", err)
+	}
+	if _, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      keyItem,
+		UpdateExpression:         aws.String("SET code_hash = :code_hash, #ttl = :ttl"),
+		ConditionExpression:      aws.String("attempts < :max"),
+		ExpressionAttributeNames: map[string]string{"#ttl": "ttl"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":code_hash": values[":code_hash"],
+			":ttl":       values[":ttl"],
+			":max":       values[":max"],
+		},
+	}); err != nil {
+		if errors.As(err, &conditionErr) {
+			return "", ErrTooManyAttempts
+		}
+		return "", fmt.Errorf("unable to update challenge, %s", err)
+	}
+	return code, nil
+}
+
+func (s *dynamoStore) Verify(ctx context.Context, key, code string, now time.Time) (bool, error) {
+	keyItem, err := attributevalue.MarshalMap(map[string]string{"id": key})
+	if err != nil {
+		return false, fmt.Errorf("unable to marshal key, %s", err)
+	}
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            keyItem,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to get challenge, %s", err)
+	}
+	if out.Item == nil {
+		return false, nil
+	}
+	var c challenge
+	if err := attributevalue.UnmarshalMap(out.Item, &c); err != nil {
+		return false, fmt.Errorf("unable to unmarshal challenge, %s", err)
+	}
+	if now.Unix() >= c.ExpiresAt || c.Attempts >= MaxAttempts {
+		return false, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashCode(code)), []byte(c.CodeHash)) != 1 {
+		// 総当たりを防ぐため、失敗した回数を数える。
+		values, err := attributevalue.MarshalMap(map[string]int{":one": 1})
+		if err != nil {
+			return false, fmt.Errorf("unable to marshal expression values, %s", err)
+		}
+		if _, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(s.table),
+			Key:                       keyItem,
+			UpdateExpression:          aws.String("ADD attempts :one"),
+			ConditionExpression:       aws.String("attribute_exists(id)"),
+			ExpressionAttributeValues: values,
+		}); err != nil {
+			var conditionErr *types.ConditionalCheckFailedException
+			if !errors.As(err, &conditionErr) {
+				return false, fmt.Errorf("unable to update challenge, %s", err)
+			}
+		}
+		return false, nil
+	}
+
+	// 同じコードを並行して使われないよう、条件付きで削除できた場合のみ成功とする。
+	hashValues, err := attributevalue.MarshalMap(map[string]string{":code_hash": c.CodeHash})
+	if err != nil {
+		return false, fmt.Errorf("unable to marshal expression values, %s", err)
+	}
+	if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       keyItem,
+		ConditionExpression:       aws.String("code_hash = :code_hash"),
+		ExpressionAttributeValues: hashValues,
+	}); err != nil {
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to delete challenge, %s", err)
+	}
+	return true, nil
+}
+
+// newCode は、CodeLength 桁の数字のコードを生成します。
+func newCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < CodeLength; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate code, %s", err)
+	}
+	return fmt.Sprintf("%0*d", CodeLength, n), nil
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewStore は、DynamoDB のテーブル table にワンタイムコードを保存する Store を生成します。
+// テーブルは id をパーティションキーとし、ttl 属性で TTL を有効にしてください。
+func NewStore(client *dynamodb.Client, table string) Store {
+	return &dynamoStore{client: client, table: table}
+}
@@ -0,0 +1,70 @@
+package otp
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+const (
+	StepEmail = "email" // メールアドレスの入力
+	StepCode  = "code"  // 届いたコードの入力
+)
+
+// Page は、ダウンロードの前に受取人を確認するページです。
+type Page struct {
+	FileName string
+	Step     string // StepEmail または StepCode
+	Email    string // StepCode で、コードを送ったメールアドレス
+	Message  string // 入力が正しくなかった場合などに表示する説明
+}
+
+var pageTemplate = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.FileName}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Hiragino Sans", "Noto Sans JP", sans-serif; margin: 0; background: #f6f7f9; color: #1d1c1d; }
+main { max-width: 560px; margin: 40px auto; padding: 24px; background: #fff; box-shadow: 0 1px 3px rgba(0,0,0,.08); }
+h1 { font-size: 1.3rem; margin-top: 0; word-break: break-all; }
+.message { border-left: 4px solid #e01e5a; padding: 8px 12px; margin: 16px 0; }
+input { padding: 6px 8px; font-size: 1rem; width: 100%; box-sizing: border-box; }
+button { margin-top: 16px; padding: 8px 20px; background: #1264a3; color: #fff; border: 0; border-radius: 4px; font-weight: 600; cursor: pointer; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.FileName}}</h1>
+{{- if .Message}}
+<p class="message">{{.Message}}</p>
+{{- end}}
+<form method="post">
+{{- if eq .Step "code"}}
+<p>{{.Email}} に確認コードを送りました。届いたコードを入力してください。</p>
+<input type="hidden" name="action" value="verify">
+<input type="hidden" name="email" value="{{.Email}}">
+<input type="text" name="code" inputmode="numeric" autocomplete="one-time-code" required>
+<button type="submit">ダウンロード</button>
+{{- else}}
+<p>このファイルは受取人が限定されています。メールアドレスを入力すると、確認コードが届きます。</p>
+<input type="hidden" name="action" value="send">
+<input type="email" name="email" autocomplete="email" required>
+<button type="submit">確認コードを送る</button>
+{{- end}}
+</form>
+</main>
+</body>
+</html>
+`))
+
+// RenderPage は、受取人を確認するページのHTMLを生成します。
+func RenderPage(p *Page) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, p); err != nil {
+		return nil, fmt.Errorf("unable to render verification page, %s", err)
+	}
+	return buf.Bytes(), nil
+}
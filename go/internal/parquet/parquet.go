@@ -0,0 +1,186 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Parquet の列の物理型、圧縮方式、エンコーディングなどの定数です。
+const (
+	typeInt64     = 2
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	convertedUTF8      = 0
+	codecGzip          = 2
+	encodingPlain      = 0
+	encodingRLE        = 3
+	pageTypeData       = 0
+)
+
+var magic = []byte("PAR1")
+
+// Column は、1列分の値です。Strings か Int64s のどちらか一方を指定します。
+type Column struct {
+	Name    string
+	Strings []string // UTF-8 の文字列の列
+	Int64s  []int64  // 64ビット整数の列
+}
+
+func (c *Column) len() int {
+	if c.Strings != nil {
+		return len(c.Strings)
+	}
+	return len(c.Int64s)
+}
+
+// Write は、列をすべて必須（REQUIRED）の列として、1つの行グループからなる Parquet ファイルを w に書き出します。
+// 各列は PLAIN エンコーディングの1つのデータページにまとめ、GZIP で圧縮します。
+func Write(w io.Writer, columns []Column) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("no columns")
+	}
+	numRows := columns[0].len()
+	for _, c := range columns {
+		if c.len() != numRows {
+			return fmt.Errorf("column %s has %d values, want %d", c.Name, c.len(), numRows)
+		}
+	}
+
+	var body bytes.Buffer
+	body.Write(magic)
+	chunks := make([]columnChunk, 0, len(columns))
+	var totalSize int64
+	for i := range columns {
+		chunk, err := writeColumnChunk(&body, &columns[i])
+		if err != nil {
+			return err
+		}
+		totalSize += chunk.uncompressedSize
+		chunks = append(chunks, chunk)
+	}
+
+	footer := fileMetaData(columns, chunks, int64(numRows), totalSize)
+	body.Write(footer)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	body.Write(length[:])
+	body.Write(magic)
+
+	if _, err := w.Write(body.Bytes()); err != nil {
+		return fmt.Errorf("unable to write parquet file, %s", err)
+	}
+	return nil
+}
+
+type columnChunk struct {
+	physicalType     int32
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// writeColumnChunk は、列の値をデータページとして body に書き出します。
+func writeColumnChunk(body *bytes.Buffer, c *Column) (columnChunk, error) {
+	var values bytes.Buffer
+	chunk := columnChunk{offset: int64(body.Len()), numValues: int64(c.len())}
+	if c.Strings != nil {
+		chunk.physicalType = typeByteArray
+		for _, s := range c.Strings {
+			var length [4]byte
+			binary.LittleEndian.PutUint32(length[:], uint32(len(s)))
+			values.Write(length[:])
+			values.WriteString(s)
+		}
+	} else {
+		chunk.physicalType = typeInt64
+		for _, v := range c.Int64s {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(v))
+			values.Write(b[:])
+		}
+	}
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(values.Bytes()); err != nil {
+		return chunk, fmt.Errorf("unable to compress column %s, %s", c.Name, err)
+	}
+	if err := zw.Close(); err != nil {
+		return chunk, fmt.Errorf("unable to compress column %s, %s", c.Name, err)
+	}
+
+	header := &compactWriter{}
+	header.i32(1, pageTypeData)
+	header.i32(2, int32(values.Len()))
+	header.i32(3, int32(compressed.Len()))
+	header.beginStruct(5)
+	header.i32(1, int32(c.len()))
+	header.i32(2, encodingPlain)
+	header.i32(3, encodingRLE)
+	header.i32(4, encodingRLE)
+	header.endStruct()
+	header.buf.WriteByte(0)
+
+	body.Write(header.buf.Bytes())
+	body.Write(compressed.Bytes())
+	chunk.uncompressedSize = int64(header.buf.Len() + values.Len())
+	chunk.compressedSize = int64(header.buf.Len() + compressed.Len())
+	return chunk, nil
+}
+
+// fileMetaData は、ファイルの末尾に置くメタデータ（FileMetaData）を書き出します。
+func fileMetaData(columns []Column, chunks []columnChunk, numRows, totalSize int64) []byte {
+	w := &compactWriter{}
+	w.i32(1, 1)
+
+	// スキーマは、ルート要素の下にすべての列を並べる。
+	w.listHeader(2, thriftStruct, len(columns)+1)
+	w.beginStruct(0)
+	w.binary(4, "schema")
+	w.i32(5, int32(len(columns)))
+	w.endStruct()
+	for i, c := range columns {
+		w.beginStruct(0)
+		w.i32(1, chunks[i].physicalType)
+		w.i32(3, repetitionRequired)
+		w.binary(4, c.Name)
+		if c.Strings != nil {
+			w.i32(6, convertedUTF8)
+		}
+		w.endStruct()
+	}
+
+	w.i64(3, numRows)
+
+	w.listHeader(4, thriftStruct, 1)
+	w.beginStruct(0)
+	w.listHeader(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		chunk := chunks[i]
+		w.beginStruct(0)
+		w.i64(2, chunk.offset)
+		w.beginStruct(3)
+		w.i32(1, chunk.physicalType)
+		w.i32List(2, []int32{encodingPlain, encodingRLE})
+		w.stringList(3, []string{c.Name})
+		w.i32(4, codecGzip)
+		w.i64(5, chunk.numValues)
+		w.i64(6, chunk.uncompressedSize)
+		w.i64(7, chunk.compressedSize)
+		w.i64(9, chunk.offset)
+		w.endStruct()
+		w.endStruct()
+	}
+	w.i64(2, totalSize)
+	w.i64(3, numRows)
+	w.endStruct()
+
+	w.binary(6, "uploader-v2")
+	w.buf.WriteByte(0)
+	return w.buf.Bytes()
+}
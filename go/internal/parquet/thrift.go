@@ -0,0 +1,100 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift の Compact Protocol の型です。
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// compactWriter は、Parquet のメタデータを Thrift の Compact Protocol で書き出します。
+// フィールドIDは構造体ごとに昇順で書く必要があります。
+type compactWriter struct {
+	buf     bytes.Buffer
+	lastIDs []int16
+	lastID  int16
+}
+
+func (w *compactWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - w.lastID; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(uint64(zigzag(int64(id))))
+	}
+	w.lastID = id
+}
+
+func (w *compactWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf.Write(b[:n])
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, thriftI32)
+	w.varint(zigzag(int64(v)))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, thriftI64)
+	w.varint(zigzag(v))
+}
+
+func (w *compactWriter) binary(id int16, v string) {
+	w.fieldHeader(id, thriftBinary)
+	w.varint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+// listHeader は、要素の型が typ で size 個の要素を持つリストのヘッダーを書き出します。
+func (w *compactWriter) listHeader(id int16, typ byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | typ)
+	} else {
+		w.buf.WriteByte(0xf0 | typ)
+		w.varint(uint64(size))
+	}
+}
+
+func (w *compactWriter) i32List(id int16, values []int32) {
+	w.listHeader(id, thriftI32, len(values))
+	for _, v := range values {
+		w.varint(zigzag(int64(v)))
+	}
+}
+
+func (w *compactWriter) stringList(id int16, values []string) {
+	w.listHeader(id, thriftBinary, len(values))
+	for _, v := range values {
+		w.varint(uint64(len(v)))
+		w.buf.WriteString(v)
+	}
+}
+
+// beginStruct は、フィールド id の構造体を書き始めます。リストの要素の場合は id に 0 を指定します。
+func (w *compactWriter) beginStruct(id int16) {
+	if id != 0 {
+		w.fieldHeader(id, thriftStruct)
+	}
+	w.lastIDs = append(w.lastIDs, w.lastID)
+	w.lastID = 0
+}
+
+func (w *compactWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.lastID = w.lastIDs[len(w.lastIDs)-1]
+	w.lastIDs = w.lastIDs[:len(w.lastIDs)-1]
+}
@@ -0,0 +1,147 @@
+package provenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	// StatementType は、in-toto の Statement（v1）の種類です。
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType は、SLSA の来歴（v1）の種類です。
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// BuildType は、CIがAPIにアップロードした成果物の来歴であることを表す buildType です。
+	BuildType = "https://github.com/kumagai-s/uploader-v2/provenance/api@v1"
+)
+
+// Build は、CIがAPIの呼び出しに添えるビルドの情報です。
+type Build struct {
+	Repository  string // ソースのリポジトリのURL（例: https://github.com/acme/app）
+	Commit      string // ビルドしたコミットのID（SHA-1 または SHA-256 の16進数）
+	WorkflowRun string // ビルドを実行したワークフローの実行のURL。省略できる
+}
+
+var commitPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// Validate は、ビルドの情報を来歴に記載できるかを検証します。
+func (b *Build) Validate() error {
+	if b.Repository == "" || b.Commit == "" {
+		return errors.New("repo and commit are required")
+	}
+	if !isHTTPSURL(b.Repository) {
+		return errors.New("repo must be an https URL")
+	}
+	if !commitPattern.MatchString(b.Commit) {
+		return errors.New("commit must be a full hex commit id")
+	}
+	if b.WorkflowRun != "" && !isHTTPSURL(b.WorkflowRun) {
+		return errors.New("workflow_run must be an https URL")
+	}
+	return nil
+}
+
+func isHTTPSURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// Statement は、in-toto の Statement です。
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject は、来歴の対象となる成果物です。
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance は、SLSA の来歴（v1）の predicate です。
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition は、成果物をビルドした入力です。
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   ExternalParameters   `json:"externalParameters"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies"`
+}
+
+// ExternalParameters は、CIから受け取ったビルドの情報です。
+type ExternalParameters struct {
+	Repository  string `json:"repository"`
+	Commit      string `json:"commit"`
+	WorkflowRun string `json:"workflowRun,omitempty"`
+}
+
+// ResourceDescriptor は、ビルドに使ったソースです。
+type ResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// RunDetails は、ビルドを実行した環境です。
+type RunDetails struct {
+	Builder  Builder  `json:"builder"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// Builder は、成果物を提出した呼び出し元です。
+type Builder struct {
+	ID string `json:"id"`
+}
+
+// Metadata は、ビルドの実行の情報です。
+type Metadata struct {
+	InvocationID string `json:"invocationId,omitempty"`
+	FinishedOn   string `json:"finishedOn"` // 成果物を受け取った日時（RFC 3339）
+}
+
+// NewStatement は、name の成果物（SHA-256 が sha256）の来歴を、builderID の呼び出し元から受け取った build で作成します。
+// 来歴は呼び出し元が申告したビルドの情報をそのまま記載するため、信頼できるのは呼び出し元の認証の範囲までです。
+func NewStatement(name, sha256 string, build *Build, builderID string, finishedOn time.Time) *Statement {
+	return &Statement{
+		Type:          StatementType,
+		Subject:       []Subject{{Name: name, Digest: map[string]string{"sha256": sha256}}},
+		PredicateType: PredicateType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType: BuildType,
+				ExternalParameters: ExternalParameters{
+					Repository:  build.Repository,
+					Commit:      build.Commit,
+					WorkflowRun: build.WorkflowRun,
+				},
+				ResolvedDependencies: []ResourceDescriptor{{
+					URI:    "git+" + build.Repository,
+					Digest: map[string]string{"gitCommit": build.Commit},
+				}},
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{ID: builderID},
+				Metadata: Metadata{
+					InvocationID: build.WorkflowRun,
+					FinishedOn:   finishedOn.UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+}
+
+// Marshal は、来歴を保存するJSONを返します。
+func (s *Statement) Marshal() ([]byte, error) {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal statement, %s", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,67 @@
+package provenance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBuildValidate(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name    string
+		build   Build
+		wantErr bool
+	}{
+		{"valid", Build{Repository: "https://github.com/acme/app", Commit: commit, WorkflowRun: "https://github.com/acme/app/actions/runs/1"}, false},
+		{"without workflow run", Build{Repository: "https://github.com/acme/app", Commit: commit}, false},
+		{"missing commit", Build{Repository: "https://github.com/acme/app"}, true},
+		{"missing repo", Build{Commit: commit}, true},
+		{"short commit", Build{Repository: "https://github.com/acme/app", Commit: "0123456"}, true},
+		{"http repo", Build{Repository: "http://github.com/acme/app", Commit: commit}, true},
+		{"invalid workflow run", Build{Repository: "https://github.com/acme/app", Commit: commit, WorkflowRun: "run-1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.build.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewStatement(t *testing.T) {
+	build := &Build{
+		Repository:  "https://github.com/acme/app",
+		Commit:      "0123456789abcdef0123456789abcdef01234567",
+		WorkflowRun: "https://github.com/acme/app/actions/runs/1",
+	}
+	doc, err := NewStatement("app.zip", "e3b0c442", build, "api-key:ci", time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(doc, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["_type"] != StatementType || got["predicateType"] != PredicateType {
+		t.Errorf("_type = %v, predicateType = %v", got["_type"], got["predicateType"])
+	}
+	subject := got["subject"].([]interface{})[0].(map[string]interface{})
+	if subject["name"] != "app.zip" || subject["digest"].(map[string]interface{})["sha256"] != "e3b0c442" {
+		t.Errorf("subject = %v", subject)
+	}
+	predicate := got["predicate"].(map[string]interface{})
+	dep := predicate["buildDefinition"].(map[string]interface{})["resolvedDependencies"].([]interface{})[0].(map[string]interface{})
+	if dep["uri"] != "git+https://github.com/acme/app" || dep["digest"].(map[string]interface{})["gitCommit"] != build.Commit {
+		t.Errorf("resolvedDependencies[0] = %v", dep)
+	}
+	run := predicate["runDetails"].(map[string]interface{})
+	if run["builder"].(map[string]interface{})["id"] != "api-key:ci" {
+		t.Errorf("builder = %v", run["builder"])
+	}
+	if metadata := run["metadata"].(map[string]interface{}); metadata["invocationId"] != build.WorkflowRun || metadata["finishedOn"] != "2026-10-17T09:00:00Z" {
+		t.Errorf("metadata = %v", metadata)
+	}
+}
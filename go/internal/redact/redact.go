@@ -0,0 +1,135 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Rules は、ログに出力する前に適用する秘匿化の規則です。
+type Rules struct {
+	Tokens    bool // Slackのトークン、Bearer トークン、署名付きURLの署名などを伏せ、認証に使うヘッダーを取り除く
+	URLs      bool // URLのパスとクエリを伏せ、スキームとホストだけを残す
+	FileNames bool // ファイル名をハッシュ値に置き換える（拡張子は残す）
+	BodyLimit int  // リクエストボディを出力する最大のバイト数。0 の場合は制限しない
+}
+
+// ParseRules は、「tokens,urls,filenames」のようにカンマで区切った規則の名前を解釈します。
+// 「all」はすべての規則、「off」は規則なしを表します。未知の名前はエラーになります。
+func ParseRules(spec string, bodyLimit int) (Rules, error) {
+	rules := Rules{BodyLimit: bodyLimit}
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "off":
+		case "tokens":
+			rules.Tokens = true
+		case "urls":
+			rules.URLs = true
+		case "filenames":
+			rules.FileNames = true
+		case "all":
+			rules.Tokens, rules.URLs, rules.FileNames = true, true, true
+		default:
+			return rules, fmt.Errorf("unknown redaction rule %s", name)
+		}
+	}
+	return rules, nil
+}
+
+var (
+	slackTokenPattern = regexp.MustCompile(`xox[a-z]-[A-Za-z0-9-]+`)
+	bearerPattern     = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`)
+	signaturePattern  = regexp.MustCompile(`(X-Amz-(?:Signature|Credential|Security-Token)=)[^&\s"']+`)
+	urlPattern        = regexp.MustCompile(`(https?://[^/\s"'<>]+)/[^\s"'<>]*`)
+	fileNamePattern   = regexp.MustCompile(`[^\s"'/\\:=]+\.(zip|pdf|docx?|xlsx?|pptx?|csv|txt|tar|gz|tgz|7z|rar|meta4)\b`)
+)
+
+// authHeaders は、Tokens の規則で取り除くヘッダーです（小文字）。
+var authHeaders = map[string]bool{
+	"authorization":        true,
+	"cookie":               true,
+	"x-api-key":            true,
+	"x-slack-signature":    true,
+	"x-amz-security-token": true,
+}
+
+// Redactor は、ログに出力する文字列を秘匿化します。
+type Redactor interface {
+	// String は、文字列に規則を適用します。
+	String(s string) string
+	// Headers は、認証に使うヘッダーを伏せたヘッダーのコピーを返します。
+	Headers(headers map[string]string) map[string]string
+	// Body は、リクエストボディを BodyLimit に切り詰めます。
+	Body(body string) string
+}
+
+type redactor struct {
+	rules Rules
+}
+
+func (r *redactor) String(s string) string {
+	if r.rules.Tokens {
+		s = slackTokenPattern.ReplaceAllStringFunc(s, func(t string) string { return t[:5] + "[REDACTED]" })
+		s = bearerPattern.ReplaceAllString(s, "${1}[REDACTED]")
+		s = signaturePattern.ReplaceAllString(s, "${1}[REDACTED]")
+	}
+	if r.rules.URLs {
+		s = urlPattern.ReplaceAllString(s, "${1}/[REDACTED]")
+	}
+	if r.rules.FileNames {
+		s = fileNamePattern.ReplaceAllStringFunc(s, hashFileName)
+	}
+	return s
+}
+
+func (r *redactor) Headers(headers map[string]string) map[string]string {
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if r.rules.Tokens && authHeaders[strings.ToLower(k)] {
+			v = "[REDACTED]"
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func (r *redactor) Body(body string) string {
+	if r.rules.BodyLimit <= 0 || len(body) <= r.rules.BodyLimit {
+		return body
+	}
+	return fmt.Sprintf("%s...(%d bytes truncated)", body[:r.rules.BodyLimit], len(body)-r.rules.BodyLimit)
+}
+
+// hashFileName は、拡張子を残してファイル名を SHA-256 の先頭8バイトに置き換えます。
+// 同じファイル名は同じ値になるため、ログの行どうしを突き合わせられます。
+func hashFileName(name string) string {
+	ext := name[strings.LastIndex(name, "."):]
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(name, ext)))
+	return "file-" + hex.EncodeToString(sum[:8]) + ext
+}
+
+// New は、規則 rules を適用する Redactor を生成します。
+func New(rules Rules) Redactor {
+	return &redactor{rules: rules}
+}
+
+type writer struct {
+	w io.Writer
+	r Redactor
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.r.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// NewWriter は、書き込まれた内容に Redactor を適用してから w に書き出す io.Writer を生成します。
+// log.SetOutput に指定すると、すべてのログに規則が適用されます。
+func NewWriter(w io.Writer, r Redactor) io.Writer {
+	return &writer{w: w, r: r}
+}
@@ -0,0 +1,111 @@
+package secretscan
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Rule は、シークレットを検出するための正規表現ルールです。
+type Rule struct {
+	ID     string
+	Regexp *regexp.Regexp
+}
+
+// Finding は、検出されたシークレットの候補を表します。
+type Finding struct {
+	RuleID string
+	File   string
+	Line   int
+}
+
+// DefaultRules は、gitleaks のルールを参考にした代表的なシークレットの検出ルールです。
+var DefaultRules = []Rule{
+	{ID: "aws-access-key-id", Regexp: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA)[0-9A-Z]{16}\b`)},
+	{ID: "aws-secret-access-key", Regexp: regexp.MustCompile(`(?i)aws.{0,20}(?:secret|private).{0,20}['"=:\s][0-9a-zA-Z/+]{40}\b`)},
+	{ID: "private-key", Regexp: regexp.MustCompile(`-----BEGIN[ A-Z0-9_-]{0,100}PRIVATE KEY( BLOCK)?-----`)},
+	{ID: "slack-token", Regexp: regexp.MustCompile(`\bxox[baprs]-[0-9A-Za-z-]{10,}`)},
+	{ID: "slack-webhook", Regexp: regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9+/]{40,}`)},
+	{ID: "github-token", Regexp: regexp.MustCompile(`\bgh[pousr]_[0-9A-Za-z]{36,}\b`)},
+	{ID: "google-api-key", Regexp: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{ID: "stripe-secret-key", Regexp: regexp.MustCompile(`\b(?:sk|rk)_live_[0-9a-zA-Z]{24,}\b`)},
+	{ID: "jwt", Regexp: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+}
+
+// 「password = "..."」のような代入の右辺を取り出し、エントロピーで判定する。
+var assignmentPattern = regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api_?key|access_?key)\s*[:=]\s*['"]?([^\s'"]{16,})`)
+
+// エントロピーがこの値以上の文字列をランダムな秘密情報とみなす。
+const entropyThreshold = 4.0
+
+// Scanner は、テキストに含まれるシークレットの候補を検出します。
+type Scanner interface {
+	Scan(file string, content []byte) []Finding
+}
+
+type scanner struct {
+	rules []Rule
+}
+
+func (s *scanner) Scan(file string, content []byte) []Finding {
+	var findings []Finding
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		matched := false
+		for _, rule := range s.rules {
+			if rule.Regexp.MatchString(text) {
+				findings = append(findings, Finding{RuleID: rule.ID, File: file, Line: line})
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+		for _, m := range assignmentPattern.FindAllStringSubmatch(text, -1) {
+			if shannonEntropy(m[1]) >= entropyThreshold {
+				findings = append(findings, Finding{RuleID: "high-entropy-assignment", File: file, Line: line})
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// shannonEntropy は、文字列のシャノンエントロピー（1文字あたりのビット数）を返します。
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var entropy float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// Summary は、検出結果をSlackに表示するための文字列にまとめます。
+func Summary(findings []Finding) string {
+	var lines []string
+	for _, f := range findings {
+		lines = append(lines, fmt.Sprintf("・%s:%d（%s）", f.File, f.Line, f.RuleID))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func NewScanner() Scanner {
+	return &scanner{rules: DefaultRules}
+}
@@ -0,0 +1,115 @@
+package semaphore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Semaphore は、複数の実行環境にまたがって、同時に実行する処理の数を制限します。
+type Semaphore interface {
+	// TryAcquire は、空きがある場合に holder の枠を確保して true を返します。空きがない場合は待たずに false を返します。
+	// 確保した枠は、Release を呼ばずに実行環境が終了した場合でも、リースの期限が過ぎると解放されます。
+	TryAcquire(ctx context.Context, holder string) (bool, error)
+	// Release は、holder の枠を解放します。
+	Release(ctx context.Context, holder string) error
+}
+
+type dynamoSemaphore struct {
+	client *dynamodb.Client
+	table  string
+	key    string
+	limit  int
+	lease  time.Duration
+}
+
+func (s *dynamoSemaphore) TryAcquire(ctx context.Context, holder string) (bool, error) {
+	for {
+		out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(s.table),
+			Key:            map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: s.key}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return false, fmt.Errorf("unable to get semaphore, %s", err)
+		}
+
+		// リースの期限が過ぎた枠は、途中で終了した実行のものとみなして取り除く。
+		now := time.Now()
+		holders := map[string]types.AttributeValue{}
+		var version int64
+		if out.Item != nil {
+			if m, ok := out.Item["holders"].(*types.AttributeValueMemberM); ok {
+				for h, v := range m.Value {
+					if n, ok := v.(*types.AttributeValueMemberN); ok {
+						if until, err := strconv.ParseInt(n.Value, 10, 64); err == nil && until > now.Unix() {
+							holders[h] = v
+						}
+					}
+				}
+			}
+			if n, ok := out.Item["version"].(*types.AttributeValueMemberN); ok {
+				version, _ = strconv.ParseInt(n.Value, 10, 64)
+			}
+		}
+		if len(holders) >= s.limit {
+			return false, nil
+		}
+		holders[holder] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(s.lease).Unix(), 10)}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item: map[string]types.AttributeValue{
+				"key":     &types.AttributeValueMemberS{Value: s.key},
+				"holders": &types.AttributeValueMemberM{Value: holders},
+				"version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)},
+			},
+		}
+		if out.Item == nil {
+			input.ConditionExpression = aws.String("attribute_not_exists(#key)")
+			input.ExpressionAttributeNames = map[string]string{"#key": "key"}
+		} else {
+			input.ConditionExpression = aws.String("version = :version")
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+			}
+		}
+		if _, err := s.client.PutItem(ctx, input); err != nil {
+			// 他の実行環境と同時に更新した場合は、読み直してやり直す。
+			var conditionFailed *types.ConditionalCheckFailedException
+			if errors.As(err, &conditionFailed) {
+				continue
+			}
+			return false, fmt.Errorf("unable to acquire semaphore, %s", err)
+		}
+		return true, nil
+	}
+}
+
+func (s *dynamoSemaphore) Release(ctx context.Context, holder string) error {
+	if _, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: s.key}},
+		UpdateExpression:         aws.String("REMOVE holders.#holder ADD version :one"),
+		ExpressionAttributeNames: map[string]string{"#holder": holder},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":one": &types.AttributeValueMemberN{Value: "1"},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to release semaphore, %s", err)
+	}
+	return nil
+}
+
+// NewDynamoSemaphore は、DynamoDB のテーブル table の key の項目で、同時に limit 件までに制限する Semaphore を返します。
+// lease は1回の処理にかかる最大の時間（Lambda のタイムアウト）です。
+// テーブルは文字列のパーティションキー「key」を持つ必要があります。
+func NewDynamoSemaphore(client *dynamodb.Client, table, key string, limit int, lease time.Duration) Semaphore {
+	return &dynamoSemaphore{client: client, table: table, key: key, limit: limit, lease: lease}
+}
@@ -0,0 +1,80 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// TimestampHeader は、署名したUNIX時間（秒）を送るヘッダーです。
+	TimestampHeader = "X-Signature-Timestamp"
+	// SignatureHeader は、署名を送るヘッダーです。
+	SignatureHeader = "X-Signature"
+	version         = "v0"
+)
+
+// Sign は、Slackのリクエスト署名と同じ方式で「v0:<timestamp>:<body>」の HMAC-SHA256 を計算し、
+// 「v0=<hex>」の形式で返します。
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(version + ":" + strconv.FormatInt(timestamp, 10) + ":"))
+	mac.Write(body)
+	return version + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify は、signature が Sign で計算した署名と一致するかを返します。
+// 比較にかかる時間から署名を推測されないよう、一定時間で比較します。
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// SignRequest は、リクエストに署名のヘッダーを設定します。
+// body はリクエストボディと同じ内容を渡します。受信側はタイムスタンプの古いリクエストを拒否することで、
+// リプレイ攻撃を防げます。
+func SignRequest(request *http.Request, secret string, body []byte) {
+	timestamp := time.Now().Unix()
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+}
+
+type transport struct {
+	next   http.RoundTripper
+	secret string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read request body, %s", err)
+		}
+	}
+	// RoundTripper はリクエストを変更してはならないため、複製に署名する。
+	signed := req.Clone(req.Context())
+	signed.Body = io.NopCloser(bytes.NewReader(body))
+	signed.ContentLength = int64(len(body))
+	SignRequest(signed, t.secret, body)
+	return t.next.RoundTrip(signed)
+}
+
+// Wrap は、送信するすべてのリクエストに SignRequest で署名する http.Client を返します。client 自体は変更しません。
+// 署名するためにリクエストボディをメモリに読み込むため、Webhook のような小さなリクエストに使ってください。
+func Wrap(client *http.Client, secret string) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = &transport{next: next, secret: secret}
+	return &wrapped
+}
@@ -0,0 +1,192 @@
+package slackdownload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kumagai-s/uploader-v2/internal/throttle"
+)
+
+// Downloader は、Slackの url_private_download からファイルを取得します。
+// 転送が途中で切れた場合は、HTTPのRangeリクエストで取得済みのバイトの続きから再開します。
+type Downloader struct {
+	Client     *http.Client
+	MaxRetries int              // 再開を試みる最大回数
+	Backoff    time.Duration    // 再開までの待ち時間（試行ごとに倍になります）
+	Limiter    throttle.Limiter // 受信する速さの上限。nil の場合は制限しません
+}
+
+// errRangeIgnored は、サーバーがRangeリクエストを無視して最初から返した場合のエラーです。
+var errRangeIgnored = errors.New("server ignored range request")
+
+// truncater は、書き込み済みの内容を捨てて最初からやり直すための interface です（bytes.Buffer など）。
+type truncater interface {
+	Truncate(n int)
+}
+
+// Download は、url のファイルを token で認証して取得し、w に書き込みます。書き込んだバイト数を返します。
+func (d *Downloader) Download(ctx context.Context, url, token string, w io.Writer) (int64, error) {
+	return d.downloadRange(ctx, url, token, 0, -1, w)
+}
+
+// DownloadParallel は、size バイトのファイルを partSize ごとの範囲に分け、concurrency 件ずつ並列に取得して w に書き込みます。
+// サーバーがRangeリクエストに対応していない場合は、Download で先頭から順に取得します。
+func (d *Downloader) DownloadParallel(ctx context.Context, url, token string, size, partSize int64, concurrency int, w io.WriterAt) error {
+	if size <= 0 || partSize <= 0 || concurrency < 1 {
+		return fmt.Errorf("invalid parallel download parameters, size=%d partSize=%d concurrency=%d", size, partSize, concurrency)
+	}
+
+	partCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for start := int64(0); start < size; start += partSize {
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			part := &offsetWriter{w: w, base: start, limit: end - start + 1}
+			if _, err := d.downloadRange(partCtx, url, token, start, end, part); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	if errors.Is(firstErr, errRangeIgnored) {
+		_, err := d.Download(ctx, url, token, &offsetWriter{w: w, limit: size})
+		return err
+	}
+	return firstErr
+}
+
+// downloadRange は、start から end バイト目までを取得して w に書き込みます。end が負の場合はファイルの末尾までを取得します。
+func (d *Downloader) downloadRange(ctx context.Context, url, token string, start, end int64, w io.Writer) (int64, error) {
+	var written int64
+	var lastErr error
+	backoff := d.Backoff
+	for attempt := 0; attempt <= d.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return written, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		n, done, err := d.fetch(ctx, url, token, start+written, end, w)
+		written += n
+		if done {
+			return written, nil
+		}
+		if errors.Is(err, errRangeIgnored) {
+			// 続きから再開できないため、書き込み済みの内容を捨てて最初から取得し直す。
+			t, ok := w.(truncater)
+			if !ok || start > 0 || end >= 0 {
+				return written, err
+			}
+			t.Truncate(0)
+			written = 0
+		}
+		lastErr = err
+	}
+	return written, fmt.Errorf("unable to download file after %d retries, %s", d.MaxRetries, lastErr)
+}
+
+// fetch は、offset から end バイト目までを1回のリクエストで取得します。
+// 最後まで取得できた場合は done に true を返します。
+func (d *Downloader) fetch(ctx context.Context, url, token string, offset, end int64, w io.Writer) (n int64, done bool, err error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to create new request, %s", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	ranged := offset > 0 || end >= 0
+	if ranged {
+		r := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+		if end >= 0 {
+			r += strconv.FormatInt(end, 10)
+		}
+		request.Header.Set("Range", r)
+	}
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to send request, %s", err)
+	}
+	defer response.Body.Close()
+
+	switch {
+	case ranged && response.StatusCode == http.StatusOK:
+		return 0, false, errRangeIgnored
+	case offset > 0 && end < 0 && response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// 前回の試行で最後まで取得できていた。
+		return 0, true, nil
+	case response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent:
+		return 0, false, fmt.Errorf("request failed with status code %d", response.StatusCode)
+	}
+
+	var body io.Reader = response.Body
+	if d.Limiter != nil {
+		body = throttle.Reader(ctx, body, d.Limiter)
+	}
+	n, err = io.Copy(w, body)
+	if err != nil {
+		return n, false, fmt.Errorf("unable to read response body, %s", err)
+	}
+	// Content-Length が分かる場合は、途中で切れていないことを確認する。
+	if response.ContentLength >= 0 && n < response.ContentLength {
+		return n, false, io.ErrUnexpectedEOF
+	}
+	return n, true, nil
+}
+
+// offsetWriter は、io.WriterAt の base バイト目から順に、最大 limit バイトを書き込む io.Writer です。
+type offsetWriter struct {
+	w     io.WriterAt
+	base  int64
+	off   int64
+	limit int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	if o.off+int64(len(p)) > o.limit {
+		return 0, errors.New("response exceeds expected size")
+	}
+	n, err := o.w.WriteAt(p, o.base+o.off)
+	o.off += int64(n)
+	return n, err
+}
+
+func (o *offsetWriter) Truncate(n int) {
+	o.off = int64(n)
+}
+
+func NewDownloader(client *http.Client, maxRetries int, backoff time.Duration) *Downloader {
+	return &Downloader{Client: client, MaxRetries: maxRetries, Backoff: backoff}
+}
@@ -0,0 +1,120 @@
+package slackdownload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// benchmarkSizes は、ベンチマークで転送するファイルのサイズです。
+var benchmarkSizes = []struct {
+	name string
+	size int64
+}{
+	{"10MB", 10 << 20},
+	{"100MB", 100 << 20},
+	{"1GB", 1 << 30},
+}
+
+// patternBlock は、合成したファイルの内容として繰り返すバイト列です。
+var patternBlock = func() []byte {
+	b := make([]byte, 32<<10)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}()
+
+// patternReader は、n バイトの合成した内容を返す io.Reader です。
+type patternReader struct {
+	n int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n := 0
+	for n < len(p) {
+		n += copy(p[n:], patternBlock)
+	}
+	r.n -= int64(n)
+	return n, nil
+}
+
+// syntheticSource は、ネットワークを使わずに size バイトのファイルを返す http.RoundTripper です。Range リクエストに対応します。
+type syntheticSource struct {
+	size int64
+}
+
+func (s *syntheticSource) RoundTrip(req *http.Request) (*http.Response, error) {
+	start, end, status := int64(0), s.size-1, http.StatusOK
+	if r := req.Header.Get("Range"); r != "" {
+		from, to, _ := strings.Cut(strings.TrimPrefix(r, "bytes="), "-")
+		start, _ = strconv.ParseInt(from, 10, 64)
+		if to != "" {
+			end, _ = strconv.ParseInt(to, 10, 64)
+		}
+		status = http.StatusPartialContent
+	}
+	length := end - start + 1
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Content-Length": {strconv.FormatInt(length, 10)}},
+		ContentLength: length,
+		Body:          io.NopCloser(&patternReader{n: length}),
+		Request:       req,
+	}, nil
+}
+
+// discardWriterAt は、書き込んだ内容を捨てる io.WriterAt です。
+type discardWriterAt struct{}
+
+func (discardWriterAt) Write(p []byte) (int, error)              { return len(p), nil }
+func (discardWriterAt) WriteAt(p []byte, off int64) (int, error) { return len(p), nil }
+
+func TestDownloadParallelSynthetic(t *testing.T) {
+	d := NewDownloader(&http.Client{Transport: &syntheticSource{size: 10<<20 + 1}}, 0, 0)
+	if err := d.DownloadParallel(context.Background(), "https://files.slack.test/f", "xoxb", 10<<20+1, 1<<20, 4, discardWriterAt{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkDownload(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		b.Run(bs.name, func(b *testing.B) {
+			d := NewDownloader(&http.Client{Transport: &syntheticSource{size: bs.size}}, 0, 0)
+			b.SetBytes(bs.size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := d.Download(context.Background(), "https://files.slack.test/f", "xoxb", discardWriterAt{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDownloadParallel(b *testing.B) {
+	for _, bs := range benchmarkSizes {
+		for _, concurrency := range []int{1, 4} {
+			b.Run(fmt.Sprintf("%s/concurrency=%d", bs.name, concurrency), func(b *testing.B) {
+				d := NewDownloader(&http.Client{Transport: &syntheticSource{size: bs.size}}, 0, 0)
+				b.SetBytes(bs.size)
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if err := d.DownloadParallel(context.Background(), "https://files.slack.test/f", "xoxb", bs.size, 8<<20, concurrency, discardWriterAt{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package slackfiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// File は、Slackに投稿するファイルです。
+type File struct {
+	Name    string // ファイル名
+	Title   string // Slackに表示するタイトル。空の場合はファイル名
+	AltText string // 画像の代替テキスト
+	Content []byte
+}
+
+// Uploader は、ボットが生成したファイルを、files.upload ではなく外部アップロードの API でSlackに投稿します。
+type Uploader interface {
+	// Upload は、files.getUploadURLExternal で取得したURLに各ファイルを送信し、
+	// files.completeUploadExternal で channel の threadTS のスレッドに comment を添えて共有します。
+	// threadTS が空の場合はチャンネルに投稿します。アップロードしたファイルのIDを返します。
+	Upload(ctx context.Context, channel, threadTS, comment string, files ...*File) ([]string, error)
+}
+
+type uploader struct {
+	client *http.Client
+	apiURL string
+	token  string
+}
+
+// slackResponse は、Slack の Web API に共通の応答です。
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+type uploadURLResponse struct {
+	slackResponse
+	UploadURL string `json:"upload_url"`
+	FileID    string `json:"file_id"`
+}
+
+type fileSummary struct {
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+}
+
+func (u *uploader) Upload(ctx context.Context, channel, threadTS, comment string, files ...*File) ([]string, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no files to upload")
+	}
+
+	summaries := make([]fileSummary, 0, len(files))
+	for _, f := range files {
+		values := url.Values{
+			"filename": {f.Name},
+			"length":   {strconv.Itoa(len(f.Content))},
+		}
+		if f.AltText != "" {
+			values.Set("alt_txt", f.AltText)
+		}
+		var res uploadURLResponse
+		if err := u.call(ctx, "files.getUploadURLExternal", values, &res); err != nil {
+			return nil, err
+		}
+		if err := u.send(ctx, res.UploadURL, f.Content); err != nil {
+			return nil, err
+		}
+		title := f.Title
+		if title == "" {
+			title = f.Name
+		}
+		summaries = append(summaries, fileSummary{ID: res.FileID, Title: title})
+	}
+
+	filesJSON, err := json.Marshal(summaries)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{
+		"files":      {string(filesJSON)},
+		"channel_id": {channel},
+	}
+	if threadTS != "" {
+		values.Set("thread_ts", threadTS)
+	}
+	if comment != "" {
+		values.Set("initial_comment", comment)
+	}
+	var res slackResponse
+	if err := u.call(ctx, "files.completeUploadExternal", values, &res); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(summaries))
+	for _, s := range summaries {
+		ids = append(ids, s.ID)
+	}
+	return ids, nil
+}
+
+// call は、Slack の Web API の method をフォームの値で呼び出し、応答を v に読み込みます。
+func (u *uploader) call(ctx context.Context, method string, values url.Values, v apiResponse) error {
+	request, err := http.NewRequestWithContext(ctx, "POST", u.apiURL+method, strings.NewReader(values.Encode()))
+	if err != nil {
+		return fmt.Errorf("unable to create new request, %s", err)
+	}
+	request.Header.Set("Authorization", "Bearer "+u.token)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to call %s, %s", method, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to call %s, status code %d", method, response.StatusCode)
+	}
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode %s response, %s", method, err)
+	}
+	if err := v.err(); err != nil {
+		return fmt.Errorf("unable to call %s, %s", method, err)
+	}
+	return nil
+}
+
+// apiResponse は、ok が false の場合に error をエラーとして返す、Web API の応答です。
+type apiResponse interface {
+	err() error
+}
+
+func (r *slackResponse) err() error {
+	if r.OK {
+		return nil
+	}
+	return errors.New(r.Error)
+}
+
+// send は、files.getUploadURLExternal で取得したURLにファイルの内容を送信します。
+func (u *uploader) send(ctx context.Context, uploadURL string, content []byte) error {
+	request, err := http.NewRequestWithContext(ctx, "POST", uploadURL, bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("unable to create new request, %s", err)
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to upload file, %s", err)
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to upload file, status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// NewUploader は、token でSlackにファイルを投稿する Uploader を返します。
+// apiURL は「https://slack.com/api/」のように、末尾に「/」を付けて指定します。
+func NewUploader(client *http.Client, apiURL, token string) Uploader {
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+	return &uploader{client: client, apiURL: apiURL, token: token}
+}
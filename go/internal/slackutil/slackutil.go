@@ -0,0 +1,115 @@
+package slackutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// ErrStop は、EachReply や EachFile のコールバックが返すと、エラーにせずに列挙を終了します。
+var ErrStop = errors.New("stop iteration")
+
+// MaxRateLimitRetries は、レート制限（HTTP 429）で拒否された1ページの取得をやり直す最大の回数です。
+var MaxRateLimitRetries = 5
+
+// RepliesClient は、conversations.replies を呼び出すクライアントです。*slack.Client が満たします。
+type RepliesClient interface {
+	GetConversationRepliesContext(ctx context.Context, params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error)
+}
+
+// FilesClient は、files.list を呼び出すクライアントです。*slack.Client が満たします。
+type FilesClient interface {
+	ListFilesContext(ctx context.Context, params slack.ListFilesParameters) ([]slack.File, *slack.ListFilesParameters, error)
+}
+
+// EachReply は、スレッド（channel の ts のメッセージ）の返信を、カーソルで次のページを取得しながら順に fn に渡します。
+// スレッドの親メッセージも最初に渡されます。
+func EachReply(ctx context.Context, client RepliesClient, channel, ts string, fn func(slack.Message) error) error {
+	params := &slack.GetConversationRepliesParameters{ChannelID: channel, Timestamp: ts}
+	for {
+		var (
+			msgs    []slack.Message
+			hasMore bool
+			cursor  string
+		)
+		err := RetryRateLimited(ctx, func() (err error) {
+			msgs, hasMore, cursor, err = client.GetConversationRepliesContext(ctx, params)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to get conversation replies, %s", err)
+		}
+		for _, msg := range msgs {
+			if err := fn(msg); err != nil {
+				return stopped(err)
+			}
+		}
+		if !hasMore || cursor == "" {
+			return nil
+		}
+		params.Cursor = cursor
+	}
+}
+
+// EachFile は、params に一致するファイルを、カーソルで次のページを取得しながら順に fn に渡します。
+func EachFile(ctx context.Context, client FilesClient, params slack.ListFilesParameters, fn func(slack.File) error) error {
+	if params.Limit == 0 {
+		params.Limit = slack.DEFAULT_FILES_COUNT
+	}
+	for {
+		var (
+			files []slack.File
+			next  *slack.ListFilesParameters
+		)
+		err := RetryRateLimited(ctx, func() (err error) {
+			files, next, err = client.ListFilesContext(ctx, params)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("unable to list files, %s", err)
+		}
+		for _, file := range files {
+			if err := fn(file); err != nil {
+				return stopped(err)
+			}
+		}
+		if next == nil || next.Cursor == "" || len(files) == 0 {
+			return nil
+		}
+		params.Cursor = next.Cursor
+	}
+}
+
+// RetryRateLimited は、call がレート制限で拒否された場合に、Retry-After の時間だけ待って MaxRateLimitRetries 回までやり直します。
+// ページの取得以外の呼び出し（chat.update など）にも使えます。
+func RetryRateLimited(ctx context.Context, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		var limited *slack.RateLimitedError
+		if !errors.As(err, &limited) || attempt >= MaxRateLimitRetries {
+			return err
+		}
+		wait := limited.RetryAfter
+		if wait <= 0 {
+			wait = time.Second
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// stopped は、コールバックが ErrStop を返した場合は nil を、それ以外はそのエラーを返します。
+func stopped(err error) error {
+	if errors.Is(err, ErrStop) {
+		return nil
+	}
+	return err
+}
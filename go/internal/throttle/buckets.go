@@ -0,0 +1,135 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Buckets は、キー（送信元のIPアドレスや短縮コードなど）ごとのトークンバケットで、リクエストの回数を制限します。
+// バケットには最大 limit 回分のトークンがあり、window ごとに limit 回分ずつ補充されます。
+type Buckets interface {
+	// Allow は、key のバケットからトークンを1つ取り出せた場合に true を返します。
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// refill は、前回の更新から経過した時間に応じて補充したトークンの数を返します。
+// 端数を切り捨てないよう、トークンは1000倍した整数で扱います。
+func refill(tokens, elapsed, limit int64, window time.Duration) int64 {
+	if elapsed > 0 {
+		tokens += elapsed * limit * 1000 / window.Milliseconds()
+	}
+	if tokens > limit*1000 {
+		tokens = limit * 1000
+	}
+	return tokens
+}
+
+type localBucket struct {
+	tokens  int64
+	updated int64
+}
+
+type localBuckets struct {
+	limit  int64
+	window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+}
+
+func (b *localBuckets) Allow(ctx context.Context, key string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().UnixMilli()
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &localBucket{tokens: b.limit * 1000, updated: now}
+		b.buckets[key] = bucket
+	}
+	bucket.tokens = refill(bucket.tokens, now-bucket.updated, b.limit, b.window)
+	bucket.updated = now
+	if bucket.tokens < 1000 {
+		return false, nil
+	}
+	bucket.tokens -= 1000
+	return true, nil
+}
+
+// NewLocalBuckets は、この実行環境の中でキーごとに window あたり limit 回までに抑える Buckets を返します。
+// 複数の実行環境が動く場合は目安です。
+func NewLocalBuckets(limit int64, window time.Duration) Buckets {
+	return &localBuckets{limit: limit, window: window, buckets: map[string]*localBucket{}}
+}
+
+type dynamoBuckets struct {
+	client *dynamodb.Client
+	table  string
+	prefix string
+	limit  int64
+	window time.Duration
+}
+
+func (b *dynamoBuckets) Allow(ctx context.Context, key string) (bool, error) {
+	key = b.prefix + key
+	for {
+		out, err := b.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(b.table),
+			Key:            map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: key}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return false, fmt.Errorf("unable to get token bucket, %s", err)
+		}
+
+		now := time.Now().UnixMilli()
+		tokens := b.limit * 1000
+		if out.Item != nil {
+			tokens = refill(numberAttr(out.Item["tokens"]), now-numberAttr(out.Item["updated_at"]), b.limit, b.window)
+		}
+		if tokens < 1000 {
+			return false, nil
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(b.table),
+			Item: map[string]types.AttributeValue{
+				"key":        &types.AttributeValueMemberS{Value: key},
+				"tokens":     &types.AttributeValueMemberN{Value: strconv.FormatInt(tokens-1000, 10)},
+				"updated_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+				// バケットが満たされた後は項目が不要なため、TTL で削除する。
+				"ttl": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.UnixMilli(now).Add(b.window).Unix(), 10)},
+			},
+		}
+		if out.Item == nil {
+			input.ConditionExpression = aws.String("attribute_not_exists(#key)")
+			input.ExpressionAttributeNames = map[string]string{"#key": "key"}
+		} else {
+			input.ConditionExpression = aws.String("updated_at = :prev")
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{":prev": out.Item["updated_at"]}
+		}
+		_, err = b.client.PutItem(ctx, input)
+		if err != nil {
+			var conditionFailed *types.ConditionalCheckFailedException
+			if errors.As(err, &conditionFailed) {
+				continue
+			}
+			return false, fmt.Errorf("unable to update token bucket, %s", err)
+		}
+		return true, nil
+	}
+}
+
+// NewDynamoBuckets は、DynamoDB のテーブル table の「prefix + キー」の項目をトークンバケットとして、
+// すべての実行環境を合わせてキーごとに window あたり limit 回までに抑える Buckets を返します。
+// テーブルは文字列のパーティションキー「key」を持ち、「ttl」属性で TTL を有効にしてください。
+func NewDynamoBuckets(client *dynamodb.Client, table, prefix string, limit int64, window time.Duration) Buckets {
+	return &dynamoBuckets{client: client, table: table, prefix: prefix, limit: limit, window: window}
+}
@@ -0,0 +1,125 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type dynamoLimiter struct {
+	client *dynamodb.Client
+	table  string
+	key    string
+	rate   int64 // すべての実行環境を合わせた1秒あたりのバイト数
+	lease  int64 // 一度にテーブルから取得するバイト数
+
+	mu        sync.Mutex
+	available int64 // テーブルから取得済みで、まだ転送していないバイト数
+}
+
+func (l *dynamoLimiter) Wait(ctx context.Context, n int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.available < n {
+		want := l.lease
+		if n-l.available > want {
+			want = n - l.available
+		}
+		got, err := l.take(ctx, want)
+		if err != nil {
+			return err
+		}
+		l.available += got
+	}
+	l.available -= n
+	return nil
+}
+
+// take は、テーブルのトークンバケットから最大 want バイト分を取得します。足りない場合は補充されるまで待ちます。
+// 他の実行環境と同時に更新した場合は、読み直してやり直します。
+func (l *dynamoLimiter) take(ctx context.Context, want int64) (int64, error) {
+	if want > l.rate {
+		want = l.rate
+	}
+	for {
+		out, err := l.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(l.table),
+			Key:            map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: l.key}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("unable to get token bucket, %s", err)
+		}
+
+		now := time.Now().UnixMilli()
+		tokens, updated := l.rate, now
+		if out.Item != nil {
+			tokens, updated = numberAttr(out.Item["tokens"]), numberAttr(out.Item["updated_at"])
+		}
+		if now > updated {
+			tokens += (now - updated) * l.rate / 1000
+		}
+		if tokens > l.rate {
+			tokens = l.rate
+		}
+		if tokens < want {
+			if err := sleep(ctx, time.Duration((want-tokens)*int64(time.Second)/l.rate)); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(l.table),
+			Item: map[string]types.AttributeValue{
+				"key":        &types.AttributeValueMemberS{Value: l.key},
+				"tokens":     &types.AttributeValueMemberN{Value: strconv.FormatInt(tokens-want, 10)},
+				"updated_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+			},
+		}
+		if out.Item == nil {
+			input.ConditionExpression = aws.String("attribute_not_exists(#key)")
+			input.ExpressionAttributeNames = map[string]string{"#key": "key"}
+		} else {
+			input.ConditionExpression = aws.String("updated_at = :prev")
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{":prev": out.Item["updated_at"]}
+		}
+		_, err = l.client.PutItem(ctx, input)
+		if err != nil {
+			var conditionFailed *types.ConditionalCheckFailedException
+			if errors.As(err, &conditionFailed) {
+				continue
+			}
+			return 0, fmt.Errorf("unable to update token bucket, %s", err)
+		}
+		return want, nil
+	}
+}
+
+func numberAttr(v types.AttributeValue) int64 {
+	n, ok := v.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+	i, _ := strconv.ParseInt(n.Value, 10, 64)
+	return i
+}
+
+// NewDynamoLimiter は、DynamoDB のテーブル table の key の項目をトークンバケットとして、
+// すべての実行環境を合わせて1秒あたり rate バイトまでに抑える Limiter を返します。
+// テーブルへの書き込みを減らすため、1回あたり rate の10分の1（最低 1MB）ずつまとめて取得します。
+// テーブルは文字列のパーティションキー「key」を持つ必要があります。
+func NewDynamoLimiter(client *dynamodb.Client, table, key string, rate int64) Limiter {
+	lease := rate / 10
+	if lease < 1<<20 {
+		lease = 1 << 20
+	}
+	return &dynamoLimiter{client: client, table: table, key: key, rate: rate, lease: lease}
+}
@@ -0,0 +1,103 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter は、転送するバイト数を単位時間あたりの上限に抑えます。
+type Limiter interface {
+	// Wait は、n バイトを転送してよくなるまで待ちます。ctx が終了した場合はそのエラーを返します。
+	Wait(ctx context.Context, n int64) error
+}
+
+type localLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // 1秒あたりのバイト数
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+func (l *localLimiter) Wait(ctx context.Context, n int64) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.updated).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.updated = now
+	// 上限を超える分は前借りし、足りない分が補充されるまで待つ。
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	return sleep(ctx, delay)
+}
+
+// NewLocal は、この実行環境の中で1秒あたり rate バイトまでに抑える Limiter を返します。
+// 1秒分までは、直前に転送しなかった分をまとめて転送できます。
+func NewLocal(rate int64) Limiter {
+	return &localLimiter{rate: float64(rate), burst: float64(rate), tokens: float64(rate), updated: time.Now()}
+}
+
+type multiLimiter []Limiter
+
+func (m multiLimiter) Wait(ctx context.Context, n int64) error {
+	for _, l := range m {
+		if err := l.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Multi は、すべての Limiter の上限を守る Limiter を返します。nil の Limiter は無視します。
+func Multi(limiters ...Limiter) Limiter {
+	var m multiLimiter
+	for _, l := range limiters {
+		if l != nil {
+			m = append(m, l)
+		}
+	}
+	return m
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.Wait(r.ctx, int64(n)); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Reader は、r から読み込む速さを l の上限に抑える io.Reader を返します。
+func Reader(ctx context.Context, r io.Reader, l Limiter) io.Reader {
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,215 @@
+package tokenstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// Tokens は、ワークスペースごとのSlackのトークンです。
+type Tokens struct {
+	Bot   string `json:"bot"`
+	User  string `json:"user"`
+	Admin string `json:"admin,omitempty"` // Enterprise Grid の組織の管理者のトークン（DELETE_MODE=admin）
+	// RoleARN は、チームのファイルをS3に保存するときに引き受けるIAMロールです。
+	RoleARN string `json:"role_arn,omitempty"`
+	// Bucket は、チームのファイルを保存するバケットです。
+	Bucket string `json:"bucket,omitempty"`
+}
+
+// Registry は、チームID（T...）またはEnterprise GridのID（E...）ごとにトークンを暗号化して保存します。
+//
+// トークンは KMS のエンベロープ暗号化で保存します。保存のたびに KMS で新しいデータキーを生成し、
+// データキーでトークンを AES-GCM で暗号化して、KMS で暗号化したデータキーと一緒に保存します。
+// データキーの暗号化にはIDを暗号化コンテキストとして指定するため、別のチームの項目に付け替えた暗号文は復号できません。
+type Registry interface {
+	Put(ctx context.Context, id string, tokens Tokens) error
+	// Get は、id のトークンを復号して返します。登録されていない場合は nil を返します。
+	Get(ctx context.Context, id string) (*Tokens, error)
+	// Rotate は、before より前に生成したデータキーで暗号化しているトークンを、新しいデータキーで暗号化し直します。
+	// 暗号化し直した件数を返します。
+	Rotate(ctx context.Context, before time.Time) (int, error)
+}
+
+type item struct {
+	encryptedKey []byte
+	nonce        []byte
+	ciphertext   []byte
+}
+
+type dynamoRegistry struct {
+	dynamo *dynamodb.Client
+	kms    *kms.Client
+	table  string
+	keyID  string
+}
+
+func (r *dynamoRegistry) Put(ctx context.Context, id string, tokens Tokens) error {
+	plaintext, err := json.Marshal(&tokens)
+	if err != nil {
+		return fmt.Errorf("unable to marshal tokens, %s", err)
+	}
+
+	dataKey, err := r.kms.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(r.keyID),
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: encryptionContext(id),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to generate data key, %s", err)
+	}
+	defer zero(dataKey.Plaintext)
+
+	gcm, err := newGCM(dataKey.Plaintext)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("unable to generate nonce, %s", err)
+	}
+
+	if _, err := r.dynamo.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(r.table),
+		Item: map[string]types.AttributeValue{
+			"id":             &types.AttributeValueMemberS{Value: id},
+			"encrypted_key":  &types.AttributeValueMemberB{Value: dataKey.CiphertextBlob},
+			"nonce":          &types.AttributeValueMemberB{Value: nonce},
+			"ciphertext":     &types.AttributeValueMemberB{Value: gcm.Seal(nil, nonce, plaintext, []byte(id))},
+			"key_created_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to put tokens, %s", err)
+	}
+	return nil
+}
+
+func (r *dynamoRegistry) Get(ctx context.Context, id string) (*Tokens, error) {
+	out, err := r.dynamo.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.table),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get tokens, %s", err)
+	}
+	if out.Item == nil {
+		return nil, nil
+	}
+	it, err := parseItem(out.Item)
+	if err != nil {
+		return nil, err
+	}
+	return r.decrypt(ctx, id, it)
+}
+
+func (r *dynamoRegistry) Rotate(ctx context.Context, before time.Time) (int, error) {
+	rotated := 0
+	paginator := dynamodb.NewScanPaginator(r.dynamo, &dynamodb.ScanInput{
+		TableName:        aws.String(r.table),
+		FilterExpression: aws.String("key_created_at < :before"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":before": &types.AttributeValueMemberN{Value: strconv.FormatInt(before.Unix(), 10)},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return rotated, fmt.Errorf("unable to scan tokens, %s", err)
+		}
+		for _, raw := range page.Items {
+			idAttr, ok := raw["id"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			it, err := parseItem(raw)
+			if err != nil {
+				return rotated, err
+			}
+			tokens, err := r.decrypt(ctx, idAttr.Value, it)
+			if err != nil {
+				return rotated, err
+			}
+			if err := r.Put(ctx, idAttr.Value, *tokens); err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+	}
+	return rotated, nil
+}
+
+func (r *dynamoRegistry) decrypt(ctx context.Context, id string, it *item) (*Tokens, error) {
+	dataKey, err := r.kms.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    it.encryptedKey,
+		EncryptionContext: encryptionContext(id),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt data key, %s", err)
+	}
+	defer zero(dataKey.Plaintext)
+
+	gcm, err := newGCM(dataKey.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, it.nonce, it.ciphertext, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt tokens, %s", err)
+	}
+	var tokens Tokens
+	if err := json.Unmarshal(plaintext, &tokens); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal tokens, %s", err)
+	}
+	return &tokens, nil
+}
+
+func parseItem(raw map[string]types.AttributeValue) (*item, error) {
+	var it item
+	for name, dst := range map[string]*[]byte{"encrypted_key": &it.encryptedKey, "nonce": &it.nonce, "ciphertext": &it.ciphertext} {
+		v, ok := raw[name].(*types.AttributeValueMemberB)
+		if !ok {
+			return nil, fmt.Errorf("unable to read tokens, missing %s", name)
+		}
+		*dst = v.Value
+	}
+	return &it, nil
+}
+
+func encryptionContext(id string) map[string]string {
+	return map[string]string{"team_id": id}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher, %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cipher, %s", err)
+	}
+	return gcm, nil
+}
+
+// zero は、使い終わった平文のデータキーをメモリから消去します。
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// NewRegistry は、DynamoDB のテーブル table に、KMS のキー keyID で暗号化したトークンを保存する Registry を返します。
+func NewRegistry(dynamoClient *dynamodb.Client, kmsClient *kms.Client, table, keyID string) Registry {
+	return &dynamoRegistry{dynamo: dynamoClient, kms: kmsClient, table: table, keyID: keyID}
+}
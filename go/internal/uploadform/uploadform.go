@@ -0,0 +1,146 @@
+package uploadform
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Policy は、ブラウザからS3にアップロードできる条件です。
+type Policy struct {
+	Bucket    string
+	KeyPrefix string            // アップロード先のキーの接頭辞。キーは KeyPrefix にアップロードしたファイル名を続けたものになります
+	Fields    map[string]string // フォームに含め、値の一致を求める項目（x-amz-meta-* など）
+	MaxSize   int64             // アップロードできるファイルの最大サイズ（バイト）
+	Expires   time.Duration     // フォームの有効期限
+}
+
+// Form は、S3の署名付きPOSTのフォームです。
+type Form struct {
+	URL       string
+	Fields    map[string]string
+	ExpiresAt time.Time
+}
+
+// Sign は、Policy に署名し、S3の署名付きPOST（POSTポリシー）のフォームを生成します。
+// endpoint: S3のエンドポイント（例: https://s3.ap-northeast-1.amazonaws.com）。パス形式でバケットを指定します。
+func Sign(creds aws.Credentials, region, endpoint string, p *Policy, now time.Time) (*Form, error) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	credential := strings.Join([]string{creds.AccessKeyID, date, region, "s3", "aws4_request"}, "/")
+	expiresAt := now.Add(p.Expires)
+
+	fields := map[string]string{
+		"key":              p.KeyPrefix + "${filename}",
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": credential,
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if creds.SessionToken != "" {
+		fields["x-amz-security-token"] = creds.SessionToken
+	}
+	for k, v := range p.Fields {
+		fields[k] = v
+	}
+
+	conditions := []interface{}{
+		map[string]string{"bucket": p.Bucket},
+		[]interface{}{"starts-with", "$key", p.KeyPrefix},
+		[]interface{}{"content-length-range", 1, p.MaxSize},
+	}
+	for k, v := range fields {
+		if k == "key" {
+			continue
+		}
+		conditions = append(conditions, map[string]string{k: v})
+	}
+	policy, err := json.Marshal(map[string]interface{}{
+		"expiration": expiresAt.Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal post policy, %s", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(policy)
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	fields["policy"] = encoded
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(key, encoded))
+
+	return &Form{
+		URL:       strings.TrimSuffix(endpoint, "/") + "/" + p.Bucket,
+		Fields:    fields,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Page は、アップロードページに表示する内容です。
+type Page struct {
+	Title string
+	Note  string
+	Form  *Form
+}
+
+var pageTemplate = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Hiragino Sans", "Noto Sans JP", sans-serif; margin: 0; background: #f6f7f9; color: #1d1c1d; }
+main { max-width: 560px; margin: 40px auto; padding: 24px; background: #fff; box-shadow: 0 1px 3px rgba(0,0,0,.08); }
+h1 { font-size: 1.3rem; margin-top: 0; }
+.meta { color: #616061; font-size: .9rem; }
+.note { border-left: 4px solid #1264a3; padding: 8px 12px; margin: 16px 0; }
+button { margin-top: 16px; padding: 8px 20px; background: #1264a3; color: #fff; border: 0; border-radius: 4px; font-weight: 600; cursor: pointer; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+<p class="meta">このページの有効期限: {{.Form.ExpiresAt.Format "2006-01-02 15:04 MST"}}</p>
+{{- if .Note}}
+<p class="note">{{.Note}}</p>
+{{- end}}
+<form action="{{.Form.URL}}" method="post" enctype="multipart/form-data">
+{{- range $name, $value := .Form.Fields}}
+<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{- end}}
+<input type="file" name="file" required>
+<br>
+<button type="submit">アップロード</button>
+</form>
+</main>
+</body>
+</html>
+`))
+
+// RenderPage は、署名付きPOSTのフォームを含むアップロードページのHTMLを生成します。
+// S3はフォームの file より後の項目を無視するため、file は最後に置きます。
+func RenderPage(p *Page) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pageTemplate.Execute(&buf, p); err != nil {
+		return nil, fmt.Errorf("unable to render upload page, %s", err)
+	}
+	return buf.Bytes(), nil
+}
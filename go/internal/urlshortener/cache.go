@@ -0,0 +1,291 @@
+package urlshortener
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Cache は、長いURLに対応する短縮URLを保持します。
+type Cache interface {
+	// Get は、key の値と、その値が期限切れになる日時を返します。
+	Get(key string) (string, time.Time, bool, error)
+	Set(key, value string, ttl time.Duration) error
+}
+
+type memoryCacheEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+func (c *memoryCache) Get(key string) (string, time.Time, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return "", time.Time{}, false, nil
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(c.entries, key)
+		return "", time.Time{}, false, nil
+	}
+	return e.value, e.expiresAt, true, nil
+}
+
+func (c *memoryCache) Set(key, value string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = memoryCacheEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// NewMemoryCache は、Lambdaのウォームスタート間で共有されるメモリ上のキャッシュを生成します。
+func NewMemoryCache() Cache {
+	return &memoryCache{entries: map[string]memoryCacheEntry{}}
+}
+
+type dynamoCache struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func (c *dynamoCache) Get(key string) (string, time.Time, bool, error) {
+	out, err := c.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+		TableName:      aws.String(c.table),
+		Key:            map[string]types.AttributeValue{"key": &types.AttributeValueMemberS{Value: key}},
+		ConsistentRead: aws.Bool(false),
+	})
+	if err != nil {
+		return "", time.Time{}, false, fmt.Errorf("unable to get cache item, %s", err)
+	}
+	value, ok := out.Item["short_url"].(*types.AttributeValueMemberS)
+	if !ok {
+		return "", time.Time{}, false, nil
+	}
+	// DynamoDB の TTL による削除は遅れることがあるため、期限を自分でも確認する。
+	ttl, ok := out.Item["ttl"].(*types.AttributeValueMemberN)
+	if !ok {
+		return "", time.Time{}, false, nil
+	}
+	n, err := strconv.ParseInt(ttl.Value, 10, 64)
+	if err != nil || time.Now().Unix() >= n {
+		return "", time.Time{}, false, nil
+	}
+	return value.Value, time.Unix(n, 0), true, nil
+}
+
+func (c *dynamoCache) Set(key, value string, ttl time.Duration) error {
+	if _, err := c.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String(c.table),
+		Item: map[string]types.AttributeValue{
+			"key":       &types.AttributeValueMemberS{Value: key},
+			"short_url": &types.AttributeValueMemberS{Value: value},
+			"ttl":       &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)},
+		},
+	}); err != nil {
+		return fmt.Errorf("unable to put cache item, %s", err)
+	}
+	return nil
+}
+
+// NewDynamoCache は、DynamoDB のテーブルを使うキャッシュを生成します。
+// テーブルのパーティションキーは「key」（文字列）とし、「ttl」属性で TTL を有効にしてください。
+func NewDynamoCache(client *dynamodb.Client, table string) Cache {
+	return &dynamoCache{client: client, table: table}
+}
+
+type tieredCache struct {
+	tiers []Cache
+}
+
+func (c *tieredCache) Get(key string) (string, time.Time, bool, error) {
+	for i, tier := range c.tiers {
+		value, expiresAt, ok, err := tier.Get(key)
+		if err != nil {
+			return "", time.Time{}, false, err
+		}
+		if ok {
+			// 上位の（速い）キャッシュにも、下位の項目と同じ期限まで保存しておく。
+			for _, upper := range c.tiers[:i] {
+				upper.Set(key, value, time.Until(expiresAt))
+			}
+			return value, expiresAt, true, nil
+		}
+	}
+	return "", time.Time{}, false, nil
+}
+
+func (c *tieredCache) Set(key, value string, ttl time.Duration) error {
+	for _, tier := range c.tiers {
+		if err := tier.Set(key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewTieredCache は、先頭から順に参照する多段のキャッシュを生成します。
+func NewTieredCache(tiers ...Cache) Cache {
+	return &tieredCache{tiers: tiers}
+}
+
+// cacheTTL は、キャッシュの有効期間の上限です。キャッシュキーに含める有効期限の区切り（1日）と合わせています。
+const cacheTTL = 24 * time.Hour
+
+// cacheKey は、署名付きURLからキャッシュキーを生成します。
+// 署名付きURLは生成するたびに署名が変わるため、S3のオブジェクト（パスとバージョン）と、
+// 署名した日（有効期限の区切り）、有効期間（X-Amz-Expires）をキーにします。
+// 有効期間を含めないと、expiry=1h の依頼に、先に7日間で署名したURLの短縮URLを返してしまいます。
+func cacheKey(longURL string) string {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return longURL
+	}
+	q := u.Query()
+	day := q.Get("X-Amz-Date")
+	if len(day) >= 8 {
+		day = day[:8]
+	}
+	return u.Host + u.Path + "?versionId=" + q.Get("versionId") + "&day=" + day + "&expires=" + q.Get("X-Amz-Expires")
+}
+
+// presignedTTL は、署名付きURLの短縮URLをキャッシュする期間を返します。
+// キャッシュした短縮URLが期限切れの署名付きURLを指さないよう、cacheTTL と署名付きURLの残りの有効期間の短い方を返します。
+// 署名した日時や有効期間が分からない場合は 0 を返し、キャッシュしません。
+func presignedTTL(longURL string, now time.Time) time.Duration {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return 0
+	}
+	q := u.Query()
+	signedAt, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	if err != nil {
+		return 0
+	}
+	expires, err := strconv.ParseInt(q.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires <= 0 {
+		return 0
+	}
+	ttl := signedAt.Add(time.Duration(expires) * time.Second).Sub(now)
+	if ttl > cacheTTL {
+		return cacheTTL
+	}
+	return ttl
+}
+
+type cachingURLShortener struct {
+	next      URLShortener
+	cache     Cache
+	namespace string
+}
+
+// key は、名前空間ごとに異なる短縮URLになるため、名前空間を含めたキャッシュキーを返します。
+func (c *cachingURLShortener) key(longURL string) string {
+	if c.namespace == "" {
+		return cacheKey(longURL)
+	}
+	return c.namespace + "/" + cacheKey(longURL)
+}
+
+// setPresigned は、署名付きURLの残りの有効期間に限って短縮URLをキャッシュします。
+// キャッシュへの保存に失敗しても、短縮URLは返すため無視します。
+func (c *cachingURLShortener) setPresigned(longURL, shortURL string) {
+	if ttl := presignedTTL(longURL, time.Now()); ttl > 0 {
+		c.cache.Set(c.key(longURL), shortURL, ttl)
+	}
+}
+
+// revokedKey は、名前空間を無効にしたことを記録するキャッシュキーです。
+func revokedKey(namespace string) string {
+	return "revoked-namespace/" + namespace
+}
+
+// bypass は、名前空間が無効にされてから cacheTTL が過ぎるまで、無効になった短縮URLを返さないようキャッシュを使わないかを返します。
+func (c *cachingURLShortener) bypass() bool {
+	if c.namespace == "" {
+		return false
+	}
+	_, _, revoked, err := c.cache.Get(revokedKey(c.namespace))
+	return err != nil || revoked
+}
+
+func (c *cachingURLShortener) Shorten(longURL string) (string, error) {
+	if c.bypass() {
+		return c.next.Shorten(longURL)
+	}
+	if shortURL, _, ok, err := c.cache.Get(c.key(longURL)); err == nil && ok {
+		return shortURL, nil
+	}
+
+	shortURL, err := c.next.Shorten(longURL)
+	if err != nil {
+		return "", err
+	}
+	c.setPresigned(longURL, shortURL)
+	return shortURL, nil
+}
+
+func (c *cachingURLShortener) ShortenBatch(longURLs []string) ([]string, error) {
+	if c.bypass() {
+		return c.next.ShortenBatch(longURLs)
+	}
+	shortURLs := make([]string, len(longURLs))
+	var missIndexes []int
+	var missURLs []string
+	for i, longURL := range longURLs {
+		if shortURL, _, ok, err := c.cache.Get(c.key(longURL)); err == nil && ok {
+			shortURLs[i] = shortURL
+			continue
+		}
+		missIndexes = append(missIndexes, i)
+		missURLs = append(missURLs, longURL)
+	}
+	if len(missURLs) == 0 {
+		return shortURLs, nil
+	}
+
+	// キャッシュにないURLだけをまとめて短縮する。
+	shortened, err := c.next.ShortenBatch(missURLs)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missIndexes {
+		shortURLs[i] = shortened[j]
+		c.setPresigned(longURLs[i], shortened[j])
+	}
+	return shortURLs, nil
+}
+
+// ShortenForRecipients は、受取人ごとに異なる短縮URLになるため、キャッシュを使わずに短縮します。
+func (c *cachingURLShortener) ShortenForRecipients(longURL string, recipients []string) (string, error) {
+	return c.next.ShortenForRecipients(longURL, recipients)
+}
+
+func (c *cachingURLShortener) WithNamespace(namespace string) URLShortener {
+	return &cachingURLShortener{next: c.next.WithNamespace(namespace), cache: c.cache, namespace: namespace}
+}
+
+// RevokeNamespace は、名前空間を無効にしたことをキャッシュにも記録し、無効になった短縮URLをキャッシュから返さないようにします。
+func (c *cachingURLShortener) RevokeNamespace(namespace string) error {
+	if err := c.next.RevokeNamespace(namespace); err != nil {
+		return err
+	}
+	return c.cache.Set(revokedKey(namespace), strconv.FormatInt(time.Now().Unix(), 10), cacheTTL)
+}
+
+// NewCachingURLShortener は、同じオブジェクトを繰り返し短縮しないよう、結果をキャッシュする URLShortener を生成します。
+func NewCachingURLShortener(next URLShortener, cache Cache) URLShortener {
+	return &cachingURLShortener{next: next, cache: cache}
+}
@@ -0,0 +1,181 @@
+package urlshortener
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// countingShortener は、呼び出した回数を連番にした短縮URLを返す URLShortener です。
+type countingShortener struct {
+	calls int
+}
+
+func (s *countingShortener) Shorten(url string) (string, error) {
+	s.calls++
+	return fmt.Sprintf("https://s.example.com/%d", s.calls), nil
+}
+
+func (s *countingShortener) ShortenBatch(urls []string) ([]string, error) {
+	shortURLs := make([]string, len(urls))
+	for i, url := range urls {
+		shortURLs[i], _ = s.Shorten(url)
+	}
+	return shortURLs, nil
+}
+
+func (s *countingShortener) ShortenForRecipients(url string, recipients []string) (string, error) {
+	return s.Shorten(url)
+}
+
+func (s *countingShortener) WithNamespace(namespace string) URLShortener { return s }
+
+func (s *countingShortener) RevokeNamespace(namespace string) error { return nil }
+
+// presignedURL は、signedAt に expires の有効期間で署名したS3の署名付きURLを模したURLを返します。
+func presignedURL(signedAt time.Time, expires time.Duration) string {
+	return fmt.Sprintf("https://bucket.s3.ap-northeast-1.amazonaws.com/release.zip?X-Amz-Date=%s&X-Amz-Expires=%d&X-Amz-Signature=%d",
+		signedAt.UTC().Format("20060102T150405Z"), int64(expires/time.Second), signedAt.UnixNano())
+}
+
+func TestCacheKeyIncludesExpires(t *testing.T) {
+	now := time.Now()
+	week := presignedURL(now, 7*24*time.Hour)
+	hour := presignedURL(now.Add(time.Second), time.Hour)
+	if cacheKey(week) == cacheKey(hour) {
+		t.Fatalf("cacheKey() is the same for different expiry: %s", cacheKey(week))
+	}
+	if cacheKey(hour) != cacheKey(presignedURL(now.Add(2*time.Second), time.Hour)) {
+		t.Error("cacheKey() differs for the same object and expiry")
+	}
+
+	next := &countingShortener{}
+	shortener := NewCachingURLShortener(next, NewMemoryCache())
+	a, _ := shortener.Shorten(week)
+	b, _ := shortener.Shorten(hour)
+	if a == b {
+		t.Errorf("Shorten() returned the 7-day link %s for a 1-hour presign", a)
+	}
+}
+
+func TestPresignedTTL(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		url  string
+		want time.Duration
+	}{
+		{"capped at cacheTTL", presignedURL(now, 7*24*time.Hour), cacheTTL},
+		{"remaining lifetime", presignedURL(now.Add(-30*time.Minute), time.Hour), 30 * time.Minute},
+		{"expired", presignedURL(now.Add(-2*time.Hour), time.Hour), -time.Hour},
+		{"not presigned", "https://example.com/release.zip", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := presignedTTL(tt.url, now); got != tt.want {
+				t.Errorf("presignedTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachingURLShortenerDoesNotOutlivePresign(t *testing.T) {
+	cache := NewMemoryCache()
+	shortener := NewCachingURLShortener(&countingShortener{}, cache)
+
+	longURL := presignedURL(time.Now().Add(-time.Hour+time.Minute), time.Hour)
+	if _, err := shortener.Shorten(longURL); err != nil {
+		t.Fatal(err)
+	}
+	_, expiresAt, ok, err := cache.Get(cacheKey(longURL))
+	if err != nil || !ok {
+		t.Fatalf("cache.Get() = %v, %v, want cached", ok, err)
+	}
+	if time.Until(expiresAt) > time.Minute {
+		t.Errorf("cached until %v, want within the presign's remaining minute", expiresAt)
+	}
+
+	// 期限切れの署名付きURLの短縮URLはキャッシュしない。
+	expired := presignedURL(time.Now().Add(-2*time.Hour), 30*time.Minute)
+	if _, err := shortener.Shorten(expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok, _ := cache.Get(cacheKey(expired)); ok {
+		t.Error("expired presign was cached")
+	}
+}
+
+func TestTieredCachePromotesWithLowerExpiry(t *testing.T) {
+	upper, lower := NewMemoryCache(), NewMemoryCache()
+	lower.Set("k", "v", time.Minute)
+	value, _, ok, err := NewTieredCache(upper, lower).Get("k")
+	if err != nil || !ok || value != "v" {
+		t.Fatalf("Get() = %q, %v, %v", value, ok, err)
+	}
+	_, expiresAt, ok, _ := upper.Get("k")
+	if !ok {
+		t.Fatal("value was not promoted")
+	}
+	if time.Until(expiresAt) > time.Minute {
+		t.Errorf("promoted until %v, want the lower tier's expiry", expiresAt)
+	}
+}
+
+// benchmarkURLs は、別々のオブジェクトを今日署名した n 件の署名付きURLを返します。
+func benchmarkURLs(n int) []string {
+	now := time.Now()
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://bucket.s3.ap-northeast-1.amazonaws.com/release-%d.zip?X-Amz-Date=%s&X-Amz-Expires=604800&X-Amz-Signature=%d",
+			i, now.UTC().Format("20060102T150405Z"), now.UnixNano())
+	}
+	return urls
+}
+
+func BenchmarkCacheKey(b *testing.B) {
+	longURL := benchmarkURLs(1)[0]
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cacheKey(longURL)
+	}
+}
+
+func BenchmarkCachingURLShortenerHit(b *testing.B) {
+	shortener := NewCachingURLShortener(&countingShortener{}, NewMemoryCache())
+	longURL := benchmarkURLs(1)[0]
+	shortener.Shorten(longURL)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := shortener.Shorten(longURL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCachingURLShortenerMiss(b *testing.B) {
+	urls := benchmarkURLs(b.N)
+	shortener := NewCachingURLShortener(&countingShortener{}, NewMemoryCache())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := shortener.Shorten(urls[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCachingURLShortenerBatch は、半分がキャッシュにある50件のURLをまとめて短縮します。
+func BenchmarkCachingURLShortenerBatch(b *testing.B) {
+	urls := benchmarkURLs(50)
+	cache := NewMemoryCache()
+	shortener := NewCachingURLShortener(&countingShortener{}, cache)
+	shortener.ShortenBatch(urls[:25])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewCachingURLShortener(&countingShortener{}, NewTieredCache(NewMemoryCache(), cache)).ShortenBatch(urls); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
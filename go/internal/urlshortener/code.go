@@ -0,0 +1,83 @@
+package urlshortener
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// CodeOptions は、内部の短縮URLサービスが短縮コードを生成する方法です。
+type CodeOptions struct {
+	Length      int    // 短縮コードの文字数
+	Alphabet    string // 短縮コードに使う文字
+	MaxAttempts int    // 既に使われている短縮コードを引いた場合に、生成し直す回数
+}
+
+// DefaultCodeOptions は、英数字8文字の短縮コードを生成する CodeOptions です。
+func DefaultCodeOptions() CodeOptions {
+	return CodeOptions{
+		Length:      8,
+		Alphabet:    "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+		MaxAttempts: 3,
+	}
+}
+
+// Validate は、短縮コードを生成できる設定かを確認します。
+// 乱数の偏りで推測しやすくならないよう、同じ文字を重ねて指定することはできません。
+// 名前空間の区切りと衝突しないよう、「/」は使えません。
+func (o CodeOptions) Validate() error {
+	if o.Length <= 0 {
+		return errors.New("short code length must be positive")
+	}
+	if len(o.Alphabet) < 2 {
+		return errors.New("short code alphabet must have at least 2 characters")
+	}
+	seen := make(map[rune]bool, len(o.Alphabet))
+	for _, c := range o.Alphabet {
+		if c > 0x7e || c <= 0x20 || c == '/' {
+			return fmt.Errorf("invalid character %q in short code alphabet", c)
+		}
+		if seen[c] {
+			return fmt.Errorf("duplicate character %q in short code alphabet", c)
+		}
+		seen[c] = true
+	}
+	if o.MaxAttempts <= 0 {
+		return errors.New("short code attempts must be positive")
+	}
+	return nil
+}
+
+// Entropy は、短縮コード1つあたりのエントロピー（ビット数）です。
+func (o CodeOptions) Entropy() float64 {
+	return float64(o.Length) * math.Log2(float64(len(o.Alphabet)))
+}
+
+// WithMinEntropy は、エントロピーが bits 以上になるまで短縮コードの文字数を増やした CodeOptions を返します。
+func (o CodeOptions) WithMinEntropy(bits float64) CodeOptions {
+	perChar := math.Log2(float64(len(o.Alphabet)))
+	if perChar <= 0 {
+		return o
+	}
+	if n := int(math.Ceil(bits / perChar)); n > o.Length {
+		o.Length = n
+	}
+	return o
+}
+
+// random は、暗号論的に安全な乱数で短縮コードを生成します。
+func (o CodeOptions) random() (string, error) {
+	var b strings.Builder
+	base := big.NewInt(int64(len(o.Alphabet)))
+	for i := 0; i < o.Length; i++ {
+		n, err := rand.Int(rand.Reader, base)
+		if err != nil {
+			return "", fmt.Errorf("unable to generate short code, %s", err)
+		}
+		b.WriteByte(o.Alphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,199 @@
+package urlshortener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Mapping は、内部の短縮URLサービスに保存した、短縮コードと長いURLの対応です。
+type Mapping struct {
+	Code      string // 名前空間を含む短縮コード（「acme/ab12cd」）
+	URL       string
+	Namespace string
+	ExpiresAt time.Time // 長いURL（署名付きURL）の有効期限。分からない場合はゼロ値
+	Revoked   bool
+}
+
+// Expired は、長いURLの有効期限が過ぎているかを返します。
+func (m *Mapping) Expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
+}
+
+// Resolver は、短縮コードから長いURLを求めます。
+type Resolver interface {
+	// Resolve は、code の対応を返します。見つからない場合は nil を返します。
+	Resolve(ctx context.Context, code string) (*Mapping, error)
+}
+
+type internalURLShortener struct {
+	client         *dynamodb.Client
+	table          string
+	namespaceIndex string
+	baseURL        string
+	namespace      string
+	grace          time.Duration
+	codes          CodeOptions
+}
+
+func (s *internalURLShortener) Shorten(longURL string) (string, error) {
+	now := time.Now()
+	item := map[string]types.AttributeValue{
+		"url":        &types.AttributeValueMemberS{Value: longURL},
+		"created_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+	}
+	if s.namespace != "" {
+		item["namespace"] = &types.AttributeValueMemberS{Value: s.namespace}
+	}
+	// 署名付きURLの場合は、有効期限に猶予を加えた時刻に DynamoDB の TTL で対応を削除する。
+	// 猶予の間は、リダイレクトの代わりに有効期限が切れたことを知らせるページを表示できる。
+	if expiresAt, ok := presignedExpiry(longURL); ok {
+		item["expires_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)}
+		item["ttl"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Add(s.grace).Unix(), 10)}
+	}
+
+	for attempt := 0; attempt < s.codes.MaxAttempts; attempt++ {
+		code, err := s.codes.random()
+		if err != nil {
+			return "", err
+		}
+		if s.namespace != "" {
+			code = s.namespace + "/" + code
+		}
+		item["code"] = &types.AttributeValueMemberS{Value: code}
+		_, err = s.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+			TableName:                aws.String(s.table),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#code)"),
+			ExpressionAttributeNames: map[string]string{"#code": "code"},
+		})
+		if err == nil {
+			return s.baseURL + "/" + code, nil
+		}
+		// 既に使われている短縮コードの場合は、別のコードでやり直す。
+		var conditionFailed *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionFailed) {
+			return "", fmt.Errorf("unable to put short code, %s", err)
+		}
+	}
+	return "", fmt.Errorf("unable to generate unique short code after %d attempts", s.codes.MaxAttempts)
+}
+
+func (s *internalURLShortener) ShortenBatch(longURLs []string) ([]string, error) {
+	return shortenEach(s, longURLs)
+}
+
+func (s *internalURLShortener) ShortenForRecipients(longURL string, recipients []string) (string, error) {
+	return "", fmt.Errorf("recipients are not supported by the internal shortener")
+}
+
+func (s *internalURLShortener) WithNamespace(namespace string) URLShortener {
+	namespaced := *s
+	namespaced.namespace = namespace
+	return &namespaced
+}
+
+func (s *internalURLShortener) RevokeNamespace(namespace string) error {
+	if namespace == "" {
+		return fmt.Errorf("no namespace specified")
+	}
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:                aws.String(s.table),
+		IndexName:                aws.String(s.namespaceIndex),
+		KeyConditionExpression:   aws.String("#namespace = :namespace"),
+		ExpressionAttributeNames: map[string]string{"#namespace": "namespace"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":namespace": &types.AttributeValueMemberS{Value: namespace},
+		},
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return fmt.Errorf("unable to query short codes, %s", err)
+		}
+		for _, item := range out.Items {
+			if _, err := s.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+				TableName:        aws.String(s.table),
+				Key:              map[string]types.AttributeValue{"code": item["code"]},
+				UpdateExpression: aws.String("SET revoked = :true"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":true": &types.AttributeValueMemberBOOL{Value: true},
+				},
+			}); err != nil {
+				return fmt.Errorf("unable to revoke short code, %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *internalURLShortener) Resolve(ctx context.Context, code string) (*Mapping, error) {
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key:       map[string]types.AttributeValue{"code": &types.AttributeValueMemberS{Value: code}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get short code, %s", err)
+	}
+	longURL, ok := out.Item["url"].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, nil
+	}
+	m := &Mapping{Code: code, URL: longURL.Value}
+	if v, ok := out.Item["namespace"].(*types.AttributeValueMemberS); ok {
+		m.Namespace = v.Value
+	}
+	if v, ok := out.Item["expires_at"].(*types.AttributeValueMemberN); ok {
+		if n, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			m.ExpiresAt = time.Unix(n, 0)
+		}
+	}
+	if v, ok := out.Item["revoked"].(*types.AttributeValueMemberBOOL); ok {
+		m.Revoked = v.Value
+	}
+	return m, nil
+}
+
+// presignedExpiry は、SigV4 の署名付きURLの X-Amz-Date と X-Amz-Expires から有効期限を求めます。
+func presignedExpiry(longURL string) (time.Time, bool) {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+	q := u.Query()
+	signedAt, err := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(q.Get("X-Amz-Expires"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return signedAt.Add(time.Duration(seconds) * time.Second), true
+}
+
+// NewInternalURLShortener は、短縮コードと長いURLの対応を DynamoDB のテーブル table に保存する URLShortener を返します。
+// 短縮URLは「baseURL/短縮コード」で、LAMBDA_HANDLER=redirect のハンドラーが Resolver で長いURLにリダイレクトします。
+// 署名付きURLの対応は、有効期限から grace が過ぎると TTL で削除されます。
+// テーブルは文字列のパーティションキー「code」を持ち、「ttl」属性で TTL を有効にしてください。
+// 名前空間をまとめて無効にするため、「namespace」をパーティションキーとするグローバルセカンダリインデックス namespaceIndex が必要です。
+// 短縮コードは codes の設定で生成します。codes が不正な場合はエラーを返します。
+func NewInternalURLShortener(client *dynamodb.Client, table, namespaceIndex, baseURL string, grace time.Duration, codes CodeOptions) (URLShortener, error) {
+	if err := codes.Validate(); err != nil {
+		return nil, err
+	}
+	return &internalURLShortener{client: client, table: table, namespaceIndex: namespaceIndex, baseURL: strings.TrimSuffix(baseURL, "/"), grace: grace, codes: codes}, nil
+}
+
+// NewInternalResolver は、NewInternalURLShortener で保存した対応を DynamoDB のテーブル table から読み取る Resolver を返します。
+func NewInternalResolver(client *dynamodb.Client, table string) Resolver {
+	return &internalURLShortener{client: client, table: table}
+}
@@ -0,0 +1,154 @@
+package urlshortener
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+const (
+	StatusExpired     = "expired"      // 署名付きURLの有効期限が切れた
+	StatusRevoked     = "revoked"      // 管理者が無効にした
+	StatusNotFound    = "not_found"    // 短縮コードが見つからない
+	StatusRateLimited = "rate_limited" // アクセスが多すぎる
+	StatusError       = "error"        // サーバーでエラーが発生した
+)
+
+// pageText は、ページに表示する見出しと説明です。
+type pageText struct {
+	Title   string
+	Message string
+}
+
+// pageTexts は、言語ごと、リダイレクトできない理由ごとの文言です。
+var pageTexts = map[string]map[string]pageText{
+	"ja": {
+		StatusExpired:     {"リンクの有効期限が切れました", "このリンクは有効期限が切れたため、ダウンロードできません。共有した方に、新しいリンクの発行を依頼してください。"},
+		StatusRevoked:     {"リンクは無効になりました", "このリンクは管理者によって無効にされたため、ダウンロードできません。"},
+		StatusNotFound:    {"リンクが見つかりません", "URLが正しいか確認してください。有効期限が切れてから時間が経ったリンクは表示できません。"},
+		StatusRateLimited: {"アクセスが集中しています", "短時間に多くのアクセスがあったため、一時的に制限しています。しばらく待ってから、もう一度お試しください。"},
+		StatusError:       {"エラーが発生しました", "ただいまリンクを開けません。しばらく待ってから、もう一度お試しください。"},
+	},
+	"en": {
+		StatusExpired:     {"This link has expired", "This link can no longer be used to download the file. Please ask the sender to share a new link."},
+		StatusRevoked:     {"This link has been revoked", "This link was disabled by an administrator and can no longer be used."},
+		StatusNotFound:    {"Link not found", "Please check that the URL is correct. Links that expired a while ago are no longer available."},
+		StatusRateLimited: {"Too many requests", "This link is temporarily limited because of too many requests. Please try again later."},
+		StatusError:       {"Something went wrong", "The link cannot be opened right now. Please try again later."},
+	},
+}
+
+// contactLabels は、言語ごとの問い合わせ先の見出しです。
+var contactLabels = map[string]string{
+	"ja": "お問い合わせ",
+	"en": "Contact",
+}
+
+// Branding は、ページに表示する組織のロゴや問い合わせ先です。
+type Branding struct {
+	Organization string // 組織名。ページのタイトルに添える
+	LogoURL      string
+	Contact      string // 問い合わせ先のメールアドレスまたはURL
+}
+
+// StatusPages は、短縮URLからリダイレクトできない場合に、S3 のエラーの代わりに表示するページを生成します。
+type StatusPages interface {
+	// Render は、status のページを言語 lang（「ja」「en」）で生成します。対応していない言語の場合は日本語で生成します。
+	Render(status, lang string) ([]byte, error)
+}
+
+// pageData は、テンプレートに渡す値です。
+type pageData struct {
+	Lang         string
+	Status       string
+	Title        string
+	Message      string
+	Organization string
+	LogoURL      string
+	Contact      string
+	ContactURL   template.URL
+	ContactLabel string
+}
+
+type statusPages struct {
+	tmpl     *template.Template
+	branding Branding
+}
+
+func (p *statusPages) Render(status, lang string) ([]byte, error) {
+	texts, ok := pageTexts[lang]
+	if !ok {
+		lang, texts = "ja", pageTexts["ja"]
+	}
+	text, ok := texts[status]
+	if !ok {
+		return nil, fmt.Errorf("unknown status %q", status)
+	}
+	data := &pageData{
+		Lang:         lang,
+		Status:       status,
+		Title:        text.Title,
+		Message:      text.Message,
+		Organization: p.branding.Organization,
+		LogoURL:      p.branding.LogoURL,
+		Contact:      p.branding.Contact,
+		ContactLabel: contactLabels[lang],
+	}
+	if c := p.branding.Contact; c != "" {
+		data.ContactURL = template.URL(c)
+		if strings.Contains(c, "@") && !strings.Contains(c, ":") {
+			data.ContactURL = template.URL("mailto:" + c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("unable to render status page, %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+const defaultStatusPageTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}{{if .Organization}} - {{.Organization}}{{end}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Hiragino Sans", "Noto Sans JP", sans-serif; margin: 0; background: #f6f7f9; color: #1d1c1d; }
+main { max-width: 560px; margin: 40px auto; padding: 24px; background: #fff; box-shadow: 0 1px 3px rgba(0,0,0,.08); }
+h1 { font-size: 1.3rem; margin-top: 0; }
+.logo { max-height: 40px; margin-bottom: 16px; }
+.contact { margin-top: 24px; font-size: .9rem; color: #616061; }
+</style>
+</head>
+<body>
+<main>
+{{- if .LogoURL}}
+<img class="logo" src="{{.LogoURL}}" alt="{{.Organization}}">
+{{- end}}
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{- if .Contact}}
+<p class="contact">{{.ContactLabel}}: <a href="{{.ContactURL}}">{{.Contact}}</a></p>
+{{- end}}
+</main>
+</body>
+</html>
+`
+
+// NewStatusPages は、branding のロゴや問い合わせ先を表示する StatusPages を返します。
+// templateText を指定した場合は、既定のページの代わりにその html/template でページを生成します。
+// テンプレートには Lang、Status、Title、Message、Organization、LogoURL、Contact、ContactURL、ContactLabel を渡します。
+func NewStatusPages(templateText string, branding Branding) (StatusPages, error) {
+	if templateText == "" {
+		templateText = defaultStatusPageTemplate
+	}
+	tmpl, err := template.New("status").Parse(templateText)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse status page template, %s", err)
+	}
+	return &statusPages{tmpl: tmpl, branding: branding}, nil
+}
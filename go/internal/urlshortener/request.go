@@ -0,0 +1,185 @@
+package urlshortener
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/kumagai-s/uploader-v2/internal/signature"
+)
+
+type RequestBody struct {
+	URL        string   `json:"url"`
+	Recipients []string `json:"recipients,omitempty"` // ダウンロードを許可する受取人のメールアドレス
+	Namespace  string   `json:"namespace,omitempty"`  // 短縮コードを区切る名前空間（「/acme/ab12cd」の「acme」）
+}
+
+type ResponseBody struct {
+	URL string `json:"shortened_url"`
+}
+
+type BatchRequestBody struct {
+	URLs      []string `json:"urls"`
+	Namespace string   `json:"namespace,omitempty"`
+}
+
+type RevokeRequestBody struct {
+	Namespace string `json:"namespace"`
+}
+
+type BatchResponseBody struct {
+	URLs []string `json:"shortened_urls"`
+}
+
+type URLShortener interface {
+	Shorten(url string) (string, error)
+	// ShortenBatch は、複数のURLをまとめて短縮し、同じ順序で短縮URLを返します。
+	ShortenBatch(urls []string) ([]string, error)
+	// ShortenForRecipients は、受取人を限定した短縮URLを発行します。
+	// 短縮URLサービスは、受取人のメールアドレスにワンタイムコードを送り、本人確認をしてからリダイレクトします。
+	ShortenForRecipients(url string, recipients []string) (string, error)
+	// WithNamespace は、短縮コードを namespace（チームやチャンネルの識別子）で区切って発行する URLShortener を返します。
+	// 短縮URLは「/acme/ab12cd」のようになり、短縮URLサービスで名前空間ごとに集計したり、まとめて無効にしたりできます。
+	// namespace が空の場合は、名前空間を指定せずに発行します。
+	WithNamespace(namespace string) URLShortener
+	// RevokeNamespace は、namespace で発行したすべての短縮URLを無効にします。
+	RevokeNamespace(namespace string) error
+}
+
+type urlShortener struct {
+	client    *http.Client
+	namespace string
+}
+
+func (r *urlShortener) Shorten(url string) (string, error) {
+	requestBody := RequestBody{
+		URL:       url,
+		Namespace: r.namespace,
+	}
+	var responseBody ResponseBody
+	if err := r.post(os.Getenv("URL_SHORTENER_URL"), requestBody, &responseBody); err != nil {
+		return "", err
+	}
+
+	return responseBody.URL, nil
+}
+
+func (r *urlShortener) ShortenForRecipients(url string, recipients []string) (string, error) {
+	if len(recipients) == 0 {
+		return "", fmt.Errorf("no recipients specified")
+	}
+	requestBody := RequestBody{
+		URL:        url,
+		Recipients: recipients,
+		Namespace:  r.namespace,
+	}
+	var responseBody ResponseBody
+	if err := r.post(os.Getenv("URL_SHORTENER_URL"), requestBody, &responseBody); err != nil {
+		return "", err
+	}
+
+	return responseBody.URL, nil
+}
+
+func (r *urlShortener) ShortenBatch(urls []string) ([]string, error) {
+	// 一括短縮に対応していない短縮URLサービスでは、1件ずつ短縮する。
+	endpoint := os.Getenv("URL_SHORTENER_BATCH_URL")
+	if endpoint == "" || len(urls) == 1 {
+		return shortenEach(r, urls)
+	}
+
+	requestBody := BatchRequestBody{
+		URLs:      urls,
+		Namespace: r.namespace,
+	}
+	var responseBody BatchResponseBody
+	if err := r.post(endpoint, requestBody, &responseBody); err != nil {
+		return nil, err
+	}
+	if len(responseBody.URLs) != len(urls) {
+		return nil, fmt.Errorf("unexpected number of shortened urls, got %d, want %d", len(responseBody.URLs), len(urls))
+	}
+
+	return responseBody.URLs, nil
+}
+
+func (r *urlShortener) WithNamespace(namespace string) URLShortener {
+	return &urlShortener{client: r.client, namespace: namespace}
+}
+
+func (r *urlShortener) RevokeNamespace(namespace string) error {
+	endpoint := os.Getenv("URL_SHORTENER_REVOKE_URL")
+	if endpoint == "" {
+		return fmt.Errorf("namespace revocation is not configured")
+	}
+	if namespace == "" {
+		return fmt.Errorf("no namespace specified")
+	}
+	var responseBody struct{}
+	return r.post(endpoint, RevokeRequestBody{Namespace: namespace}, &responseBody)
+}
+
+func (r *urlShortener) post(endpoint string, requestBody interface{}, responseBody interface{}) error {
+	method := "POST"
+
+	requestBodyBytes, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("unable to marshal request body, %s", err)
+	}
+
+	request, err := http.NewRequestWithContext(context.TODO(), method, endpoint, bytes.NewBuffer(requestBodyBytes))
+	if err != nil {
+		return fmt.Errorf("unable to create new request, %s", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if apiKey := os.Getenv("URL_SHORTENER_API_KEY"); apiKey != "" {
+		request.Header.Set("x-api-key", apiKey)
+	}
+	// 署名用のシークレットが設定されている場合は、シークレット自体を送らずにHMACで署名する。
+	if secret := os.Getenv("URL_SHORTENER_SIGNING_SECRET"); secret != "" {
+		signature.SignRequest(request, secret, requestBodyBytes)
+	}
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to send request, %s", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status code %d", response.StatusCode)
+	}
+
+	responseBodyBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read response body, %s", err)
+	}
+
+	err = json.Unmarshal(responseBodyBytes, responseBody)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal response body, %s", err)
+	}
+
+	return nil
+}
+
+// shortenEach は、URLを1件ずつ Shorten で短縮します。
+func shortenEach(s URLShortener, urls []string) ([]string, error) {
+	shortURLs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		shortURL, err := s.Shorten(url)
+		if err != nil {
+			return nil, err
+		}
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs, nil
+}
+
+func NewURLShortener(client *http.Client) URLShortener {
+	return &urlShortener{client: client}
+}
@@ -0,0 +1,67 @@
+package urlshortener
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newShortenerServer は、受け取ったURLの件数だけ短縮URLを返す短縮URLサービスの代わりのサーバーを起動します。
+func newShortenerServer(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			URL  string   `json:"url"`
+			URLs []string `json:"urls"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.URLs == nil {
+			json.NewEncoder(w).Encode(ResponseBody{URL: "https://s.example.com/a"})
+			return
+		}
+		shortURLs := make([]string, len(body.URLs))
+		for i := range shortURLs {
+			shortURLs[i] = "https://s.example.com/a"
+		}
+		json.NewEncoder(w).Encode(BatchResponseBody{URLs: shortURLs})
+	}))
+	tb.Cleanup(server.Close)
+	tb.Setenv("URL_SHORTENER_URL", server.URL+"/shorten")
+	tb.Setenv("URL_SHORTENER_BATCH_URL", server.URL+"/batch")
+	return server
+}
+
+func TestURLShortenerShortenBatch(t *testing.T) {
+	server := newShortenerServer(t)
+	shortURLs, err := NewURLShortener(server.Client()).ShortenBatch(benchmarkURLs(3))
+	if err != nil || len(shortURLs) != 3 {
+		t.Fatalf("ShortenBatch() = %v, %v", shortURLs, err)
+	}
+}
+
+func BenchmarkURLShortenerShorten(b *testing.B) {
+	server := newShortenerServer(b)
+	shortener := NewURLShortener(server.Client())
+	longURL := benchmarkURLs(1)[0]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := shortener.Shorten(longURL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkURLShortenerShortenBatch(b *testing.B) {
+	server := newShortenerServer(b)
+	shortener := NewURLShortener(server.Client())
+	urls := benchmarkURLs(50)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := shortener.ShortenBatch(urls); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package watermark
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// スタンプの書式。各ページの下部中央に小さく半透明で表示する。
+const description = "font:Helvetica, points:9, pos:bc, offset:0 12, scale:1 abs, rot:0, opacity:0.6, fillcolor:#555555"
+
+func init() {
+	// Lambdaではホームディレクトリに書き込めないため、設定ファイルを使わない。
+	api.DisableConfigDir()
+}
+
+// StampPDF は、PDFの全ページに text をスタンプしたPDFを返します。
+func StampPDF(data []byte, text string) ([]byte, error) {
+	wm, err := api.TextWatermark(text, description, true, false, pdfcpu.POINTS)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create watermark, %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := api.AddWatermarks(bytes.NewReader(data), &buf, nil, wm, nil); err != nil {
+		return nil, fmt.Errorf("unable to stamp pdf, %s", err)
+	}
+	return buf.Bytes(), nil
+}